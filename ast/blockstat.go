@@ -7,6 +7,8 @@ type BlockStat struct {
 	Location
 	Stats  []Stat
 	Return []ExpNode
+
+	Comments *ListComments // Only set when the parser keeps comments
 }
 
 var _ Stat = BlockStat{}
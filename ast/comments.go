@@ -0,0 +1,35 @@
+package ast
+
+// The types in this file are only used when the parser is asked to keep
+// comments (see parsing.ParseChunkWithComments), so that the source code can be
+// reproduced faithfully, e.g. by Format.
+
+// A Comment is a comment in the source code.
+type Comment struct {
+	Text            string // Includes the leading "--"
+	BlankLineBefore bool   // True if there is a blank line before the comment
+}
+
+// NodeComments holds the comments attached to an item in a list, i.e. a
+// statement in a block or a field in a table constructor.
+type NodeComments struct {
+	Leading         []Comment // Comments on the lines before the item
+	Trailing        []Comment // Comments after the item, on its last line
+	BlankLineBefore bool      // True if there is a blank line before the item
+}
+
+// ListComments holds the comments in a block or a table constructor.
+type ListComments struct {
+	Open  []Comment      // Comments on the line of the opening token (e.g. "then" or "{")
+	Items []NodeComments // One per item (in a block, the return statement is an item)
+	Close []Comment      // Comments after the last item
+}
+
+// FunctionSyntax tells how a function was defined.
+type FunctionSyntax uint8
+
+const (
+	FunctionExpSyntax  FunctionSyntax = iota // function(...) ... end
+	FunctionStatSyntax                       // function f(...) ... end
+	MethodStatSyntax                         // function t:f(...) ... end, with an implicit "self" parameter
+)
@@ -31,3 +31,32 @@ func (e Etc) ProcessTailExp(p TailExpProcessor) {
 func (e Etc) HWrite(w HWriter) {
 	w.Writef("...")
 }
+
+// A BEtc is an Etc surrounded by brackets.  It only evaluates to the first
+// value of the ellipsis, so unlike Etc it is not a TailExpNode.
+//
+// For example:
+//
+//	return ...   -- this returns all the values of the ellipsis
+//	return (...) -- this only returns the first one
+type BEtc struct {
+	Location
+}
+
+var _ ExpNode = BEtc{}
+
+// InBrackets turns the receiver into a BEtc.
+func (e Etc) InBrackets() BEtc {
+	return BEtc(e)
+}
+
+// ProcessExp uses the given ExpProcessor to process the receiver.  As an
+// expression, it has the same value as an Etc.
+func (e BEtc) ProcessExp(p ExpProcessor) {
+	p.ProcessEtcExp(Etc(e))
+}
+
+// HWrite prints a tree representation of the node.
+func (e BEtc) HWrite(w HWriter) {
+	w.Writef("(...)")
+}
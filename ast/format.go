@@ -0,0 +1,806 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/arnodel/golua/ops"
+)
+
+// QuoteStyle tells Format which quotes to use for short string literals.
+type QuoteStyle uint8
+
+const (
+	DoubleQuotes QuoteStyle = iota // Use double quotes unless it requires more escaping
+	SingleQuotes                   // Use single quotes unless it requires more escaping
+	KeepQuotes                     // Keep the quotes used in the source
+)
+
+// FormatOptions controls the output of Format.
+type FormatOptions struct {
+	IndentWidth int // Number of spaces per indentation level (defaults to 4)
+	Quotes      QuoteStyle
+}
+
+// DefaultIndentWidth is the indentation width used by Format when
+// FormatOptions.IndentWidth is not set.
+const DefaultIndentWidth = 4
+
+// Format writes Lua source code for a chunk in a canonical layout.  The output
+// only depends on the AST, so formatting is deterministic and formatting
+// the output again does not change it.
+//
+// For the output to be faithful to the source (comments, blank lines, string
+// and number literals as written), the chunk should be obtained with
+// parsing.ParseChunkWithComments.  Otherwise string and number literals are
+// produced from their values.
+func Format(w io.Writer, chunk BlockStat, opts FormatOptions) error {
+	if opts.IndentWidth <= 0 {
+		opts.IndentWidth = DefaultIndentWidth
+	}
+	f := &formatter{opts: opts}
+	f.stats(chunk, true)
+	if f.buf.Len() > 0 {
+		f.buf.WriteByte('\n')
+	}
+	_, err := w.Write(f.buf.Bytes())
+	return err
+}
+
+type formatter struct {
+	opts        FormatOptions
+	buf         bytes.Buffer
+	depth       int
+	needsIndent bool // true at the start of a line
+}
+
+// Writes s, indenting it if it starts a line.
+func (f *formatter) write(s string) {
+	if f.needsIndent {
+		f.buf.WriteString(strings.Repeat(" ", f.depth*f.opts.IndentWidth))
+		f.needsIndent = false
+	}
+	f.buf.WriteString(s)
+}
+
+func (f *formatter) newline() {
+	f.buf.WriteByte('\n')
+	f.needsIndent = true
+}
+
+// Writes the statements of a block, each on its own line with its comments.  If
+// top is false, the block is nested so it starts on a new line.
+func (f *formatter) stats(b BlockStat, top bool) {
+	lines := 0
+	startLine := func(blank bool) {
+		if lines > 0 || !top {
+			f.newline()
+		}
+		if blank && lines > 0 {
+			f.newline()
+		}
+		lines++
+	}
+	pendingBlank := false
+	written := 0
+	for i, s := range b.Stats {
+		c := itemComments(b.Comments, i)
+		f.leading(c.Leading, startLine)
+		if _, ok := s.(EmptyStat); ok {
+			// Empty statements are dropped, but not their comments.
+			pendingBlank = pendingBlank || c.BlankLineBefore
+			for _, tc := range c.Trailing {
+				startLine(false)
+				f.write(commentText(tc))
+			}
+			continue
+		}
+		startLine(c.BlankLineBefore || pendingBlank)
+		pendingBlank = false
+		if written > 0 && statStartsWithParen(s) {
+			// Otherwise it would be parsed as a call continuing the previous
+			// statement.
+			f.write(";")
+		}
+		f.stat(s)
+		f.trailing(c.Trailing)
+		written++
+	}
+	if b.Return != nil {
+		c := itemComments(b.Comments, len(b.Stats))
+		f.leading(c.Leading, startLine)
+		startLine(c.BlankLineBefore || pendingBlank)
+		f.write("return")
+		if len(b.Return) > 0 {
+			f.write(" ")
+			f.expList(b.Return)
+		}
+		f.trailing(c.Trailing)
+	}
+	if b.Comments != nil {
+		f.leading(b.Comments.Close, startLine)
+	}
+}
+
+// Writes a nested block, starting on the current line (after e.g. "do") and
+// leaving the current line empty for the closing keyword.
+func (f *formatter) block(b BlockStat) {
+	if b.Comments != nil {
+		f.trailing(b.Comments.Open)
+	}
+	f.depth++
+	f.stats(b, false)
+	f.depth--
+	f.newline()
+}
+
+func (f *formatter) leading(comments []Comment, startLine func(bool)) {
+	for _, c := range comments {
+		startLine(c.BlankLineBefore)
+		f.write(commentText(c))
+	}
+}
+
+func (f *formatter) trailing(comments []Comment) {
+	for _, c := range comments {
+		f.write(" ")
+		f.write(commentText(c))
+	}
+}
+
+func itemComments(comments *ListComments, i int) NodeComments {
+	if comments == nil || i >= len(comments.Items) {
+		return NodeComments{}
+	}
+	return comments.Items[i]
+}
+
+func commentText(c Comment) string {
+	return strings.TrimRight(c.Text, " \t\r")
+}
+
+func (f *formatter) stat(s Stat) {
+	switch s := s.(type) {
+	case AssignStat:
+		if fx, ok := functionStat(s); ok {
+			f.write("function ")
+			if idx, ok := s.Dest[0].(IndexExp); ok && fx.Syntax == MethodStatSyntax {
+				f.prefixExp(idx.Coll)
+				f.write(":")
+				f.write(string(idx.Idx.(String).Val))
+			} else {
+				f.exp(s.Dest[0])
+			}
+			f.funcBody(fx)
+			return
+		}
+		for i, v := range s.Dest {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.exp(v)
+		}
+		f.write(" = ")
+		f.expList(s.Src)
+	case LocalStat:
+		f.write("local ")
+		for i, na := range s.NameAttribs {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.write(na.Name.Val)
+			switch na.Attrib {
+			case ConstAttrib:
+				f.write(" <const>")
+			case CloseAttrib:
+				f.write(" <close>")
+			}
+		}
+		if len(s.Values) > 0 {
+			f.write(" = ")
+			f.expList(s.Values)
+		}
+	case LocalFunctionStat:
+		f.write("local function ")
+		f.write(s.Name.Val)
+		f.funcBody(s.Function)
+	case FunctionCall:
+		f.exp(s)
+	case BlockStat:
+		f.write("do")
+		f.block(s)
+		f.write("end")
+	case WhileStat:
+		f.write("while ")
+		f.exp(s.Cond)
+		f.write(" do")
+		f.block(s.Body)
+		f.write("end")
+	case RepeatStat:
+		f.write("repeat")
+		f.block(s.Body)
+		f.write("until ")
+		f.exp(s.Cond)
+	case IfStat:
+		f.write("if ")
+		f.exp(s.If.Cond)
+		f.write(" then")
+		f.block(s.If.Body)
+		for _, c := range s.ElseIfs {
+			f.write("elseif ")
+			f.exp(c.Cond)
+			f.write(" then")
+			f.block(c.Body)
+		}
+		if s.Else != nil {
+			f.write("else")
+			f.block(*s.Else)
+		}
+		f.write("end")
+	case *ForStat:
+		f.write("for ")
+		f.write(s.Var.Val)
+		f.write(" = ")
+		f.exp(s.Start)
+		f.write(", ")
+		f.exp(s.Stop)
+		if !isImplicitStep(s.Step) {
+			f.write(", ")
+			f.exp(s.Step)
+		}
+		f.write(" do")
+		f.block(s.Body)
+		f.write("end")
+	case *ForInStat:
+		f.write("for ")
+		for i, v := range s.Vars {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.write(v.Val)
+		}
+		f.write(" in ")
+		f.expList(s.Params)
+		f.write(" do")
+		f.block(s.Body)
+		f.write("end")
+	case GotoStat:
+		f.write("goto ")
+		f.write(s.Label.Val)
+	case LabelStat:
+		f.write("::")
+		f.write(s.Name.Val)
+		f.write("::")
+	case BreakStat:
+		f.write("break")
+	case EmptyStat:
+		f.write(";")
+	default:
+		panic(fmt.Sprintf("cannot format statement %T", s))
+	}
+}
+
+// Returns the function if the statement is a "function f() ... end" statement.
+func functionStat(s AssignStat) (Function, bool) {
+	if len(s.Dest) != 1 || len(s.Src) != 1 {
+		return Function{}, false
+	}
+	fx, ok := s.Src[0].(Function)
+	if !ok || fx.Syntax == FunctionExpSyntax {
+		return Function{}, false
+	}
+	if fx.Syntax == MethodStatSyntax {
+		idx, ok := s.Dest[0].(IndexExp)
+		if !ok || len(fx.Params) == 0 {
+			return Function{}, false
+		}
+		if _, ok := idx.Idx.(String); !ok {
+			return Function{}, false
+		}
+	}
+	return fx, true
+}
+
+// The parser makes up a step of 1 when the numeric for loop does not have one.
+func isImplicitStep(e ExpNode) bool {
+	n, ok := e.(Int)
+	return ok && n.Val == 1 && n.Lit == "" && n.StartPos() == nil
+}
+
+// Writes the parameters and body of a function.
+func (f *formatter) funcBody(fx Function) {
+	f.write("(")
+	params := fx.Params
+	if fx.Syntax == MethodStatSyntax {
+		params = params[1:] // Drop the implicit "self"
+	}
+	for i, p := range params {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.write(p.Val)
+	}
+	if fx.HasDots {
+		if len(params) > 0 {
+			f.write(", ")
+		}
+		f.write("...")
+	}
+	f.write(")")
+	body := fx.Body
+	if len(body.Return) == 0 && (body.Comments == nil || len(body.Comments.Items) <= len(body.Stats)) {
+		// NewFunction adds an empty return statement if there is none, but a
+		// "return" at the end of a function is useless anyway.
+		body.Return = nil
+	}
+	if isEmptyBlock(body) {
+		f.write(" end")
+		return
+	}
+	f.block(body)
+	f.write("end")
+}
+
+func isEmptyBlock(b BlockStat) bool {
+	if len(b.Stats) > 0 || b.Return != nil {
+		return false
+	}
+	c := b.Comments
+	return c == nil || len(c.Open) == 0 && len(c.Close) == 0
+}
+
+func (f *formatter) expList(exps []ExpNode) {
+	for i, e := range exps {
+		if i > 0 {
+			f.write(", ")
+		}
+		f.exp(e)
+	}
+}
+
+// Precedences used to decide where brackets are needed.
+const (
+	unaryPrecedence  = 10
+	atomicPrecedence = 100
+)
+
+func precedence(e ExpNode) int {
+	switch e := e.(type) {
+	case *BinOp:
+		return e.OpType.Precedence()
+	case BinOp:
+		return e.OpType.Precedence()
+	case *UnOp, UnOp:
+		return unaryPrecedence
+	case Float:
+		if e.Lit == "" && math.Signbit(e.Val) && !math.IsNaN(e.Val) {
+			// Written with a leading "-"
+			return unaryPrecedence
+		}
+	}
+	return atomicPrecedence
+}
+
+func (f *formatter) exp(e ExpNode) {
+	switch e := e.(type) {
+	case Nil:
+		f.write("nil")
+	case Bool:
+		f.write(strconv.FormatBool(e.Val))
+	case Etc:
+		f.write("...")
+	case BEtc:
+		f.write("(...)")
+	case Int:
+		f.write(intLiteral(e))
+	case Float:
+		f.write(floatLiteral(e))
+	case String:
+		f.write(f.stringLiteral(e))
+	case Name:
+		f.write(e.Val)
+	case Function:
+		f.write("function")
+		f.funcBody(e)
+	case TableConstructor:
+		f.tableConstructor(e)
+	case IndexExp:
+		f.prefixExp(e.Coll)
+		if name, ok := nameIndex(e.Idx); ok {
+			f.write(".")
+			f.write(name)
+		} else {
+			f.write("[")
+			f.bracketedExp(e.Idx)
+			f.write("]")
+		}
+	case FunctionCall:
+		f.prefixExp(e.Target)
+		if e.Method.Val != "" {
+			f.write(":")
+			f.write(e.Method.Val)
+		}
+		f.write("(")
+		f.expList(e.Args)
+		f.write(")")
+	case *BFunctionCall:
+		f.write("(")
+		f.exp(FunctionCall{e})
+		f.write(")")
+	case BFunctionCall:
+		f.exp(&e)
+	case *BinOp:
+		f.binOp(*e)
+	case BinOp:
+		f.binOp(e)
+	case *UnOp:
+		f.unOp(*e)
+	case UnOp:
+		f.unOp(e)
+	default:
+		panic(fmt.Sprintf("cannot format expression %T", e))
+	}
+}
+
+// Writes an expression that is indexed or called, with brackets if needed.
+func (f *formatter) prefixExp(e ExpNode) {
+	switch e.(type) {
+	case Name, IndexExp, FunctionCall, *BFunctionCall, BFunctionCall, BEtc:
+		f.exp(e)
+	default:
+		f.write("(")
+		f.exp(e)
+		f.write(")")
+	}
+}
+
+// Writes an expression between square brackets, avoiding "[[" which would
+// start a long string.
+func (f *formatter) bracketedExp(e ExpNode) {
+	if s, ok := e.(String); ok && strings.HasPrefix(s.Lit, "[") {
+		f.write(" ")
+		f.exp(e)
+		f.write(" ")
+	} else {
+		f.exp(e)
+	}
+}
+
+func (f *formatter) binOp(b BinOp) {
+	// The operations in b.Right are applied from left to right.  Turn them
+	// into nested binary operations to decide where brackets are needed.
+	left := b.Left
+	last := len(b.Right) - 1
+	for _, r := range b.Right[:last] {
+		left = &BinOp{OpType: b.OpType, Left: left, Right: []Operation{r}}
+	}
+	r := b.Right[last]
+	prec := b.OpType.Precedence()
+	rightAssoc := b.OpType == ops.OpConcat || b.OpType == ops.OpPow
+	lp, rp := precedence(left), precedence(r.Operand)
+	f.operand(left, lp < prec || lp == prec && rightAssoc)
+	f.write(" ")
+	f.write(binOpStrings[r.Op])
+	f.write(" ")
+	_, unary := r.Operand.(*UnOp)
+	f.operand(r.Operand, rp < prec && !(b.OpType == ops.OpPow && unary) || rp == prec && !rightAssoc)
+}
+
+func (f *formatter) unOp(u UnOp) {
+	f.write(unOpStrings[u.Op])
+	if u.Op == ops.OpNeg && startsWithMinus(u.Operand) {
+		// Otherwise it would be a comment
+		f.write(" ")
+	}
+	f.operand(u.Operand, precedence(u.Operand) < unaryPrecedence)
+}
+
+func (f *formatter) operand(e ExpNode, brackets bool) {
+	if brackets {
+		f.write("(")
+		f.exp(e)
+		f.write(")")
+	} else {
+		f.exp(e)
+	}
+}
+
+func startsWithMinus(e ExpNode) bool {
+	switch e := e.(type) {
+	case *UnOp:
+		return e.Op == ops.OpNeg
+	case UnOp:
+		return e.Op == ops.OpNeg
+	case Float:
+		return precedence(e) == unaryPrecedence
+	}
+	return false
+}
+
+var binOpStrings = map[ops.Op]string{
+	ops.OpOr:       "or",
+	ops.OpAnd:      "and",
+	ops.OpLt:       "<",
+	ops.OpLeq:      "<=",
+	ops.OpGt:       ">",
+	ops.OpGeq:      ">=",
+	ops.OpEq:       "==",
+	ops.OpNeq:      "~=",
+	ops.OpBitOr:    "|",
+	ops.OpBitXor:   "~",
+	ops.OpBitAnd:   "&",
+	ops.OpShiftL:   "<<",
+	ops.OpShiftR:   ">>",
+	ops.OpConcat:   "..",
+	ops.OpAdd:      "+",
+	ops.OpSub:      "-",
+	ops.OpMul:      "*",
+	ops.OpDiv:      "/",
+	ops.OpFloorDiv: "//",
+	ops.OpMod:      "%",
+	ops.OpPow:      "^",
+}
+
+var unOpStrings = map[ops.Op]string{
+	ops.OpNeg:    "-",
+	ops.OpNot:    "not ",
+	ops.OpLen:    "#",
+	ops.OpBitNot: "~",
+}
+
+// Returns true if the statement would start with an opening bracket.
+func statStartsWithParen(s Stat) bool {
+	switch s := s.(type) {
+	case FunctionCall:
+		return expStartsWithParen(s)
+	case AssignStat:
+		if _, ok := functionStat(s); ok {
+			return false
+		}
+		return expStartsWithParen(s.Dest[0])
+	}
+	return false
+}
+
+func expStartsWithParen(e ExpNode) bool {
+	switch e := e.(type) {
+	case Name:
+		return false
+	case IndexExp:
+		return expStartsWithParen(e.Coll)
+	case FunctionCall:
+		return expStartsWithParen(e.Target)
+	}
+	return true
+}
+
+// A table constructor is written on one line if it was on one line in the
+// source, it has no comments and its fields fit on one line.  Otherwise each
+// field goes on its own line and is followed by a comma.
+func (f *formatter) tableConstructor(t TableConstructor) {
+	if len(t.Fields) == 0 && t.Comments == nil {
+		f.write("{}")
+		return
+	}
+	start, end := t.StartPos(), t.EndPos()
+	if (start == nil || end == nil || start.Line == end.Line) && !hasComments(t.Comments) {
+		mark, needsIndent := f.buf.Len(), f.needsIndent
+		f.write("{")
+		for i, field := range t.Fields {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.field(field)
+		}
+		f.write("}")
+		if bytes.IndexByte(f.buf.Bytes()[mark:], '\n') == -1 {
+			return
+		}
+		// A field contains a line break, so make it multi-line.
+		f.buf.Truncate(mark)
+		f.needsIndent = needsIndent
+	}
+	f.write("{")
+	c := t.Comments
+	if c != nil {
+		f.trailing(c.Open)
+	}
+	f.depth++
+	lines := 0
+	startLine := func(blank bool) {
+		f.newline()
+		if blank && lines > 0 {
+			f.newline()
+		}
+		lines++
+	}
+	for i, field := range t.Fields {
+		ic := itemComments(c, i)
+		f.leading(ic.Leading, startLine)
+		startLine(ic.BlankLineBefore)
+		f.field(field)
+		f.write(",")
+		f.trailing(ic.Trailing)
+	}
+	if c != nil {
+		f.leading(c.Close, startLine)
+	}
+	f.depth--
+	f.newline()
+	f.write("}")
+}
+
+func hasComments(c *ListComments) bool {
+	if c == nil {
+		return false
+	}
+	if len(c.Open) > 0 || len(c.Close) > 0 {
+		return true
+	}
+	for _, item := range c.Items {
+		if len(item.Leading) > 0 || len(item.Trailing) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *formatter) field(field TableField) {
+	if _, ok := field.Key.(NoTableKey); ok {
+		f.exp(field.Value)
+		return
+	}
+	if name, ok := nameIndex(field.Key); ok {
+		f.write(name)
+	} else {
+		f.write("[")
+		f.bracketedExp(field.Key)
+		f.write("]")
+	}
+	f.write(" = ")
+	f.exp(field.Value)
+}
+
+// Returns the name to use for indexing with e if it can be written as t.name
+// (or as name = value in a table constructor).  Strings which are not written
+// as names in the source keep their literal.
+func nameIndex(e ExpNode) (string, bool) {
+	s, ok := e.(String)
+	if !ok || s.Lit != "" || !isName(s.Val) {
+		return "", false
+	}
+	return string(s.Val), true
+}
+
+func isName(s []byte) bool {
+	if len(s) == 0 || luaKeywords[string(s)] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && '0' <= c && c <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true, "or": true,
+	"repeat": true, "return": true, "then": true, "true": true, "until": true,
+	"while": true,
+}
+
+func intLiteral(n Int) string {
+	if n.Lit != "" {
+		return n.Lit
+	}
+	if int64(n.Val) < 0 {
+		// A decimal literal would be read as a float
+		return fmt.Sprintf("0x%x", n.Val)
+	}
+	return strconv.FormatUint(n.Val, 10)
+}
+
+func floatLiteral(n Float) string {
+	if n.Lit != "" {
+		return n.Lit
+	}
+	switch {
+	case math.IsInf(n.Val, 1):
+		return "1e999"
+	case math.IsInf(n.Val, -1):
+		return "-1e999"
+	case math.IsNaN(n.Val):
+		return "(0/0)"
+	}
+	s := strconv.FormatFloat(n.Val, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// Returns the literal for a string, changing the quotes of short strings as
+// required by the options.
+func (f *formatter) stringLiteral(s String) string {
+	lit := s.Lit
+	if lit == "" {
+		q := byte('"')
+		if f.opts.Quotes == SingleQuotes {
+			q = '\''
+		}
+		return quoteString(s.Val, q)
+	}
+	var q byte
+	switch f.opts.Quotes {
+	case DoubleQuotes:
+		q = '"'
+	case SingleQuotes:
+		q = '\''
+	default:
+		return lit
+	}
+	if lit[0] == q || lit[0] == '[' {
+		return lit
+	}
+	return requote(lit, q)
+}
+
+// Changes the quotes of a short string literal to q, unless that requires
+// escaping quotes which are not escaped in lit.
+func requote(lit string, q byte) string {
+	orig := lit[0]
+	inner := lit[1 : len(lit)-1]
+	var b strings.Builder
+	b.WriteByte(q)
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\' && i+1 < len(inner):
+			i++
+			if inner[i] != orig {
+				b.WriteByte(c)
+			}
+			b.WriteByte(inner[i])
+		case c == q:
+			return lit
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+// Quotes a string value, using escape sequences for control characters and
+// bytes which are not ASCII.
+func quoteString(s []byte, q byte) string {
+	var b strings.Builder
+	b.WriteByte(q)
+	for _, c := range s {
+		switch {
+		case c == q || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < ' ' || c >= 0x7f:
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
@@ -11,6 +11,8 @@ type Function struct {
 	ParList
 	Body BlockStat
 	Name string
+
+	Syntax FunctionSyntax // Only set when the parser keeps comments
 }
 
 var _ ExpNode = Function{}
@@ -0,0 +1,297 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// EncodeJSON writes a JSON representation of a node to w, for use by tools.
+// Each node is an object with a "kind" field naming its type (e.g.
+// "LocalStat" or "BinOp"), a "loc" field giving its location in the source
+// (if known) and fields for its children.  Operators are given as they are
+// written in Lua (e.g. "+" or "not").  For example the expression "x + 1"
+// becomes (locations omitted):
+//
+//	{
+//	  "kind": "BinOp",
+//	  "left": {"kind": "Name", "val": "x"},
+//	  "right": [{"op": "+", "operand": {"kind": "Int", "val": 1}}]
+//	}
+//
+// Floats which are not finite are given as the strings "inf", "-inf" or "nan".
+func EncodeJSON(w io.Writer, n Node) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonNode(n))
+}
+
+// A jsonObject is a JSON object whose fields are output in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Returns an object for a node of the given kind, with its location.
+func jsonNodeObject(kind string, loc Locator, fields ...jsonField) jsonObject {
+	o := jsonObject{{"kind", kind}}
+	if l := jsonLocation(loc.Locate()); l != nil {
+		o = append(o, jsonField{"loc", l})
+	}
+	return append(o, fields...)
+}
+
+func jsonLocation(l Location) interface{} {
+	if l.start == nil && l.end == nil {
+		return nil
+	}
+	var o jsonObject
+	if l.start != nil {
+		o = append(o, jsonField{"start", jsonPos(l.start.Offset, l.start.Line, l.start.Column)})
+	}
+	if l.end != nil {
+		o = append(o, jsonField{"end", jsonPos(l.end.Offset, l.end.Line, l.end.Column)})
+	}
+	return o
+}
+
+func jsonPos(offset, line, column int) jsonObject {
+	return jsonObject{{"offset", offset}, {"line", line}, {"column", column}}
+}
+
+// Returns the JSON representation of a node, or nil if n is nil.
+func jsonNode(n Node) interface{} {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case AssignStat:
+		return jsonNodeObject("AssignStat", n,
+			jsonField{"dest", jsonVars(n.Dest)},
+			jsonField{"src", jsonExps(n.Src)})
+	case LocalStat:
+		names := make([]interface{}, len(n.NameAttribs))
+		for i, na := range n.NameAttribs {
+			attrib := ""
+			switch na.Attrib {
+			case ConstAttrib:
+				attrib = "const"
+			case CloseAttrib:
+				attrib = "close"
+			}
+			names[i] = jsonNodeObject("NameAttrib", na,
+				jsonField{"name", jsonNode(na.Name)},
+				jsonField{"attrib", attrib})
+		}
+		return jsonNodeObject("LocalStat", n,
+			jsonField{"names", names},
+			jsonField{"values", jsonExps(n.Values)})
+	case LocalFunctionStat:
+		return jsonNodeObject("LocalFunctionStat", n,
+			jsonField{"name", jsonNode(n.Name)},
+			jsonField{"function", jsonNode(n.Function)})
+	case FunctionCall:
+		return jsonCall("FunctionCall", *n.BFunctionCall)
+	case *BFunctionCall:
+		return jsonCall("BFunctionCall", *n)
+	case BFunctionCall:
+		return jsonCall("BFunctionCall", n)
+	case *BlockStat:
+		return jsonNode(*n)
+	case BlockStat:
+		var ret interface{}
+		if n.Return != nil {
+			ret = jsonExps(n.Return)
+		}
+		stats := make([]interface{}, len(n.Stats))
+		for i, s := range n.Stats {
+			stats[i] = jsonNode(s)
+		}
+		return jsonNodeObject("BlockStat", n,
+			jsonField{"stats", stats},
+			jsonField{"return", ret})
+	case WhileStat:
+		return jsonNodeObject("WhileStat", n,
+			jsonField{"cond", jsonNode(n.Cond)},
+			jsonField{"body", jsonNode(n.Body)})
+	case RepeatStat:
+		return jsonNodeObject("RepeatStat", n,
+			jsonField{"body", jsonNode(n.Body)},
+			jsonField{"cond", jsonNode(n.Cond)})
+	case IfStat:
+		elseIfs := make([]interface{}, len(n.ElseIfs))
+		for i, c := range n.ElseIfs {
+			elseIfs[i] = jsonCond(c)
+		}
+		var elseBody interface{}
+		if n.Else != nil {
+			elseBody = jsonNode(*n.Else)
+		}
+		return jsonNodeObject("IfStat", n,
+			jsonField{"if", jsonCond(n.If)},
+			jsonField{"elseIfs", elseIfs},
+			jsonField{"else", elseBody})
+	case *ForStat:
+		return jsonNode(*n)
+	case ForStat:
+		return jsonNodeObject("ForStat", n,
+			jsonField{"var", jsonNode(n.Var)},
+			jsonField{"start", jsonNode(n.Start)},
+			jsonField{"stop", jsonNode(n.Stop)},
+			jsonField{"step", jsonNode(n.Step)},
+			jsonField{"body", jsonNode(n.Body)})
+	case *ForInStat:
+		return jsonNode(*n)
+	case ForInStat:
+		vars := make([]interface{}, len(n.Vars))
+		for i, v := range n.Vars {
+			vars[i] = jsonNode(v)
+		}
+		return jsonNodeObject("ForInStat", n,
+			jsonField{"vars", vars},
+			jsonField{"params", jsonExps(n.Params)},
+			jsonField{"body", jsonNode(n.Body)})
+	case GotoStat:
+		return jsonNodeObject("GotoStat", n, jsonField{"label", jsonNode(n.Label)})
+	case LabelStat:
+		return jsonNodeObject("LabelStat", n, jsonField{"name", jsonNode(n.Name)})
+	case BreakStat:
+		return jsonNodeObject("BreakStat", n)
+	case EmptyStat:
+		return jsonNodeObject("EmptyStat", n)
+	case Nil:
+		return jsonNodeObject("Nil", n)
+	case Bool:
+		return jsonNodeObject("Bool", n, jsonField{"val", n.Val})
+	case Etc:
+		return jsonNodeObject("Etc", n)
+	case BEtc:
+		return jsonNodeObject("BEtc", n)
+	case Int:
+		return jsonNodeObject("Int", n, jsonField{"val", int64(n.Val)})
+	case Float:
+		var val interface{} = n.Val
+		switch {
+		case math.IsInf(n.Val, 1):
+			val = "inf"
+		case math.IsInf(n.Val, -1):
+			val = "-inf"
+		case math.IsNaN(n.Val):
+			val = "nan"
+		}
+		return jsonNodeObject("Float", n, jsonField{"val", val})
+	case String:
+		return jsonNodeObject("String", n, jsonField{"val", string(n.Val)})
+	case Name:
+		return jsonNodeObject("Name", n, jsonField{"val", n.Val})
+	case Function:
+		params := make([]interface{}, len(n.Params))
+		for i, p := range n.Params {
+			params[i] = jsonNode(p)
+		}
+		return jsonNodeObject("Function", n,
+			jsonField{"name", n.Name},
+			jsonField{"params", params},
+			jsonField{"hasDots", n.HasDots},
+			jsonField{"body", jsonNode(n.Body)})
+	case TableConstructor:
+		fields := make([]interface{}, len(n.Fields))
+		for i, f := range n.Fields {
+			var key interface{}
+			if _, ok := f.Key.(NoTableKey); !ok {
+				key = jsonNode(f.Key)
+			}
+			fields[i] = jsonNodeObject("TableField", f,
+				jsonField{"key", key},
+				jsonField{"value", jsonNode(f.Value)})
+		}
+		return jsonNodeObject("TableConstructor", n, jsonField{"fields", fields})
+	case IndexExp:
+		return jsonNodeObject("IndexExp", n,
+			jsonField{"coll", jsonNode(n.Coll)},
+			jsonField{"idx", jsonNode(n.Idx)})
+	case *BinOp:
+		return jsonNode(*n)
+	case BinOp:
+		right := make([]interface{}, len(n.Right))
+		for i, r := range n.Right {
+			right[i] = jsonObject{
+				{"op", binOpStrings[r.Op]},
+				{"operand", jsonNode(r.Operand)},
+			}
+		}
+		return jsonNodeObject("BinOp", n,
+			jsonField{"left", jsonNode(n.Left)},
+			jsonField{"right", right})
+	case *UnOp:
+		return jsonNode(*n)
+	case UnOp:
+		return jsonNodeObject("UnOp", n,
+			jsonField{"op", strings.TrimSpace(unOpStrings[n.Op])},
+			jsonField{"operand", jsonNode(n.Operand)})
+	default:
+		panic(fmt.Sprintf("cannot encode node %T", n))
+	}
+}
+
+func jsonCall(kind string, c BFunctionCall) jsonObject {
+	var method interface{}
+	if c.Method.Val != "" {
+		method = jsonNode(c.Method)
+	}
+	return jsonNodeObject(kind, c,
+		jsonField{"target", jsonNode(c.Target)},
+		jsonField{"method", method},
+		jsonField{"args", jsonExps(c.Args)})
+}
+
+func jsonCond(c CondStat) jsonObject {
+	return jsonObject{
+		{"cond", jsonNode(c.Cond)},
+		{"body", jsonNode(c.Body)},
+	}
+}
+
+func jsonExps(exps []ExpNode) []interface{} {
+	res := make([]interface{}, len(exps))
+	for i, e := range exps {
+		res[i] = jsonNode(e)
+	}
+	return res
+}
+
+func jsonVars(vars []Var) []interface{} {
+	res := make([]interface{}, len(vars))
+	for i, v := range vars {
+		res[i] = jsonNode(v)
+	}
+	return res
+}
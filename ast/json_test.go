@@ -0,0 +1,116 @@
+package ast_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	src := "local x <const> = -a.b + 2\nreturn (f(x, ...))"
+	chunk, err := parsing.ParseChunk(scanner.New("test", []byte(src)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ast.EncodeJSON(&buf, chunk); err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, buf.Bytes())
+	}
+	removeLocations(got)
+	want := map[string]interface{}{
+		"kind": "BlockStat",
+		"stats": []interface{}{
+			map[string]interface{}{
+				"kind": "LocalStat",
+				"names": []interface{}{
+					map[string]interface{}{
+						"kind":   "NameAttrib",
+						"name":   map[string]interface{}{"kind": "Name", "val": "x"},
+						"attrib": "const",
+					},
+				},
+				"values": []interface{}{
+					map[string]interface{}{
+						"kind": "BinOp",
+						"left": map[string]interface{}{
+							"kind": "UnOp",
+							"op":   "-",
+							"operand": map[string]interface{}{
+								"kind": "IndexExp",
+								"coll": map[string]interface{}{"kind": "Name", "val": "a"},
+								"idx":  map[string]interface{}{"kind": "String", "val": "b"},
+							},
+						},
+						"right": []interface{}{
+							map[string]interface{}{
+								"op":      "+",
+								"operand": map[string]interface{}{"kind": "Int", "val": 2.0},
+							},
+						},
+					},
+				},
+			},
+		},
+		"return": []interface{}{
+			map[string]interface{}{
+				"kind":   "BFunctionCall",
+				"target": map[string]interface{}{"kind": "Name", "val": "f"},
+				"method": nil,
+				"args": []interface{}{
+					map[string]interface{}{"kind": "Name", "val": "x"},
+					map[string]interface{}{"kind": "Etc"},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s", buf.Bytes())
+	}
+}
+
+func TestEncodeJSON_locations(t *testing.T) {
+	chunk, err := parsing.ParseChunk(scanner.New("test", []byte("x = 1\n  y = 2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ast.EncodeJSON(&buf, chunk.Stats[1]); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Kind string
+		Loc  struct {
+			Start struct{ Offset, Line, Column int }
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != "AssignStat" || got.Loc.Start.Line != 2 || got.Loc.Start.Column != 3 || got.Loc.Start.Offset != 8 {
+		t.Errorf("got %s", buf.Bytes())
+	}
+}
+
+// Removes "loc" fields so that the expected output is easier to write.
+func removeLocations(v interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		delete(x, "loc")
+		for _, y := range x {
+			removeLocations(y)
+		}
+	case []interface{}:
+		for _, y := range x {
+			removeLocations(y)
+		}
+	}
+}
@@ -62,6 +62,8 @@ func IsNumber(e ExpNode) bool {
 type Int struct {
 	Location
 	Val uint64
+
+	Lit string // The literal in the source, only set when the parser keeps comments
 }
 
 var _ ExpNode = Int{}
@@ -93,6 +95,8 @@ func (n Int) isNumber() bool {
 type Float struct {
 	Location
 	Val float64
+
+	Lit string // The literal in the source, only set when the parser keeps comments
 }
 
 var _ ExpNode = Float{}
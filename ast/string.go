@@ -14,6 +14,8 @@ import (
 type String struct {
 	Location
 	Val []byte
+
+	Lit string // The literal in the source, only set when the parser keeps comments
 }
 
 var _ ExpNode = String{}
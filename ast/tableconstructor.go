@@ -14,6 +14,8 @@ import (
 type TableConstructor struct {
 	Location
 	Fields []TableField
+
+	Comments *ListComments // Only set when the parser keeps comments
 }
 
 var _ ExpNode = TableConstructor{}
@@ -2,6 +2,7 @@ package astcomp
 
 import (
 	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/code"
 	"github.com/arnodel/golua/ir"
 	"github.com/arnodel/golua/ops"
 )
@@ -34,9 +35,11 @@ func (c *expCompiler) ProcessBinOpExp(b ast.BinOp) {
 		return
 	}
 	lsrc := c.compileExpNoDestHint(b.Left)
+	lname := c.varName(b.Left)
 	for _, r := range b.Right {
 		c.TakeRegister(lsrc)
 		rsrc := c.compileExpNoDestHint(r.Operand)
+		rname := c.varName(r.Operand)
 		switch r.Op {
 		case ops.OpNeq:
 			// x ~= y ==> ~(x = y)
@@ -68,15 +71,16 @@ func (c *expCompiler) ProcessBinOpExp(b ast.BinOp) {
 				Rsrc: lsrc,
 			})
 		default:
-			c.emitInstr(b, ir.Combine{
+			c.emitInstrWithNames(b, ir.Combine{
 				Op:   r.Op,
 				Dst:  c.dst,
 				Lsrc: lsrc,
 				Rsrc: rsrc,
-			})
+			}, lname, rname)
 		}
 		c.ReleaseRegister(lsrc)
 		lsrc = c.dst
+		lname = code.VarName{}
 	}
 }
 
@@ -108,6 +112,9 @@ func (c *expCompiler) ProcessEtcExp(e ast.Etc) {
 // ProcessFunctionExp compiles a Function.
 func (c *expCompiler) ProcessFunctionExp(f ast.Function) {
 	fc := c.NewChild(f.Name)
+	if start, end := f.StartPos(), f.EndPos(); start != nil && end != nil {
+		fc.SetLineRange(start.Line, end.Line)
+	}
 	fc.compileFunctionBody(f)
 	kidx, upvalues := fc.Close()
 	c.emitInstr(f, ir.MkClosure{
@@ -127,11 +134,11 @@ func (c *expCompiler) ProcessIndexExp(e ast.IndexExp) {
 	tReg := c.compileExpNoDestHint(e.Coll)
 	c.TakeRegister(tReg)
 	iReg := c.compileExpNoDestHint(e.Idx)
-	c.emitInstr(e, ir.Lookup{
+	c.emitInstrWithNames(e, ir.Lookup{
 		Dst:   c.dst,
 		Table: tReg,
 		Index: iReg,
-	})
+	}, c.varName(e.Coll))
 	c.ReleaseRegister(tReg)
 }
 
@@ -206,11 +213,12 @@ func (c *expCompiler) ProcessTableConstructorExp(t ast.TableConstructor) {
 
 // ProcessUnOpExp compiles a UnOpExp.
 func (c *expCompiler) ProcessUnOpExp(u ast.UnOp) {
-	c.emitInstr(u, ir.Transform{
+	src := c.compileExpNoDestHint(u.Operand)
+	c.emitInstrWithNames(u, ir.Transform{
 		Op:  u.Op,
 		Dst: c.dst,
-		Src: c.compileExpNoDestHint(u.Operand),
-	})
+		Src: src,
+	}, c.varName(u.Operand))
 }
 
 func (c *expCompiler) CompileExp(e ast.ExpNode) {
@@ -408,29 +416,29 @@ func (c *compiler) compileCall(f ast.BFunctionCall, tail bool) {
 		fReg = c.GetFreeRegister()
 		mReg := c.GetFreeRegister()
 		c.emitLoadConst(f.Method, ir.String(f.Method.Val), mReg)
-		c.emitInstr(f.Target, ir.Lookup{
+		c.emitInstrWithNames(f.Target, ir.Lookup{
 			Dst:   fReg,
 			Table: self,
 			Index: mReg,
-		})
+		}, c.varName(f.Target))
 		contReg = c.GetFreeRegister()
-		c.emitInstr(f, ir.MkCont{
+		c.emitInstrWithNames(f, ir.MkCont{
 			Dst:     contReg,
 			Closure: fReg,
 			Tail:    tail,
-		})
+		}, code.VarName{Kind: code.MethodVar, Name: f.Method.Val})
 		c.emitInstr(f, ir.Push{
-			Cont: fReg,
+			Cont: contReg,
 			Item: self,
 		})
 		c.ReleaseRegister(self)
 	} else {
 		contReg = c.GetFreeRegister()
-		c.emitInstr(f, ir.MkCont{
+		c.emitInstrWithNames(f, ir.MkCont{
 			Dst:     contReg,
 			Closure: fReg,
 			Tail:    tail,
-		})
+		}, c.varName(f.Target))
 	}
 	c.compilePushArgs(f.Args, contReg)
 	c.emitInstr(f, ir.Call{
@@ -464,6 +472,40 @@ func (c *compiler) compilePushArgs(args []ast.ExpNode, contReg ir.Register) {
 	c.ReleaseRegister(contReg)
 }
 
+// varName returns the name of the variable that the value of e is read from,
+// or a zero VarName if e is not a variable.  It is used to name operands in
+// runtime error messages.  It must be called after e has been compiled so that
+// the upvalues it refers to are already known.
+func (c *compiler) varName(e ast.ExpNode) code.VarName {
+	switch x := e.(type) {
+	case ast.Name:
+		if x.Val == "_ENV" {
+			// Naming _ENV would add an entry for each global variable access
+			// for little benefit.
+			break
+		}
+		reg, ok := c.GetRegister(ir.Name(x.Val))
+		switch {
+		case !ok:
+			return code.VarName{Kind: code.GlobalVar, Name: x.Val}
+		case c.IsUpvalueReg(reg):
+			return code.VarName{Kind: code.UpvalueVar, Name: x.Val}
+		default:
+			return code.VarName{Kind: code.LocalVar, Name: x.Val}
+		}
+	case ast.IndexExp:
+		key, ok := x.Idx.(ast.String)
+		if !ok {
+			break
+		}
+		if env, ok := x.Coll.(ast.Name); ok && env.Val == "_ENV" {
+			return code.VarName{Kind: code.GlobalVar, Name: string(key.Val)}
+		}
+		return code.VarName{Kind: code.FieldVar, Name: string(key.Val)}
+	}
+	return code.VarName{}
+}
+
 func globalVar(n ast.Name) ast.IndexExp {
 	return ast.IndexExp{
 		Location: n.Location,
@@ -27,11 +27,11 @@ func (c *assignCompiler) ProcessIndexExpVar(e ast.IndexExp) {
 	c.assigns = append(c.assigns, func(src ir.Register) {
 		c.ReleaseRegister(tReg)
 		c.ReleaseRegister(iReg)
-		c.emitInstr(e, ir.SetIndex{
+		c.emitInstrWithNames(e, ir.SetIndex{
 			Table: tReg,
 			Index: iReg,
 			Src:   src,
-		})
+		}, c.varName(e.Coll))
 	})
 }
 
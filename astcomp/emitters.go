@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/code"
 	"github.com/arnodel/golua/ir"
 )
 
@@ -14,6 +15,12 @@ func (c *compiler) emitInstr(l ast.Locator, instr ir.Instruction) {
 	c.CodeBuilder.Emit(instr, getLine(l))
 }
 
+func (c *compiler) emitInstrWithNames(l ast.Locator, instr ir.Instruction, names ...code.VarName) {
+	var opNames ir.OperandNames
+	copy(opNames[:], names)
+	c.CodeBuilder.EmitWithNames(instr, getLine(l), opNames)
+}
+
 func (c *compiler) emitJump(l ast.Locator, lbl ir.Name) {
 	if !c.CodeBuilder.EmitJump(lbl, getLine(l)) {
 		panic(Error{
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/coverage"
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/lib/base"
 	"github.com/arnodel/golua/lib/debuglib"
@@ -20,22 +21,38 @@ import (
 )
 
 type luaCmd struct {
-	disFlag        bool
-	astFlag        bool
+	disFlag        outputFlag
+	astFlag        outputFlag
 	unbufferedFlag bool
 	cpuLimit       uint64
 	memLimit       uint64
 	flags          string
 	exec           execFlags
+	dapFlag        bool
+	dapAddr        string
+	cpuProfile     string
+	profileTicks   uint64
+	coverProfile   string
+	coverMerge     bool
+	outFile        string
+	optLevel       int
 
 	complianceFlags rt.ComplianceFlags
 }
 
 func (c *luaCmd) setFlags() {
-	flag.BoolVar(&c.disFlag, "dis", false, "Disassemble source instead of running it")
-	flag.BoolVar(&c.astFlag, "ast", false, "Print AST instead of running code")
+	flag.Var(&c.disFlag, "dis", "Disassemble source instead of running it (-dis=json for JSON output)")
+	flag.Var(&c.astFlag, "ast", "Print AST instead of running code (-ast=json for JSON output)")
 	flag.BoolVar(&c.unbufferedFlag, "u", false, "Force unbuffered output")
 	flag.Var(&c.exec, "e", "statement to execute")
+	flag.BoolVar(&c.dapFlag, "dap", false, "Run a debug adapter (DAP) server on stdin / stdout")
+	flag.StringVar(&c.dapAddr, "dapaddr", "", "Run a debug adapter (DAP) server on this TCP address")
+	flag.StringVar(&c.cpuProfile, "cpuprofile", "", "Write a pprof profile of the Lua code to `file`")
+	flag.Uint64Var(&c.profileTicks, "cpuprofileticks", 0, "Sample the Lua profile every `n` instructions instead of every 10ms")
+	flag.StringVar(&c.coverProfile, "coverprofile", "", "Write Lua coverage to `file` (Cobertura XML if it ends in .xml, LCOV otherwise)")
+	flag.StringVar(&c.outFile, "o", "", "Write the precompiled chunk to `file` instead of running it")
+	flag.IntVar(&c.optLevel, "O", 0, "Optimization `level` used to compile Lua code (0 to 2)")
+	flag.BoolVar(&c.coverMerge, "covermerge", false, "Merge coverage with the existing content of the -coverprofile file")
 
 	if rt.QuotasAvailable {
 		flag.Uint64Var(&c.cpuLimit, "cpulimit", 0, "CPU limit")
@@ -54,6 +71,10 @@ func (c *luaCmd) run() (retcode int) {
 		repl      bool
 	)
 
+	if c.dapFlag || c.dapAddr != "" {
+		return c.serveDAP()
+	}
+
 	buffered := !isaTTY(os.Stdin) || flag.NArg() > 0
 	if c.unbufferedFlag {
 		buffered = false
@@ -71,7 +92,7 @@ func (c *luaCmd) run() (retcode int) {
 	}
 
 	// Get a Lua runtime
-	r := rt.New(nil)
+	r := rt.New(nil, rt.WithOptimizationLevel(c.optLevel))
 	c.pushContext(r)
 
 	cleanup := lib.LoadAll(r)
@@ -79,6 +100,45 @@ func (c *luaCmd) run() (retcode int) {
 
 	// Run finalizers before we exit
 	defer runtime.GC()
+	defer r.Close(r.MainThread())
+
+	if c.coverProfile != "" {
+		var merged *coverage.Profile
+		if c.coverMerge {
+			merged, err = coverage.ReadFile(c.coverProfile)
+			if os.IsNotExist(err) {
+				merged = coverage.NewProfile()
+			} else if err != nil {
+				return fatal("Could not read coverage profile: %s", err)
+			}
+		}
+		r.StartCoverage()
+		defer func() {
+			profile := r.StopCoverage()
+			if merged != nil {
+				profile.Merge(merged)
+			}
+			if err := profile.WriteFile(c.coverProfile); err != nil {
+				retcode = fatal("Could not write coverage profile: %s", err)
+			}
+		}()
+	}
+
+	if c.cpuProfile != "" {
+		f, err := os.Create(c.cpuProfile)
+		if err != nil {
+			return fatal("Could not create CPU profile: %s", err)
+		}
+		defer f.Close()
+		if err := r.StartCPUProfile(f, rt.CPUProfileOptions{Ticks: c.profileTicks}); err != nil {
+			return fatal("Could not start CPU profile: %s", err)
+		}
+		defer func() {
+			if err := r.StopCPUProfile(); err != nil {
+				retcode = fatal("Could not write CPU profile: %s", err)
+			}
+		}()
+	}
 
 	if len(c.exec) == 0 && flag.NArg() == 0 {
 		chunkName = "<stdin>"
@@ -128,25 +188,48 @@ func (c *luaCmd) run() (retcode int) {
 		}
 	}
 
-	if c.astFlag {
+	if c.astFlag != noOutput {
 		stat, _, err := r.ParseLuaChunk(chunkName, chunk)
 		if err != nil {
 			return fatal("Error parsing %s: %s", chunkName, err)
 		}
+		if c.astFlag == jsonOutput {
+			if err := ast.EncodeJSON(os.Stdout, stat); err != nil {
+				return fatal("Error writing AST: %s", err)
+			}
+			return 0
+		}
 		w := ast.NewIndentWriter(os.Stdout)
 		stat.HWrite(w)
 		return 0
 	}
 
-	if c.disFlag {
+	if c.disFlag != noOutput {
 		unit, _, err := r.CompileLuaChunk(chunkName, chunk)
 		if err != nil {
 			return fatal("Error parsing %s: %s", chunkName, err)
 		}
+		if c.disFlag == jsonOutput {
+			if err := unit.EncodeJSON(os.Stdout); err != nil {
+				return fatal("Error writing disassembly: %s", err)
+			}
+			return 0
+		}
 		unit.Disassemble(os.Stdout)
 		return 0
 	}
 
+	if c.outFile != "" {
+		var w bytes.Buffer
+		if err := r.Precompile(&w, chunkName, chunk); err != nil {
+			return fatal("Error compiling %s: %s", chunkName, err)
+		}
+		if err := ioutil.WriteFile(c.outFile, w.Bytes(), 0666); err != nil {
+			return fatal("Error writing %s: %s", c.outFile, err)
+		}
+		return 0
+	}
+
 	defer func() {
 		if rec := recover(); rec != nil {
 			quotaExceeded, ok := rec.(rt.ContextTerminationError)
@@ -259,6 +342,39 @@ func (c *luaCmd) pushContext(r *rt.Runtime) {
 	})
 }
 
+// An outputFlag is a boolean flag which can also be set to "json" to ask for
+// JSON output rather than text.
+type outputFlag string
+
+const (
+	noOutput   outputFlag = ""
+	textOutput outputFlag = "text"
+	jsonOutput outputFlag = "json"
+)
+
+func (o *outputFlag) String() string {
+	return string(*o)
+}
+
+func (o *outputFlag) Set(value string) error {
+	switch value {
+	case "false":
+		*o = noOutput
+	case "true", "text":
+		*o = textOutput
+	case "json":
+		*o = jsonOutput
+	default:
+		return fmt.Errorf("invalid value %q (expected true, false, text or json)", value)
+	}
+	return nil
+}
+
+// IsBoolFlag allows the flag to be given without a value.
+func (o *outputFlag) IsBoolFlag() bool {
+	return true
+}
+
 type execFlags []string
 
 func (e *execFlags) String() string {
@@ -20,6 +20,8 @@ type Code struct {
 	CellCount              int16    // Number of cell registers needed to run the code
 	RegCount               int16    // Number of registers needed to run the coee
 	UpNames                []string // Names of the upvalues
+	LineDefined            int32    // Line where the function definition starts (0 for the main chunk)
+	LastLineDefined        int32    // Line where the function definition ends
 }
 
 var _ Constant = Code{}
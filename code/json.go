@@ -0,0 +1,286 @@
+package code
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// EncodeJSON writes a JSON representation of the unit to w, for use by tools.
+// It is an object with the following fields.
+//
+//   - "source": the source of the unit.
+//   - "constants": the constant pool.  Each constant is an object with a
+//     "type" field ("int", "float", "string", "bool", "nil" or "function") and
+//     a "value" field, except functions which have fields describing the code
+//     (name, range of opcodes, register counts...).
+//   - "lines": the line table, i.e. the source line of each opcode.
+//   - "code": the opcodes.  Each opcode is an object with its "offset", its
+//     "line", its "label" if it is the target of a jump, its "opcode" as an
+//     hexadecimal string, its disassembly as "text", and decoded as an "op"
+//     name with a list of "operands".  Operands are objects with a "type"
+//     ("reg", "int", "string", "bool", "const" for an index into the constant
+//     pool or "offset" for a jump target) and a "value".  Instructions which
+//     push their result to the continuation in their first operand rather than
+//     setting a register have "push" set to true.
+//
+// Floats which are not finite are given as the strings "inf", "-inf" or "nan".
+func (u *Unit) EncodeJSON(w io.Writer) error {
+	d := newUnitDisassembler(u)
+	constants := make([]interface{}, len(u.Constants))
+	for i, k := range u.Constants {
+		constants[i] = jsonConstant(k)
+	}
+	texts := make([]string, len(u.Code))
+	for i, opcode := range u.Code {
+		texts[i] = opcode.Disassemble(d, i)
+	}
+	opcodes := make([]interface{}, len(u.Code))
+	for i, opcode := range u.Code {
+		o := jsonObject{{"offset", i}}
+		if i < len(u.Lines) {
+			o = append(o, jsonField{"line", u.Lines[i]})
+		}
+		if lbl, ok := d.labels[i]; ok {
+			o = append(o, jsonField{"label", lbl})
+		}
+		op, push, operands := opcode.decode(i)
+		o = append(o,
+			jsonField{"opcode", fmt.Sprintf("%08x", uint32(opcode))},
+			jsonField{"op", op})
+		if push {
+			o = append(o, jsonField{"push", true})
+		}
+		o = append(o,
+			jsonField{"operands", operands},
+			jsonField{"text", texts[i]})
+		opcodes[i] = o
+	}
+	lines := u.Lines
+	if lines == nil {
+		lines = []int32{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonObject{
+		{"source", u.Source},
+		{"constants", constants},
+		{"lines", lines},
+		{"code", opcodes},
+	})
+}
+
+// A jsonObject is a JSON object whose fields are output in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func jsonConstant(k Constant) jsonObject {
+	switch k := k.(type) {
+	case Int:
+		return jsonObject{{"type", "int"}, {"value", int64(k)}}
+	case Float:
+		var val interface{} = float64(k)
+		switch f := float64(k); {
+		case math.IsInf(f, 1):
+			val = "inf"
+		case math.IsInf(f, -1):
+			val = "-inf"
+		case math.IsNaN(f):
+			val = "nan"
+		}
+		return jsonObject{{"type", "float"}, {"value", val}}
+	case String:
+		return jsonObject{{"type", "string"}, {"value", string(k)}}
+	case Bool:
+		return jsonObject{{"type", "bool"}, {"value", bool(k)}}
+	case NilType:
+		return jsonObject{{"type", "nil"}, {"value", nil}}
+	case Code:
+		upNames := k.UpNames
+		if upNames == nil {
+			upNames = []string{}
+		}
+		return jsonObject{
+			{"type", "function"},
+			{"name", k.Name},
+			{"startOffset", k.StartOffset},
+			{"endOffset", k.EndOffset},
+			{"upvalueCount", k.UpvalueCount},
+			{"cellCount", k.CellCount},
+			{"regCount", k.RegCount},
+			{"upNames", upNames},
+			{"lineDefined", k.LineDefined},
+			{"lastLineDefined", k.LastLineDefined},
+		}
+	default:
+		return jsonObject{{"type", "unknown"}, {"value", k.ShortString()}}
+	}
+}
+
+var binOpNames = [...]string{
+	OpAdd:      "add",
+	OpSub:      "sub",
+	OpMul:      "mul",
+	OpDiv:      "div",
+	OpFloorDiv: "floordiv",
+	OpMod:      "mod",
+	OpPow:      "pow",
+	OpBitAnd:   "band",
+	OpBitOr:    "bor",
+	OpBitXor:   "bxor",
+	OpShiftL:   "shl",
+	OpShiftR:   "shr",
+	OpEq:       "eq",
+	OpLt:       "lt",
+	OpLeq:      "le",
+	OpConcat:   "concat",
+}
+
+var unOpNames = [...]string{
+	OpNeg:      "neg",
+	OpBitNot:   "bnot",
+	OpLen:      "len",
+	OpCont:     "cont",
+	OpTailCont: "tailcont",
+	OpId:       "move",
+	OpTruth:    "truth",
+	OpNot:      "not",
+	OpUpvalue:  "upval",
+	OpEtcId:    "etcmove",
+}
+
+func regOperand(r Reg) jsonObject {
+	return jsonObject{{"type", "reg"}, {"value", r.String()}}
+}
+
+func operand(tp string, val interface{}) jsonObject {
+	return jsonObject{{"type", tp}, {"value", val}}
+}
+
+// Returns the name of the operation performed by the opcode at offset i, true
+// if it pushes its result to a continuation and its operands.
+func (c Opcode) decode(i int) (op string, push bool, operands []jsonObject) {
+	if c.HasType1() {
+		op = "???"
+		if x := c.GetX(); int(x) < len(binOpNames) {
+			op = binOpNames[x]
+		}
+		return op, false, []jsonObject{regOperand(c.GetA()), regOperand(c.GetB()), regOperand(c.GetC())}
+	}
+	rA := c.GetA()
+	f := c.GetF()
+	switch c.TypePfx() {
+	case Type0Pfx:
+		if f {
+			return "recvetc", false, []jsonObject{regOperand(rA)}
+		}
+		return "recv", false, []jsonObject{regOperand(rA)}
+	case Type2Pfx:
+		if f {
+			return "setindex", false, []jsonObject{regOperand(c.GetB()), regOperand(c.GetC()), regOperand(rA)}
+		}
+		return "lookup", false, []jsonObject{regOperand(rA), regOperand(c.GetB()), regOperand(c.GetC())}
+	case Type3Pfx:
+		n := c.GetN()
+		operands = []jsonObject{regOperand(rA)}
+		switch c.GetY() {
+		case OpInt16:
+			return "loadint", f, append(operands, operand("int", n.ToInt16()))
+		case OpStr2:
+			return "loadstr", f, append(operands, operand("string", string(n.ToStr2())))
+		case OpK:
+			return "loadk", f, append(operands, operand("const", n))
+		case OpClosureK:
+			return "closure", f, append(operands, operand("const", n))
+		}
+	case Type4Pfx:
+		if c.HasType4a() {
+			op = "???"
+			if x := c.GetUnOp(); int(x) < len(unOpNames) {
+				op = unOpNames[x]
+			}
+			return op, f && c.GetUnOp() != OpUpvalue, []jsonObject{regOperand(rA), regOperand(c.GetB())}
+		}
+		operands = []jsonObject{regOperand(rA)}
+		switch c.GetUnOpK() {
+		case OpNil:
+			return "loadnil", f, operands
+		case OpStr0:
+			return "loadstr", f, append(operands, operand("string", ""))
+		case OpTable:
+			return "newtable", f, operands
+		case OpStr1:
+			return "loadstr", f, append(operands, operand("string", string(c.GetL().ToStr1())))
+		case OpBool:
+			return "loadbool", f, append(operands, operand("bool", c.GetL().ToBool()))
+		case OpCC:
+			return "loadcc", f, operands
+		case OpClear:
+			return "clear", false, operands
+		}
+	case Type5Pfx:
+		switch c.GetJ() {
+		case OpJump:
+			return "jump", false, []jsonObject{operand("offset", i+int(c.GetOffset()))}
+		case OpJumpIf:
+			op = "jumpif"
+			if !f {
+				op = "jumpifnot"
+			}
+			return op, false, []jsonObject{regOperand(rA), operand("offset", i+int(c.GetOffset()))}
+		case OpCall:
+			if f {
+				return "tailcall", false, []jsonObject{regOperand(rA)}
+			}
+			return "call", false, []jsonObject{regOperand(rA)}
+		case OpClStack:
+			if f {
+				return "clpush", false, []jsonObject{regOperand(rA)}
+			}
+			return "cltrunc", false, []jsonObject{operand("int", c.GetClStackOffset())}
+		}
+	case Type6Pfx:
+		m := operand("int", c.GetM())
+		if f {
+			return "fill", false, []jsonObject{regOperand(rA), m, regOperand(c.GetB())}
+		}
+		return "etclookup", false, []jsonObject{regOperand(rA), regOperand(c.GetB()), m}
+	case Type7Pfx:
+		op = "prepfor"
+		if f {
+			op = "advfor"
+		}
+		return op, false, []jsonObject{regOperand(rA), regOperand(c.GetB()), regOperand(c.GetC())}
+	}
+	return "???", false, []jsonObject{}
+}
@@ -0,0 +1,79 @@
+package code_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/arnodel/golua/code"
+)
+
+func TestUnit_EncodeJSON(t *testing.T) {
+	r0, r1, r2 := code.ValueReg(0), code.ValueReg(1), code.ValueReg(2)
+	unit := &code.Unit{
+		Source: "test",
+		Code: []code.Opcode{
+			code.Receive(r1),
+			code.LoadConst(r2, 0),
+			code.Combine(code.OpAdd, r1, r1, r2),
+			code.JumpIfNot(-1, r1),
+			code.Push(r0, r1),
+			code.TailCall(r0),
+		},
+		Lines: []int32{1, 2, 2, 2, 3, 3},
+		Constants: []code.Constant{
+			code.Float(1.5),
+			code.Code{Name: "f", EndOffset: 6, RegCount: 3},
+		},
+	}
+	var buf bytes.Buffer
+	if err := unit.EncodeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Source    string
+		Constants []map[string]interface{}
+		Lines     []int
+		Code      []struct {
+			Offset   int
+			Line     int
+			Label    string
+			Op       string
+			Push     bool
+			Operands []struct {
+				Type  string
+				Value interface{}
+			}
+			Text string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %s\n%s", err, buf.Bytes())
+	}
+	if got.Source != "test" || !reflect.DeepEqual(got.Lines, []int{1, 2, 2, 2, 3, 3}) {
+		t.Errorf("wrong source or lines: %s", buf.Bytes())
+	}
+	if len(got.Constants) != 2 || got.Constants[0]["type"] != "float" || got.Constants[0]["value"] != 1.5 ||
+		got.Constants[1]["type"] != "function" || got.Constants[1]["name"] != "f" || got.Constants[1]["regCount"] != 3.0 {
+		t.Errorf("wrong constants: %v", got.Constants)
+	}
+	wantOps := []string{"recv", "loadk", "add", "jumpifnot", "move", "tailcall"}
+	if len(got.Code) != len(wantOps) {
+		t.Fatalf("got %d opcodes", len(got.Code))
+	}
+	for i, op := range wantOps {
+		if got.Code[i].Op != op || got.Code[i].Offset != i {
+			t.Errorf("opcode %d: got %s at %d, want %s", i, got.Code[i].Op, got.Code[i].Offset, op)
+		}
+	}
+	if c := got.Code[2]; c.Label != "L0" || c.Text != "r1 <- r1 + r2" || len(c.Operands) != 3 || c.Operands[1].Type != "reg" || c.Operands[1].Value != "r1" {
+		t.Errorf("wrong add opcode: %+v", c)
+	}
+	if c := got.Code[3]; len(c.Operands) != 2 || c.Operands[1].Type != "offset" || c.Operands[1].Value != 2.0 {
+		t.Errorf("wrong jump opcode: %+v", c)
+	}
+	if c := got.Code[4]; !c.Push {
+		t.Errorf("push not flagged: %+v", c)
+	}
+}
@@ -11,6 +11,14 @@ type Unit struct {
 	Code      []Opcode   // The code
 	Lines     []int32    // Optional: source code line for the corresponding opcode
 	Constants []Constant // All the constants required for running the code
+
+	// Optional: names of the variables operands were read from, sorted by
+	// offset.  Used to improve runtime error messages.
+	OperandNames []OperandNames
+
+	// Optional: local variables, sorted by start offset (so in the order they
+	// are declared in).  Used for debugging.
+	Locals []Local
 }
 
 // Disassemble outputs the disassembly of the unit code into the given
@@ -68,12 +76,11 @@ func (d *unitDisassembler) GetLabel(offset int) string {
 
 func (d *unitDisassembler) setSpan(name string, startOffset, endOffset int) {
 	d.spans[startOffset] = name
-	for {
+	for startOffset < endOffset {
 		startOffset++
 		d.spans[startOffset] = " |"
 		if startOffset == endOffset {
 			d.spans[endOffset] = `  \`
-			return
 		}
 	}
 }
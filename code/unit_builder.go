@@ -12,14 +12,18 @@ type Builder struct {
 	jumpTo    map[Label]int   // destination locations for the labels
 	jumpFrom  map[Label][]int // lists of locations for opcode that jump to a given label
 	constants []Constant      // constants required for the code
+	names     []OperandNames  // names of operands, sorted by offset
+	locals    []Local         // local variables, sorted by start offset
+	openLocal map[Reg]int     // index in locals of the variable held in a register
 }
 
 // NewBuilder returns an empty Builder for the given source.
 func NewBuilder(source string) *Builder {
 	return &Builder{
-		source:   source,
-		jumpTo:   make(map[Label]int),
-		jumpFrom: make(map[Label][]int),
+		source:    source,
+		jumpTo:    make(map[Label]int),
+		jumpFrom:  make(map[Label][]int),
+		openLocal: make(map[Reg]int),
 	}
 }
 
@@ -29,6 +33,41 @@ func (c *Builder) Emit(opcode Opcode, line int) {
 	c.lines = append(c.lines, int32(line))
 }
 
+// NameOperands records the names of the variables that the B and C operands of
+// the last emitted opcode were read from.
+func (c *Builder) NameOperands(b, cc VarName) {
+	if b.IsZero() && cc.IsZero() {
+		return
+	}
+	c.names = append(c.names, OperandNames{Offset: len(c.code) - 1, B: b, C: cc})
+}
+
+// StartLocal records that the local variable name is held in register reg from
+// the current location.
+func (c *Builder) StartLocal(name string, reg Reg) {
+	c.EndLocal(reg)
+	c.openLocal[reg] = len(c.locals)
+	c.locals = append(c.locals, Local{Name: name, Reg: reg, StartOffset: len(c.code), EndOffset: -1})
+}
+
+// EndLocal records that the local variable held in register reg goes out of
+// scope at the current location.  It does nothing if no variable is held in
+// reg.
+func (c *Builder) EndLocal(reg Reg) {
+	if i, ok := c.openLocal[reg]; ok {
+		c.locals[i].EndOffset = len(c.code)
+		delete(c.openLocal, reg)
+	}
+}
+
+// EndAllLocals makes all local variables go out of scope at the current
+// location.  It should be called at the end of each function.
+func (c *Builder) EndAllLocals() {
+	for reg := range c.openLocal {
+		c.EndLocal(reg)
+	}
+}
+
 // EmitJump adds a jump opcode, jumping to the given label.  The offset part of
 // the opcode must be left as 0, it will be filled by the builder when the
 // location of the label is known.
@@ -75,9 +114,11 @@ func (c *Builder) AddConstant(k Constant) {
 // GetUnit returns the build code Unit.
 func (c *Builder) GetUnit() *Unit {
 	return &Unit{
-		Source:    c.source,
-		Code:      c.code,
-		Lines:     c.lines,
-		Constants: c.constants,
+		Source:       c.source,
+		Code:         c.code,
+		Lines:        c.lines,
+		Constants:    c.constants,
+		OperandNames: c.names,
+		Locals:       c.locals,
 	}
 }
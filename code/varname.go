@@ -0,0 +1,65 @@
+package code
+
+import "fmt"
+
+// VarKind is the kind of variable a value was read from.
+type VarKind uint8
+
+// Kinds of variables that can be named in runtime error messages.
+const (
+	NoVar VarKind = iota
+	GlobalVar
+	LocalVar
+	UpvalueVar
+	FieldVar
+	MethodVar
+)
+
+var varKindNames = [...]string{
+	NoVar:      "?",
+	GlobalVar:  "global",
+	LocalVar:   "local",
+	UpvalueVar: "upvalue",
+	FieldVar:   "field",
+	MethodVar:  "method",
+}
+
+func (k VarKind) String() string {
+	if int(k) >= len(varKindNames) {
+		return "?"
+	}
+	return varKindNames[k]
+}
+
+// A VarName describes the variable that a value was read from, so that runtime
+// errors can refer to it (e.g. "global 'x'").
+type VarName struct {
+	Kind VarKind
+	Name string
+}
+
+// IsZero returns true if no variable is described.
+func (n VarName) IsZero() bool {
+	return n.Kind == NoVar
+}
+
+func (n VarName) String() string {
+	return fmt.Sprintf("%s '%s'", n.Kind, n.Name)
+}
+
+// OperandNames records the variables that the B and C operands of the opcode at
+// Offset were read from.  Either can be zero if it is unknown.
+type OperandNames struct {
+	Offset int
+	B, C   VarName
+}
+
+// A Local records where a local variable is held and in which part of the code
+// it is in scope.  The variable is in scope for offsets in the range
+// [StartOffset, EndOffset).
+type Local struct {
+	Name        string
+	Reg         Reg
+	StartOffset int
+	EndOffset   int
+}
@@ -0,0 +1,176 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// The Cobertura XML format, as described by
+// http://cobertura.sourceforge.net/xml/coverage-04.dtd.  Source files are
+// classes and they are grouped in packages by directory.
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        float64            `xml:"line-rate,attr"`
+	BranchRate      float64            `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      float64            `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   float64          `xml:"line-rate,attr"`
+	BranchRate float64          `xml:"branch-rate,attr"`
+	Complexity float64          `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   float64         `xml:"line-rate,attr"`
+	BranchRate float64         `xml:"branch-rate,attr"`
+	Complexity float64         `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              uint64 `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+const coberturaDoctype = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+// WriteCobertura writes the profile as a Cobertura XML report, which is
+// understood by many CI systems.
+func (p *Profile) WriteCobertura(w io.Writer) error {
+	report := coberturaCoverage{
+		Version:   "golua",
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Sources:   []string{"."},
+	}
+	var stats, pkgStats coverageStats
+	var pkg *coberturaPackage
+	endPackage := func() {
+		if pkg != nil {
+			pkg.LineRate, pkg.BranchRate = pkgStats.rates()
+			report.Packages = append(report.Packages, *pkg)
+		}
+	}
+	files := p.Files()
+	sort.SliceStable(files, func(i, j int) bool {
+		return path.Dir(files[i].Name) < path.Dir(files[j].Name)
+	})
+	for _, f := range files {
+		dir := path.Dir(f.Name)
+		if pkg == nil || pkg.Name != dir {
+			endPackage()
+			pkg = &coberturaPackage{Name: dir}
+			pkgStats = coverageStats{}
+		}
+		var fileStats coverageStats
+		fileStats.add(f)
+		pkgStats.add(f)
+		stats.add(f)
+		class := coberturaClass{Name: f.Name, Filename: f.Name}
+		class.LineRate, class.BranchRate = fileStats.rates()
+		for _, n := range f.SortedLines() {
+			line := coberturaLine{Number: n, Hits: f.Lines[n]}
+			if found, hit := f.lineBranchStats(n); found > 0 {
+				line.Branch = true
+				line.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", hit*100/found, hit, found)
+			}
+			class.Lines = append(class.Lines, line)
+		}
+		pkg.Classes = append(pkg.Classes, class)
+	}
+	endPackage()
+	report.LineRate, report.BranchRate = stats.rates()
+	report.LinesValid, report.LinesCovered = stats.lines, stats.linesHit
+	report.BranchesValid, report.BranchesCovered = stats.branches, stats.branchesHit
+
+	if _, err := io.WriteString(w, xml.Header+coberturaDoctype+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ReadCobertura reads a profile from a Cobertura XML report.  As the report
+// only says how many branches on a line were taken, the branch counts are
+// approximate: each branch is counted as taken at most once.
+func ReadCobertura(r io.Reader) (*Profile, error) {
+	var report coberturaCoverage
+	dec := xml.NewDecoder(r)
+	if err := dec.Decode(&report); err != nil {
+		return nil, err
+	}
+	p := NewProfile()
+	for _, pkg := range report.Packages {
+		for _, class := range pkg.Classes {
+			f := p.File(class.Filename)
+			for _, line := range class.Lines {
+				f.AddLine(line.Number, line.Hits)
+				if !line.Branch || line.ConditionCoverage == "" {
+					continue
+				}
+				var pct, hit, found int
+				if _, err := fmt.Sscanf(line.ConditionCoverage, "%d%% (%d/%d)", &pct, &hit, &found); err != nil {
+					return nil, fmt.Errorf("line %d of %s: invalid condition coverage %q", line.Number, class.Filename, line.ConditionCoverage)
+				}
+				if found < 0 || hit < 0 || hit > found || found > 2*maxBlocksPerLine {
+					return nil, fmt.Errorf("line %d of %s: invalid condition coverage %q", line.Number, class.Filename, line.ConditionCoverage)
+				}
+				counts := make([]uint64, found)
+				for i := 0; i < hit; i++ {
+					counts[i] = 1
+				}
+				f.AddBranches(line.Number, counts)
+			}
+		}
+	}
+	return p, nil
+}
+
+type coverageStats struct {
+	lines, linesHit, branches, branchesHit int
+}
+
+func (s *coverageStats) add(f *File) {
+	found, hit := f.LineStats()
+	s.lines += found
+	s.linesHit += hit
+	found, hit = f.BranchStats()
+	s.branches += found
+	s.branchesHit += hit
+}
+
+func (s *coverageStats) rates() (lineRate, branchRate float64) {
+	return rate(s.linesHit, s.lines), rate(s.branchesHit, s.branches)
+}
+
+func rate(hit, found int) float64 {
+	if found == 0 {
+		return 1
+	}
+	return float64(hit) / float64(found)
+}
@@ -0,0 +1,199 @@
+// Package coverage holds line and branch coverage data for Lua source files
+// (see runtime.StartCoverage) and reads and writes it in the LCOV tracefile
+// format and in the Cobertura XML format.
+//
+// Profiles can be merged, so that the coverage of several runs of a program
+// (or of several programs) can be reported together.
+package coverage
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	errNoFile        = errors.New("record outside of a file section")
+	errInvalidRecord = errors.New("invalid record")
+)
+
+// This bounds the memory used when reading a profile.
+const maxBlocksPerLine = 1 << 12
+
+// A Profile contains coverage data for a set of source files.
+type Profile struct {
+	files map[string]*File
+}
+
+// NewProfile returns an empty profile.
+func NewProfile() *Profile {
+	return &Profile{files: map[string]*File{}}
+}
+
+// File returns the coverage data for the named file, adding it to the profile
+// if needed.
+func (p *Profile) File(name string) *File {
+	f := p.files[name]
+	if f == nil {
+		f = &File{
+			Name:     name,
+			Lines:    map[int]uint64{},
+			Branches: map[int][]uint64{},
+		}
+		p.files[name] = f
+	}
+	return f
+}
+
+// Files returns the coverage data for all the files in the profile, sorted by
+// name.
+func (p *Profile) Files() []*File {
+	files := make([]*File, 0, len(p.files))
+	for _, f := range p.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files
+}
+
+// Merge adds the counts in q to p, file by file.
+func (p *Profile) Merge(q *Profile) {
+	for _, qf := range q.files {
+		p.File(qf.Name).Merge(qf)
+	}
+}
+
+// ReadFile reads a profile from a file, which should contain a Cobertura report
+// if its name ends in ".xml" and LCOV data otherwise.
+func ReadFile(name string) (*Profile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if isCoberturaFile(name) {
+		return ReadCobertura(f)
+	}
+	return ReadLCOV(f)
+}
+
+// WriteFile writes the profile to a file, as a Cobertura report if its name
+// ends in ".xml" and as LCOV data otherwise.
+func (p *Profile) WriteFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if isCoberturaFile(name) {
+		err = p.WriteCobertura(f)
+	} else {
+		err = p.WriteLCOV(f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func isCoberturaFile(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".xml")
+}
+
+// A File contains the coverage data of a source file.
+type File struct {
+	Name string
+
+	// Lines maps line numbers that contain code to the number of times they
+	// were executed.
+	Lines map[int]uint64
+
+	// Branches maps line numbers to the outcomes of the conditional jumps on
+	// that line, in code order.  There are two counts per jump: the number of
+	// times it was taken followed by the number of times it was not taken.
+	Branches map[int][]uint64
+}
+
+// AddLine adds count to the execution count of a line.
+func (f *File) AddLine(line int, count uint64) {
+	f.Lines[line] += count
+}
+
+// AddBranches adds counts element-wise to the branch counts of a line.
+func (f *File) AddBranches(line int, counts []uint64) {
+	current := f.Branches[line]
+	for len(current) < len(counts) {
+		current = append(current, 0)
+	}
+	for i, n := range counts {
+		current[i] += n
+	}
+	f.Branches[line] = current
+}
+
+// Merge adds the counts of g to f.
+func (f *File) Merge(g *File) {
+	for line, n := range g.Lines {
+		f.AddLine(line, n)
+	}
+	for line, counts := range g.Branches {
+		f.AddBranches(line, counts)
+	}
+}
+
+// SortedLines returns the lines that contain code in increasing order.
+func (f *File) SortedLines() []int {
+	lines := make([]int, 0, len(f.Lines))
+	for line := range f.Lines {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// LineStats returns the number of lines that contain code and the number of
+// those which were executed.
+func (f *File) LineStats() (found, hit int) {
+	for _, n := range f.Lines {
+		found++
+		if n > 0 {
+			hit++
+		}
+	}
+	return
+}
+
+// BranchStats returns the number of branches and the number of those which
+// were taken.
+func (f *File) BranchStats() (found, hit int) {
+	for _, counts := range f.Branches {
+		for _, n := range counts {
+			found++
+			if n > 0 {
+				hit++
+			}
+		}
+	}
+	return
+}
+
+func (f *File) lineBranchStats(line int) (found, hit int) {
+	for _, n := range f.Branches[line] {
+		found++
+		if n > 0 {
+			hit++
+		}
+	}
+	return
+}
+
+func (f *File) sortedBranchLines() []int {
+	lines := make([]int, 0, len(f.Branches))
+	for line := range f.Branches {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
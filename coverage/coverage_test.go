@@ -0,0 +1,157 @@
+package coverage_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/coverage"
+)
+
+func testProfile() *coverage.Profile {
+	p := coverage.NewProfile()
+	f := p.File("lib/a.lua")
+	f.AddLine(1, 1)
+	f.AddLine(2, 3)
+	f.AddLine(3, 0)
+	f.AddBranches(2, []uint64{3, 0})
+	g := p.File("main.lua")
+	g.AddLine(1, 1)
+	g.AddLine(5, 0)
+	g.AddBranches(5, []uint64{0, 0, 0, 0})
+	return p
+}
+
+const testLCOV = `TN:
+SF:lib/a.lua
+BRDA:2,0,0,3
+BRDA:2,0,1,0
+BRF:2
+BRH:1
+DA:1,1
+DA:2,3
+DA:3,0
+LF:3
+LH:2
+end_of_record
+TN:
+SF:main.lua
+BRDA:5,0,0,-
+BRDA:5,0,1,-
+BRDA:5,1,0,-
+BRDA:5,1,1,-
+BRF:4
+BRH:0
+DA:1,1
+DA:5,0
+LF:2
+LH:1
+end_of_record
+`
+
+func TestWriteLCOV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile().WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != testLCOV {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestReadLCOV(t *testing.T) {
+	p, err := coverage.ReadLCOV(strings.NewReader(testLCOV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSameProfiles(t, p, testProfile())
+
+	for _, bad := range []string{"DA:1,1\n", "SF:x\nDA:1\n", "SF:x\nBRDA:1,0,2,1\n", "SF:x\nBRDA:1,a,0,1\n"} {
+		if _, err := coverage.ReadLCOV(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error reading %q", bad)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	p := testProfile()
+	q := coverage.NewProfile()
+	q.File("main.lua").AddLine(5, 2)
+	q.File("main.lua").AddBranches(5, []uint64{1, 1})
+	q.File("b.lua").AddLine(1, 0)
+	p.Merge(q)
+	var names []string
+	for _, f := range p.Files() {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, " ") != "b.lua lib/a.lua main.lua" {
+		t.Fatalf("unexpected files: %q", names)
+	}
+	f := p.File("main.lua")
+	if f.Lines[5] != 2 || !reflect.DeepEqual(f.Branches[5], []uint64{1, 1, 0, 0}) {
+		t.Fatalf("unexpected merged counts: %v %v", f.Lines, f.Branches)
+	}
+	if found, hit := f.BranchStats(); found != 4 || hit != 2 {
+		t.Fatalf("unexpected branch stats: %d %d", found, hit)
+	}
+}
+
+func TestCobertura(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testProfile().WriteCobertura(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, expected := range []string{
+		`<coverage line-rate="0.6" branch-rate="0.16666666666666666" lines-covered="3" lines-valid="5" branches-covered="1" branches-valid="6"`,
+		`<package name="lib" line-rate="0.6666666666666666" branch-rate="0.5" complexity="0">`,
+		`<class name="lib/a.lua" filename="lib/a.lua" line-rate="0.6666666666666666" branch-rate="0.5" complexity="0">`,
+		`<line number="2" hits="3" branch="true" condition-coverage="50% (1/2)"></line>`,
+		`<line number="5" hits="0" branch="true" condition-coverage="0% (0/4)"></line>`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected output to contain %s", expected)
+		}
+	}
+	p, err := coverage.ReadCobertura(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Branch counts are not preserved, only whether they were taken.
+	expected := testProfile()
+	expected.File("lib/a.lua").Branches[2] = []uint64{1, 0}
+	checkSameProfiles(t, p, expected)
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cov.lcov", "cov.xml"} {
+		path := filepath.Join(dir, name)
+		if err := testProfile().WriteFile(path); err != nil {
+			t.Fatal(err)
+		}
+		p, err := coverage.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Files()) != 2 {
+			t.Fatalf("%s: expected 2 files", name)
+		}
+	}
+}
+
+func checkSameProfiles(t *testing.T, p, q *coverage.Profile) {
+	t.Helper()
+	pf, qf := p.Files(), q.Files()
+	if len(pf) != len(qf) {
+		t.Fatalf("expected %d files, got %d", len(qf), len(pf))
+	}
+	for i, f := range pf {
+		g := qf[i]
+		if f.Name != g.Name || !reflect.DeepEqual(f.Lines, g.Lines) || !reflect.DeepEqual(f.Branches, g.Branches) {
+			t.Fatalf("expected %+v, got %+v", g, f)
+		}
+	}
+}
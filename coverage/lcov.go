@@ -0,0 +1,133 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteLCOV writes the profile in the LCOV tracefile format (as used by
+// geninfo / genhtml and understood by most coverage services).
+//
+// Each conditional jump is a block with two branches: 0 is the jump being
+// taken and 1 the jump not being taken.  Branches of jumps that were never
+// reached are reported as "-".
+func (p *Profile) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range p.Files() {
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+		for _, line := range f.sortedBranchLines() {
+			counts := f.Branches[line]
+			for i := 0; i+1 < len(counts); i += 2 {
+				taken, notTaken := counts[i], counts[i+1]
+				if taken+notTaken == 0 {
+					fmt.Fprintf(bw, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", line, i/2, line, i/2)
+				} else {
+					fmt.Fprintf(bw, "BRDA:%d,%d,0,%d\nBRDA:%d,%d,1,%d\n", line, i/2, taken, line, i/2, notTaken)
+				}
+			}
+		}
+		found, hit := f.BranchStats()
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", found, hit)
+		for _, line := range f.SortedLines() {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		found, hit = f.LineStats()
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+	}
+	return bw.Flush()
+}
+
+// ReadLCOV reads a profile in the LCOV tracefile format.  Only line (DA) and
+// branch (BRDA) records are taken into account, and records for the same file
+// are merged.
+func ReadLCOV(r io.Reader) (*Profile, error) {
+	p := NewProfile()
+	var f *File
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		record := strings.TrimSpace(scanner.Text())
+		tag, data := record, ""
+		if i := strings.IndexByte(record, ':'); i >= 0 {
+			tag, data = record[:i], record[i+1:]
+		}
+		var err error
+		switch tag {
+		case "SF":
+			f = p.File(data)
+		case "end_of_record":
+			f = nil
+		case "DA":
+			err = readDA(f, data)
+		case "BRDA":
+			err = readBRDA(f, data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reads "<line>,<count>[,<checksum>]".
+func readDA(f *File, data string) error {
+	if f == nil {
+		return errNoFile
+	}
+	fields := strings.Split(data, ",")
+	if len(fields) < 2 {
+		return errInvalidRecord
+	}
+	line, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return errInvalidRecord
+	}
+	count, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return errInvalidRecord
+	}
+	f.AddLine(line, count)
+	return nil
+}
+
+// Reads "<line>,<block>,<branch>,<taken>", where taken may be "-".
+func readBRDA(f *File, data string) error {
+	if f == nil {
+		return errNoFile
+	}
+	fields := strings.Split(data, ",")
+	if len(fields) != 4 {
+		return errInvalidRecord
+	}
+	var nums [3]int
+	for i := range nums {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || n < 0 {
+			return errInvalidRecord
+		}
+		nums[i] = n
+	}
+	var taken uint64
+	if fields[3] != "-" {
+		var err error
+		taken, err = strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return errInvalidRecord
+		}
+	}
+	line, block, branch := nums[0], nums[1], nums[2]
+	if branch > 1 || block >= maxBlocksPerLine {
+		return errInvalidRecord
+	}
+	counts := make([]uint64, 2*block+2)
+	counts[2*block+branch] = taken
+	f.AddBranches(line, counts)
+	return nil
+}
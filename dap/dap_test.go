@@ -0,0 +1,354 @@
+package dap_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/dap"
+)
+
+// A testClient talks to a dap.Session over an in-memory connection.
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	seq    int
+	msgs   chan *testMessage
+	output strings.Builder
+	done   chan error
+}
+
+type testMessage struct {
+	Type    string          `json:"type"`
+	Command string          `json:"command"`
+	Event   string          `json:"event"`
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientConn, serverConn := net.Pipe()
+	c := &testClient{
+		t:    t,
+		conn: clientConn,
+		msgs: make(chan *testMessage, 100),
+		done: make(chan error, 1),
+	}
+	go func() {
+		c.done <- dap.Serve(serverConn)
+		serverConn.Close()
+	}()
+	go c.readMessages()
+	return c
+}
+
+func (c *testClient) readMessages() {
+	defer close(c.msgs)
+	r := textproto.NewReader(bufio.NewReader(c.conn))
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			return
+		}
+		msg := new(testMessage)
+		if err := json.Unmarshal(body, msg); err != nil {
+			c.t.Errorf("invalid message %q: %s", body, err)
+			return
+		}
+		c.msgs <- msg
+	}
+}
+
+func (c *testClient) send(command string, args interface{}) {
+	c.seq++
+	data, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Returns the next message which is not an output event.  Output is
+// accumulated in c.output.
+func (c *testClient) next() *testMessage {
+	c.t.Helper()
+	for {
+		select {
+		case msg, ok := <-c.msgs:
+			if !ok {
+				c.t.Fatal("connection closed")
+			}
+			if msg.Type == "event" && msg.Event == "output" {
+				var body struct{ Output string }
+				_ = json.Unmarshal(msg.Body, &body)
+				c.output.WriteString(body.Output)
+				continue
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			c.t.Fatal("timed out waiting for a message")
+		}
+	}
+}
+
+// Sends a request and checks that it gets a successful response, whose body
+// is decoded into body if it is not nil.
+func (c *testClient) request(command string, args interface{}, body interface{}) {
+	c.t.Helper()
+	c.send(command, args)
+	msg := c.next()
+	if msg.Type != "response" || msg.Command != command {
+		c.t.Fatalf("expected %s response, got %+v", command, msg)
+	}
+	if !msg.Success {
+		c.t.Fatalf("%s failed: %s", command, msg.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(msg.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *testClient) expectEvent(name string, body interface{}) {
+	c.t.Helper()
+	msg := c.next()
+	if msg.Type != "event" || msg.Event != name {
+		c.t.Fatalf("expected %s event, got %+v", name, msg)
+	}
+	if body != nil {
+		if err := json.Unmarshal(msg.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *testClient) expectStopped(reason string) {
+	c.t.Helper()
+	var body struct{ Reason string }
+	c.expectEvent("stopped", &body)
+	if body.Reason != reason {
+		c.t.Fatalf("expected to stop because of %s, got %s", reason, body.Reason)
+	}
+}
+
+type testFrame struct {
+	ID     int
+	Name   string
+	Line   int
+	Source struct{ Path string }
+}
+
+func (c *testClient) topFrame() testFrame {
+	c.t.Helper()
+	var body struct{ StackFrames []testFrame }
+	c.request("stackTrace", map[string]interface{}{"threadId": 1}, &body)
+	if len(body.StackFrames) == 0 {
+		c.t.Fatal("empty stack trace")
+	}
+	return body.StackFrames[0]
+}
+
+func (c *testClient) expectLine(line int) testFrame {
+	c.t.Helper()
+	f := c.topFrame()
+	if f.Line != line {
+		c.t.Fatalf("expected to be at line %d, got %d", line, f.Line)
+	}
+	return f
+}
+
+// Returns the variables in the given scope of the top frame, formatted as
+// "name=value".
+func (c *testClient) scopeVariables(scopeName string) []string {
+	c.t.Helper()
+	var scopes struct {
+		Scopes []struct {
+			Name               string
+			VariablesReference int
+		}
+	}
+	c.request("scopes", map[string]interface{}{"frameId": c.topFrame().ID}, &scopes)
+	for _, s := range scopes.Scopes {
+		if s.Name == scopeName {
+			return c.variables(s.VariablesReference)
+		}
+	}
+	c.t.Fatalf("no %s scope", scopeName)
+	return nil
+}
+
+func (c *testClient) variables(ref int) []string {
+	c.t.Helper()
+	var body struct {
+		Variables []struct{ Name, Value string }
+	}
+	c.request("variables", map[string]interface{}{"variablesReference": ref}, &body)
+	vars := make([]string, len(body.Variables))
+	for i, v := range body.Variables {
+		// Drop addresses, e.g. "table: 0xc000123456" => "table"
+		value := strings.SplitN(v.Value, ": ", 2)[0]
+		vars[i] = v.Name + "=" + value
+	}
+	return vars
+}
+
+func (c *testClient) evaluate(expr string) (string, int) {
+	c.t.Helper()
+	var body struct {
+		Result             string
+		VariablesReference int
+	}
+	c.request("evaluate", map[string]interface{}{"expression": expr, "frameId": c.topFrame().ID}, &body)
+	return body.Result, body.VariablesReference
+}
+
+func (c *testClient) launch(program string, breakpoints ...map[string]interface{}) {
+	c.t.Helper()
+	c.request("initialize", map[string]interface{}{"adapterID": "golua"}, nil)
+	c.expectEvent("initialized", nil)
+	c.request("launch", map[string]interface{}{"program": program}, nil)
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": program},
+		"breakpoints": breakpoints,
+	}, nil)
+	c.request("configurationDone", nil, nil)
+}
+
+func (c *testClient) disconnect() {
+	c.t.Helper()
+	c.request("disconnect", nil, nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn.Close()
+}
+
+func checkStrings(t *testing.T, got []string, expected ...string) {
+	t.Helper()
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestDebugSession(t *testing.T) {
+	program, err := filepath.Abs("testdata/script.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+	c.launch(program,
+		map[string]interface{}{"line": 9, "condition": "i == 2"},
+		map[string]interface{}{"line": 11},
+	)
+
+	// Conditional breakpoint
+	c.expectStopped("breakpoint")
+	if f := c.expectLine(9); f.Source.Path != program {
+		t.Fatalf("expected source %s, got %s", program, f.Source.Path)
+	}
+	checkStrings(t, c.scopeVariables("Locals"), "add=function", `t=table`, "total=1", "i=2")
+	if res, _ := c.evaluate("total + i * 10"); res != "21" {
+		t.Fatalf("expected 21, got %s", res)
+	}
+
+	// Stepping
+	c.request("stepIn", map[string]interface{}{"threadId": 1}, nil)
+	c.expectStopped("step")
+	if f := c.expectLine(2); f.Name != "add" {
+		t.Fatalf("expected to be in add, got %s", f.Name)
+	}
+	c.request("next", map[string]interface{}{"threadId": 1}, nil)
+	c.expectStopped("step")
+	c.expectLine(3)
+	checkStrings(t, c.scopeVariables("Locals"), "x=1", "y=2", "sum=3")
+	c.request("stepOut", map[string]interface{}{"threadId": 1}, nil)
+	c.expectStopped("step")
+	c.expectLine(9)
+	c.request("next", map[string]interface{}{"threadId": 1}, nil)
+	c.expectStopped("step")
+	c.expectLine(8)
+	checkStrings(t, c.scopeVariables("Locals"), "add=function", `t=table`, "total=3")
+
+	// The condition is false when i == 3
+	c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	c.expectStopped("breakpoint")
+	c.expectLine(11)
+	res, ref := c.evaluate("t")
+	if !strings.HasPrefix(res, "table: ") || ref == 0 {
+		t.Fatalf("unexpected result for t: %s, %d", res, ref)
+	}
+	checkStrings(t, c.variables(ref), "[1]=10", `name="golua"`)
+
+	c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	var exited struct{ ExitCode int }
+	c.expectEvent("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exited.ExitCode)
+	}
+	c.expectEvent("terminated", nil)
+	if out := c.output.String(); out != "total\t6\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	c.disconnect()
+}
+
+func TestPauseAndTerminate(t *testing.T) {
+	program, err := filepath.Abs("testdata/loop.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+	c.launch(program)
+	c.request("pause", map[string]interface{}{"threadId": 1}, nil)
+	c.expectStopped("pause")
+	f := c.topFrame()
+	if f.Line < 1 || f.Line > 4 {
+		t.Fatalf("unexpected line %d", f.Line)
+	}
+	c.request("terminate", nil, nil)
+	c.expectEvent("exited", nil)
+	c.expectEvent("terminated", nil)
+	c.disconnect()
+}
+
+func TestEvaluateNilLocal(t *testing.T) {
+	program, err := filepath.Abs("testdata/shadow.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t)
+	c.launch(program, map[string]interface{}{"line": 3})
+	c.expectStopped("breakpoint")
+	if res, _ := c.evaluate("x"); res != "nil" {
+		t.Fatalf("expected nil, got %s", res)
+	}
+	if res, _ := c.evaluate("_G.x"); res != `"global"` {
+		t.Fatalf(`expected "global", got %s`, res)
+	}
+	c.request("continue", map[string]interface{}{"threadId": 1}, nil)
+	c.expectEvent("exited", nil)
+	c.expectEvent("terminated", nil)
+	c.disconnect()
+}
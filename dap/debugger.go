@@ -0,0 +1,418 @@
+package dap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A frame is an entry in the call stack of the stopped program.
+type frame struct {
+	info *rt.DebugInfo
+	lua  *rt.LuaCont // nil if this is not a Lua function
+}
+
+// A handle is something that contains variables, which the client refers to
+// with a "variables reference".
+type handle struct {
+	kind  handleKind
+	frame *frame    // for locals and upvalues
+	table *rt.Table // for tables
+}
+
+type handleKind uint8
+
+const (
+	localsHandle handleKind = iota
+	upvaluesHandle
+	tableHandle
+)
+
+// The debug hook, called each time a new line is reached.  It stops the
+// program if needed.
+func (s *Session) hook(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	next := c.Next()
+	if atomic.LoadInt32(&s.terminating) != 0 {
+		return nil, errTerminated
+	}
+	lc := rt.LuaContOf(next)
+	if lc == nil {
+		return next, nil
+	}
+	line := lc.DebugInfo().CurrentLine
+	if reason := s.stopReason(t, next, lc, line); reason != "" {
+		if !s.stop(t, next, lc, line, reason) {
+			return nil, errTerminated
+		}
+	}
+	return next, nil
+}
+
+// Returns the reason why the program should stop at the current line, or ""
+// if it should keep running.
+func (s *Session) stopReason(t *rt.Thread, cont rt.Cont, lc *rt.LuaCont, line int32) string {
+	if atomic.CompareAndSwapInt32(&s.pauseRequested, 1, 0) {
+		return "pause"
+	}
+	sameLine := lc == s.stepFrame && line == s.stepLine
+	switch s.step {
+	case stepEntry:
+		return "entry"
+	case stepIn:
+		if !sameLine {
+			return "step"
+		}
+	case stepOver:
+		if !sameLine && stackDepth(cont) <= s.stepDepth {
+			return "step"
+		}
+	case stepOut:
+		if stackDepth(cont) < s.stepDepth {
+			return "step"
+		}
+	}
+	if cond, ok := s.breakpointAt(lc.DebugInfo().Source, int(line)); ok {
+		if cond == "" || s.conditionHolds(t, lc, cond) {
+			return "breakpoint"
+		}
+	}
+	return ""
+}
+
+// Returns the condition of the breakpoint at the given location, if there is
+// one.
+func (s *Session) breakpointAt(source string, line int) (string, bool) {
+	path := s.absPath(source)
+	if path == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cond, ok := s.breakpoints[path][line]
+	return cond, ok
+}
+
+// Evaluates the condition of a breakpoint.  If it cannot be evaluated, the
+// error is reported and the program stops.
+func (s *Session) conditionHolds(t *rt.Thread, lc *rt.LuaCont, cond string) bool {
+	s.thread = t
+	vals, err := s.eval(cond, &frame{info: lc.DebugInfo(), lua: lc})
+	if err != nil {
+		fmt.Fprintf(s.Output("stderr"), "error in breakpoint condition %q: %s\n", cond, err)
+		return true
+	}
+	return len(vals) > 0 && rt.Truth(vals[0])
+}
+
+// Stops the program and serves requests from the client until it is resumed.
+// It returns false if the program should be terminated.
+func (s *Session) stop(t *rt.Thread, cont rt.Cont, lc *rt.LuaCont, line int32, reason string) bool {
+	s.thread = t
+	s.frames = getFrames(cont)
+	s.handles = nil
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	_ = s.conn.sendEvent("stopped", stoppedEventBody{
+		Reason:            reason,
+		ThreadID:          mainThreadID,
+		AllThreadsStopped: true,
+	})
+	for {
+		select {
+		case task := <-s.tasks:
+			task()
+		case mode := <-s.resume:
+			s.frames = nil
+			s.handles = nil
+			s.step = mode
+			s.stepFrame = lc
+			s.stepLine = line
+			s.stepDepth = stackDepth(cont)
+			return mode != stepTerminate
+		}
+	}
+}
+
+func getFrames(cont rt.Cont) []frame {
+	var frames []frame
+	for ; cont != nil; cont = cont.Parent() {
+		if info := cont.DebugInfo(); info != nil {
+			frames = append(frames, frame{info: info, lua: rt.LuaContOf(cont)})
+		}
+	}
+	return frames
+}
+
+func stackDepth(cont rt.Cont) int {
+	depth := 0
+	for ; cont != nil; cont = cont.Parent() {
+		depth++
+	}
+	return depth
+}
+
+// Returns the absolute path of the file a chunk was loaded from, or "" if the
+// chunk was not loaded from a file.
+func (s *Session) absPath(source string) string {
+	path, ok := s.absPaths[source]
+	if !ok {
+		var err error
+		path, err = filepath.Abs(source)
+		if err == nil {
+			if fi, statErr := os.Stat(path); statErr != nil || fi.IsDir() {
+				err = os.ErrNotExist
+			}
+		}
+		if err != nil {
+			path = ""
+		}
+		s.absPaths[source] = path
+	}
+	return path
+}
+
+// Frame ids are indexes in s.frames, starting from 1.
+func (s *Session) getFrame(id int) (*frame, error) {
+	if id <= 0 || id > len(s.frames) {
+		return nil, errInvalidFrame
+	}
+	return &s.frames[id-1], nil
+}
+
+// Variables references are indexes in s.handles, starting from 1 (0 means that
+// there are no variables).
+func (s *Session) newHandle(h handle) int {
+	s.handles = append(s.handles, h)
+	return len(s.handles)
+}
+
+func (s *Session) getStackTrace(start, levels int) *stackTraceResponseBody {
+	frames := s.frames
+	if start > len(frames) {
+		start = len(frames)
+	}
+	end := len(frames)
+	if levels > 0 && start+levels < end {
+		end = start + levels
+	}
+	body := &stackTraceResponseBody{
+		StackFrames: []stackFrame{},
+		TotalFrames: len(frames),
+	}
+	for i := start; i < end; i++ {
+		f := &frames[i]
+		sf := stackFrame{ID: i + 1, Name: f.info.Name}
+		if f.lua != nil {
+			sf.Line = int(f.info.CurrentLine)
+			sf.Column = 1
+			if path := s.absPath(f.info.Source); path != "" {
+				sf.Source = &source{Name: filepath.Base(path), Path: path}
+			} else {
+				sf.Source = &source{Name: f.info.Source}
+			}
+		}
+		body.StackFrames = append(body.StackFrames, sf)
+	}
+	return body
+}
+
+func (s *Session) getScopes(frameID int) (*scopesResponseBody, error) {
+	f, err := s.getFrame(frameID)
+	if err != nil {
+		return nil, err
+	}
+	body := &scopesResponseBody{Scopes: []scope{}}
+	if f.lua != nil {
+		body.Scopes = append(body.Scopes, scope{
+			Name:               "Locals",
+			VariablesReference: s.newHandle(handle{kind: localsHandle, frame: f}),
+		})
+		if f.lua.Closure.UpvalueCount > 0 {
+			body.Scopes = append(body.Scopes, scope{
+				Name:               "Upvalues",
+				VariablesReference: s.newHandle(handle{kind: upvaluesHandle, frame: f}),
+			})
+		}
+	}
+	body.Scopes = append(body.Scopes, scope{
+		Name:               "Globals",
+		VariablesReference: s.newHandle(handle{kind: tableHandle, table: s.thread.GlobalEnv()}),
+		Expensive:          true,
+	})
+	return body, nil
+}
+
+func (s *Session) getVariables(ref int) (*variablesResponseBody, error) {
+	if ref <= 0 || ref > len(s.handles) {
+		return nil, errInvalidHandle
+	}
+	h := s.handles[ref-1]
+	body := &variablesResponseBody{Variables: []variable{}}
+	add := func(name string, v rt.Value) {
+		body.Variables = append(body.Variables, s.variable(name, v))
+	}
+	switch h.kind {
+	case localsHandle:
+		forEachLocal(h.frame.lua, add)
+	case upvaluesHandle:
+		forEachUpvalue(h.frame.lua, add)
+	case tableHandle:
+		k, v, _ := h.table.Next(rt.NilValue)
+		for !k.IsNil() {
+			add(keyString(k), v)
+			k, v, _ = h.table.Next(k)
+		}
+	}
+	return body, nil
+}
+
+// Calls f with the names and values of the local variables in scope, in the
+// order they were declared.
+func forEachLocal(lc *rt.LuaCont, f func(string, rt.Value)) {
+	for i := 1; ; i++ {
+		name, val, ok := lc.GetLocal(i)
+		if !ok {
+			return
+		}
+		f(name, val)
+	}
+}
+
+// Calls f with the names and values of the upvalues of the function.
+func forEachUpvalue(lc *rt.LuaCont, f func(string, rt.Value)) {
+	clos := lc.Closure
+	for i := 0; i < int(clos.UpvalueCount); i++ {
+		name := "?"
+		if i < len(clos.UpNames) {
+			name = clos.UpNames[i]
+		}
+		f(name, clos.GetUpvalue(i))
+	}
+}
+
+func (s *Session) variable(name string, v rt.Value) variable {
+	return variable{
+		Name:               name,
+		Value:              valueString(v),
+		Type:               v.TypeName(),
+		VariablesReference: s.tableRef(v),
+	}
+}
+
+// Returns a variables reference for v if it is a non-empty table, 0 otherwise.
+func (s *Session) tableRef(v rt.Value) int {
+	t, ok := v.TryTable()
+	if !ok {
+		return 0
+	}
+	if k, _, _ := t.Next(rt.NilValue); k.IsNil() {
+		return 0
+	}
+	return s.newHandle(handle{kind: tableHandle, table: t})
+}
+
+// Returns a representation of v for the client.  Metamethods are not used.
+func valueString(v rt.Value) string {
+	if str, ok := v.TryString(); ok {
+		return fmt.Sprintf("%q", str)
+	}
+	str, _ := v.ToString()
+	return str
+}
+
+func keyString(k rt.Value) string {
+	if str, ok := k.TryString(); ok {
+		return str
+	}
+	return "[" + valueString(k) + "]"
+}
+
+func (s *Session) evaluateInFrame(expr string, frameID int) (*evaluateResponseBody, error) {
+	var f *frame
+	if frameID != 0 {
+		var err error
+		f, err = s.getFrame(frameID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	vals, err := s.eval(expr, f)
+	if err != nil {
+		return nil, err
+	}
+	body := &evaluateResponseBody{}
+	switch len(vals) {
+	case 0:
+		body.Result = "nil"
+	case 1:
+		body.Result = valueString(vals[0])
+		body.Type = vals[0].TypeName()
+		body.VariablesReference = s.tableRef(vals[0])
+	default:
+		strs := make([]string, len(vals))
+		for i, v := range vals {
+			strs[i] = valueString(v)
+		}
+		body.Result = strings.Join(strs, ", ")
+	}
+	return body, nil
+}
+
+// Evaluates an expression (or runs a statement) in the context of a frame,
+// which may be nil.  The local variables and upvalues of the frame are visible,
+// but assigning to them has no effect.  Global variables can be read and
+// written.
+func (s *Session) eval(expr string, f *frame) ([]rt.Value, error) {
+	t := s.thread
+	env := t.GlobalEnv()
+	if f != nil && f.lua != nil {
+		// Names are looked up in a map rather than copied to a table so that
+		// a nil local variable hides a global variable with the same name.
+		vars := map[string]rt.Value{}
+		set := func(name string, v rt.Value) {
+			if name != "_ENV" {
+				vars[name] = v
+			} else if e, ok := v.TryTable(); ok {
+				env = e
+			}
+		}
+		forEachUpvalue(f.lua, set)
+		forEachLocal(f.lua, set)
+		globals := rt.TableValue(env)
+		index := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+			if err := c.CheckNArgs(2); err != nil {
+				return nil, err
+			}
+			if name, ok := c.Arg(1).TryString(); ok {
+				if v, ok := vars[name]; ok {
+					return c.PushingNext1(t.Runtime, v), nil
+				}
+			}
+			v, err := rt.Index(t, globals, c.Arg(1))
+			if err != nil {
+				return nil, err
+			}
+			return c.PushingNext1(t.Runtime, v), nil
+		}
+		meta := rt.NewTable()
+		t.SetEnv(meta, "__index", rt.FunctionValue(rt.NewGoFunction(index, "__index", 2, false)))
+		t.SetEnv(meta, "__newindex", globals)
+		scope := rt.NewTable()
+		scope.SetMetatable(meta)
+		env = scope
+	}
+	clos, err := t.CompileAndLoadLuaChunkOrExp("eval", []byte(expr), rt.TableValue(env))
+	if err != nil {
+		return nil, err
+	}
+	term := rt.NewTerminationWith(t.CurrentCont(), 0, true)
+	if err := rt.Call(t, rt.FunctionValue(clos), nil, term); err != nil {
+		return nil, err
+	}
+	return term.Etc(), nil
+}
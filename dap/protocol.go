@@ -0,0 +1,236 @@
+package dap
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/arnodel/golua/internal/framing"
+)
+
+// Messages are framed as in the Language Server Protocol: a "Content-Length"
+// header, an empty line and then the JSON encoded message.
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// A conn reads and writes DAP messages.  Writing is safe for concurrent use.
+type conn struct {
+	r *framing.Reader
+
+	mu  sync.Mutex // protects the fields below
+	w   io.Writer
+	seq int
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{
+		r: framing.NewReader(rw),
+		w: rw,
+	}
+}
+
+func (c *conn) readRequest() (*request, error) {
+	body, err := c.r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (c *conn) respond(req *request, body interface{}) error {
+	return c.write(&response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    true,
+		Command:    req.Command,
+		Body:       body,
+	})
+}
+
+func (c *conn) respondError(req *request, err error) error {
+	return c.write(&response{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Message:    err.Error(),
+	})
+}
+
+func (c *conn) sendEvent(name string, body interface{}) error {
+	return c.write(&event{
+		Type:  "event",
+		Event: name,
+		Body:  body,
+	})
+}
+
+func (c *conn) write(msg interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = c.seq
+	case *event:
+		m.Seq = c.seq
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return framing.WriteMessage(c.w, data)
+}
+
+// Arguments and bodies of the requests, responses and events that are
+// supported.  Only the fields that are used are declared.
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsConditionalBreakpoints   bool `json:"supportsConditionalBreakpoints"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+}
+
+type launchArguments struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+	NoDebug     bool     `json:"noDebug"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition,omitempty"`
+}
+
+type setBreakpointsArguments struct {
+	Source      source             `json:"source"`
+	Breakpoints []sourceBreakpoint `json:"breakpoints"`
+}
+
+type breakpointInfo struct {
+	Verified bool `json:"verified"`
+	Line     int  `json:"line"`
+}
+
+type setBreakpointsResponseBody struct {
+	Breakpoints []breakpointInfo `json:"breakpoints"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type threadsResponseBody struct {
+	Threads []thread `json:"threads"`
+}
+
+type stackTraceArguments struct {
+	ThreadID   int `json:"threadId"`
+	StartFrame int `json:"startFrame"`
+	Levels     int `json:"levels"`
+}
+
+type stackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type stackTraceResponseBody struct {
+	StackFrames []stackFrame `json:"stackFrames"`
+	TotalFrames int          `json:"totalFrames"`
+}
+
+type scopesArguments struct {
+	FrameID int `json:"frameId"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type scopesResponseBody struct {
+	Scopes []scope `json:"scopes"`
+}
+
+type variablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type variablesResponseBody struct {
+	Variables []variable `json:"variables"`
+}
+
+type evaluateArguments struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+}
+
+type evaluateResponseBody struct {
+	Result             string `json:"result"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+type continueResponseBody struct {
+	AllThreadsContinued bool `json:"allThreadsContinued"`
+}
+
+type stoppedEventBody struct {
+	Reason            string `json:"reason"`
+	ThreadID          int    `json:"threadId"`
+	AllThreadsStopped bool   `json:"allThreadsStopped"`
+}
+
+type outputEventBody struct {
+	Category string `json:"category"`
+	Output   string `json:"output"`
+}
+
+type exitedEventBody struct {
+	ExitCode int `json:"exitCode"`
+}
@@ -0,0 +1,450 @@
+// Package dap implements a debug adapter for golua, i.e. a server for the Debug
+// Adapter Protocol (see https://microsoft.github.io/debug-adapter-protocol/).
+// It allows editors that support the protocol to run a Lua script and debug it
+// with line breakpoints (optionally conditional), stepping, call stack
+// inspection, local variables and upvalues and evaluation of expressions.
+//
+// The debugger is built on top of the runtime debug hooks (see
+// runtime.DebugHooks), so it only sees the code running in the main thread:
+// code running in coroutines is not stepped through.  It also replaces any hook
+// set by the script with debug.sethook.
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// The only thread that is reported to the client.
+const mainThreadID = 1
+
+var (
+	errNotStopped    = errors.New("program is not stopped")
+	errNotLaunched   = errors.New("no program has been launched")
+	errTerminated    = errors.New("terminated by debugger")
+	errInvalidFrame  = errors.New("invalid frame id")
+	errInvalidHandle = errors.New("invalid variables reference")
+)
+
+type stepMode uint8
+
+const (
+	stepNone      stepMode = iota // run until a breakpoint is hit
+	stepEntry                     // stop at the first line
+	stepIn                        // stop at the next line
+	stepOver                      // stop at the next line in the same function or a caller
+	stepOut                       // stop at the next line in a caller
+	stepTerminate                 // stop running the program
+)
+
+// A Session is a debugging session with a client.  It runs at most one Lua
+// program, given by the client in a "launch" request.
+type Session struct {
+	conn *conn
+
+	mu          sync.Mutex                // protects the fields below
+	breakpoints map[string]map[int]string // maps path -> line -> condition
+	stopped     bool                      // true when the program is stopped
+
+	pauseRequested int32 // set atomically
+	terminating    int32 // set atomically
+
+	// Fields only used by the goroutine serving requests
+	launch     *launchArguments
+	configured bool
+	started    bool
+	done       chan struct{} // closed when the program has finished
+	cancel     context.CancelFunc
+	runtime    *rt.Runtime
+	main       *rt.Closure
+	args       []rt.Value
+	cleanup    func()
+
+	// Used to communicate with the program goroutine when it is stopped
+	tasks  chan func()
+	resume chan stepMode
+
+	// Fields only used by the goroutine running the program
+	step      stepMode
+	stepFrame *rt.LuaCont
+	stepLine  int32
+	stepDepth int
+	thread    *rt.Thread
+	frames    []frame
+	handles   []handle
+	absPaths  map[string]string
+}
+
+// NewSession returns a new session with a client that sends requests to rw and
+// reads responses and events from it.  Call Run to serve the requests.
+func NewSession(rw io.ReadWriter) *Session {
+	return &Session{
+		conn:        newConn(rw),
+		breakpoints: map[string]map[int]string{},
+		tasks:       make(chan func()),
+		resume:      make(chan stepMode),
+		absPaths:    map[string]string{},
+	}
+}
+
+// Output returns a writer whose output is sent to the client in "output"
+// events of the given category (e.g. "stdout" or "stderr").  The Lua print
+// function writes to Output("stdout"), but this can be used to forward output
+// that does not go through the runtime (e.g. that of io.write).
+func (s *Session) Output(category string) io.Writer {
+	return outputWriter{conn: s.conn, category: category}
+}
+
+// Run serves the requests sent by the client until it disconnects or rw is
+// closed.  If the program is still running at that point, it is terminated.
+func (s *Session) Run() error {
+	defer s.terminate()
+	for {
+		req, err := s.conn.readRequest()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+// Serve runs a debugging session with a client over rw (see Session.Run).
+func Serve(rw io.ReadWriter) error {
+	return NewSession(rw).Run()
+}
+
+type requestHandler func(s *Session, req *request) error
+
+var requestHandlers = map[string]requestHandler{
+	"initialize":        (*Session).initialize,
+	"launch":            (*Session).launchProgram,
+	"setBreakpoints":    (*Session).setBreakpoints,
+	"configurationDone": (*Session).configurationDone,
+	"threads":           (*Session).threads,
+	"stackTrace":        (*Session).stackTrace,
+	"scopes":            (*Session).scopes,
+	"variables":         (*Session).variables,
+	"evaluate":          (*Session).evaluate,
+	"continue":          resumeHandler(stepNone),
+	"next":              resumeHandler(stepOver),
+	"stepIn":            resumeHandler(stepIn),
+	"stepOut":           resumeHandler(stepOut),
+	"pause":             (*Session).pause,
+	"terminate":         (*Session).terminateRequest,
+	"disconnect":        (*Session).terminateRequest,
+}
+
+// Handles a request.  Only errors writing to the client are returned, other
+// errors are reported in the response.
+func (s *Session) handle(req *request) error {
+	handler, ok := requestHandlers[req.Command]
+	if !ok {
+		return s.conn.respondError(req, fmt.Errorf("unsupported request: %q", req.Command))
+	}
+	return handler(s, req)
+}
+
+// Responds to req with the result of f, which is run in the program goroutine.
+// This requires the program to be stopped.
+func (s *Session) respondFromProgram(req *request, f func() (interface{}, error)) error {
+	var (
+		body interface{}
+		err  error
+	)
+	if stopErr := s.inProgram(func() { body, err = f() }); stopErr != nil {
+		err = stopErr
+	}
+	if err != nil {
+		return s.conn.respondError(req, err)
+	}
+	return s.conn.respond(req, body)
+}
+
+// Runs f in the program goroutine while the program is stopped.
+func (s *Session) inProgram(f func()) error {
+	s.mu.Lock()
+	stopped := s.stopped
+	s.mu.Unlock()
+	if !stopped {
+		return errNotStopped
+	}
+	done := make(chan struct{})
+	s.tasks <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+	return nil
+}
+
+// Resumes the program if it is stopped.
+func (s *Session) resumeProgram(mode stepMode) error {
+	s.mu.Lock()
+	stopped := s.stopped
+	s.stopped = false
+	s.mu.Unlock()
+	if !stopped {
+		return errNotStopped
+	}
+	s.resume <- mode
+	return nil
+}
+
+func (s *Session) initialize(req *request) error {
+	err := s.conn.respond(req, capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsConditionalBreakpoints:   true,
+		SupportsEvaluateForHovers:        true,
+		SupportsTerminateRequest:         true,
+	})
+	if err != nil {
+		return err
+	}
+	return s.conn.sendEvent("initialized", nil)
+}
+
+func (s *Session) launchProgram(req *request) error {
+	var args launchArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	if s.launch != nil {
+		return s.conn.respondError(req, errors.New("a program has already been launched"))
+	}
+	if err := s.load(&args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	s.launch = &args
+	if err := s.conn.respond(req, nil); err != nil {
+		return err
+	}
+	s.startIfReady()
+	return nil
+}
+
+// Creates a runtime and loads the program into it.
+func (s *Session) load(args *launchArguments) error {
+	if args.Program == "" {
+		return errors.New("missing program")
+	}
+	chunk, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	r := rt.New(s.Output("stdout"))
+	cleanup := lib.LoadAll(r)
+	main, err := r.LoadFromSourceOrCode(args.Program, chunk, "bt", rt.TableValue(r.GlobalEnv()), true)
+	if err != nil {
+		cleanup()
+		return err
+	}
+	argTable := rt.NewTable()
+	r.SetTable(argTable, rt.IntValue(0), rt.StringValue(args.Program))
+	for i, arg := range args.Args {
+		argVal := rt.StringValue(arg)
+		r.SetTable(argTable, rt.IntValue(int64(i+1)), argVal)
+		s.args = append(s.args, argVal)
+	}
+	r.SetTable(r.GlobalEnv(), rt.StringValue("arg"), rt.TableValue(argTable))
+	s.runtime, s.main, s.cleanup = r, main, cleanup
+	return nil
+}
+
+func (s *Session) configurationDone(req *request) error {
+	s.configured = true
+	if err := s.conn.respond(req, nil); err != nil {
+		return err
+	}
+	s.startIfReady()
+	return nil
+}
+
+// The program is started when it has been launched and the client has finished
+// configuring the session (e.g. setting breakpoints).
+func (s *Session) startIfReady() {
+	if s.started || !s.configured || s.launch == nil {
+		return
+	}
+	s.started = true
+	if s.launch.StopOnEntry {
+		s.step = stepEntry
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+	go s.runProgram(ctx)
+}
+
+func (s *Session) setBreakpoints(req *request) error {
+	var args setBreakpointsArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return s.conn.respondError(req, err)
+	}
+	lines := make(map[int]string, len(args.Breakpoints))
+	infos := make([]breakpointInfo, len(args.Breakpoints))
+	for i, bp := range args.Breakpoints {
+		lines[bp.Line] = bp.Condition
+		infos[i] = breakpointInfo{Verified: true, Line: bp.Line}
+	}
+	s.mu.Lock()
+	s.breakpoints[path] = lines
+	s.mu.Unlock()
+	return s.conn.respond(req, setBreakpointsResponseBody{Breakpoints: infos})
+}
+
+func (s *Session) threads(req *request) error {
+	return s.conn.respond(req, threadsResponseBody{
+		Threads: []thread{{ID: mainThreadID, Name: "main"}},
+	})
+}
+
+func (s *Session) stackTrace(req *request) error {
+	var args stackTraceArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	return s.respondFromProgram(req, func() (interface{}, error) {
+		return s.getStackTrace(args.StartFrame, args.Levels), nil
+	})
+}
+
+func (s *Session) scopes(req *request) error {
+	var args scopesArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	return s.respondFromProgram(req, func() (interface{}, error) {
+		return s.getScopes(args.FrameID)
+	})
+}
+
+func (s *Session) variables(req *request) error {
+	var args variablesArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	return s.respondFromProgram(req, func() (interface{}, error) {
+		return s.getVariables(args.VariablesReference)
+	})
+}
+
+func (s *Session) evaluate(req *request) error {
+	var args evaluateArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		return s.conn.respondError(req, err)
+	}
+	return s.respondFromProgram(req, func() (interface{}, error) {
+		return s.evaluateInFrame(args.Expression, args.FrameID)
+	})
+}
+
+func resumeHandler(mode stepMode) requestHandler {
+	return func(s *Session, req *request) error {
+		s.mu.Lock()
+		stopped := s.stopped
+		s.mu.Unlock()
+		if !stopped {
+			return s.conn.respondError(req, errNotStopped)
+		}
+		var body interface{}
+		if mode == stepNone {
+			body = continueResponseBody{AllThreadsContinued: true}
+		}
+		// Respond before resuming, so the response is sent before the next
+		// stopped event.
+		if err := s.conn.respond(req, body); err != nil {
+			return err
+		}
+		return s.resumeProgram(mode)
+	}
+}
+
+func (s *Session) pause(req *request) error {
+	if !s.started {
+		return s.conn.respondError(req, errNotLaunched)
+	}
+	atomic.StoreInt32(&s.pauseRequested, 1)
+	return s.conn.respond(req, nil)
+}
+
+func (s *Session) terminateRequest(req *request) error {
+	if err := s.conn.respond(req, nil); err != nil {
+		return err
+	}
+	s.terminate()
+	return nil
+}
+
+// Stops the program if it is running and waits for it to finish.
+func (s *Session) terminate() {
+	if s.started {
+		atomic.StoreInt32(&s.terminating, 1)
+		s.cancel()
+		_ = s.resumeProgram(stepTerminate)
+		<-s.done
+		s.started = false
+	}
+	if s.cleanup != nil {
+		s.cleanup()
+		s.cleanup = nil
+	}
+}
+
+// Runs the program in its own goroutine.  Once it is finished, "exited" and
+// "terminated" events are sent.
+func (s *Session) runProgram(ctx context.Context) {
+	defer close(s.done)
+	t := s.runtime.MainThread()
+	if !s.launch.NoDebug {
+		hook := rt.NewGoFunction(s.hook, "debugger", 0, true)
+		t.SetupHooks(rt.DebugHooks{
+			DebugHookFlags: rt.HookFlagLine,
+			Hook:           rt.FunctionValue(hook),
+		})
+	}
+	term := rt.NewTerminationWith(nil, 0, false)
+	err := rt.CallWithContext(ctx, t, rt.FunctionValue(s.main), s.args, term)
+	exitCode := 0
+	if err != nil && atomic.LoadInt32(&s.terminating) == 0 {
+		fmt.Fprintf(s.Output("stderr"), "!!! %s\n", err)
+		exitCode = 1
+	}
+	t.SetupHooks(rt.DebugHooks{})
+	_ = s.conn.sendEvent("exited", exitedEventBody{ExitCode: exitCode})
+	_ = s.conn.sendEvent("terminated", nil)
+}
+
+type outputWriter struct {
+	conn     *conn
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	err := w.conn.sendEvent("output", outputEventBody{Category: w.category, Output: string(p)})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
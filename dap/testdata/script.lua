@@ -0,0 +1,11 @@
+local function add(x, y)
+    local sum = x + y
+    return sum
+end
+
+local t = {name = "golua", 10}
+local total = 0
+for i = 1, 3 do
+    total = add(total, i)
+end
+print("total", total)
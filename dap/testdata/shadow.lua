@@ -0,0 +1,3 @@
+x = "global"
+local x
+print(x)
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/arnodel/golua/dap"
+	"github.com/arnodel/golua/lib/iolib"
+)
+
+// Runs a debug adapter server.  The program to debug is given by the client.
+func (c *luaCmd) serveDAP() int {
+	iolib.BufferedStdFiles = false
+	if c.dapAddr != "" {
+		return serveDAPOnTCP(c.dapAddr)
+	}
+
+	// The protocol is spoken on stdout, so output written directly to it by
+	// the program (e.g. with io.write) is sent to the client in output events.
+	stdout := os.Stdout
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return fatal("Error creating pipe: %s", err)
+	}
+	os.Stdout = pw
+	defer func() { os.Stdout = stdout }()
+
+	session := dap.NewSession(stdio{Reader: os.Stdin, Writer: stdout})
+	go io.Copy(session.Output("stdout"), pr)
+	if err := session.Run(); err != nil {
+		return fatal("Error serving DAP: %s", err)
+	}
+	return 0
+}
+
+func serveDAPOnTCP(addr string) int {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fatal("Error listening on %s: %s", addr, err)
+	}
+	fmt.Fprintf(os.Stderr, "Listening for DAP connections on %s\n", l.Addr())
+	conn, err := l.Accept()
+	l.Close()
+	if err != nil {
+		return fatal("Error accepting connection: %s", err)
+	}
+	defer conn.Close()
+	if err := dap.Serve(conn); err != nil {
+		return fatal("Error serving DAP: %s", err)
+	}
+	return 0
+}
+
+type stdio struct {
+	io.Reader
+	io.Writer
+}
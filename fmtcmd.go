@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/format"
+)
+
+// Runs "golua fmt [flags] [files]", which formats Lua source files.  With no
+// files, it formats the standard input.
+func fmtMain(args []string) int {
+	flags := flag.NewFlagSet("golua fmt", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: golua fmt [flags] [files]")
+		flags.PrintDefaults()
+	}
+	indent := flags.Int("indent", ast.DefaultIndentWidth, "Indent with `n` spaces")
+	quote := flags.String("quote", "double", "Quotes for short strings: `double, single or keep`")
+	check := flags.Bool("check", false, "List files which are not formatted and exit with status 1 if there are any")
+	write := flags.Bool("w", false, "Write the result to the source file instead of stdout")
+	flags.Parse(args)
+
+	opts := ast.FormatOptions{IndentWidth: *indent}
+	switch *quote {
+	case "double":
+		opts.Quotes = ast.DoubleQuotes
+	case "single":
+		opts.Quotes = ast.SingleQuotes
+	case "keep":
+		opts.Quotes = ast.KeepQuotes
+	default:
+		return fatal("Invalid quote style: %q", *quote)
+	}
+	if flags.NArg() == 0 {
+		if *write {
+			return fatal("Cannot use -w with standard input")
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fatal("Error reading <stdin>: %s", err)
+		}
+		return fmtSource("<stdin>", src, opts, *check, false)
+	}
+	retcode := 0
+	for _, name := range flags.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return fatal("Error reading %s: %s", name, err)
+		}
+		if code := fmtSource(name, src, opts, *check, *write); code > retcode {
+			retcode = code
+		}
+	}
+	return retcode
+}
+
+// Formats one source file.  It returns 1 in check mode if the source is not
+// formatted and 2 on errors.
+func fmtSource(name string, src []byte, opts ast.FormatOptions, check, write bool) int {
+	out, err := format.Source(name, src, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	switch {
+	case check:
+		if !bytes.Equal(src, out) {
+			fmt.Println(name)
+			return 1
+		}
+	case write:
+		if bytes.Equal(src, out) {
+			return 0
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			fatal("Error writing %s: %s", name, err)
+			return 2
+		}
+		if err := ioutil.WriteFile(name, out, info.Mode().Perm()); err != nil {
+			fatal("Error writing %s: %s", name, err)
+			return 2
+		}
+	default:
+		os.Stdout.Write(out)
+	}
+	return 0
+}
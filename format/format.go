@@ -0,0 +1,41 @@
+// Package format formats Lua source code.  See ast.Format for the details of
+// the output.
+package format
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+)
+
+// Source formats the Lua source code src.  The name is used in error messages.
+// A first line starting with "#" (e.g. "#!/usr/bin/env golua") is kept as is.
+func Source(name string, src []byte, opts ast.FormatOptions) ([]byte, error) {
+	var out bytes.Buffer
+	var scanOpts []scanner.Option
+	if bytes.HasPrefix(src, []byte("#")) {
+		i := bytes.IndexAny(src, "\r\n")
+		if i < 0 {
+			return append(src, '\n'), nil
+		}
+		out.Write(src[:i])
+		out.WriteByte('\n')
+		if bytes.HasPrefix(src[i:], []byte("\r\n")) {
+			i++
+		}
+		src = src[i+1:]
+		scanOpts = append(scanOpts, scanner.WithStartLine(2))
+	}
+	scanOpts = append(scanOpts, scanner.WithComments())
+	chunk, err := parsing.ParseChunkWithComments(scanner.New(name, src, scanOpts...))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", name, err)
+	}
+	if err := ast.Format(&out, chunk, opts); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,164 @@
+package format_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/format"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ast.FormatOptions
+		src  string
+		want string
+	}{
+		{
+			name: "layout",
+			src: `local  x,y=1,'a'   ;
+if x then f (x) elseif y then else return end
+for i=1,10 do end
+for i=1,10,2 do   print(i)end
+function t.a.b:m(p,...) return self,p end
+local f=function()end`,
+			want: `local x, y = 1, "a"
+if x then
+    f(x)
+elseif y then
+else
+    return
+end
+for i = 1, 10 do
+end
+for i = 1, 10, 2 do
+    print(i)
+end
+function t.a.b:m(p, ...)
+    return self, p
+end
+local f = function() end
+`,
+		},
+		{
+			name: "comments",
+			src: `#!/usr/bin/env golua
+-- header
+
+
+local t = { -- open
+  1, 2;   -- numbers
+  --[[ long
+  comment ]]
+  x=3
+}
+do -- block
+  f() -- call
+
+  -- last
+end --end`,
+			want: `#!/usr/bin/env golua
+-- header
+
+local t = { -- open
+    1,
+    2, -- numbers
+    --[[ long
+  comment ]]
+    x = 3,
+}
+do -- block
+    f() -- call
+
+    -- last
+end --end
+`,
+		},
+		{
+			name: "brackets",
+			src: `x = (a+b)*c - (d-e) .. (f..g) .. h
+y = -(-a) ^ 2, 2^-3, (2^3)^4, not (a and b)
+z = ("s"):rep(2), (a or b).c, ({}).x, (f())
+;(g or h)()`,
+			want: `x = (a + b) * c - (d - e) .. (f .. g) .. h
+y = -(-a) ^ 2, 2 ^ -3, (2 ^ 3) ^ 4, not (a and b)
+z = ("s"):rep(2), (a or b).c, ({}).x, (f())
+;(g or h)()
+`,
+		},
+		{
+			name: "truncating brackets",
+			src:  `local a, b = (...) local c, d = (f()) print((...), (...)[1]) return (...)`,
+			want: `local a, b = (...)
+local c, d = (f())
+print((...), (...)[1])
+return (...)
+`,
+		},
+		{
+			name: "indexes",
+			src:  `t = {a=1, ["b"]=2, ["c d"]=3, [ [[e]] ]=4, ["if"]=5}; print(t.a, t["b"], t[ [[c]] ])`,
+			want: `t = {a = 1, ["b"] = 2, ["c d"] = 3, [ [[e]] ] = 4, ["if"] = 5}
+print(t.a, t["b"], t[ [[c]] ])
+`,
+		},
+		{
+			name: "tables with functions",
+			src:  `t = {f = function() return 1 end}`,
+			want: `t = {
+    f = function()
+        return 1
+    end,
+}
+`,
+		},
+		{
+			name: "double quotes",
+			src:  `print('a', 'b"c', 'it\'s', "d")`,
+			want: `print("a", 'b"c', "it's", "d")
+`,
+		},
+		{
+			name: "single quotes",
+			opts: ast.FormatOptions{Quotes: ast.SingleQuotes, IndentWidth: 2},
+			src:  `print('a', "b'c", "say \"hi\"") do x() end`,
+			want: `print('a', "b'c", 'say "hi"')
+do
+  x()
+end
+`,
+		},
+		{
+			name: "keep quotes",
+			opts: ast.FormatOptions{Quotes: ast.KeepQuotes},
+			src:  `print('a', "b", [==[c]==], 0x10, 1e3)`,
+			want: `print('a', "b", [==[c]==], 0x10, 1e3)
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := format.Source("test", []byte(test.src), test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+			again, err := format.Source("test", got, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(got) {
+				t.Fatalf("formatting is not idempotent, got:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	_, err := format.Source("test", []byte("x = "), ast.FormatOptions{})
+	if err == nil || err.Error() != "test:1:5: unexpected symbol near <eof>" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
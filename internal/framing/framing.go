@@ -0,0 +1,72 @@
+// Package framing reads and writes messages framed as in the Language Server
+// Protocol (which the Debug Adapter Protocol also uses): a "Content-Length"
+// header, an empty line and then the message itself.
+package framing
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxMessageSize is the maximum size of a message read by a Reader
+// returned by NewReader.
+const DefaultMaxMessageSize = 16 << 20
+
+var (
+	// ErrInvalidLength is returned when the Content-Length header is missing
+	// or is not a non-negative integer.
+	ErrInvalidLength = errors.New("invalid Content-Length header")
+
+	// ErrTooLarge is returned when the Content-Length header exceeds the
+	// maximum message size.
+	ErrTooLarge = errors.New("message too large")
+)
+
+// A Reader reads framed messages.
+type Reader struct {
+	r *textproto.Reader
+
+	// MaxMessageSize is the maximum length of a message.  The memory for a
+	// message is allocated before reading it, so this bounds what a peer can
+	// make the reader allocate.
+	MaxMessageSize int
+}
+
+// NewReader returns a Reader reading messages from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:              textproto.NewReader(bufio.NewReader(r)),
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+// ReadMessage reads the next message and returns its contents.
+func (r *Reader) ReadMessage() ([]byte, error) {
+	header, err := r.r.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	switch {
+	case err != nil || length < 0:
+		return nil, ErrInvalidLength
+	case length > r.MaxMessageSize:
+		return nil, ErrTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r.r.R, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// WriteMessage writes a message containing data to w.
+func WriteMessage(w io.Writer, data []byte) error {
+	_, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
@@ -0,0 +1,47 @@
+package framing
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	var buf bytes.Buffer
+	for _, msg := range []string{`{"a":1}`, ``, `{"b":2}`} {
+		if err := WriteMessage(&buf, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewReader(&buf)
+	for _, want := range []string{`{"a":1}`, ``, `{"b":2}`} {
+		got, err := r.ReadMessage()
+		if err != nil || string(got) != want {
+			t.Fatalf("got %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadMessageBadLength(t *testing.T) {
+	tests := []struct {
+		input string
+		err   error
+	}{
+		{"Content-Type: text\r\n\r\n{}", ErrInvalidLength},
+		{"Content-Length: abc\r\n\r\n{}", ErrInvalidLength},
+		{"Content-Length: -1\r\n\r\n{}", ErrInvalidLength},
+		{"Content-Length: 101\r\n\r\n{}", ErrTooLarge},
+		{"Content-Length: 99999999999999999999\r\n\r\n{}", ErrInvalidLength},
+	}
+	for _, test := range tests {
+		r := NewReader(strings.NewReader(test.input))
+		r.MaxMessageSize = 100
+		if _, err := r.ReadMessage(); err != test.err {
+			t.Errorf("%q: got %v, want %v", test.input, err, test.err)
+		}
+	}
+}
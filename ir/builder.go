@@ -26,8 +26,11 @@ type CodeBuilder struct {
 	upnames      []string
 	code         []Instruction
 	lines        []int
+	names        []OperandNames
 	labels       []bool
 	constantPool *ConstantPool
+
+	lineDefined, lastLineDefined int
 }
 
 func NewCodeBuilder(chunkName string, constantPool *ConstantPool) *CodeBuilder {
@@ -47,6 +50,13 @@ func (c *CodeBuilder) NewChild(chunkName string) *CodeBuilder {
 	}
 }
 
+// SetLineRange records the lines where the definition of the function being
+// built starts and ends.
+func (c *CodeBuilder) SetLineRange(lineDefined, lastLineDefined int) {
+	c.lineDefined = lineDefined
+	c.lastLineDefined = lastLineDefined
+}
+
 func (c *CodeBuilder) Dump() {
 	fmt.Println("--context")
 	c.context.dump()
@@ -174,6 +184,9 @@ func (c *CodeBuilder) PopContext() {
 	c.emitTruncate(context.top())
 	c.context = context
 	c.emitClearReg(top)
+	for i := len(top.locals) - 1; i >= 0; i-- {
+		c.EmitNoLine(EndLocal{Reg: top.locals[i]})
+	}
 	for _, tr := range top.reg {
 		c.ReleaseRegister(tr.reg)
 	}
@@ -227,6 +240,16 @@ func (c *CodeBuilder) EmitJump(lblName Name, line int) bool {
 func (c *CodeBuilder) DeclareLocal(name Name, reg Register) {
 	c.TakeRegister(reg)
 	c.context.addToTop(name, reg)
+	if isDebugName(name) {
+		c.context.addLocal(reg)
+		c.EmitNoLine(DeclareLocal{Name: name, Reg: reg})
+	}
+}
+
+// Names of locals used internally by the compiler start with "<", they are not
+// reported in debugging information.
+func isDebugName(name Name) bool {
+	return name != "" && name[0] != '<'
 }
 
 func (c *CodeBuilder) MarkConstantReg(reg Register) {
@@ -242,8 +265,25 @@ func (c *CodeBuilder) EmitNoLine(instr Instruction) {
 }
 
 func (c *CodeBuilder) Emit(instr Instruction, line int) {
+	c.EmitWithNames(instr, line, OperandNames{})
+}
+
+// EmitWithNames emits an instruction, recording the names of the variables its
+// operands were read from.
+func (c *CodeBuilder) EmitWithNames(instr Instruction, line int, names OperandNames) {
 	c.code = append(c.code, instr)
 	c.lines = append(c.lines, line)
+	c.names = append(c.names, names)
+}
+
+// IsUpvalueReg returns true if reg holds an upvalue.
+func (c *CodeBuilder) IsUpvalueReg(reg Register) bool {
+	for _, r := range c.upvalueDests {
+		if r == reg {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *CodeBuilder) Close() (uint, []Register) {
@@ -258,11 +298,15 @@ func (c *CodeBuilder) getCode() *Code {
 	return &Code{
 		Instructions: c.code,
 		Lines:        c.lines,
+		OperandNames: c.names,
 		Constants:    c.constantPool.Constants(),
 		Registers:    c.registers,
 		UpvalueDests: c.upvalueDests,
 		UpNames:      c.upnames,
 		Name:         c.chunkName,
+
+		LineDefined:     c.lineDefined,
+		LastLineDefined: c.lastLineDefined,
 	}
 }
 
@@ -1,5 +1,7 @@
 package ir
 
+import "github.com/arnodel/golua/code"
+
 // Constant is the interface implemented by constant values (e.g. numbers,
 // strings, but also code chunks).
 type Constant interface {
@@ -61,13 +63,25 @@ func (n NilType) ProcessConstant(p ConstantProcessor) {
 type Code struct {
 	Instructions []Instruction
 	Lines        []int
+	OperandNames []OperandNames
 	Constants    []Constant
 	UpvalueDests []Register
 	Registers    []RegData
 	UpNames      []string
 	Name         string
+
+	// Lines where the function definition starts and ends (0 for the main
+	// chunk)
+	LineDefined, LastLineDefined int
 }
 
+// OperandNames records the variables that the operands of an instruction were
+// read from, so that runtime errors can name them.  The first one is for the
+// left operand of a Combine, the operand of a Transform, the table of a Lookup
+// or SetIndex or the function of a MkCont.  The second one is for the right
+// operand of a Combine.
+type OperandNames [2]code.VarName
+
 // ProcessConstant uses the given ConstantProcessor to process the receiver.
 func (c Code) ProcessConstant(p ConstantProcessor) {
 	p.ProcessCode(c)
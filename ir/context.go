@@ -6,6 +6,7 @@ type lexicalScope struct {
 	reg    map[Name]taggedReg     // maps variable names to registers
 	label  map[Name]labelWithLine // maps label names to labels
 	height int                    // This is the height of the close stack in this scope
+	locals []Register             // registers of the local variables declared in this scope, for debugging
 }
 
 func (s lexicalScope) getLabel(name Name) (label Label, line int, ok bool) {
@@ -83,6 +84,16 @@ func (c lexicalContext) addToTop(name Name, reg Register) (ok bool) {
 	return
 }
 
+// addLocal records that reg holds a local variable declared in the topmost
+// lexical scope in this context.
+func (c lexicalContext) addLocal(reg Register) (ok bool) {
+	ok = len(c) > 0
+	if ok {
+		c[len(c)-1].locals = append(c[len(c)-1].locals, reg)
+	}
+	return
+}
+
 // addLabel adds a name => label mapping to the topmost lexical scope in this
 // context.
 func (c lexicalContext) addLabel(name Name, label Label, line int) (ok bool) {
@@ -0,0 +1,346 @@
+package ir
+
+import "math/bits"
+
+// A regSet is a set of registers represented as a bitset.
+type regSet []uint64
+
+func newRegSet(n int) regSet {
+	return make(regSet, (n+63)/64)
+}
+
+func (s regSet) has(r Register) bool {
+	return s[r/64]&(1<<(r%64)) != 0
+}
+
+func (s regSet) add(r Register) {
+	s[r/64] |= 1 << (r % 64)
+}
+
+func (s regSet) remove(r Register) {
+	s[r/64] &^= 1 << (r % 64)
+}
+
+// union adds all the registers in t to s and returns true if s changed.
+func (s regSet) union(t regSet) (changed bool) {
+	for i, w := range t {
+		if s[i]|w != s[i] {
+			s[i] |= w
+			changed = true
+		}
+	}
+	return
+}
+
+func (s regSet) clone() regSet {
+	return append(regSet(nil), s...)
+}
+
+func (s regSet) forEach(f func(Register)) {
+	for i, w := range s {
+		for w != 0 {
+			j := bits.TrailingZeros64(w)
+			f(Register(i*64 + j))
+			w &^= 1 << j
+		}
+	}
+}
+
+// isRealInstr returns true if the instruction is compiled to an opcode.  Other
+// instructions are hints or debugging information.
+func isRealInstr(instr Instruction) bool {
+	switch instr.(type) {
+	case DeclareLabel, TakeRegister, ReleaseRegister, DeclareLocal, EndLocal:
+		return false
+	default:
+		return true
+	}
+}
+
+// instrRegs returns the registers read and written by an instruction.  Pseudo
+// instructions read and write no registers.
+func instrRegs(instr Instruction) (uses, defs []Register) {
+	switch x := instr.(type) {
+	case Combine:
+		return []Register{x.Lsrc, x.Rsrc}, []Register{x.Dst}
+	case Transform:
+		return []Register{x.Src}, []Register{x.Dst}
+	case LoadConst:
+		return nil, []Register{x.Dst}
+	case Push:
+		return []Register{x.Cont, x.Item}, nil
+	case JumpIf:
+		return []Register{x.Cond}, nil
+	case Call:
+		return []Register{x.Cont}, nil
+	case MkClosure:
+		return x.Upvalues, []Register{x.Dst}
+	case MkCont:
+		return []Register{x.Closure}, []Register{x.Dst}
+	case ClearReg:
+		return nil, []Register{x.Dst}
+	case MkTable:
+		return nil, []Register{x.Dst}
+	case Lookup:
+		return []Register{x.Table, x.Index}, []Register{x.Dst}
+	case SetIndex:
+		return []Register{x.Table, x.Index, x.Src}, nil
+	case Receive:
+		return nil, x.Dst
+	case ReceiveEtc:
+		return nil, append(append([]Register(nil), x.Dst...), x.Etc)
+	case EtcLookup:
+		return []Register{x.Etc}, []Register{x.Dst}
+	case FillTable:
+		return []Register{x.Dst, x.Etc}, nil
+	case PushCloseStack:
+		return []Register{x.Src}, nil
+	case PrepForLoop:
+		regs := []Register{x.Start, x.Stop, x.Step}
+		return regs, regs
+	case AdvForLoop:
+		regs := []Register{x.Start, x.Stop, x.Step}
+		return regs, regs
+	default:
+		return nil, nil
+	}
+}
+
+// rewriteRegs returns the instruction with the registers it reads replaced
+// using the use function and all other registers (including those in pseudo
+// instructions) replaced using the def function.  Registers that are both read
+// and written (in for loop instructions) are replaced using def.
+func rewriteRegs(instr Instruction, use, def func(Register) Register) Instruction {
+	mapRegs := func(regs []Register) []Register {
+		if regs == nil {
+			return nil
+		}
+		mapped := make([]Register, len(regs))
+		for i, r := range regs {
+			mapped[i] = def(r)
+		}
+		return mapped
+	}
+	switch x := instr.(type) {
+	case Combine:
+		x.Dst, x.Lsrc, x.Rsrc = def(x.Dst), use(x.Lsrc), use(x.Rsrc)
+		return x
+	case Transform:
+		x.Dst, x.Src = def(x.Dst), use(x.Src)
+		return x
+	case LoadConst:
+		x.Dst = def(x.Dst)
+		return x
+	case Push:
+		x.Cont, x.Item = use(x.Cont), use(x.Item)
+		return x
+	case JumpIf:
+		x.Cond = use(x.Cond)
+		return x
+	case Call:
+		x.Cont = use(x.Cont)
+		return x
+	case MkClosure:
+		upvalues := make([]Register, len(x.Upvalues))
+		for i, r := range x.Upvalues {
+			upvalues[i] = use(r)
+		}
+		x.Dst, x.Upvalues = def(x.Dst), upvalues
+		return x
+	case MkCont:
+		x.Dst, x.Closure = def(x.Dst), use(x.Closure)
+		return x
+	case ClearReg:
+		x.Dst = def(x.Dst)
+		return x
+	case MkTable:
+		x.Dst = def(x.Dst)
+		return x
+	case Lookup:
+		x.Dst, x.Table, x.Index = def(x.Dst), use(x.Table), use(x.Index)
+		return x
+	case SetIndex:
+		x.Table, x.Index, x.Src = use(x.Table), use(x.Index), use(x.Src)
+		return x
+	case Receive:
+		x.Dst = mapRegs(x.Dst)
+		return x
+	case ReceiveEtc:
+		x.Dst, x.Etc = mapRegs(x.Dst), def(x.Etc)
+		return x
+	case EtcLookup:
+		x.Dst, x.Etc = def(x.Dst), use(x.Etc)
+		return x
+	case FillTable:
+		x.Dst, x.Etc = use(x.Dst), use(x.Etc)
+		return x
+	case PushCloseStack:
+		x.Src = use(x.Src)
+		return x
+	case PrepForLoop:
+		x.Start, x.Stop, x.Step = def(x.Start), def(x.Stop), def(x.Step)
+		return x
+	case AdvForLoop:
+		x.Start, x.Stop, x.Step = def(x.Start), def(x.Stop), def(x.Step)
+		return x
+	case TakeRegister:
+		x.Reg = def(x.Reg)
+		return x
+	case ReleaseRegister:
+		x.Reg = def(x.Reg)
+		return x
+	case DeclareLocal:
+		x.Reg = def(x.Reg)
+		return x
+	case EndLocal:
+		x.Reg = def(x.Reg)
+		return x
+	default:
+		return instr
+	}
+}
+
+// A basicBlock is a maximal sequence of instructions that can only be entered
+// at its first instruction and only left after its last one.
+type basicBlock struct {
+	start, end int   // The block is made of instructions start to end - 1
+	succs      []int // Indices of the successor blocks
+}
+
+// A flowGraph is the control flow graph of a function.
+type flowGraph struct {
+	blocks []basicBlock
+	labels map[Label]int // Index of the DeclareLabel instruction of each label
+}
+
+func newFlowGraph(c *Code) *flowGraph {
+	n := len(c.Instructions)
+	labels := labelIndices(c)
+	isLeader := make([]bool, n+1)
+	isLeader[0] = true
+	for i, instr := range c.Instructions {
+		switch x := instr.(type) {
+		case DeclareLabel:
+			isLeader[i] = true
+		case Jump, JumpIf:
+			isLeader[i+1] = true
+		case Call:
+			if x.Tail {
+				isLeader[i+1] = true
+			}
+		}
+	}
+	var blocks []basicBlock
+	blockOf := make([]int, n+1)
+	for i := 0; i < n; i++ {
+		if isLeader[i] {
+			if len(blocks) > 0 {
+				blocks[len(blocks)-1].end = i
+			}
+			blocks = append(blocks, basicBlock{start: i})
+		}
+		blockOf[i] = len(blocks) - 1
+	}
+	if len(blocks) > 0 {
+		blocks[len(blocks)-1].end = n
+	}
+	for b := range blocks {
+		blk := &blocks[b]
+		next := b + 1
+		if next == len(blocks) {
+			next = -1
+		}
+		target := func(lbl Label) int {
+			return blockOf[labels[lbl]]
+		}
+		switch x := c.Instructions[blk.end-1].(type) {
+		case Jump:
+			blk.succs = []int{target(x.Label)}
+		case JumpIf:
+			blk.succs = []int{target(x.Label)}
+			if next >= 0 {
+				blk.succs = append(blk.succs, next)
+			}
+		case Call:
+			if !x.Tail && next >= 0 {
+				blk.succs = []int{next}
+			}
+		default:
+			if next >= 0 {
+				blk.succs = []int{next}
+			}
+		}
+	}
+	return &flowGraph{blocks: blocks, labels: labels}
+}
+
+func labelIndices(c *Code) map[Label]int {
+	labels := map[Label]int{}
+	for i, instr := range c.Instructions {
+		if l, ok := instr.(DeclareLabel); ok {
+			labels[l.Label] = i
+		}
+	}
+	return labels
+}
+
+// reachable returns which blocks can be reached from the start of the
+// function.
+func (g *flowGraph) reachable() []bool {
+	seen := make([]bool, len(g.blocks))
+	if len(g.blocks) == 0 {
+		return seen
+	}
+	stack := []int{0}
+	seen[0] = true
+	for len(stack) > 0 {
+		b := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, s := range g.blocks[b].succs {
+			if !seen[s] {
+				seen[s] = true
+				stack = append(stack, s)
+			}
+		}
+	}
+	return seen
+}
+
+// liveOut returns for each block the set of registers whose value may be read
+// after the block is executed.
+func (g *flowGraph) liveOut(c *Code) []regSet {
+	nregs := len(c.Registers)
+	nblocks := len(g.blocks)
+	gen := make([]regSet, nblocks)
+	kill := make([]regSet, nblocks)
+	in := make([]regSet, nblocks)
+	out := make([]regSet, nblocks)
+	for b, blk := range g.blocks {
+		gen[b], kill[b] = newRegSet(nregs), newRegSet(nregs)
+		for i := blk.end - 1; i >= blk.start; i-- {
+			uses, defs := instrRegs(c.Instructions[i])
+			for _, r := range defs {
+				kill[b].add(r)
+				gen[b].remove(r)
+			}
+			for _, r := range uses {
+				gen[b].add(r)
+			}
+		}
+		in[b], out[b] = gen[b].clone(), newRegSet(nregs)
+	}
+	for changed := true; changed; {
+		changed = false
+		for b := nblocks - 1; b >= 0; b-- {
+			for _, s := range g.blocks[b].succs {
+				if out[b].union(in[s]) {
+					changed = true
+				}
+			}
+			for i, w := range out[b] {
+				in[b][i] |= w &^ kill[b][i]
+			}
+		}
+	}
+	return out
+}
@@ -27,37 +27,41 @@ func FoldCode(c Code, f FoldFunc) Code {
 	}
 	var s foldStack
 	var i1 Instruction
-	var l1 int
+	var m1 instrMeta
 	for i, i2 := range c.Instructions {
-		l2 := c.Lines[i]
+		m2 := instrMeta{line: c.Lines[i]}
+		if c.OperandNames != nil {
+			m2.names = c.OperandNames[i]
+		}
 		if i1 != nil {
 			i1, i2 = f(i1, i2, c.Registers)
 			switch {
 			case i1 == nil && i2 == nil:
 				// Folded to nothing, pop from the stack to be able to fold the
 				// next instruction.
-				l2, i2 = s.pop()
+				m2, i2 = s.pop()
 			case i1 == nil:
 				// Folded to i2
-				l2 = mergeLines(l1, l2)
+				m2 = mergeMeta(m1, m2)
 			case i2 == nil:
 				// Folded to i1
 				i1, i2 = nil, i1
-				l2 = mergeLines(l1, l2)
+				m2 = mergeMeta(m1, m2)
 			default:
 				// Not folded
 			}
 		}
 		if i1 != nil {
-			s.push(l1, i1)
+			s.push(m1, i1)
 		}
 		i1 = i2
-		l1 = l2
+		m1 = m2
 	}
 	if i1 != nil {
-		s.push(l1, i1)
+		s.push(m1, i1)
 	}
 	c.Lines = s.lines
+	c.OperandNames = s.names
 	c.Instructions = s.instructions
 	return c
 }
@@ -123,13 +127,21 @@ func ComposeFolds(fs ...FoldFunc) FoldFunc {
 	}
 }
 
+// Information attached to an instruction that must be preserved when folding.
+type instrMeta struct {
+	line  int
+	names OperandNames
+}
+
 type foldStack struct {
 	lines        []int
+	names        []OperandNames
 	instructions []Instruction
 }
 
-func (s *foldStack) push(l int, i Instruction) {
-	s.lines = append(s.lines, l)
+func (s *foldStack) push(m instrMeta, i Instruction) {
+	s.lines = append(s.lines, m.line)
+	s.names = append(s.names, m.names)
 	s.instructions = append(s.instructions, i)
 }
 
@@ -137,18 +149,27 @@ func (s *foldStack) empty() bool {
 	return len(s.instructions) == 0
 }
 
-func (s *foldStack) pop() (l int, i Instruction) {
+func (s *foldStack) pop() (m instrMeta, i Instruction) {
 	last := len(s.instructions) - 1
 	if last < 0 {
 		return
 	}
-	l = s.lines[last]
+	m = instrMeta{line: s.lines[last], names: s.names[last]}
 	i = s.instructions[last]
 	s.lines = s.lines[:last]
+	s.names = s.names[:last]
 	s.instructions = s.instructions[:last]
 	return
 }
 
+func mergeMeta(m1, m2 instrMeta) instrMeta {
+	if m1.names == (OperandNames{}) {
+		m1.names = m2.names
+	}
+	m1.line = mergeLines(m1.line, m2.line)
+	return m1
+}
+
 func mergeLines(l1, l2 int) int {
 	if l1 != 0 {
 		return l1
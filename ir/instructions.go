@@ -50,6 +50,10 @@ type InstrProcessor interface {
 	ProcessTakeRegisterInstr(TakeRegister)
 	ProcessReleaseRegisterInstr(ReleaseRegister)
 
+	// These record where local variables are in scope, for debugging.
+	ProcessDeclareLocalInstr(DeclareLocal)
+	ProcessEndLocalInstr(EndLocal)
+
 	// A label (for jumping to)
 	ProcessDeclareLabelInstr(DeclareLabel)
 }
@@ -486,6 +490,39 @@ func (r ReleaseRegister) ProcessInstr(p InstrProcessor) {
 	p.ProcessReleaseRegisterInstr(r)
 }
 
+// DeclareLocal is not a real instruction.  It records that from this point the
+// register Reg holds the value of the local variable Name.  It is used to
+// provide debugging information about local variables.
+type DeclareLocal struct {
+	Name Name
+	Reg  Register
+}
+
+func (d DeclareLocal) String() string {
+	return fmt.Sprintf("local %s %s", d.Name, d.Reg)
+}
+
+// ProcessInstr makes the InstrProcessor process this instruction.
+func (d DeclareLocal) ProcessInstr(p InstrProcessor) {
+	p.ProcessDeclareLocalInstr(d)
+}
+
+// EndLocal is not a real instruction.  It records that the local variable held
+// in register Reg goes out of scope at this point.  It should be preceded by a
+// DeclareLocal for the same register.
+type EndLocal struct {
+	Reg Register
+}
+
+func (e EndLocal) String() string {
+	return fmt.Sprintf("endlocal %s", e.Reg)
+}
+
+// ProcessInstr makes the InstrProcessor process this instruction.
+func (e EndLocal) ProcessInstr(p InstrProcessor) {
+	p.ProcessEndLocalInstr(e)
+}
+
 // DeclareLabel is not a real IR instruction.  It is a placeholder for the
 // destination location of a jump label.  Being an instruction makes it easier
 // to perform IR code transformations and keep the labels in correct locations.
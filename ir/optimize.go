@@ -0,0 +1,590 @@
+package ir
+
+import (
+	"math"
+	"sort"
+
+	"github.com/arnodel/golua/ops"
+)
+
+// Optimization levels accepted by Optimize.
+const (
+	// NoOptimization leaves the code as emitted by the AST compiler.
+	NoOptimization = 0
+
+	// BasicOptimization removes unreachable code, threads jumps and reuses
+	// registers.  It does not change what the debug library reports about
+	// local variables.
+	BasicOptimization = 1
+
+	// FullOptimization also propagates constants and copies through registers
+	// (including local variables), folds constant expressions and removes dead
+	// stores.  Local variables declared <const> may disappear altogether.
+	FullOptimization = 2
+
+	// MaxOptimizationLevel is the highest meaningful optimization level.
+	MaxOptimizationLevel = FullOptimization
+)
+
+// A pass transforms the code of a function and returns true if it changed it.
+type pass func(o *optimizer) bool
+
+// The passes applied at each optimization level.  They run repeatedly until
+// none of them changes the code, then registers are reallocated.
+var passesByLevel = [...][]pass{
+	NoOptimization:    nil,
+	BasicOptimization: {simplifyJumps},
+	FullOptimization:  {simplifyJumps, propagateValues, removeDeadStores, coalesceMoves},
+}
+
+// Passes are repeated at most this many times, which is plenty in practice.
+const maxPassRounds = 8
+
+// Functions where the number of instructions multiplied by the number of
+// registers exceeds this are not optimized, as the analyses would require too
+// much memory.
+const maxOptimizedSize = 1 << 26
+
+// Optimize applies the optimization passes selected by level to all the code
+// constants in consts and returns the resulting constants.  Constants may be
+// added (e.g. by constant folding) at the end of the returned slice, so
+// existing constant indices remain valid.  The consts slice is not modified.
+func Optimize(consts []Constant, level int) []Constant {
+	if level <= NoOptimization {
+		return consts
+	}
+	if level > MaxOptimizationLevel {
+		level = MaxOptimizationLevel
+	}
+	kt := newConstantTable(consts)
+	for i, k := range consts {
+		c, ok := k.(*Code)
+		if !ok || len(c.Instructions)*len(c.Registers) > maxOptimizedSize {
+			continue
+		}
+		oc := *c
+		o := &optimizer{code: &oc, consts: kt}
+		o.run(passesByLevel[level])
+		kt.consts[i] = &oc
+	}
+	return kt.consts
+}
+
+// An optimizer transforms the code of one function.
+type optimizer struct {
+	code   *Code
+	consts *constantTable
+}
+
+func (o *optimizer) run(passes []pass) {
+	// Take a copy of the instructions as they may be shared with the
+	// unoptimized code and passes modify them in place.
+	c := o.code
+	c.Instructions = append([]Instruction(nil), c.Instructions...)
+	c.Lines = append([]int(nil), c.Lines...)
+	if c.OperandNames == nil {
+		c.OperandNames = make([]OperandNames, len(c.Instructions))
+	} else {
+		c.OperandNames = append([]OperandNames(nil), c.OperandNames...)
+	}
+	for i := 0; i < maxPassRounds; i++ {
+		changed := false
+		for _, p := range passes {
+			if p(o) {
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	removeUnusedLocals(o)
+	reuseRegisters(o)
+}
+
+func (o *optimizer) isCell(r Register) bool {
+	return o.code.Registers[r].IsCell
+}
+
+// delete marks the instruction at index i for deletion by compact.
+func (o *optimizer) delete(i int) {
+	o.code.Instructions[i] = nil
+}
+
+// replace replaces the instruction at index i, keeping its line.
+func (o *optimizer) replace(i int, instr Instruction, names OperandNames) {
+	o.code.Instructions[i] = instr
+	o.code.OperandNames[i] = names
+}
+
+// compact removes the instructions marked for deletion.
+func (o *optimizer) compact() {
+	c := o.code
+	j := 0
+	for i, instr := range c.Instructions {
+		if instr == nil {
+			continue
+		}
+		c.Instructions[j] = instr
+		c.Lines[j] = c.Lines[i]
+		c.OperandNames[j] = c.OperandNames[i]
+		j++
+	}
+	c.Instructions = c.Instructions[:j]
+	c.Lines = c.Lines[:j]
+	c.OperandNames = c.OperandNames[:j]
+}
+
+// nextRealInstr returns the index of the first real instruction at or after
+// index i (or the number of instructions if there is none).
+func (o *optimizer) nextRealInstr(i int) int {
+	instrs := o.code.Instructions
+	for i < len(instrs) && (instrs[i] == nil || !isRealInstr(instrs[i])) {
+		i++
+	}
+	return i
+}
+
+// simplifyJumps makes jumps go directly to their final destination, removes
+// jumps to the next instruction and unreachable code, then removes the labels
+// that are no longer used.
+func simplifyJumps(o *optimizer) bool {
+	c := o.code
+	changed := false
+	labels := labelIndices(c)
+
+	// Follow chains of unconditional jumps.
+	finalLabel := func(lbl Label) Label {
+		for n := len(labels); n > 0; n-- {
+			j := o.nextRealInstr(labels[lbl])
+			if j == len(c.Instructions) {
+				break
+			}
+			next, ok := c.Instructions[j].(Jump)
+			if !ok || next.Label == lbl {
+				break
+			}
+			lbl = next.Label
+		}
+		return lbl
+	}
+	for i, instr := range c.Instructions {
+		switch x := instr.(type) {
+		case Jump:
+			if lbl := finalLabel(x.Label); lbl != x.Label {
+				x.Label = lbl
+				o.replace(i, x, c.OperandNames[i])
+				changed = true
+			}
+			if o.nextRealInstr(i+1) == o.nextRealInstr(labels[x.Label]) {
+				o.delete(i)
+				changed = true
+			}
+		case JumpIf:
+			if lbl := finalLabel(x.Label); lbl != x.Label {
+				x.Label = lbl
+				o.replace(i, x, c.OperandNames[i])
+				changed = true
+			}
+			dst := o.nextRealInstr(labels[x.Label])
+			j := o.nextRealInstr(i + 1)
+			if j == dst {
+				o.delete(i)
+				changed = true
+				break
+			}
+			// (jump L1 if c; jump L2; L1:) ==> (jump L2 if not c; L1:)
+			if j == len(c.Instructions) {
+				break
+			}
+			next, ok := c.Instructions[j].(Jump)
+			if ok && !o.hasLabelBetween(i, j) && o.nextRealInstr(j+1) == dst {
+				x.Label = next.Label
+				x.Not = !x.Not
+				o.replace(i, x, c.OperandNames[i])
+				o.delete(j)
+				changed = true
+			}
+		}
+	}
+	o.compact()
+
+	// Remove unreachable instructions, keeping pseudo instructions as the
+	// register allocator and debug information rely on them.
+	g := newFlowGraph(c)
+	for b, ok := range g.reachable() {
+		if ok {
+			continue
+		}
+		blk := g.blocks[b]
+		for i := blk.start; i < blk.end; i++ {
+			if isRealInstr(c.Instructions[i]) {
+				o.delete(i)
+				changed = true
+			}
+		}
+	}
+	o.compact()
+
+	// Remove labels that are no longer jumped to.
+	used := map[Label]bool{}
+	for _, instr := range c.Instructions {
+		switch x := instr.(type) {
+		case Jump:
+			used[x.Label] = true
+		case JumpIf:
+			used[x.Label] = true
+		}
+	}
+	for i, instr := range c.Instructions {
+		if l, ok := instr.(DeclareLabel); ok && !used[l.Label] {
+			o.delete(i)
+			changed = true
+		}
+	}
+	o.compact()
+	return changed
+}
+
+func (o *optimizer) hasLabelBetween(i, j int) bool {
+	for k := i + 1; k < j; k++ {
+		if _, ok := o.code.Instructions[k].(DeclareLabel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedRegs returns the set of registers holding local variables whose
+// values must be kept for the debug library to report them.
+func (o *optimizer) pinnedRegs() regSet {
+	pinned := newRegSet(len(o.code.Registers))
+	for _, instr := range o.code.Instructions {
+		if d, ok := instr.(DeclareLocal); ok && !o.code.Registers[d.Reg].IsConstant {
+			pinned.add(d.Reg)
+		}
+	}
+	return pinned
+}
+
+// removeDeadStores removes instructions without side effects that write to a
+// register which is not read afterwards.
+func removeDeadStores(o *optimizer) bool {
+	c := o.code
+	changed := false
+	g := newFlowGraph(c)
+	out := g.liveOut(c)
+	pinned := o.pinnedRegs()
+	for b, blk := range g.blocks {
+		live := out[b]
+		for i := blk.end - 1; i >= blk.start; i-- {
+			instr := c.Instructions[i]
+			if dst, ok := pureDest(instr); ok && !o.isCell(dst) && !pinned.has(dst) && !live.has(dst) {
+				o.delete(i)
+				changed = true
+				continue
+			}
+			uses, defs := instrRegs(instr)
+			for _, r := range defs {
+				live.remove(r)
+			}
+			for _, r := range uses {
+				live.add(r)
+			}
+		}
+	}
+	o.compact()
+	return changed
+}
+
+// coalesceMoves makes instructions write directly to the destination of a move
+// which follows them:
+//
+// (r1 <- X; r2 <- r1) ==> r2 <- X
+//
+// when r1 is not read afterwards.
+func coalesceMoves(o *optimizer) bool {
+	c := o.code
+	changed := false
+	g := newFlowGraph(c)
+	out := g.liveOut(c)
+	pinned := o.pinnedRegs()
+	for b, blk := range g.blocks {
+		live := out[b]
+		for i := blk.end - 1; i >= blk.start; i-- {
+			instr := c.Instructions[i]
+			if mv, ok := instr.(Transform); ok && mv.Op == ops.OpId {
+				j := i - 1
+				for j >= blk.start && !isRealInstr(c.Instructions[j]) {
+					j--
+				}
+				var prev SetRegInstruction
+				if j >= blk.start {
+					prev, _ = c.Instructions[j].(SetRegInstruction)
+				}
+				if prev != nil && prev.DestReg() == mv.Src && !o.isCell(mv.Src) &&
+					!o.isCell(mv.Dst) && !pinned.has(mv.Src) && !live.has(mv.Src) {
+					instr = prev.WithDestReg(mv.Dst)
+					o.replace(j, instr, c.OperandNames[j])
+					o.delete(i)
+					changed = true
+					i = j
+				}
+			}
+			uses, defs := instrRegs(instr)
+			for _, r := range defs {
+				live.remove(r)
+			}
+			for _, r := range uses {
+				live.add(r)
+			}
+		}
+	}
+	o.compact()
+	return changed
+}
+
+// pureDest returns the register written by the instruction if it has no other
+// effect and cannot fail.
+func pureDest(instr Instruction) (Register, bool) {
+	switch x := instr.(type) {
+	case LoadConst:
+		return x.Dst, true
+	case Transform:
+		return x.Dst, x.Op == ops.OpId
+	case MkTable:
+		return x.Dst, true
+	case MkClosure:
+		return x.Dst, true
+	case EtcLookup:
+		return x.Dst, true
+	default:
+		return 0, false
+	}
+}
+
+// removeUnusedLocals removes the debug information for local variables whose
+// registers are no longer used by any instruction.
+func removeUnusedLocals(o *optimizer) {
+	c := o.code
+	used := newRegSet(len(c.Registers))
+	for _, instr := range c.Instructions {
+		uses, defs := instrRegs(instr)
+		for _, r := range uses {
+			used.add(r)
+		}
+		for _, r := range defs {
+			used.add(r)
+		}
+	}
+	for i, instr := range c.Instructions {
+		switch x := instr.(type) {
+		case DeclareLocal:
+			if !used.has(x.Reg) {
+				o.delete(i)
+			}
+		case EndLocal:
+			if !used.has(x.Reg) {
+				o.delete(i)
+			}
+		}
+	}
+	o.compact()
+}
+
+// reuseRegisters allocates registers according to their live ranges, so that
+// registers whose values are not needed at the same time share the same
+// location.  Then register hints are replaced so that the IR compiler
+// allocates the same locations.
+//
+// Cell registers are left alone, as their identity matters to closures.
+// Registers of local variables are kept alive for the whole scope of the
+// variable, so that the debug library can report their values.
+func reuseRegisters(o *optimizer) {
+	c := o.code
+	n := len(c.Instructions)
+	nregs := len(c.Registers)
+
+	// Compute the live interval of each value register.  Position 2i is when
+	// instruction i reads its operands and 2i+1 when it writes its results.
+	start := make([]int, nregs)
+	end := make([]int, nregs)
+	for r := range start {
+		start[r] = -1
+	}
+	mark := func(r Register, p int) {
+		if o.isCell(r) {
+			return
+		}
+		if start[r] < 0 || p < start[r] {
+			start[r] = p
+		}
+		if p > end[r] {
+			end[r] = p
+		}
+	}
+	g := newFlowGraph(c)
+	out := g.liveOut(c)
+	for b, blk := range g.blocks {
+		live := out[b]
+		for i := blk.end - 1; i >= blk.start; i-- {
+			uses, defs := instrRegs(c.Instructions[i])
+			for _, r := range defs {
+				mark(r, 2*i+1)
+				live.remove(r)
+			}
+			// Registers still in the set are live through the instruction.
+			live.forEach(func(r Register) {
+				mark(r, 2*i)
+				mark(r, 2*i+1)
+			})
+			for _, r := range uses {
+				mark(r, 2*i)
+				live.add(r)
+			}
+		}
+	}
+	for i, instr := range c.Instructions {
+		d, ok := instr.(DeclareLocal)
+		if !ok {
+			continue
+		}
+		mark(d.Reg, 2*i)
+		j := i + 1
+		for j < n {
+			if e, ok := c.Instructions[j].(EndLocal); ok && e.Reg == d.Reg {
+				break
+			}
+			j++
+		}
+		if j == n {
+			j = n - 1
+		}
+		mark(d.Reg, 2*j+1)
+	}
+	// The first register holds the continuation of the function, which the
+	// runtime may read at any point.
+	if nregs > 0 && n > 0 {
+		mark(0, 0)
+		mark(0, 2*n-1)
+	}
+
+	// Allocate registers to locations, taking the first free location each
+	// time.  Intervals are sorted by start position, so register 0 gets
+	// location 0.
+	var regs []Register
+	for r, s := range start {
+		if s >= 0 {
+			regs = append(regs, Register(r))
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool {
+		return start[regs[i]] < start[regs[j]] || start[regs[i]] == start[regs[j]] && regs[i] < regs[j]
+	})
+	var (
+		locEnd []int      // End of the interval occupying each location
+		locReg []Register // Register representing each location
+		alloc  = make([]Register, nregs)
+	)
+	for _, r := range regs {
+		loc := -1
+		for l, e := range locEnd {
+			if e < start[r] {
+				loc = l
+				break
+			}
+		}
+		if loc < 0 {
+			loc = len(locEnd)
+			locEnd = append(locEnd, 0)
+			locReg = append(locReg, r)
+		}
+		locEnd[loc] = end[r]
+		alloc[r] = locReg[loc]
+	}
+
+	// Rewrite the code.  The location representatives are taken at the start,
+	// in order, and value register hints are removed.
+	rename := func(r Register) Register {
+		if o.isCell(r) || start[r] < 0 {
+			return r
+		}
+		return alloc[r]
+	}
+	m := len(locReg)
+	instrs := make([]Instruction, m, m+n)
+	lines := make([]int, m, m+n)
+	names := make([]OperandNames, m, m+n)
+	for l, r := range locReg {
+		instrs[l] = TakeRegister{Reg: r}
+	}
+	for i, instr := range c.Instructions {
+		switch x := instr.(type) {
+		case TakeRegister:
+			if !o.isCell(x.Reg) {
+				continue
+			}
+		case ReleaseRegister:
+			if !o.isCell(x.Reg) {
+				continue
+			}
+		}
+		instrs = append(instrs, rewriteRegs(instr, rename, rename))
+		lines = append(lines, c.Lines[i])
+		names = append(names, c.OperandNames[i])
+	}
+	c.Instructions, c.Lines, c.OperandNames = instrs, lines, names
+}
+
+// A constantTable gives access to the constants of a unit, allowing new
+// constants to be added.
+type constantTable struct {
+	consts []Constant
+	index  map[interface{}]uint
+}
+
+// Floats are indexed by their bit pattern, so that e.g. 0.0 and -0.0 are
+// different constants.
+type floatBits uint64
+
+func constantKey(k Constant) (interface{}, bool) {
+	switch x := k.(type) {
+	case Float:
+		return floatBits(math.Float64bits(float64(x))), true
+	case Int, Bool, String, NilType:
+		return x, true
+	default:
+		return nil, false
+	}
+}
+
+func newConstantTable(consts []Constant) *constantTable {
+	kt := &constantTable{
+		consts: append([]Constant(nil), consts...),
+		index:  map[interface{}]uint{},
+	}
+	for i, k := range consts {
+		if key, ok := constantKey(k); ok {
+			if _, ok := kt.index[key]; !ok {
+				kt.index[key] = uint(i)
+			}
+		}
+	}
+	return kt
+}
+
+func (kt *constantTable) get(i uint) Constant {
+	return kt.consts[i]
+}
+
+// indexOf returns the index of a constant, adding it to the table if needed.
+func (kt *constantTable) indexOf(k Constant) uint {
+	key, _ := constantKey(k)
+	i, ok := kt.index[key]
+	if !ok {
+		i = uint(len(kt.consts))
+		kt.consts = append(kt.consts, k)
+		kt.index[key] = i
+	}
+	return i
+}
@@ -0,0 +1,358 @@
+package ir
+
+import "github.com/arnodel/golua/ops"
+
+// A valueFact records what is known about the value of a register at some
+// point in the code: either it holds a constant or it holds the same value as
+// another register.
+type valueFact struct {
+	isConst bool
+	k       uint     // Index of the constant if isConst
+	src     Register // Register holding the same value otherwise
+}
+
+// valueFacts maps value registers to what is known about them.  Cell registers
+// never appear as their value can be changed by other functions.
+type valueFacts map[Register]valueFact
+
+func (f valueFacts) clone() valueFacts {
+	g := make(valueFacts, len(f))
+	for r, v := range f {
+		g[r] = v
+	}
+	return g
+}
+
+// meet keeps only the facts which are also in g, and returns true if f
+// changed.
+func (f valueFacts) meet(g valueFacts) (changed bool) {
+	for r, v := range f {
+		if w, ok := g[r]; !ok || w != v {
+			delete(f, r)
+			changed = true
+		}
+	}
+	return
+}
+
+// kill forgets all the facts involving r, as r is being written to.
+func (f valueFacts) kill(r Register) {
+	delete(f, r)
+	for s, v := range f {
+		if !v.isConst && v.src == r {
+			delete(f, s)
+		}
+	}
+}
+
+// propagateValues replaces reads of registers known to hold the same value as
+// another register with reads of that register, and computations whose
+// operands are known constants with their result.  This makes many moves and
+// constant loads dead, so they can be removed by removeDeadStores.
+func propagateValues(o *optimizer) bool {
+	c := o.code
+	g := newFlowGraph(c)
+	if len(g.blocks) == 0 {
+		return false
+	}
+
+	// Compute the facts that hold on entry to each block.  A nil entry means
+	// the block has not been reached by the analysis yet.
+	in := make([]valueFacts, len(g.blocks))
+	in[0] = valueFacts{}
+	for changed := true; changed; {
+		changed = false
+		for b, blk := range g.blocks {
+			if in[b] == nil {
+				continue
+			}
+			facts := in[b].clone()
+			for i := blk.start; i < blk.end; i++ {
+				o.updateFacts(facts, c.Instructions[i])
+			}
+			for _, s := range blk.succs {
+				if in[s] == nil {
+					in[s] = facts.clone()
+					changed = true
+				} else if in[s].meet(facts) {
+					changed = true
+				}
+			}
+		}
+	}
+
+	// Rewrite the code using the facts.
+	changed := false
+	for b, blk := range g.blocks {
+		facts := in[b]
+		if facts == nil {
+			// Unreachable, simplifyJumps will remove it
+			continue
+		}
+		for i := blk.start; i < blk.end; i++ {
+			instr := c.Instructions[i]
+			if newInstr, ok := o.simplify(facts, instr); ok {
+				if newInstr == nil {
+					o.delete(i)
+				} else {
+					names := c.OperandNames[i]
+					if _, ok := newInstr.(LoadConst); ok {
+						names = OperandNames{}
+					}
+					o.replace(i, newInstr, names)
+				}
+				changed = true
+				if newInstr == nil {
+					continue
+				}
+				instr = newInstr
+			}
+			o.updateFacts(facts, instr)
+		}
+	}
+	o.compact()
+	return changed
+}
+
+// simplify returns a simpler instruction equivalent to instr given the facts
+// known before it, and true if there is one.  The instruction returned is nil
+// if instr can be removed.
+func (o *optimizer) simplify(facts valueFacts, instr Instruction) (Instruction, bool) {
+	changed := false
+	substitute := func(r Register) Register {
+		if v, ok := facts[r]; ok && !v.isConst {
+			changed = true
+			return v.src
+		}
+		return r
+	}
+	keep := func(r Register) Register { return r }
+	instr = rewriteRegs(instr, substitute, keep)
+	switch x := instr.(type) {
+	case Transform:
+		if x.Op == ops.OpId && x.Dst == x.Src {
+			return nil, true
+		}
+		if k, ok := o.constValue(facts, x.Src); ok {
+			if v, ok := foldUnOp(x.Op, k); ok {
+				return LoadConst{Dst: x.Dst, Kidx: o.consts.indexOf(v)}, true
+			}
+		}
+	case Combine:
+		k1, ok1 := o.constValue(facts, x.Lsrc)
+		k2, ok2 := o.constValue(facts, x.Rsrc)
+		if ok1 && ok2 {
+			if v, ok := foldBinOp(x.Op, k1, k2); ok {
+				return LoadConst{Dst: x.Dst, Kidx: o.consts.indexOf(v)}, true
+			}
+		}
+	case JumpIf:
+		if k, ok := o.constValue(facts, x.Cond); ok {
+			if isTruthy(k) == x.Not {
+				return nil, true
+			}
+			return Jump{Label: x.Label}, true
+		}
+	case LoadConst:
+		if v, ok := facts[x.Dst]; ok && v.isConst && v.k == x.Kidx && !o.isCell(x.Dst) {
+			// The register already holds this constant.
+			return nil, true
+		}
+	}
+	return instr, changed
+}
+
+func (o *optimizer) constValue(facts valueFacts, r Register) (Constant, bool) {
+	if v, ok := facts[r]; ok && v.isConst {
+		return o.consts.get(v.k), true
+	}
+	return nil, false
+}
+
+// updateFacts updates the facts to take into account the effect of instr.
+func (o *optimizer) updateFacts(facts valueFacts, instr Instruction) {
+	var fact valueFact
+	var hasFact bool
+	switch x := instr.(type) {
+	case LoadConst:
+		fact, hasFact = valueFact{isConst: true, k: x.Kidx}, true
+	case Transform:
+		if x.Op == ops.OpId && !o.isCell(x.Src) && x.Src != x.Dst {
+			if v, ok := facts[x.Src]; ok {
+				fact = v
+			} else {
+				fact = valueFact{src: x.Src}
+			}
+			hasFact = fact.isConst || fact.src != x.Dst
+		} else if k, ok := o.constValue(facts, x.Src); ok {
+			if v, ok := foldUnOp(x.Op, k); ok {
+				fact, hasFact = valueFact{isConst: true, k: o.consts.indexOf(v)}, true
+			}
+		}
+	case Combine:
+		k1, ok1 := o.constValue(facts, x.Lsrc)
+		k2, ok2 := o.constValue(facts, x.Rsrc)
+		if ok1 && ok2 {
+			if v, ok := foldBinOp(x.Op, k1, k2); ok {
+				fact, hasFact = valueFact{isConst: true, k: o.consts.indexOf(v)}, true
+			}
+		}
+	}
+	_, defs := instrRegs(instr)
+	for _, r := range defs {
+		facts.kill(r)
+	}
+	if hasFact {
+		dst := instr.(SetRegInstruction).DestReg()
+		if !o.isCell(dst) {
+			facts[dst] = fact
+		}
+	}
+}
+
+func isTruthy(k Constant) bool {
+	switch x := k.(type) {
+	case NilType:
+		return false
+	case Bool:
+		return bool(x)
+	default:
+		return true
+	}
+}
+
+// foldUnOp returns the result of applying op to k, if it can be computed at
+// compile time without error.
+func foldUnOp(op ops.Op, k Constant) (Constant, bool) {
+	switch op {
+	case ops.OpNot:
+		return Bool(!isTruthy(k)), true
+	case ops.OpNeg:
+		switch x := k.(type) {
+		case Int:
+			return -x, true
+		case Float:
+			return -x, true
+		}
+	case ops.OpBitNot:
+		if x, ok := k.(Int); ok {
+			return ^x, true
+		}
+	case ops.OpLen:
+		if x, ok := k.(String); ok {
+			return Int(len(x)), true
+		}
+	}
+	return nil, false
+}
+
+// foldBinOp returns the result of applying op to k1 and k2, if it can be
+// computed at compile time without error.  Operations whose result depends on
+// conversions between numbers and strings are not folded.
+func foldBinOp(op ops.Op, k1, k2 Constant) (Constant, bool) {
+	switch op {
+	case ops.OpEq:
+		return foldEq(k1, k2)
+	case ops.OpLt, ops.OpLeq:
+		switch x := k1.(type) {
+		case Int:
+			if y, ok := k2.(Int); ok {
+				return Bool(x < y || op == ops.OpLeq && x == y), true
+			}
+		case Float:
+			if y, ok := k2.(Float); ok {
+				return Bool(x < y || op == ops.OpLeq && x == y), true
+			}
+		}
+	case ops.OpConcat:
+		x, ok1 := k1.(String)
+		y, ok2 := k2.(String)
+		if ok1 && ok2 {
+			return x + y, true
+		}
+	case ops.OpAdd, ops.OpSub, ops.OpMul:
+		x, ok1 := k1.(Int)
+		y, ok2 := k2.(Int)
+		if ok1 && ok2 {
+			switch op {
+			case ops.OpAdd:
+				return x + y, true
+			case ops.OpSub:
+				return x - y, true
+			default:
+				return x * y, true
+			}
+		}
+		fx, ok1 := toFloat(k1)
+		fy, ok2 := toFloat(k2)
+		if ok1 && ok2 {
+			switch op {
+			case ops.OpAdd:
+				return fx + fy, true
+			case ops.OpSub:
+				return fx - fy, true
+			default:
+				return fx * fy, true
+			}
+		}
+	case ops.OpDiv:
+		fx, ok1 := toFloat(k1)
+		fy, ok2 := toFloat(k2)
+		if ok1 && ok2 {
+			return fx / fy, true
+		}
+	case ops.OpBitAnd, ops.OpBitOr, ops.OpBitXor:
+		x, ok1 := k1.(Int)
+		y, ok2 := k2.(Int)
+		if ok1 && ok2 {
+			switch op {
+			case ops.OpBitAnd:
+				return x & y, true
+			case ops.OpBitOr:
+				return x | y, true
+			default:
+				return x ^ y, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func foldEq(k1, k2 Constant) (Constant, bool) {
+	switch x := k1.(type) {
+	case Int:
+		switch y := k2.(type) {
+		case Int:
+			return Bool(x == y), true
+		case Float:
+			return nil, false
+		}
+	case Float:
+		switch y := k2.(type) {
+		case Float:
+			return Bool(x == y), true
+		case Int:
+			return nil, false
+		}
+	case String, Bool, NilType:
+	default:
+		return nil, false
+	}
+	switch k2.(type) {
+	case Int, Float, String, Bool, NilType:
+		return Bool(k1 == k2), true
+	default:
+		return nil, false
+	}
+}
+
+func toFloat(k Constant) (Float, bool) {
+	switch x := k.(type) {
+	case Int:
+		return Float(x), true
+	case Float:
+		return x, true
+	default:
+		return 0, false
+	}
+}
@@ -12,13 +12,15 @@ import (
 type instrCompiler struct {
 	*ConstantCompiler
 	*regAllocator
-	line int
+	line  int
+	names ir.OperandNames
 }
 
 var _ ir.InstrProcessor = instrCompiler{}
 
 func (ic instrCompiler) Emit(opcode code.Opcode) {
 	ic.builder.Emit(opcode, ic.line)
+	ic.builder.NameOperands(ic.names[0], ic.names[1])
 }
 
 func (ic instrCompiler) EmitJump(opcode code.Opcode, lbl code.Label) {
@@ -244,6 +246,14 @@ func (ic instrCompiler) ProcessReleaseRegisterInstr(r ir.ReleaseRegister) {
 	ic.releaseRegister(r.Reg)
 }
 
+func (ic instrCompiler) ProcessDeclareLocalInstr(d ir.DeclareLocal) {
+	ic.builder.StartLocal(string(d.Name), ic.codeReg(d.Reg))
+}
+
+func (ic instrCompiler) ProcessEndLocalInstr(e ir.EndLocal) {
+	ic.builder.EndLocal(ic.codeReg(e.Reg))
+}
+
 func (ic instrCompiler) ProcessDeclareLabelInstr(l ir.DeclareLabel) {
 	ic.builder.EmitLabel(code.Label(l.Label))
 }
@@ -67,8 +67,12 @@ func (kc *ConstantCompiler) ProcessCode(c ir.Code) {
 	}
 	for i, instr := range c.Instructions {
 		ic.line = c.Lines[i]
+		if c.OperandNames != nil {
+			ic.names = c.OperandNames[i]
+		}
 		instr.ProcessInstr(ic)
 	}
+	kc.builder.EndAllLocals()
 	end := kc.builder.Offset()
 	kc.addCompiled(code.Code{
 		Name:         c.Name,
@@ -78,6 +82,9 @@ func (kc *ConstantCompiler) ProcessCode(c ir.Code) {
 		CellCount:    int16(len(regAllocator.cells)),
 		UpNames:      c.UpNames,
 		RegCount:     int16(len(regAllocator.regs)),
+
+		LineDefined:     int32(c.LineDefined),
+		LastLineDefined: int32(c.LastLineDefined),
 	})
 }
 
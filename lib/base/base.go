@@ -15,6 +15,18 @@ var LibLoader = packagelib.Loader{
 	Load: Load,
 }
 
+// These functions are shared by all runtimes, so they are declared compliant
+// once rather than each time the library is loaded, which would be a data race
+// when runtimes are created concurrently.
+func init() {
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+
+		ipairsIterator,
+		nextGoFunc,
+	)
+}
+
 func Load(r *rt.Runtime) (rt.Value, func()) {
 	env := r.GlobalEnv()
 	r.SetEnv(env, "_G", rt.TableValue(env))
@@ -24,8 +36,6 @@ func Load(r *rt.Runtime) (rt.Value, func()) {
 	rt.SolemnlyDeclareCompliance(
 		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
 
-		ipairsIterator,
-		nextGoFunc,
 		r.SetEnvGoFunc(env, "assert", assert, 1, true),
 		r.SetEnvGoFunc(env, "error", errorF, 2, false),
 		r.SetEnvGoFunc(env, "getmetatable", getmetatable, 1, false),
@@ -91,7 +101,10 @@ func loadChunk(t *rt.Thread, args []rt.Value) (chunk []byte, chunkName string, e
 	var reader io.Reader
 	if len(args) == 0 {
 		chunkName = "stdin"
-		reader = os.Stdin
+		reader, _, _ = t.Stdio()
+		if reader == nil {
+			reader = os.Stdin
+		}
 	} else {
 		var ok bool
 		chunkName, ok = args[0].TryString()
@@ -3,6 +3,7 @@ package base
 import (
 	"bytes"
 	"errors"
+	"strings"
 
 	rt "github.com/arnodel/golua/runtime"
 )
@@ -15,7 +16,7 @@ func load(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	}
 	var (
 		chunk     []byte
-		chunkName = "chunk"
+		chunkName string
 		chunkMode = "bt"
 		chunkEnv  = rt.TableValue(t.GlobalEnv())
 		next      = c.Next()
@@ -53,6 +54,9 @@ func load(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 			// Use CPU as well as memory, but not much
 			t.LinearRequire(10, uint64(len(xs)))
 			chunk = []byte(xs)
+			if chunkName == "" {
+				chunkName = stringChunkName(xs)
+			}
 		case rt.FunctionType:
 			var buf bytes.Buffer
 			for {
@@ -81,6 +85,9 @@ func load(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 				buf.WriteString(bitString)
 			}
 			chunk = buf.Bytes()
+			if chunkName == "" {
+				chunkName = "chunk"
+			}
 		default:
 			return nil, errors.New("#1 must be a string or function")
 		}
@@ -96,3 +103,24 @@ func load(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	}
 	return next, nil
 }
+
+// Maximum length of the part of the chunk in the name of a chunk loaded from a
+// string, so that the name is no longer than maxChunkNameLen.
+const maxStringChunkLen = maxChunkNameLen - len(`[string "..."]`)
+
+// Returns the name of a chunk loaded from the string chunk when no name is
+// given, which is made from its first line as in the reference implementation,
+// e.g. [string "return x + 1"].
+func stringChunkName(chunk string) string {
+	line := chunk
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	if len(line) == len(chunk) && len(line) <= maxStringChunkLen {
+		return `[string "` + line + `"]`
+	}
+	if len(line) > maxStringChunkLen {
+		line = line[:maxStringChunkLen]
+	}
+	return `[string "` + line + `..."]`
+}
@@ -0,0 +1,83 @@
+local gcmeta = {__gc = function(x) print("gc", x.name) end}
+
+local function make(name)
+    return setmetatable({name=name}, gcmeta)
+end
+
+-- Unreachable values are finalized by collectgarbage()
+do
+    local function f()
+        make("a")
+    end
+    f()
+    collectgarbage()
+    --> =gc	a
+end
+
+-- Finalizers run in reverse order of marking
+do
+    local function f()
+        make("x")
+        make("y")
+        make("z")
+    end
+    f()
+    collectgarbage()
+    --> =gc	z
+    --> =gc	y
+    --> =gc	x
+end
+
+-- Reachable values are not finalized
+kept = make("kept")
+collectgarbage()
+print("after collect")
+--> =after collect
+
+-- Only metatables with a __gc field at the time of setmetatable mark the value
+do
+    local meta = {}
+    local function f()
+        setmetatable({name="late"}, meta)
+    end
+    f()
+    meta.__gc = gcmeta.__gc
+    collectgarbage()
+    print("nothing")
+    --> =nothing
+end
+
+-- Errors in finalizers are turned into warnings
+warn("@on")
+do
+    local function f()
+        setmetatable({}, {__gc = function() error("oops", 0) end})
+    end
+    f()
+    collectgarbage()
+    --> =Test warning: error in __gc metamethod (oops)
+end
+warn("@off")
+
+-- The object can be resurrected by its finalizer
+do
+    local saved
+    local function f()
+        setmetatable({name="resurrected"}, {__gc = function(x) saved = x end})
+    end
+    f()
+    collectgarbage()
+    print(saved.name)
+    --> =resurrected
+end
+
+-- Finalizers can be non functions with a __call metamethod
+do
+    local callable = setmetatable({}, {__call = function(_, x) print("called", x.name) end})
+    local function f()
+        setmetatable({name="c"}, {__gc = callable})
+    end
+    f()
+    collectgarbage()
+    --> =called	c
+end
@@ -2,8 +2,10 @@ package debuglib
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
+	"github.com/arnodel/golua/code"
 	"github.com/arnodel/golua/lib/packagelib"
 	rt "github.com/arnodel/golua/runtime"
 )
@@ -24,8 +26,14 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 
 		r.SetEnvGoFunc(pkg, "gethook", gethook, 1, false),
 		r.SetEnvGoFunc(pkg, "getinfo", getinfo, 3, false),
+		r.SetEnvGoFunc(pkg, "getlocal", getlocal, 3, false),
+		r.SetEnvGoFunc(pkg, "setlocal", setlocal, 4, false),
+		r.SetEnvGoFunc(pkg, "getmetatable", getmetatable, 1, false),
+		r.SetEnvGoFunc(pkg, "getregistry", getregistry, 0, false),
 		r.SetEnvGoFunc(pkg, "getupvalue", getupvalue, 2, false),
 		r.SetEnvGoFunc(pkg, "setupvalue", setupvalue, 3, false),
+		r.SetEnvGoFunc(pkg, "getuservalue", getuservalue, 2, false),
+		r.SetEnvGoFunc(pkg, "setuservalue", setuservalue, 3, false),
 		r.SetEnvGoFunc(pkg, "upvaluejoin", upvaluejoin, 4, false),
 		r.SetEnvGoFunc(pkg, "setmetatable", setmetatable, 2, false),
 		r.SetEnvGoFunc(pkg, "sethook", sethook, 4, false),
@@ -44,7 +52,8 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 		thread *rt.Thread
 		idx    int64
 		cont   rt.Cont
-		what   string
+		fn     rt.Value
+		what   = "flnSrtu"
 		fIdx   int
 	)
 	thread, ok := c.Arg(0).TryThread()
@@ -60,6 +69,7 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	case rt.IntType:
 		idx = arg.AsInt()
 	case rt.FunctionType:
+		fn = arg
 		term := rt.NewTerminationWith(c, 0, false)
 		cont = arg.AsCallable().Continuation(t, term)
 	case rt.FloatType:
@@ -71,30 +81,305 @@ func getinfo(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	default:
 		return nil, errors.New("f should be an integer or function")
 	}
-	if cont == nil {
-		cont = thread.CurrentCont()
+	if c.NArgs() > fIdx+1 {
+		var err error
+		what, err = c.StringArg(fIdx + 1)
+		if err != nil {
+			return nil, err
+		}
+		// As in Lua, ">" is not allowed as the function is given by f.
+		if strings.Trim(what, "SlutnfrL") != "" {
+			return nil, fmt.Errorf("bad argument #%d to 'getinfo' (invalid option)", fIdx+2)
+		}
 	}
-	for idx > 0 && cont != nil {
-		cont = cont.Parent()
-		idx--
+	if cont == nil {
+		cont = getLevel(thread, idx)
 	}
-	// TODO: support what arg
-	_ = what
 	next := c.Next()
 	if cont == nil {
 		t.Push1(next, rt.NilValue)
 	} else if info := cont.DebugInfo(); info == nil {
 		t.Push1(next, rt.NilValue)
 	} else {
-		res := rt.NewTable()
-		t.SetEnv(res, "name", rt.StringValue(info.Name))
-		t.SetEnv(res, "currentline", rt.IntValue(int64(info.CurrentLine)))
-		t.SetEnv(res, "source", rt.StringValue(info.Source))
-		t.Push1(next, rt.TableValue(res))
+		var callName code.VarName
+		if fn.IsNil() {
+			fn = contFunction(cont)
+			callName = callSiteName(cont)
+		}
+		t.Push1(next, rt.TableValue(infoTable(t.Runtime, what, info, fn, callName, isTailCall(cont))))
 	}
 	return next, nil
 }
 
+// Returns the continuation at the given level in the call stack of thread (0
+// being the current function).
+func getLevel(thread *rt.Thread, level int64) rt.Cont {
+	cont := thread.CurrentCont()
+	for level > 0 && cont != nil {
+		cont = cont.Parent()
+		level--
+	}
+	return cont
+}
+
+// Returns the function that cont is running.
+func contFunction(cont rt.Cont) rt.Value {
+	if lc := rt.LuaContOf(cont); lc != nil {
+		return rt.FunctionValue(lc.Closure)
+	}
+	if gc, ok := cont.(*rt.GoCont); ok {
+		return rt.FunctionValue(gc.GoFunction)
+	}
+	return rt.NilValue
+}
+
+// Returns the name of the variable that the function running in cont was read
+// from by its caller, if it is known (the zero value otherwise).
+func callSiteName(cont rt.Cont) code.VarName {
+	if isTailCall(cont) {
+		// The caller is gone
+		return code.VarName{}
+	}
+	caller := rt.LuaContOf(cont.Parent())
+	if caller == nil {
+		return code.VarName{}
+	}
+	name, _ := caller.CalledName()
+	return name
+}
+
+// Returns true if the function running in cont was called with a tail call.
+func isTailCall(cont rt.Cont) bool {
+	lc, ok := cont.(*rt.LuaCont)
+	return ok && lc.IsTailCall()
+}
+
+// Returns the table returned by debug.getinfo, with the fields selected by
+// what (see the Lua reference manual).  If callName is not zero, it is the name
+// the function was called by.  A function called with a tail call has no name.
+func infoTable(r *rt.Runtime, what string, info *rt.DebugInfo, fn rt.Value, callName code.VarName, tailCall bool) *rt.Table {
+	res := rt.NewTable()
+	clos, isLua := fn.TryClosure()
+	for _, opt := range what {
+		switch opt {
+		case 'S':
+			r.SetEnv(res, "source", rt.StringValue(info.Source))
+			r.SetEnv(res, "short_src", rt.StringValue(shortSource(info.Source)))
+			if isLua {
+				first, last := clos.Code.LineRange()
+				r.SetEnv(res, "linedefined", rt.IntValue(int64(first)))
+				r.SetEnv(res, "lastlinedefined", rt.IntValue(int64(last)))
+				if first == 0 {
+					r.SetEnv(res, "what", rt.StringValue("main"))
+				} else {
+					r.SetEnv(res, "what", rt.StringValue("Lua"))
+				}
+			} else {
+				r.SetEnv(res, "linedefined", rt.IntValue(-1))
+				r.SetEnv(res, "lastlinedefined", rt.IntValue(-1))
+				r.SetEnv(res, "what", rt.StringValue("C"))
+			}
+		case 'l':
+			r.SetEnv(res, "currentline", rt.IntValue(int64(info.CurrentLine)))
+		case 'u':
+			nups, nparams, isVararg := 0, 0, true
+			if isLua {
+				nups = int(clos.Code.UpvalueCount)
+				nparams, isVararg = clos.Code.ParamInfo()
+			}
+			r.SetEnv(res, "nups", rt.IntValue(int64(nups)))
+			r.SetEnv(res, "nparams", rt.IntValue(int64(nparams)))
+			r.SetEnv(res, "isvararg", rt.BoolValue(isVararg))
+		case 'n':
+			if tailCall {
+				// The caller is gone so the name is not known
+				r.SetEnv(res, "namewhat", rt.StringValue(""))
+			} else if callName.IsZero() {
+				r.SetEnv(res, "name", rt.StringValue(info.Name))
+				r.SetEnv(res, "namewhat", rt.StringValue(""))
+			} else {
+				r.SetEnv(res, "name", rt.StringValue(callName.Name))
+				r.SetEnv(res, "namewhat", rt.StringValue(callName.Kind.String()))
+			}
+		case 't':
+			r.SetEnv(res, "istailcall", rt.BoolValue(tailCall))
+		case 'f':
+			r.SetEnv(res, "func", fn)
+		case 'L':
+			if isLua {
+				lines := rt.NewTable()
+				for _, l := range clos.Code.ActiveLines() {
+					r.SetTable(lines, rt.IntValue(int64(l)), rt.BoolValue(true))
+				}
+				r.SetEnv(res, "activelines", rt.TableValue(lines))
+			}
+		}
+	}
+	return res
+}
+
+// Maximum length of short_src, as in the reference implementation.
+const maxShortSourceLen = 60
+
+// Returns a version of source suitable for messages.  As in Lua, a source
+// starting with '=' or '@' is shown without it.  Other sources are names given
+// by the host program (e.g. the name of a script file), or names made by load
+// for chunks loaded from a string, which already have the [string "..."] form.
+func shortSource(source string) string {
+	if source != "" && (source[0] == '=' || source[0] == '@') {
+		source = source[1:]
+	}
+	if len(source) > maxShortSourceLen {
+		source = "..." + source[len(source)-maxShortSourceLen+3:]
+	}
+	return source
+}
+
+func getlocal(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	thread, argOffset, err := threadArg(t, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.CheckNArgs(argOffset + 2); err != nil {
+		return nil, err
+	}
+	n, err := c.IntArg(argOffset + 1)
+	if err != nil {
+		return nil, err
+	}
+	next := c.Next()
+	if clos, ok := c.Arg(argOffset).TryClosure(); ok {
+		// Only parameter names are available for functions
+		name, ok := clos.Code.ParamName(int(n))
+		if !ok {
+			return c.PushingNext1(t.Runtime, rt.NilValue), nil
+		}
+		return c.PushingNext1(t.Runtime, rt.StringValue(name)), nil
+	}
+	lc, err := luaContAtLevel(thread, c, argOffset)
+	if err != nil {
+		return nil, err
+	}
+	if lc != nil {
+		if name, val, ok := lc.GetLocal(int(n)); ok {
+			t.Push(next, rt.StringValue(name), val)
+			return next, nil
+		}
+	}
+	t.Push1(next, rt.NilValue)
+	return next, nil
+}
+
+func setlocal(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	thread, argOffset, err := threadArg(t, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.CheckNArgs(argOffset + 3); err != nil {
+		return nil, err
+	}
+	n, err := c.IntArg(argOffset + 1)
+	if err != nil {
+		return nil, err
+	}
+	lc, err := luaContAtLevel(thread, c, argOffset)
+	if err != nil {
+		return nil, err
+	}
+	if lc != nil {
+		if name, ok := lc.SetLocal(int(n), c.Arg(argOffset+2)); ok {
+			return c.PushingNext1(t.Runtime, rt.StringValue(name)), nil
+		}
+	}
+	return c.PushingNext1(t.Runtime, rt.NilValue), nil
+}
+
+// Returns the thread given as the first argument if there is one (and the
+// offset of the next argument), otherwise the current thread.
+func threadArg(t *rt.Thread, c *rt.GoCont) (*rt.Thread, int, error) {
+	if c.NArgs() > 0 {
+		if thread, ok := c.Arg(0).TryThread(); ok {
+			return thread, 1, nil
+		}
+	}
+	return t, 0, nil
+}
+
+// Returns the Lua continuation at the level given by the argument n, or nil if
+// the function at that level is not a Lua function.  It is an error if there is
+// no such level.
+func luaContAtLevel(thread *rt.Thread, c *rt.GoCont, n int) (*rt.LuaCont, error) {
+	level, err := c.IntArg(n)
+	if err != nil {
+		return nil, err
+	}
+	cont := getLevel(thread, level)
+	if level < 0 || cont == nil || cont.DebugInfo() == nil {
+		return nil, fmt.Errorf("#%d out of range", n+1)
+	}
+	return rt.LuaContOf(cont), nil
+}
+
+func getregistry(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	return c.PushingNext1(t.Runtime, rt.TableValue(t.RegistryTable())), nil
+}
+
+func getmetatable(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	meta := t.RawMetatable(c.Arg(0))
+	if meta == nil {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	return c.PushingNext1(t.Runtime, rt.TableValue(meta)), nil
+}
+
+// Userdata have a single user value in golua, so n must be 1 (the default).
+func getuservalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	n, err := userValueIndex(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := c.Arg(0).TryUserData()
+	if !ok {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	if n != 1 {
+		return c.PushingNext(t.Runtime, rt.NilValue, rt.BoolValue(false)), nil
+	}
+	return c.PushingNext(t.Runtime, u.UserValue(), rt.BoolValue(true)), nil
+}
+
+func setuservalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.CheckNArgs(2); err != nil {
+		return nil, err
+	}
+	u, err := c.UserDataArg(0)
+	if err != nil {
+		return nil, err
+	}
+	n, err := userValueIndex(c, 2)
+	if err != nil {
+		return nil, err
+	}
+	if n != 1 {
+		return c.PushingNext1(t.Runtime, rt.NilValue), nil
+	}
+	u.SetUserValue(c.Arg(1))
+	return c.PushingNext1(t.Runtime, c.Arg(0)), nil
+}
+
+func userValueIndex(c *rt.GoCont, i int) (int64, error) {
+	if c.NArgs() <= i || c.Arg(i).IsNil() {
+		return 1, nil
+	}
+	return c.IntArg(i)
+}
+
 func getupvalue(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.CheckNArgs(2); err != nil {
 		return nil, err
@@ -62,3 +62,104 @@ foo(co, 1.0)
 
 print(pcall(foo, co, 1.5))
 --> ~false\t.*
+
+-- More fields
+
+local function bar(x, y, ...)
+    local z = x
+    return z
+end
+
+do
+    local i = debug.getinfo(bar)
+    print(i.what, i.short_src, i.linedefined, i.lastlinedefined)
+    --> =Lua	luatest	68	71
+    print(i.nups, i.nparams, i.isvararg, i.func == bar)
+    --> =0	2	true	true
+    print(i.activelines)
+    --> =nil
+end
+
+do
+    local i = debug.getinfo(bar, "L")
+    local lines = {}
+    for l in pairs(i.activelines) do
+        lines[#lines + 1] = l
+    end
+    table.sort(lines)
+    print(table.concat(lines, ","))
+    --> =68,69,70,71
+    print(i.what, i.currentline)
+    --> =nil	nil
+end
+
+do
+    local i = debug.getinfo(print)
+    print(i.what, i.linedefined, i.nparams, i.isvararg, i.func == print)
+    --> =C	-1	0	true	true
+end
+
+do
+    local i = debug.getinfo(1, "Sf")
+    print(i.what, i.linedefined, i.func ~= nil)
+    --> =main	0	true
+    local function f()
+        return debug.getinfo(2, "f").func
+    end
+    print(f() == i.func)
+    --> =true
+end
+
+print(pcall(debug.getinfo, 1, "x"))
+--> ~false\t.*bad argument #2 to 'getinfo' \(invalid option\)
+
+print(pcall(debug.getinfo, 1, ">"))
+--> ~false\t.*bad argument #2 to 'getinfo' \(invalid option\)
+
+print(pcall(debug.getinfo, bar, ">S"))
+--> ~false\t.*bad argument #2 to 'getinfo' \(invalid option\)
+
+-- Names of functions as they were called
+
+local function callName()
+    local i = debug.getinfo(2, "nt")
+    return tostring(i.name) .. " " .. i.namewhat .. " " .. tostring(i.istailcall)
+end
+
+local function localFunc() return callName(), 1 end
+print(localFunc())
+--> =localFunc local false	1
+
+function globalFunc() return callName(), 1 end
+print(globalFunc())
+--> =globalFunc global false	1
+
+local obj = {}
+function obj.field() return callName(), 1 end
+function obj:method() return callName(), 1 end
+print(obj.field())
+--> =field field false	1
+print(obj:method())
+--> =method method false	1
+
+local function upvalueCaller() return localFunc(), 1 end
+print(upvalueCaller())
+--> =localFunc upvalue false	1
+
+-- The caller of a function called with a tail call is gone
+local function tailCalled() return callName(), 1 end
+local function tailCaller() return tailCalled() end
+print(tailCaller())
+--> =nil  true	1
+
+-- Chunks loaded from strings are named after their first line
+print(load("return debug.getinfo(1, 'S').short_src")())
+--> =[string "return debug.getinfo(1, 'S').short_src"]
+print(load("return debug.getinfo(1, 'S').short_src\n-- second line")())
+--> =[string "return debug.getinfo(1, 'S').short_src..."]
+print(load("return debug.getinfo(1, 'S').short_src", "=named")())
+--> =named
+print(load("return debug.getinfo(1, 'S').short_src", "@file.lua")())
+--> =file.lua
+print(pcall(load("error('oops')")))
+--> =false	[string "error('oops')"]:1: oops
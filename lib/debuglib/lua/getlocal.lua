@@ -0,0 +1,145 @@
+local function f(a, b, ...)
+    local c = a + b
+    do
+        local d = "inner"
+        for i = 1, 2 do
+            local n = 1
+            while debug.getlocal(1, n) do
+                print(n, debug.getlocal(1, n))
+                n = n + 1
+            end
+            print(debug.getlocal(1, -1))
+            print(debug.getlocal(1, -3))
+            break
+        end
+    end
+    return c
+end
+
+f(1, 2, "x", "y")
+--> =1	a	1
+--> =2	b	2
+--> =3	c	3
+--> =4	d	inner
+--> =5	i	1
+--> =6	n	6
+--> =(vararg)	x
+--> =nil
+
+-- Locals go out of scope
+do
+    local x = 1
+end
+local y = 2
+print(debug.getlocal(1, 1), debug.getlocal(1, 2))
+--> =f	y	2
+
+-- Parameter names of a function
+print(debug.getlocal(f, 1), debug.getlocal(f, 2), debug.getlocal(f, 3))
+--> =a	b	nil
+
+-- Looking at locals of the caller
+local function getcaller(name)
+    local n = 1
+    while true do
+        local lname, val = debug.getlocal(2, n)
+        if lname == nil then
+            return
+        elseif lname == name then
+            return val
+        end
+        n = n + 1
+    end
+end
+
+local function g()
+    local secret = "s3cr3t"
+    local v = getcaller("secret")
+    return v
+end
+print(g())
+--> =s3cr3t
+
+-- setlocal
+local function h(v)
+    local a = 1
+    local function set()
+        print(debug.setlocal(2, 2, v))
+    end
+    set()
+    return a
+end
+print(h(42))
+--> =a
+--> =42
+
+print(debug.setlocal(1, 100, 0))
+--> =nil
+
+-- setlocal for varargs
+local function va(...)
+    debug.setlocal(1, -2, "changed")
+    return ...
+end
+print(va(1, 2, 3))
+--> =1	changed	3
+
+-- Go functions have no locals
+print(debug.getlocal(0, 1))
+--> =nil
+
+print(pcall(debug.getlocal, 100, 1))
+--> ~false\t.*#1 out of range
+
+-- Threads
+local co = coroutine.create(function(x)
+    local y = x * 2
+    coroutine.yield()
+end)
+coroutine.resume(co, 5)
+print(debug.getlocal(co, 1, 1))
+--> =x	5
+print(debug.getlocal(co, 1, 2))
+--> =y	10
+print(debug.setlocal(co, 1, 2, 11))
+--> =y
+print(debug.getlocal(co, 1, 2))
+--> =y	11
+
+-- Other functions
+print(debug.getregistry() ~= nil, type(debug.getregistry()))
+--> =true	table
+
+print(debug.getmetatable("x").__index == string)
+--> =true
+local t = setmetatable({}, {__metatable = "protected"})
+print(getmetatable(t), type(debug.getmetatable(t)))
+--> =protected	table
+print(debug.getmetatable({}))
+--> =nil
+
+print(debug.getuservalue({}))
+--> =nil
+print(pcall(debug.setuservalue, {}, 1))
+--> ~false\t.*
+
+print(debug.setuservalue(io.stdout, "hello") == io.stdout)
+--> =true
+print(debug.getuservalue(io.stdout))
+--> =hello	true
+print(debug.getuservalue(io.stdout, 2))
+--> =nil	false
+print(debug.setuservalue(io.stdout, "x", 2))
+--> =nil
+
+-- Debug information survives string.dump
+local function dumped(p, q)
+    local r = p .. q
+    local name, val = debug.getlocal(1, 3)
+    return name, val
+end
+local undumped = load(string.dump(dumped))
+print(debug.getlocal(undumped, 2), undumped("a", "b"))
+--> =q	r	ab
+print(debug.getinfo(undumped, "S").linedefined)
+--> =136
@@ -8,6 +8,7 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"runtime"
 	"strings"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/arnodel/golua/safeio"
 	"github.com/arnodel/golua/scanner"
 	"github.com/arnodel/golua/token"
+	"github.com/arnodel/golua/vfs"
 )
 
 const (
@@ -29,11 +31,16 @@ var (
 	errFileAlreadyClosed = errors.New("file already closed")
 	errInvalidBufferMode = errors.New("invalid buffer mode")
 	errInvalidBufferSize = errors.New("invalid buffer size")
+	errIllegalSeek       = errors.New("illegal seek")
 )
 
-// A File wraps an os.File for manipulation by iolib.
+// A File wraps a vfs.File (e.g. an *os.File) for manipulation by iolib.
 type File struct {
-	file   *os.File
+	file   vfs.File
+	cmd    *exec.Cmd    // Set if the file is a pipe to a process (see PopenFile)
+	flush  func() error // Writes the collected output of cmd (see safeio.SetCommandStdio)
+	stop   func()       // Stops watching for interrupts of cmd (see safeio.KillOnInterrupt)
+	fsys   vfs.FS       // Set if the file is temporary, to remove it (see TempFile)
 	status fileStatus
 	reader bufReader
 	writer bufWriter
@@ -47,8 +54,8 @@ const (
 	statusNotClosable
 )
 
-// NewFile returns a new *File from an *os.File.
-func NewFile(file *os.File, options int) *File {
+// NewFile returns a new *File from a vfs.File.
+func NewFile(file vfs.File, options int) *File {
 	f := &File{file: file}
 	// TODO: find out if there is mileage in having unbuffered readers.
 	if true || options&bufferedRead != 0 {
@@ -100,7 +107,7 @@ func OpenFile(r *rt.Runtime, name, mode string) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewFile(f, options), nil
+	return NewFile(withMemQuota(r, f), options), nil
 }
 
 // TempFile tries to make a temporary file, and if successful schedules the file
@@ -110,10 +117,74 @@ func TempFile(r *rt.Runtime) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
-	ff := NewFile(f, bufferedRead|bufferedWrite|tempFile)
+	ff := NewFile(withMemQuota(r, f), bufferedRead|bufferedWrite|tempFile)
+	ff.fsys = r.FS()
 	return ff, nil
 }
 
+// PopenFile starts a process running cmdline with the system shell and returns
+// a File connected to its standard output if mode is "r", or to its standard
+// input if mode is "w".  The other standard streams of the process
+// are those of the runtime (see safeio.SetCommandStdio).  Closing the file
+// waits for the process to terminate.  The process is killed if the Go context
+// of the current runtime context is done before that.
+func PopenFile(r *rt.Runtime, cmdline, mode string) (*File, error) {
+	if mode != "r" && mode != "w" {
+		return nil, errors.New("invalid mode")
+	}
+	cmd, err := safeio.ShellCommand(r, cmdline)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	var f, childEnd *os.File
+	if mode == "r" {
+		f, childEnd = pr, pw
+		cmd.Stdout = pw
+	} else {
+		f, childEnd = pw, pr
+		cmd.Stdin = pr
+	}
+	flush := safeio.SetCommandStdio(r, cmd)
+	err = cmd.Start()
+	childEnd.Close()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	ff := NewFile(f, bufferedRead|bufferedWrite)
+	ff.cmd = cmd
+	ff.flush = flush
+	ff.stop = safeio.KillOnInterrupt(r, cmd, f)
+	return ff, nil
+}
+
+// A memQuotaFile is a file held in memory whose growth is counted against the
+// memory quota of a runtime.
+type memQuotaFile struct {
+	vfs.MemoryFile
+	rt *rt.Runtime // Set to nil when the file is no longer used by the runtime
+}
+
+// If f is held in memory, returns a file which counts the memory taken by
+// writing to f against the memory quota of r, otherwise returns f.
+func withMemQuota(r *rt.Runtime, f vfs.File) vfs.File {
+	if mf, ok := f.(vfs.MemoryFile); ok {
+		return &memQuotaFile{MemoryFile: mf, rt: r}
+	}
+	return f
+}
+
+func (q *memQuotaFile) Write(b []byte) (int, error) {
+	if q.rt != nil {
+		q.rt.RequireBytes(int(q.WriteGrowth(len(b))))
+	}
+	return q.MemoryFile.Write(b)
+}
+
 // FileArg turns a continuation argument into a *File.
 func FileArg(c *rt.GoCont, n int) (*File, error) {
 	f, ok := ValueToFile(c.Arg(n))
@@ -146,7 +217,14 @@ func (f *File) IsClosable() bool {
 	return f.status&statusNotClosable == 0
 }
 
-// Close attempts to close the file, returns an error if not successful.
+// IsProcess returns true if the file is a pipe to a process (see PopenFile).
+func (f *File) IsProcess() bool {
+	return f.cmd != nil
+}
+
+// Close attempts to close the file, returns an error if not successful.  If the
+// file is a pipe to a process, it waits for the process to terminate and
+// returns the error returned by (*exec.Cmd).Wait if closing was successful.
 func (f *File) Close() error {
 	if !f.IsClosable() {
 		// Lua doesn't return a Lua error, so wrap this in a PathError
@@ -164,7 +242,18 @@ func (f *File) Close() error {
 	errFlush := f.writer.Flush()
 	err := f.file.Close()
 	if err == nil {
-		return errFlush
+		err = errFlush
+	}
+	if f.cmd != nil {
+		errWait := f.cmd.Wait()
+		f.stop()
+		errOutput := f.flush()
+		if err == nil {
+			err = errOutput
+		}
+		if err == nil {
+			err = errWait
+		}
 	}
 	return err
 }
@@ -174,6 +263,10 @@ func (f *File) Flush() error {
 	if err := f.writer.Flush(); err != nil {
 		return err
 	}
+	if f.cmd != nil {
+		// Pipes cannot be synced
+		return nil
+	}
 	return f.file.Sync()
 }
 
@@ -263,6 +356,9 @@ func (f *File) WriteString(s string) error {
 
 // Seek seeks from the file.
 func (f *File) Seek(offset int64, whence int) (n int64, err error) {
+	if f.cmd != nil {
+		return 0, errIllegalSeek
+	}
 	err = f.writer.Flush()
 	if err != nil {
 		return
@@ -320,10 +416,31 @@ func (f *File) Name() string {
 
 // Best effort to flush and close files when they are no longer accessible.
 func (f *File) cleanup() {
+	if f.cmd != nil {
+		if !f.IsClosed() {
+			// Reap the process so it does not linger as a zombie.  This
+			// must not block finalizers until the process terminates and
+			// its output can no longer be written safely, so it is
+			// discarded.
+			f.status |= statusClosed
+			_ = f.writer.Flush()
+			_ = f.file.Close()
+			go func() {
+				_ = f.cmd.Wait()
+				f.stop()
+			}()
+		}
+		return
+	}
+	if q, ok := f.file.(*memQuotaFile); ok {
+		// This runs in the goroutine dedicated to Go finalizers, where the
+		// runtime's quotas cannot be used.
+		q.rt = nil
+	}
 	if !f.IsClosed() {
 		f.Close()
 	}
 	if f.IsTemp() {
-		_ = os.Remove(f.Name())
+		_ = f.fsys.Remove(f.Name())
 	}
 }
@@ -0,0 +1,35 @@
+package iolib
+
+import (
+	"bytes"
+	goruntime "runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func TestPopenFileCleanupReapsProcess(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("uses a unix shell")
+	}
+	r := rt.New(new(bytes.Buffer))
+	f, err := PopenFile(r, "echo hello", "r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := f.cmd.Process
+
+	// This is what happens when the file is garbage collected without being
+	// closed.
+	f.cleanup()
+	deadline := time.Now().Add(5 * time.Second)
+	// A zombie process can still be signalled, a reaped one cannot.
+	for proc.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("process was not reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -61,9 +61,10 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		stdinOpts |= bufferedRead
 	}
 
-	stdinFile := NewFile(os.Stdin, stdinOpts)
-	stdoutFile := NewFile(os.Stdout, stdoutOpts)
-	stderrFile := NewFile(os.Stderr, stderrOpts)
+	stdinStream, stdoutStream, stderrStream := r.Stdio()
+	stdinFile := NewFile(stdFile("<stdin>", stdinStream, nil, os.Stdin), stdinOpts)
+	stdoutFile := NewFile(stdFile("<stdout>", nil, stdoutStream, os.Stdout), stdoutOpts)
+	stderrFile := NewFile(stdFile("<stderr>", nil, stderrStream, os.Stderr), stderrOpts)
 	// This is not a good pattern - it has to do for now.
 	if r.Stdout == nil {
 		r.Stdout = stdoutFile.writer
@@ -91,7 +92,7 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "lines", iolines, 1, true),
 		r.SetEnvGoFunc(pkg, "open", open, 2, false),
 		r.SetEnvGoFunc(pkg, "output", output, 1, false),
-		// TODO: popen,
+		r.SetEnvGoFunc(pkg, "popen", popen, 2, false),
 		r.SetEnvGoFunc(pkg, "read", ioread, 0, true),
 		r.SetEnvGoFunc(pkg, "tmpfile", tmpfile, 0, false),
 		r.SetEnvGoFunc(pkg, "write", iowrite, 0, true),
@@ -151,6 +152,9 @@ func ioclose(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 			return nil, err
 		}
 	}
+	if f.IsProcess() && !f.IsClosed() {
+		return t.ProcessExitStatus(c.Next(), f.Close())
+	}
 	return pushingNextIoResult(t.Runtime, c, f.Close())
 }
 
@@ -359,6 +363,29 @@ func open(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext(t.Runtime, rt.UserDataValue(u)), nil
 }
 
+func popen(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if err := c.Check1Arg(); err != nil {
+		return nil, err
+	}
+	cmdline, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	mode := "r"
+	if c.NArgs() >= 2 {
+		mode, err = c.StringArg(1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, ioErr := PopenFile(t.Runtime, cmdline, mode)
+	if ioErr != nil {
+		return pushingNextIoResult(t.Runtime, c, ioErr)
+	}
+	u := newFileUserData(f, getIoData(t.Runtime).metatable)
+	return c.PushingNext(t.Runtime, rt.UserDataValue(u)), nil
+}
+
 func typef(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if err := c.Check1Arg(); err != nil {
 		return nil, err
@@ -0,0 +1,56 @@
+-- Reading the output of a command
+do
+    local f = io.popen("echo hello")
+    print(io.type(f))
+    --> =file
+    print(f:read("l"))
+    --> =hello
+    print(f:close())
+    --> =true	exit	0
+    print(pcall(f.close, f))
+    --> ~false\t.*file already closed
+end
+
+-- Writing to the input of a command
+do
+    local f = io.popen("sort > files/popentest.txt", "w")
+    f:write("pear\n", "apple\n")
+    print(f:close())
+    --> =true	exit	0
+    for line in io.lines("files/popentest.txt") do
+        print(line)
+    end
+    --> =apple
+    --> =pear
+end
+
+-- The output of a command run in "w" mode goes to the runtime's stdout
+do
+    print("before")
+    local f = io.popen("cat", "w")
+    f:write("hello\n")
+    f:close()
+    print("after")
+    --> =before
+    --> =hello
+    --> =after
+end
+
+-- Closing reports the exit status
+do
+    print(io.popen("exit 3"):close())
+    --> =nil	exit	3
+    print(io.close(io.popen("exit 1")))
+    --> =nil	exit	1
+end
+
+-- Pipes cannot be seeked
+do
+    local f = io.popen("echo hello")
+    print(f:seek("set"))
+    --> =nil	illegal seek
+    f:close()
+end
+
+print(pcall(io.popen, "echo hello", "rw"))
+--> ~false\t.*invalid mode
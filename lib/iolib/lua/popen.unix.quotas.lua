@@ -0,0 +1,30 @@
+-- Reading the output of a command is accounted for like reading a file, so
+-- streaming a large output does not exhaust the memory quota.
+do
+    local f = io.open("files/popentest.txt", "w")
+    for i = 1, 20000 do
+        f:write(("x"):rep(99), "\n")
+    end
+    f:close()
+
+    local function count(lines)
+        local n = 0
+        for line in lines do
+            n = n + #line
+        end
+        return n
+    end
+
+    print(runtime.callcontext({kill={memory=200000}}, function()
+        return count(io.lines("files/popentest.txt"))
+    end))
+    --> =done	1980000
+
+    print(runtime.callcontext({kill={memory=200000}}, function()
+        local p = io.popen("cat files/popentest.txt")
+        local n = count(p:lines())
+        p:close()
+        return n
+    end))
+    --> =done	1980000
+end
@@ -43,3 +43,9 @@ end)
 
 print(io.open("files/writetest-safeio.txt", "r"):read("a"))
 --> =some text
+
+-- Commands cannot be run
+runtime.callcontext({flags="iosafe"}, function()
+    print(pcall(io.popen, "echo hello"))
+    --> ~false\t.*: safeio: operation not allowed
+end)
@@ -0,0 +1,65 @@
+package iolib
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/arnodel/golua/vfs"
+)
+
+// A streamFile is a vfs.File which reads from or writes to a stream.  It is
+// used for standard files which are not those of the process (see
+// rt.WithStdio).
+type streamFile struct {
+	name string
+	r    io.Reader // nil if not readable
+	w    io.Writer // nil if not writable
+}
+
+var _ vfs.File = (*streamFile)(nil)
+
+var errBadFileDescriptor = errors.New("bad file descriptor")
+
+// Returns a vfs.File for the stream r or w, or def if the stream is nil.
+func stdFile(name string, r io.Reader, w io.Writer, def *os.File) vfs.File {
+	if r == nil && w == nil {
+		return def
+	}
+	return &streamFile{name: name, r: r, w: w}
+}
+
+func (f *streamFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, errBadFileDescriptor
+	}
+	return f.r.Read(p)
+}
+
+func (f *streamFile) Write(p []byte) (int, error) {
+	if f.w == nil {
+		return 0, errBadFileDescriptor
+	}
+	return f.w.Write(p)
+}
+
+func (f *streamFile) Seek(int64, int) (int64, error) {
+	return 0, errIllegalSeek
+}
+
+func (f *streamFile) Stat() (fs.FileInfo, error) {
+	return nil, errors.New("cannot stat stream")
+}
+
+func (f *streamFile) Close() error {
+	return nil
+}
+
+func (f *streamFile) Name() string {
+	return f.name
+}
+
+func (f *streamFile) Sync() error {
+	return nil
+}
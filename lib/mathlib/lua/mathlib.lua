@@ -225,6 +225,33 @@ do
     --> =true
 end
 
+-- Seeded sequences are the same as in PUC-Rio Lua 5.4
+do
+    print(math.randomseed(42))
+    --> =42	0
+
+    print(string.format("%.17g", math.random()))
+    --> =0.93081217803956817
+    math.random()
+    math.random()
+
+    for i = 1, 5 do
+        print(math.random(100))
+    end
+    --> =86
+    --> =54
+    --> =64
+    --> =7
+    --> =25
+
+    print(math.random(0))
+    --> =3570341730643388674
+
+    math.randomseed(-1, 7)
+    print(math.random(-1000, 1000), math.random(-1000, 1000), math.random(-1000, 1000))
+    --> =-884	792	-631
+end
+
 do
     checknumarg(math.sin)
     --> =ok
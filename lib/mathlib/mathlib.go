@@ -1,11 +1,8 @@
 package mathlib
 
 import (
-	crypto "crypto/rand"
-	"encoding/binary"
 	"errors"
 	"math"
-	"math/rand"
 
 	"github.com/arnodel/golua/lib/packagelib"
 	rt "github.com/arnodel/golua/runtime"
@@ -307,21 +304,22 @@ func rad(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext1(t.Runtime, y), nil
 }
 
-// TODO: have a per runtime random generator
 func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
 		err error
 		m   int64 = 1
 		n   int64
+		src = t.RandomSource()
+		x   = src.Uint64()
 	)
 	switch c.NArgs() {
 	case 0:
-		return c.PushingNext1(t.Runtime, rt.FloatValue(rand.Float64())), nil
+		return c.PushingNext1(t.Runtime, rt.FloatValue(randomFloat(x))), nil
 	case 1:
 		n, err = c.IntArg(0)
 		// Special case, new in Lua 5.4: math.random(0) returns a uniform integer.
-		if n == 0 {
-			return c.PushingNext1(t.Runtime, rt.IntValue(int64(rand.Uint64()))), nil
+		if err == nil && n == 0 {
+			return c.PushingNext1(t.Runtime, rt.IntValue(int64(x))), nil
 		}
 	case 2:
 		m, err = c.IntArg(0)
@@ -335,54 +333,64 @@ func random(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	if m > n {
 		return nil, errors.New("#2 must be >= #1")
 	}
-	var r int64
-	if m <= 0 && m+math.MaxInt64 < n {
-		// There's >= 50% chance the loop stops at each iteration so we're OK!
-		for {
-			r = int64(rand.Uint64())
-			if r >= m && r <= n {
-				break
-			}
-		}
-	} else if m+math.MaxInt64 == n {
-		r = rand.Int63()
-	} else {
-		r = rand.Int63n(n - m + 1)
-	}
-	return c.PushingNext1(t.Runtime, rt.IntValue(m+r)), nil
+	r := randomProject(x, uint64(n)-uint64(m), src)
+	return c.PushingNext1(t.Runtime, rt.IntValue(int64(r+uint64(m)))), nil
+}
+
+// Returns a float in [0, 1) made from the 53 most significant bits of x, as in
+// Lua 5.4.
+func randomFloat(x uint64) float64 {
+	return float64(x>>11) * (0.5 / (1 << 52))
+}
+
+// Returns an integer in [0, n] obtained from x (and more random integers from
+// src if needed), in the same way as Lua 5.4 so that seeded sequences match.
+func randomProject(x, n uint64, src rt.RandomSource) uint64 {
+	if n&(n+1) == 0 {
+		// n + 1 is a power of 2 so there is no bias.
+		return x & n
+	}
+	// Compute the smallest 2^b - 1 not smaller than n.
+	lim := n
+	lim |= lim >> 1
+	lim |= lim >> 2
+	lim |= lim >> 4
+	lim |= lim >> 8
+	lim |= lim >> 16
+	lim |= lim >> 32
+	for x &= lim; x > n; x &= lim {
+		x = src.Uint64()
+	}
+	return x
 }
 
 func randomseed(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
-		seed int64
-		err  error
+		n1, n2 int64
+		err    error
 	)
 	switch c.NArgs() {
 	case 0:
 		// We need something as random as possible to make a seed.
-		readErr := binary.Read(crypto.Reader, binary.LittleEndian, &seed)
-		if readErr != nil {
-			return nil, errors.New("unable to get random seed")
-		}
+		u1, u2 := rt.NewRandomSeed()
+		n1, n2 = int64(u1), int64(u2)
 	case 1:
-		seed, err = c.IntArg(0)
+		n1, err = c.IntArg(0)
 		if err != nil {
 			return nil, err
 		}
 	case 2:
-		seed, err = c.IntArg(0)
+		n1, err = c.IntArg(0)
 		if err != nil {
 			return nil, err
 		}
-		seed2, err := c.IntArg(1)
+		n2, err = c.IntArg(1)
 		if err != nil {
 			return nil, err
 		}
-		// In Go the seed is only 64 bits so we mangle the seeds
-		seed ^= seed2
 	}
-	rand.Seed(seed)
-	return c.PushingNext(t.Runtime, rt.IntValue(seed), rt.IntValue(0)), nil
+	t.RandomSource().Seed(uint64(n1), uint64(n2))
+	return c.PushingNext(t.Runtime, rt.IntValue(n1), rt.IntValue(n2)), nil
 }
 
 func sin(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
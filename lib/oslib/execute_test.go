@@ -0,0 +1,102 @@
+package oslib_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// Commands are killed when the Go context of the runtime context is done.
+func TestCommandInterrupted(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("uses a unix shell")
+	}
+	if !rt.QuotasAvailable {
+		t.Skip("Go contexts are not supported without quotas")
+	}
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "execute",
+			src:  `os.execute("sleep 5")`,
+		},
+		{
+			name: "popen",
+			src:  `io.popen("sleep 5"):read("a")`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := rt.New(nil)
+			defer lib.LoadAll(r)()
+			clos, err := r.MainThread().LoadFromSourceOrCode("test", []byte(test.src), "t", rt.TableValue(r.GlobalEnv()), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err = rt.CallWithContext(ctx, r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected deadline exceeded, got %v", err)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("command was not interrupted, it ran for %s", d)
+			}
+		})
+	}
+}
+
+// The output of commands which is collected in memory cannot exceed the memory
+// quota: the command is killed and the context terminated.
+func TestCommandOutputQuota(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("uses a unix shell")
+	}
+	if !rt.QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "execute",
+			src:  `os.execute("yes 1>&2")`,
+		},
+		{
+			name: "popen",
+			src:  `io.popen("yes 1>&2"):close()`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stderr := new(bytes.Buffer)
+			r := rt.New(nil, rt.WithStdio(nil, nil, stderr))
+			defer lib.LoadAll(r)()
+			clos, err := r.MainThread().LoadFromSourceOrCode("test", []byte(test.src), "t", rt.TableValue(r.GlobalEnv()), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, err := r.MainThread().CallContext(rt.RuntimeContextDef{
+				HardLimits: rt.RuntimeResources{Memory: 1000000},
+			}, func() error {
+				return rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, rt.NewTerminationWith(nil, 0, false))
+			})
+			if ctx.Status() != rt.StatusKilled {
+				t.Errorf("expected context to be killed, got %s, %v", ctx.Status(), err)
+			}
+			if stderr.Len() > 1000000 {
+				t.Errorf("too much output collected: %d bytes", stderr.Len())
+			}
+		})
+	}
+}
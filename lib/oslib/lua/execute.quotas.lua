@@ -0,0 +1,16 @@
+-- Commands cannot be run when io safety is required
+runtime.callcontext({flags="iosafe"}, function()
+    print(os.execute())
+    --> =false
+    print(pcall(os.execute, "echo hello"))
+    --> ~false\t.*: safeio: operation not allowed
+end)
+
+-- The output of commands is streamed to stdout, so it is not counted against
+-- memory quotas
+do
+    local cmd = "echo " .. ("x"):rep(5000)
+    print(runtime.callcontext({kill={memory=2000}}, os.execute, cmd))
+    --> ~^x+$
+    --> =done	true	exit	0
+end
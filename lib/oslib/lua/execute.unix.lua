@@ -0,0 +1,16 @@
+print(os.execute())
+--> =true
+
+print(os.execute("exit 0"))
+--> =true	exit	0
+
+print(os.execute("exit 2"))
+--> =nil	exit	2
+
+-- The output of the command goes to stdout
+print("before")
+os.execute("echo hello")
+print("after")
+--> =before
+--> =hello
+--> =after
@@ -0,0 +1,12 @@
+package oslib_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/luatesting"
+)
+
+func TestOsLib(t *testing.T) {
+	luatesting.RunLuaTestsInDir(t, "lua", lib.LoadAll)
+}
@@ -3,6 +3,7 @@ package oslib
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"time"
 
 	"github.com/arnodel/golua/lib/packagelib"
@@ -32,6 +33,11 @@ func load(r *rt.Runtime) (rt.Value, func()) {
 		r.SetEnvGoFunc(pkg, "remove", remove, 1, false),
 		r.SetEnvGoFunc(pkg, "rename", rename, 2, false),
 	)
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyIoSafe,
+
+		r.SetEnvGoFunc(pkg, "execute", execute, 1, false),
+	)
 	// These functions are not safe - I don't know what compliance category to
 	// put them in.
 	r.SetEnvGoFunc(pkg, "setlocale", setlocale, 2, false)
@@ -109,6 +115,63 @@ func difftime(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	return c.PushingNext1(t.Runtime, rt.IntValue(t2-t1)), nil
 }
 
+func execute(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+	if c.NArgs() == 0 {
+		return c.PushingNext1(t.Runtime, rt.BoolValue(safeio.ShellAvailable(t.Runtime))), nil
+	}
+	cmdline, err := c.StringArg(0)
+	if err != nil {
+		return nil, err
+	}
+	cmd, ioErr := safeio.ShellCommand(t.Runtime, cmdline)
+	if ioErr != nil {
+		return t.ProcessIoError(c.Next(), ioErr)
+	}
+	return t.ProcessExitStatus(c.Next(), runCommand(t, cmd))
+}
+
+// Runs cmd, copying its standard output to the runtime's stdout as it is
+// produced.  The other standard streams of cmd are those of the runtime (see
+// safeio.SetCommandStdio).  The command is killed and the runtime context
+// terminated if the Go context of the runtime context is done while it runs.
+func runCommand(t *rt.Thread, cmd *exec.Cmd) error {
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	flush := safeio.SetCommandStdio(t.Runtime, cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	stop := safeio.KillOnInterrupt(t.Runtime, cmd, out)
+	defer stop()
+	done := false
+	defer func() {
+		// If the output cannot be written, the command is killed.
+		if !done {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}
+	}()
+	var buf [4096]byte
+	for {
+		n, readErr := out.Read(buf[:])
+		if _, err := t.Stdout.Write(buf[:n]); err != nil {
+			return err
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	done = true
+	err = cmd.Wait()
+	t.CheckInterrupt()
+	if flushErr := flush(); flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
 func exit(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
 	var (
 		code  = 0 // 0 for success, 1 for failure
@@ -1,4 +1,13 @@
--- If IO is disabled, it's not possilbe to load a lua module from a file.
+-- If IO is disabled, it's not possible to load a lua module from a file, even
+-- one that was loaded already, or to search for one.
 
 print(runtime.callcontext({flags="iosafe"}, pcall, require, "testlib.foo"))
 --> ~done\tfalse\t.*: missing flags: iosafe
+
+print(require "testlib.foo")
+--> =foo
+--> =bar
+print(runtime.callcontext({flags="iosafe"}, pcall, require, "testlib.foo"))
+--> ~done\tfalse\t.*: missing flags: iosafe
+print(runtime.callcontext({flags="iosafe"}, pcall, package.searchpath, "testlib.foo", "./?.lua"))
+--> ~done\tfalse\t.*: missing flags: iosafe
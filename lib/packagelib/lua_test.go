@@ -1,12 +1,48 @@
 package packagelib_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/arnodel/golua/lib"
 	"github.com/arnodel/golua/luatesting"
+	rt "github.com/arnodel/golua/runtime"
 )
 
 func TestPackageLib(t *testing.T) {
 	luatesting.RunLuaTestsInDir(t, "lua", lib.LoadAll)
 }
+
+func TestRequirePrecompiled(t *testing.T) {
+	dir := t.TempDir()
+	r := rt.New(nil)
+	defer lib.LoadAll(r)()
+
+	var w bytes.Buffer
+	if err := r.Precompile(&w, "mod.lua", []byte("return {answer = 42}")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "mod.luac"), w.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	r.SetEnv(r.GlobalEnv(), "dir", rt.StringValue(dir))
+	src := `
+package.path = dir .. "/?.lua;" .. dir .. "/?.luac"
+return require("mod").answer, loadfile(dir .. "/mod.luac")().answer
+`
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte(src), rt.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	term := rt.NewTerminationWith(nil, 2, false)
+	if err := rt.Call(r.MainThread(), rt.FunctionValue(clos), nil, term); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if n, _ := term.Get(i).TryInt(); n != 42 {
+			t.Errorf("value %d: got %v, want 42", i, term.Get(i))
+		}
+	}
+}
@@ -139,11 +139,12 @@ end
 
 -- table.sort
 do
-    -- table.sort consumes cpu
+    -- table.sort consumes cpu.  The values are scrambled rather than in
+    -- reverse order, as sorting a reversed sequence may take linear time.
     local function unsorted(n)
         t = {}
         for i = 1, n do
-            t[i] = n - i
+            t[i] = (i * 7) % n
         end
         return t
     end
@@ -0,0 +1,107 @@
+package lib_test
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/luatesting"
+	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
+)
+
+const vfsTestSource = `
+-- Only the files in the in-memory filesystem are visible
+print(io.open("lib/iolib/files/hello.txt"))
+--> =nil	open lib/iolib/files/hello.txt: file does not exist
+
+local f = assert(io.open("/data/out.txt", "w"))
+f:write("hello\n", 42, "\n")
+f:close()
+for line in io.lines("./data/out.txt") do
+    print(line)
+end
+--> =hello
+--> =42
+
+print(os.rename("data/out.txt", "data/moved.txt"))
+--> =true
+print(io.open("data/out.txt"))
+--> =nil	open data/out.txt: file does not exist
+print(os.remove("data/moved.txt"))
+--> =true
+print(os.remove("data/moved.txt"))
+--> =nil	remove data/moved.txt: file does not exist
+
+-- Temporary files live in the filesystem too
+local name = os.tmpname()
+print(name)
+--> ~^/tmp/.+
+print(io.open(name):read("a"))
+--> =
+
+-- Code is loaded from the filesystem
+print(require("mod").name)
+--> =mod
+print(dofile("script.lua"))
+--> =from script
+print(loadfile("missing.lua"))
+--> =nil	open missing.lua: file does not exist
+
+-- The shell is not available as it would see the host filesystem
+print(os.execute())
+--> =false
+print(pcall(io.popen, "echo hello"))
+--> ~false\t.*: safeio: operation not allowed
+
+-- Files cannot be made arbitrarily large by seeking
+local f = assert(io.open("big.txt", "w"))
+print(f:seek("set", 1 << 40))
+--> ~nil\t.*: file too large
+f:close()
+
+io.open("result.txt", "w"):write("done"):close()
+`
+
+func TestMemFS(t *testing.T) {
+	fsys := vfs.NewMemFS()
+	_ = fsys.WriteFile("mod.lua", []byte(`return {name="mod"}`), 0644)
+	_ = fsys.WriteFile("script.lua", []byte(`return "from script"`), 0644)
+
+	out := new(bytes.Buffer)
+	r := rt.New(out, rt.WithFS(fsys))
+	defer lib.LoadAll(r)()
+	luatesting.RunSource(r, []byte(vfsTestSource))
+	if err := luatesting.CheckLines(out.Bytes(), luatesting.ExtractLineCheckers([]byte(vfsTestSource))); err != nil {
+		t.Fatal(err)
+	}
+	result, err := fs.ReadFile(fsys, "result.txt")
+	if err != nil || string(result) != "done" {
+		t.Fatalf("got %q, %v", result, err)
+	}
+}
+
+const vfsQuotaTestSource = `
+-- Writing to a file in memory is counted against the memory quota
+local f = assert(io.open("big.txt", "w"))
+print(runtime.callcontext({kill={memory=100000}}, function()
+    f:seek("set", 1000000)
+    f:write("x")
+    f:flush()
+end))
+--> =killed
+`
+
+func TestMemFSQuota(t *testing.T) {
+	if !rt.QuotasAvailable {
+		t.Skip("requires quotas")
+	}
+	out := new(bytes.Buffer)
+	r := rt.New(out, rt.WithFS(vfs.NewMemFS()))
+	defer lib.LoadAll(r)()
+	luatesting.RunSource(r, []byte(vfsQuotaTestSource))
+	if err := luatesting.CheckLines(out.Bytes(), luatesting.ExtractLineCheckers([]byte(vfsQuotaTestSource))); err != nil {
+		t.Fatal(err)
+	}
+}
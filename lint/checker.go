@@ -0,0 +1,388 @@
+package lint
+
+import (
+	"strings"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/token"
+)
+
+// A checker walks the AST, keeping track of the local variables in scope.
+type checker struct {
+	file            string
+	globals         map[string]bool // Known globals
+	assignedGlobals map[string]bool // Globals assigned to in the chunk
+	globalReads     []globalRead    // Reads of unknown globals, checked at the end
+	scope           *scope
+	diagnostics     []Diagnostic
+}
+
+var _ ast.StatProcessor = (*checker)(nil)
+var _ ast.ExpProcessor = (*checker)(nil)
+
+type globalRead struct {
+	name string
+	pos  *token.Pos
+}
+
+type scope struct {
+	parent *scope
+	vars   []*variable
+}
+
+type variable struct {
+	name   string
+	kind   string // e.g. "local variable", "parameter"
+	attrib ast.LocalAttrib
+	pos    *token.Pos
+	used   bool
+}
+
+func (c *checker) openScope() {
+	c.scope = &scope{parent: c.scope}
+}
+
+// Closes the current scope, reporting variables which were not used.
+func (c *checker) closeScope() {
+	for _, v := range c.scope.vars {
+		if v.used || v.pos == nil || strings.HasPrefix(v.name, "_") || v.attrib == ast.CloseAttrib {
+			continue
+		}
+		if v.kind == "parameter" {
+			c.report(v.pos, UnusedParameter, "unused parameter %s", v.name)
+		} else {
+			c.report(v.pos, UnusedLocal, "unused %s %s", v.kind, v.name)
+		}
+	}
+	c.scope = c.scope.parent
+}
+
+func (c *checker) lookup(name string) *variable {
+	for s := c.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if v := s.vars[i]; v.name == name {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func (c *checker) declare(name ast.Name, kind string, attrib ast.LocalAttrib) {
+	pos := name.StartPos()
+	if prev := c.lookup(name.Val); prev != nil && pos != nil && name.Val != "_" {
+		if prev.pos != nil {
+			c.report(pos, ShadowedLocal, "%s %s shadows %s declared on line %d", kind, name.Val, prev.kind, prev.pos.Line)
+		} else {
+			c.report(pos, ShadowedLocal, "%s %s shadows %s", kind, name.Val, prev.kind)
+		}
+	}
+	c.scope.vars = append(c.scope.vars, &variable{
+		name:   name.Val,
+		kind:   kind,
+		attrib: attrib,
+		pos:    pos,
+	})
+}
+
+// Checks a function (or the main chunk, which has no parameters).
+func (c *checker) function(params []ast.Name, body ast.BlockStat) {
+	c.openScope()
+	for _, p := range params {
+		c.declare(p, "parameter", ast.NoAttrib)
+	}
+	c.stats(body)
+	c.closeScope()
+}
+
+// Checks a block in its own scope.
+func (c *checker) block(b ast.BlockStat) {
+	c.openScope()
+	c.stats(b)
+	c.closeScope()
+}
+
+// Checks the statements of a block in the current scope.  Code following a
+// statement that never completes normally is reported, unless there is a label
+// in between.
+func (c *checker) stats(b ast.BlockStat) {
+	terminated, reported := false, false
+	for _, s := range b.Stats {
+		switch s.(type) {
+		case ast.LabelStat:
+			terminated = false
+		case ast.EmptyStat:
+		default:
+			if terminated && !reported {
+				c.report(statPos(s), Unreachable, "unreachable code")
+				reported = true
+			}
+		}
+		s.ProcessStat(c)
+		if terminates(s) {
+			terminated, reported = true, false
+		}
+	}
+	if b.Return != nil {
+		if terminated && !reported && len(b.Return) > 0 {
+			c.report(b.Return[0].Locate().StartPos(), Unreachable, "unreachable code")
+		}
+		c.exps(b.Return)
+	}
+}
+
+// Returns true if control never goes to the statement after s.
+func terminates(s ast.Stat) bool {
+	switch s := s.(type) {
+	case ast.GotoStat, ast.BreakStat:
+		return true
+	case ast.BlockStat:
+		return blockTerminates(s)
+	case ast.IfStat:
+		if s.Else == nil || !blockTerminates(s.If.Body) || !blockTerminates(*s.Else) {
+			return false
+		}
+		for _, elseIf := range s.ElseIfs {
+			if !blockTerminates(elseIf.Body) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func blockTerminates(b ast.BlockStat) bool {
+	if b.Return != nil {
+		return true
+	}
+	for i := len(b.Stats) - 1; i >= 0; i-- {
+		if _, ok := b.Stats[i].(ast.EmptyStat); !ok {
+			return terminates(b.Stats[i])
+		}
+	}
+	return false
+}
+
+// Returns the position of a statement, which for a do block is that of its
+// first statement.
+func statPos(s ast.Stat) *token.Pos {
+	if b, ok := s.(ast.BlockStat); ok {
+		for _, s := range b.Stats {
+			if pos := statPos(s); pos != nil {
+				return pos
+			}
+		}
+		if len(b.Return) > 0 {
+			return b.Return[0].Locate().StartPos()
+		}
+		return nil
+	}
+	return s.Locate().StartPos()
+}
+
+func (c *checker) exps(exps []ast.ExpNode) {
+	for _, e := range exps {
+		e.ProcessExp(c)
+	}
+}
+
+// Checks an assignment to v.
+func (c *checker) assign(v ast.Var) {
+	switch v := v.(type) {
+	case ast.Name:
+		if local := c.lookup(v.Val); local != nil {
+			switch local.attrib {
+			case ast.ConstAttrib:
+				c.report(v.StartPos(), ConstAssign, "assignment to const variable %s", v.Val)
+			case ast.CloseAttrib:
+				c.report(v.StartPos(), ConstAssign, "assignment to close variable %s", v.Val)
+			}
+			return
+		}
+		if !c.globals[v.Val] && !c.assignedGlobals[v.Val] {
+			c.report(v.StartPos(), ImplicitGlobal, "assignment to undefined global %s", v.Val)
+		}
+		if c.assignedGlobals == nil {
+			c.assignedGlobals = map[string]bool{}
+		}
+		c.assignedGlobals[v.Val] = true
+	case ast.IndexExp:
+		v.Coll.ProcessExp(c)
+		v.Idx.ProcessExp(c)
+	}
+}
+
+//
+// Statements
+//
+
+// ProcessAssignStat checks an assignment statement.
+func (c *checker) ProcessAssignStat(s ast.AssignStat) {
+	c.exps(s.Src)
+	for _, v := range s.Dest {
+		c.assign(v)
+	}
+}
+
+// ProcessBlockStat checks a do ... end statement.
+func (c *checker) ProcessBlockStat(s ast.BlockStat) {
+	c.block(s)
+}
+
+// ProcessBreakStat does nothing.
+func (c *checker) ProcessBreakStat(s ast.BreakStat) {}
+
+// ProcessEmptyStat does nothing.
+func (c *checker) ProcessEmptyStat(s ast.EmptyStat) {}
+
+// ProcessForInStat checks a for in statement.
+func (c *checker) ProcessForInStat(s ast.ForInStat) {
+	c.exps(s.Params)
+	c.openScope()
+	for _, v := range s.Vars {
+		c.declare(v, "loop variable", ast.NoAttrib)
+	}
+	c.stats(s.Body)
+	c.closeScope()
+}
+
+// ProcessForStat checks a numeric for statement.
+func (c *checker) ProcessForStat(s ast.ForStat) {
+	c.exps([]ast.ExpNode{s.Start, s.Stop, s.Step})
+	c.openScope()
+	c.declare(s.Var, "loop variable", ast.NoAttrib)
+	c.stats(s.Body)
+	c.closeScope()
+}
+
+// ProcessFunctionCallStat checks a function call statement.
+func (c *checker) ProcessFunctionCallStat(s ast.FunctionCall) {
+	c.ProcessFunctionCallExp(s)
+}
+
+// ProcessGotoStat does nothing.
+func (c *checker) ProcessGotoStat(s ast.GotoStat) {}
+
+// ProcessIfStat checks an if statement.
+func (c *checker) ProcessIfStat(s ast.IfStat) {
+	s.If.Cond.ProcessExp(c)
+	c.block(s.If.Body)
+	for _, elseIf := range s.ElseIfs {
+		elseIf.Cond.ProcessExp(c)
+		c.block(elseIf.Body)
+	}
+	if s.Else != nil {
+		c.block(*s.Else)
+	}
+}
+
+// ProcessLabelStat does nothing.
+func (c *checker) ProcessLabelStat(s ast.LabelStat) {}
+
+// ProcessLocalFunctionStat checks a local function definition.  The function
+// is in scope in its body.
+func (c *checker) ProcessLocalFunctionStat(s ast.LocalFunctionStat) {
+	c.declare(s.Name, "local function", ast.NoAttrib)
+	c.function(s.Params, s.Body)
+}
+
+// ProcessLocalStat checks a local variable declaration.
+func (c *checker) ProcessLocalStat(s ast.LocalStat) {
+	c.exps(s.Values)
+	for _, na := range s.NameAttribs {
+		c.declare(na.Name, "local variable", na.Attrib)
+	}
+}
+
+// ProcessRepeatStat checks a repeat ... until statement.  The condition is in
+// the scope of the body.
+func (c *checker) ProcessRepeatStat(s ast.RepeatStat) {
+	c.openScope()
+	c.stats(s.Body)
+	s.Cond.ProcessExp(c)
+	c.closeScope()
+}
+
+// ProcessWhileStat checks a while statement.
+func (c *checker) ProcessWhileStat(s ast.WhileStat) {
+	s.Cond.ProcessExp(c)
+	c.block(s.Body)
+}
+
+//
+// Expressions
+//
+
+// ProcessBFunctionCallExp checks a function call in brackets.
+func (c *checker) ProcessBFunctionCallExp(f ast.BFunctionCall) {
+	c.ProcessFunctionCallExp(ast.FunctionCall{BFunctionCall: &f})
+}
+
+// ProcessBinOpExp checks the operands of a binary operation.
+func (c *checker) ProcessBinOpExp(b ast.BinOp) {
+	b.Left.ProcessExp(c)
+	for _, r := range b.Right {
+		r.Operand.ProcessExp(c)
+	}
+}
+
+// ProcesBoolExp does nothing.
+func (c *checker) ProcesBoolExp(b ast.Bool) {}
+
+// ProcessEtcExp does nothing.
+func (c *checker) ProcessEtcExp(e ast.Etc) {}
+
+// ProcessFunctionExp checks a function definition.
+func (c *checker) ProcessFunctionExp(f ast.Function) {
+	c.function(f.Params, f.Body)
+}
+
+// ProcessFunctionCallExp checks a function call.
+func (c *checker) ProcessFunctionCallExp(f ast.FunctionCall) {
+	c.checkArgCount(f)
+	f.Target.ProcessExp(c)
+	c.exps(f.Args)
+}
+
+// ProcessIndexExp checks an index expression.
+func (c *checker) ProcessIndexExp(e ast.IndexExp) {
+	e.Coll.ProcessExp(c)
+	e.Idx.ProcessExp(c)
+}
+
+// ProcessNameExp checks that the name is a local variable or a known global.
+func (c *checker) ProcessNameExp(n ast.Name) {
+	if v := c.lookup(n.Val); v != nil {
+		v.used = true
+	} else if !c.globals[n.Val] {
+		c.globalReads = append(c.globalReads, globalRead{name: n.Val, pos: n.StartPos()})
+	}
+}
+
+// ProcessNilExp does nothing.
+func (c *checker) ProcessNilExp(n ast.Nil) {}
+
+// ProcessIntExp does nothing.
+func (c *checker) ProcessIntExp(n ast.Int) {}
+
+// ProcessFloatExp does nothing.
+func (c *checker) ProcessFloatExp(f ast.Float) {}
+
+// ProcessStringExp does nothing.
+func (c *checker) ProcessStringExp(s ast.String) {}
+
+// ProcessTableConstructorExp checks the fields of a table constructor.
+func (c *checker) ProcessTableConstructorExp(t ast.TableConstructor) {
+	for _, f := range t.Fields {
+		if _, ok := f.Key.(ast.NoTableKey); !ok {
+			f.Key.ProcessExp(c)
+		}
+		f.Value.ProcessExp(c)
+	}
+}
+
+// ProcessUnOpExp checks the operand of a unary operation.
+func (c *checker) ProcessUnOpExp(u ast.UnOp) {
+	u.Operand.ProcessExp(c)
+}
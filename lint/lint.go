@@ -0,0 +1,126 @@
+// Package lint finds likely mistakes in Lua code by looking at its AST: typos in
+// global names, unused or shadowed local variables, unreachable code,
+// assignments to constant variables and calls to standard library functions
+// with the wrong number of arguments.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+	"github.com/arnodel/golua/token"
+)
+
+// The codes of the problems reported.
+const (
+	UndefinedGlobal = "undefined-global" // Reading a global which is not known
+	ImplicitGlobal  = "implicit-global"  // Assigning to a global which is not known
+	UnusedLocal     = "unused-local"     // A local variable which is never read
+	UnusedParameter = "unused-parameter" // A function parameter which is never read
+	ShadowedLocal   = "shadowed-local"   // A local variable which hides another one
+	Unreachable     = "unreachable-code" // A statement which cannot be executed
+	ConstAssign     = "const-assign"     // Assigning to a <const> or <close> variable
+	WrongArgCount   = "wrong-arg-count"  // A standard library function called with the wrong number of arguments
+	SyntaxError     = "syntax-error"     // The source could not be parsed
+)
+
+// A Diagnostic is a problem found in Lua code.
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s (%s)", d.File, d.Line, d.Column, d.Message, d.Code)
+}
+
+// Config controls what is reported.
+type Config struct {
+	// Globals which can be used in addition to DefaultGlobals.
+	Globals []string
+}
+
+// DefaultGlobals are the globals defined by golua and its standard library.
+var DefaultGlobals = []string{
+	"_ENV", "_G", "_VERSION", "arg", "assert", "collectgarbage", "coroutine",
+	"debug", "dofile", "error", "getmetatable", "golib", "io", "ipairs",
+	"load", "loadfile", "math", "next", "os", "package", "pairs", "pcall",
+	"print", "rawequal", "rawget", "rawlen", "rawset", "require", "runtime",
+	"select", "setmetatable", "string", "table", "tonumber", "tostring",
+	"type", "utf8", "warn", "xpcall",
+}
+
+// Source parses the Lua source code and checks it.  If the source cannot be
+// parsed, the syntax errors are the only diagnostics.  A first line starting
+// with "#" is ignored.
+func Source(name string, src []byte, cfg Config) []Diagnostic {
+	var opts []scanner.Option
+	if len(src) > 0 && src[0] == '#' {
+		for i, b := range src {
+			if b == '\n' || b == '\r' {
+				src = src[i+1:]
+				break
+			}
+		}
+		opts = append(opts, scanner.WithStartLine(2))
+	}
+	chunk, errs := parsing.ParseChunkRecovering(scanner.New(name, src, opts...))
+	if len(errs) > 0 {
+		diagnostics := make([]Diagnostic, len(errs))
+		for i, err := range errs {
+			pos := err.StartPos()
+			diagnostics[i] = Diagnostic{
+				File:    name,
+				Line:    pos.Line,
+				Column:  pos.Column,
+				Code:    SyntaxError,
+				Message: strings.TrimPrefix(err.Error(), fmt.Sprintf("%d:%d: ", pos.Line, pos.Column)),
+			}
+		}
+		return diagnostics
+	}
+	return Check(name, chunk, cfg)
+}
+
+// Check returns the problems found in a chunk, sorted by position.
+func Check(name string, chunk ast.BlockStat, cfg Config) []Diagnostic {
+	c := &checker{
+		file:    name,
+		globals: map[string]bool{},
+	}
+	for _, g := range DefaultGlobals {
+		c.globals[g] = true
+	}
+	for _, g := range cfg.Globals {
+		c.globals[g] = true
+	}
+	c.function(nil, chunk)
+	for _, read := range c.globalReads {
+		if !c.assignedGlobals[read.name] {
+			c.report(read.pos, UndefinedGlobal, "undefined global %s", read.name)
+		}
+	}
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		di, dj := c.diagnostics[i], c.diagnostics[j]
+		if di.Line != dj.Line {
+			return di.Line < dj.Line
+		}
+		return di.Column < dj.Column
+	})
+	return c.diagnostics
+}
+
+func (c *checker) report(pos *token.Pos, code string, format string, args ...interface{}) {
+	d := Diagnostic{File: c.file, Code: code, Message: fmt.Sprintf(format, args...)}
+	if pos != nil {
+		d.Line, d.Column = pos.Line, pos.Column
+	}
+	c.diagnostics = append(c.diagnostics, d)
+}
@@ -0,0 +1,87 @@
+package lint_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/arnodel/golua/lint"
+)
+
+const lintedSource = `#!/usr/bin/env golua
+local unused = 1
+local x <const> = 2
+x = 3
+prnt("hello")
+counter = 0
+counter = counter + 1
+local function f(a, b, _c)
+  local b = b
+  return b
+end
+for i = 1, 10 do
+  if i > 5 then goto done end
+  print(i)
+end
+do
+  return f(1)
+end
+print(string.rep("x"), string.format("%d", f()), tostring(1, 2), select('#', ...))
+::done::
+local t <close> = nil
+print(math.max(f()), os.time(1, 2, f()))
+`
+
+func TestSource(t *testing.T) {
+	got := lint.Source("test.lua", []byte(lintedSource), lint.Config{})
+	want := []string{
+		"test.lua:2:7: unused local variable unused (unused-local)",
+		"test.lua:3:7: unused local variable x (unused-local)",
+		"test.lua:4:1: assignment to const variable x (const-assign)",
+		"test.lua:5:1: undefined global prnt (undefined-global)",
+		"test.lua:6:1: assignment to undefined global counter (implicit-global)",
+		"test.lua:8:18: unused parameter a (unused-parameter)",
+		"test.lua:9:9: local variable b shadows parameter declared on line 8 (shadowed-local)",
+		"test.lua:19:1: unreachable code (unreachable-code)",
+		"test.lua:19:7: string.rep expects 2 to 3 arguments, got 1 (wrong-arg-count)",
+		"test.lua:19:50: tostring expects 1 argument, got 2 (wrong-arg-count)",
+		"test.lua:22:22: os.time expects 0 to 1 arguments, got 3 (wrong-arg-count)",
+	}
+	checkDiagnostics(t, got, want)
+
+	got = lint.Source("test.lua", []byte(lintedSource), lint.Config{Globals: []string{"prnt", "counter"}})
+	for _, d := range got {
+		if d.Code == lint.UndefinedGlobal || d.Code == lint.ImplicitGlobal {
+			t.Errorf("unexpected diagnostic: %s", d)
+		}
+	}
+}
+
+func TestSourceArgCounts(t *testing.T) {
+	got := lint.Source("test.lua", []byte(`collectgarbage("incremental", 100, 200, 13)
+print(os.difftime(1), os.difftime(1, 2))
+debug.sethook()
+`), lint.Config{})
+	checkDiagnostics(t, got, []string{
+		"test.lua:2:7: os.difftime expects 2 arguments, got 1 (wrong-arg-count)",
+	})
+}
+
+func TestSourceSyntaxError(t *testing.T) {
+	got := lint.Source("test.lua", []byte("x = = 1\ny = 2\nz = ("), lint.Config{})
+	checkDiagnostics(t, got, []string{
+		"test.lua:1:5: unexpected symbol near '=' (syntax-error)",
+		"test.lua:3:6: unexpected symbol near <eof> (syntax-error)",
+	})
+}
+
+func checkDiagnostics(t *testing.T, got []lint.Diagnostic, want []string) {
+	t.Helper()
+	var gotStrings []string
+	for _, d := range got {
+		gotStrings = append(gotStrings, fmt.Sprint(d))
+	}
+	if !reflect.DeepEqual(gotStrings, want) {
+		t.Errorf("got diagnostics:\n%q\nwant:\n%q", gotStrings, want)
+	}
+}
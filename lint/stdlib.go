@@ -0,0 +1,81 @@
+package lint
+
+import (
+	"fmt"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/luastdlib"
+)
+
+// Returns the name of the standard library function called, if it is known.
+func (c *checker) stdlibFunction(f ast.FunctionCall) (string, bool) {
+	if f.Method.Val != "" {
+		return "", false
+	}
+	var name string
+	switch t := f.Target.(type) {
+	case ast.Name:
+		if c.lookup(t.Val) != nil {
+			return "", false
+		}
+		name = t.Val
+	case ast.IndexExp:
+		lib, ok := t.Coll.(ast.Name)
+		if !ok || c.lookup(lib.Val) != nil {
+			return "", false
+		}
+		fn, ok := t.Idx.(ast.String)
+		if !ok {
+			return "", false
+		}
+		name = lib.Val + "." + string(fn.Val)
+	default:
+		return "", false
+	}
+	_, ok := luastdlib.Functions[name]
+	return name, ok
+}
+
+// Reports calls to standard library functions with a wrong number of
+// arguments.  If the last argument may expand to several values, only an
+// excess of arguments is reported.
+func (c *checker) checkArgCount(f ast.FunctionCall) {
+	name, ok := c.stdlibFunction(f)
+	if !ok || c.assignedGlobals[name] {
+		return
+	}
+	min, max := luastdlib.Functions[name].ArgCount()
+	n := len(f.Args)
+	if n > 0 {
+		switch f.Args[n-1].(type) {
+		case ast.FunctionCall, ast.Etc:
+			if max >= 0 && n-1 > max {
+				c.reportArgCount(f, name, min, max, n)
+			}
+			return
+		}
+	}
+	if n < min || max >= 0 && n > max {
+		c.reportArgCount(f, name, min, max, n)
+	}
+}
+
+func (c *checker) reportArgCount(f ast.FunctionCall, name string, min, max, n int) {
+	var expected string
+	switch {
+	case max < 0:
+		expected = fmt.Sprintf("at least %s", plural(min, "argument"))
+	case min == max:
+		expected = plural(min, "argument")
+	default:
+		expected = fmt.Sprintf("%d to %d arguments", min, max)
+	}
+	c.report(f.StartPos(), WrongArgCount, "%s expects %s, got %d", name, expected, n)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
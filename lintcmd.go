@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/arnodel/golua/lint"
+)
+
+// Runs "golua lint [flags] [files]", which reports likely mistakes in Lua
+// source files.  With no files, it checks the standard input.
+func lintMain(args []string) int {
+	flags := flag.NewFlagSet("golua lint", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: golua lint [flags] [files]")
+		flags.PrintDefaults()
+	}
+	globals := flags.String("globals", "", "Comma separated `list` of globals allowed in addition to the standard library")
+	jsonOutput := flags.Bool("json", false, "Output a JSON array of diagnostics")
+	flags.Parse(args)
+
+	var cfg lint.Config
+	for _, g := range strings.Split(*globals, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			cfg.Globals = append(cfg.Globals, g)
+		}
+	}
+	var diagnostics []lint.Diagnostic
+	if flags.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fatal("Error reading <stdin>: %s", err)
+		}
+		diagnostics = lint.Source("<stdin>", src, cfg)
+	}
+	for _, name := range flags.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			return fatal("Error reading %s: %s", name, err)
+		}
+		diagnostics = append(diagnostics, lint.Source(name, src, cfg)...)
+	}
+	if *jsonOutput {
+		if diagnostics == nil {
+			diagnostics = []lint.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diagnostics); err != nil {
+			return fatal("Error writing JSON: %s", err)
+		}
+	} else {
+		for _, d := range diagnostics {
+			fmt.Println(d)
+		}
+	}
+	if len(diagnostics) > 0 {
+		return 1
+	}
+	return 0
+}
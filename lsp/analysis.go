@@ -0,0 +1,702 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/astcomp"
+	"github.com/arnodel/golua/parsing"
+	"github.com/arnodel/golua/scanner"
+	"github.com/arnodel/golua/token"
+)
+
+// A document is a Lua source file opened in the client.
+type document struct {
+	uri         string
+	name        string // The chunk name, used in diagnostics
+	text        string
+	diagnostics []diagnostic
+
+	// The analysis of the last version of the text which could be parsed, so
+	// symbols are still available while the user is typing.
+	analysis *analysis
+}
+
+// Sets the text of the document, computing its diagnostics and analysing it
+// if it can be parsed.
+func (d *document) update(text string) {
+	d.text = text
+	lines := newLineIndex(text)
+	chunk, errs := parse(d.name, text)
+	if len(errs) > 0 {
+		d.diagnostics = nil
+		for _, err := range errs {
+			d.diagnostics = append(d.diagnostics, lines.diagnostic(text, err))
+		}
+		return
+	}
+	d.analysis = analyse(text, lines, chunk)
+	d.diagnostics = []diagnostic{}
+	if _, _, err := astcomp.CompileLuaChunk(d.name, chunk); err != nil {
+		d.diagnostics = append(d.diagnostics, lines.diagnostic(text, err))
+	}
+}
+
+// Parses the text with the parser used by the runtime, recovering from errors
+// so they are all reported.  A first line starting with "#" is ignored, as when
+// golua runs a file.  It is blanked rather than removed so that the positions
+// of tokens are unchanged.
+func parse(name, text string) (ast.BlockStat, []parsing.Error) {
+	src := []byte(text)
+	if len(src) > 0 && src[0] == '#' {
+		for i := 0; i < len(src) && src[i] != '\n' && src[i] != '\r'; i++ {
+			src[i] = ' '
+		}
+	}
+	return parsing.ParseChunkRecovering(scanner.New(name, src))
+}
+
+// A lineIndex converts between the positions in the source (byte offsets)
+// and LSP positions, whose characters are counted in UTF-16 code units.
+type lineIndex []int // Offsets of the start of lines
+
+func newLineIndex(text string) lineIndex {
+	lines := lineIndex{0}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n', '\r':
+			// "\r\n" and "\n\r" are a single line break, as in the scanner.
+			if i+1 < len(text) && text[i+1] != text[i] && (text[i+1] == '\n' || text[i+1] == '\r') {
+				i++
+			}
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// Returns the LSP position of the given (0-based) line and byte offset.
+func (l lineIndex) position(text string, line, offset int) position {
+	if line >= len(l) {
+		line = len(l) - 1
+	}
+	start := l[line]
+	if offset < start {
+		offset = start
+	}
+	if offset > len(text) {
+		offset = len(text)
+	}
+	n := 0
+	for _, r := range text[start:offset] {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return position{Line: line, Character: n}
+}
+
+// Returns the byte offset of an LSP position.
+func (l lineIndex) offset(text string, pos position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(l) {
+		return len(text)
+	}
+	offset := l[pos.Line]
+	for n := 0; n < pos.Character && offset < len(text); {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		if r == '\n' || r == '\r' {
+			break
+		}
+		n += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// Returns the LSP range that starts at pos and spans n bytes.
+func (l lineIndex) rangeAt(text string, pos *token.Pos, n int) lspRange {
+	if pos == nil {
+		return lspRange{}
+	}
+	return lspRange{
+		Start: l.position(text, pos.Line-1, pos.Offset),
+		End:   l.position(text, pos.Line-1, pos.Offset+n),
+	}
+}
+
+// Converts an error returned by the parser or the compiler to a diagnostic.
+// The message is the one load would return (for the first syntax error), without
+// the chunk name and position which are given by the range.
+func (l lineIndex) diagnostic(text string, err error) diagnostic {
+	var pos *token.Pos
+	var perr parsing.Error
+	var cerr astcomp.Error
+	n := 0
+	switch {
+	case errors.As(err, &perr):
+		start, end := perr.StartPos(), perr.EndPos()
+		pos, n = &start, end.Offset-start.Offset
+	case errors.As(err, &cerr):
+		pos = cerr.Where.Locate().StartPos()
+	}
+	msg := err.Error()
+	if pos != nil {
+		msg = strings.TrimPrefix(msg, fmt.Sprintf("%d:%d: ", pos.Line, pos.Column))
+	}
+	return diagnostic{
+		Range:    l.rangeAt(text, pos, n),
+		Severity: severityError,
+		Source:   "golua",
+		Message:  msg,
+	}
+}
+
+// An analysis records the variables in a chunk and where they are used.
+type analysis struct {
+	text        string
+	lines       lineIndex
+	occurrences []occurrence // Sorted by offset
+	globals     map[string]*variable
+	locals      []*variable
+	fields      map[string]map[string]bool // Fields assigned to, by table name
+	symbols     []documentSymbol
+}
+
+// A variable is a local variable or a global.
+type variable struct {
+	name     string
+	kind     string     // "local variable", "parameter", "local function", "loop variable" or "global"
+	decl     *token.Pos // Nil for globals
+	depth    int        // Depth of the function declaring it
+	scopeEnd int        // Offset of the end of its scope
+	detail   string     // E.g. the parameters of a function
+}
+
+// An occurrence of a name in the source.  It is either a variable or a field
+// of a global table, e.g. "format" in "string.format".
+type occurrence struct {
+	pos     *token.Pos
+	name    string
+	v       *variable // Nil for a field
+	write   bool      // True for assignments and declarations
+	upvalue bool      // True if v is a local of an enclosing function
+	field   string    // E.g. "string.format" for a field
+}
+
+func (o *occurrence) contains(offset int) bool {
+	return o.pos.Offset <= offset && offset <= o.pos.Offset+len(o.name)
+}
+
+// Returns the occurrence at the given offset, if there is one.
+func (a *analysis) occurrenceAt(offset int) *occurrence {
+	i := sort.Search(len(a.occurrences), func(i int) bool {
+		return a.occurrences[i].pos.Offset+len(a.occurrences[i].name) >= offset
+	})
+	if i < len(a.occurrences) && a.occurrences[i].contains(offset) {
+		return &a.occurrences[i]
+	}
+	return nil
+}
+
+// Returns the occurrences of the variable.
+func (a *analysis) occurrencesOf(v *variable) []occurrence {
+	var occs []occurrence
+	for _, o := range a.occurrences {
+		if o.v == v {
+			occs = append(occs, o)
+		}
+	}
+	return occs
+}
+
+// Returns the local variables in scope at the given offset.  If several have
+// the same name, only the innermost one is returned.
+func (a *analysis) localsAt(offset int) []*variable {
+	byName := map[string]*variable{}
+	for _, v := range a.locals {
+		if v.decl.Offset < offset && offset <= v.scopeEnd {
+			if prev := byName[v.name]; prev == nil || prev.decl.Offset < v.decl.Offset {
+				byName[v.name] = v
+			}
+		}
+	}
+	vars := make([]*variable, 0, len(byName))
+	for _, v := range byName {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+	return vars
+}
+
+func (a *analysis) rangeOf(o occurrence) lspRange {
+	return a.lines.rangeAt(a.text, o.pos, len(o.name))
+}
+
+func analyse(text string, lines lineIndex, chunk ast.BlockStat) *analysis {
+	r := &resolver{
+		analysis: &analysis{
+			text:    text,
+			lines:   lines,
+			globals: map[string]*variable{},
+			fields:  map[string]map[string]bool{},
+		},
+	}
+	r.curSymbols = &r.analysis.symbols
+	r.openScope(len(text))
+	r.chunkScope = r.scope
+	r.stats(chunk)
+	a := r.analysis
+	sort.SliceStable(a.occurrences, func(i, j int) bool {
+		return a.occurrences[i].pos.Offset < a.occurrences[j].pos.Offset
+	})
+	return a
+}
+
+// A resolver walks the AST, keeping track of the local variables in scope to
+// resolve names.
+type resolver struct {
+	*analysis
+	scope      *scope
+	chunkScope *scope
+	depth      int               // Depth of the current function
+	curSymbols *[]documentSymbol // Where to add symbols
+}
+
+var _ ast.StatProcessor = (*resolver)(nil)
+var _ ast.ExpProcessor = (*resolver)(nil)
+
+type scope struct {
+	parent *scope
+	vars   []*variable
+	end    int
+}
+
+func (r *resolver) openScope(end int) {
+	r.scope = &scope{parent: r.scope, end: end}
+}
+
+func (r *resolver) closeScope() {
+	r.scope = r.scope.parent
+}
+
+// Returns the offset of the end of a node, or the end of the current scope if
+// it is not known.
+func (r *resolver) endOf(l ast.Locator) int {
+	if end := l.Locate().EndPos(); end != nil {
+		return end.Offset
+	}
+	return r.scope.end
+}
+
+func (r *resolver) lookup(name string) *variable {
+	for s := r.scope; s != nil; s = s.parent {
+		for i := len(s.vars) - 1; i >= 0; i-- {
+			if v := s.vars[i]; v.name == name {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func (r *resolver) declare(name ast.Name, kind string) *variable {
+	v := &variable{
+		name:     name.Val,
+		kind:     kind,
+		decl:     name.StartPos(),
+		depth:    r.depth,
+		scopeEnd: r.scope.end,
+	}
+	r.scope.vars = append(r.scope.vars, v)
+	if v.decl != nil {
+		r.locals = append(r.locals, v)
+		r.occurrences = append(r.occurrences, occurrence{pos: v.decl, name: v.name, v: v, write: true})
+	}
+	return v
+}
+
+// Records an occurrence of a name, resolving it to a local or a global.
+func (r *resolver) use(name ast.Name, write bool) {
+	pos := name.StartPos()
+	if pos == nil {
+		return
+	}
+	v := r.lookup(name.Val)
+	if v == nil {
+		v = r.globals[name.Val]
+		if v == nil {
+			v = &variable{name: name.Val, kind: "global"}
+			r.globals[name.Val] = v
+		}
+	}
+	r.occurrences = append(r.occurrences, occurrence{
+		pos:     pos,
+		name:    name.Val,
+		v:       v,
+		write:   write,
+		upvalue: v.kind != "global" && v.depth < r.depth,
+	})
+}
+
+// Records the fields of a table constructor assigned to a name.
+func (r *resolver) tableFields(name string, e ast.ExpNode) {
+	t, ok := e.(ast.TableConstructor)
+	if !ok {
+		return
+	}
+	for _, f := range t.Fields {
+		if k, ok := f.Key.(ast.String); ok {
+			r.addField(name, string(k.Val))
+		}
+	}
+}
+
+func (r *resolver) addField(name, field string) {
+	if r.fields[name] == nil {
+		r.fields[name] = map[string]bool{}
+	}
+	r.fields[name][field] = true
+}
+
+// Analyses a function (or the main chunk, which has no parameters).
+func (r *resolver) function(f ast.Function) {
+	r.depth++
+	r.openScope(r.endOf(f))
+	for _, p := range f.Params {
+		r.declare(p, "parameter")
+	}
+	r.stats(f.Body)
+	r.closeScope()
+	r.depth--
+}
+
+// Analyses a named function, adding a symbol for it.  The symbols of the
+// functions defined in its body are its children.
+func (r *resolver) namedFunction(name string, namePos *token.Pos, f ast.Function) {
+	sym := documentSymbol{
+		Name:           name,
+		Detail:         functionDetail(f),
+		Kind:           symbolKindFunction,
+		SelectionRange: r.lines.rangeAt(r.text, namePos, len(name)),
+	}
+	sym.Range = sym.SelectionRange
+	if end := f.EndPos(); end != nil {
+		sym.Range.End = r.lines.position(r.text, end.Line-1, end.Offset+len("end"))
+	}
+	symbols := r.curSymbols
+	r.curSymbols = &sym.Children
+	r.function(f)
+	r.curSymbols = symbols
+	*r.curSymbols = append(*r.curSymbols, sym)
+}
+
+func functionDetail(f ast.Function) string {
+	var params []string
+	for _, p := range f.Params {
+		params = append(params, p.Val)
+	}
+	if f.HasDots {
+		params = append(params, "...")
+	}
+	return "function(" + strings.Join(params, ", ") + ")"
+}
+
+func (r *resolver) variableSymbol(name ast.Name) {
+	rg := r.lines.rangeAt(r.text, name.StartPos(), len(name.Val))
+	*r.curSymbols = append(*r.curSymbols, documentSymbol{
+		Name:           name.Val,
+		Kind:           symbolKindVariable,
+		Range:          rg,
+		SelectionRange: rg,
+	})
+}
+
+// Analyses the statements of a block in the current scope.
+func (r *resolver) stats(b ast.BlockStat) {
+	for _, s := range b.Stats {
+		s.ProcessStat(r)
+	}
+	r.exps(b.Return)
+}
+
+func (r *resolver) block(b ast.BlockStat, end int) {
+	r.openScope(end)
+	r.stats(b)
+	r.closeScope()
+}
+
+func (r *resolver) exps(exps []ast.ExpNode) {
+	for _, e := range exps {
+		e.ProcessExp(r)
+	}
+}
+
+//
+// Statements
+//
+
+// ProcessAssignStat analyses an assignment statement, which may be a function
+// statement.
+func (r *resolver) ProcessAssignStat(s ast.AssignStat) {
+	for i, e := range s.Src {
+		f, ok := e.(ast.Function)
+		if !ok || i >= len(s.Dest) || f.Name == "" {
+			e.ProcessExp(r)
+			continue
+		}
+		var namePos *token.Pos
+		switch v := s.Dest[i].(type) {
+		case ast.Name:
+			namePos = v.StartPos()
+		case ast.IndexExp:
+			namePos = v.Idx.Locate().StartPos()
+		}
+		if namePos == nil {
+			e.ProcessExp(r)
+			continue
+		}
+		name := functionStatName(s.Dest[i], f)
+		if name == "" {
+			name = f.Name
+		}
+		r.namedFunction(name, namePos, f)
+	}
+	for i, v := range s.Dest {
+		switch v := v.(type) {
+		case ast.Name:
+			r.use(v, true)
+			if i < len(s.Src) {
+				r.tableFields(v.Val, s.Src[i])
+				if _, ok := s.Src[i].(ast.Function); !ok && r.scope == r.chunkScope && r.lookup(v.Val) == nil {
+					r.variableSymbol(v)
+				}
+			}
+		case ast.IndexExp:
+			r.ProcessIndexExp(v)
+			if coll, ok := v.Coll.(ast.Name); ok {
+				if k, ok := v.Idx.(ast.String); ok {
+					r.addField(coll.Val, string(k.Val))
+				}
+			}
+		}
+	}
+}
+
+// Returns the name of the function as written in a function statement, e.g.
+// "M.f" or "M:f".
+func functionStatName(v ast.Var, f ast.Function) string {
+	var parts []string
+	for {
+		switch e := v.(type) {
+		case ast.Name:
+			parts = append(parts, e.Val)
+			name := ""
+			for i := len(parts) - 1; i >= 0; i-- {
+				name += parts[i]
+				if i > 0 {
+					name += "."
+				}
+			}
+			if f.Syntax == ast.MethodStatSyntax || len(f.Params) > 0 && f.Params[0].Val == "self" && f.Params[0].StartPos() == nil {
+				if i := strings.LastIndexByte(name, '.'); i >= 0 {
+					name = name[:i] + ":" + name[i+1:]
+				}
+			}
+			return name
+		case ast.IndexExp:
+			k, ok := e.Idx.(ast.String)
+			if !ok {
+				return ""
+			}
+			parts = append(parts, string(k.Val))
+			v = e.Coll.(ast.Var)
+		default:
+			return ""
+		}
+	}
+}
+
+// ProcessBlockStat analyses a do ... end statement.
+func (r *resolver) ProcessBlockStat(s ast.BlockStat) {
+	r.block(s, r.scope.end)
+}
+
+// ProcessBreakStat does nothing.
+func (r *resolver) ProcessBreakStat(s ast.BreakStat) {}
+
+// ProcessEmptyStat does nothing.
+func (r *resolver) ProcessEmptyStat(s ast.EmptyStat) {}
+
+// ProcessForInStat analyses a for in statement.
+func (r *resolver) ProcessForInStat(s ast.ForInStat) {
+	r.exps(s.Params)
+	r.openScope(r.endOf(s))
+	for _, v := range s.Vars {
+		r.declare(v, "loop variable")
+	}
+	r.stats(s.Body)
+	r.closeScope()
+}
+
+// ProcessForStat analyses a numeric for statement.
+func (r *resolver) ProcessForStat(s ast.ForStat) {
+	r.exps([]ast.ExpNode{s.Start, s.Stop, s.Step})
+	r.openScope(r.endOf(s))
+	r.declare(s.Var, "loop variable")
+	r.stats(s.Body)
+	r.closeScope()
+}
+
+// ProcessFunctionCallStat analyses a function call statement.
+func (r *resolver) ProcessFunctionCallStat(s ast.FunctionCall) {
+	r.ProcessFunctionCallExp(s)
+}
+
+// ProcessGotoStat does nothing.
+func (r *resolver) ProcessGotoStat(s ast.GotoStat) {}
+
+// ProcessIfStat analyses an if statement.  The end of the statement is not
+// known so the scopes of its blocks are extended to the enclosing scope.
+func (r *resolver) ProcessIfStat(s ast.IfStat) {
+	s.If.Cond.ProcessExp(r)
+	r.block(s.If.Body, r.scope.end)
+	for _, elseIf := range s.ElseIfs {
+		elseIf.Cond.ProcessExp(r)
+		r.block(elseIf.Body, r.scope.end)
+	}
+	if s.Else != nil {
+		r.block(*s.Else, r.scope.end)
+	}
+}
+
+// ProcessLabelStat does nothing.
+func (r *resolver) ProcessLabelStat(s ast.LabelStat) {}
+
+// ProcessLocalFunctionStat analyses a local function definition.  The
+// function is in scope in its body.
+func (r *resolver) ProcessLocalFunctionStat(s ast.LocalFunctionStat) {
+	v := r.declare(s.Name, "local function")
+	v.detail = functionDetail(s.Function)
+	r.namedFunction(s.Name.Val, s.Name.StartPos(), s.Function)
+}
+
+// ProcessLocalStat analyses a local variable declaration.
+func (r *resolver) ProcessLocalStat(s ast.LocalStat) {
+	r.exps(s.Values)
+	for i, na := range s.NameAttribs {
+		r.declare(na.Name, "local variable")
+		if i < len(s.Values) {
+			r.tableFields(na.Name.Val, s.Values[i])
+		}
+		if r.scope == r.chunkScope {
+			r.variableSymbol(na.Name)
+		}
+	}
+}
+
+// ProcessRepeatStat analyses a repeat ... until statement.  The condition is
+// in the scope of the body.
+func (r *resolver) ProcessRepeatStat(s ast.RepeatStat) {
+	r.openScope(r.endOf(s))
+	r.stats(s.Body)
+	s.Cond.ProcessExp(r)
+	r.closeScope()
+}
+
+// ProcessWhileStat analyses a while statement.
+func (r *resolver) ProcessWhileStat(s ast.WhileStat) {
+	s.Cond.ProcessExp(r)
+	r.block(s.Body, r.endOf(s))
+}
+
+//
+// Expressions
+//
+
+// ProcessBFunctionCallExp analyses a function call in brackets.
+func (r *resolver) ProcessBFunctionCallExp(f ast.BFunctionCall) {
+	r.ProcessFunctionCallExp(ast.FunctionCall{BFunctionCall: &f})
+}
+
+// ProcessBinOpExp analyses the operands of a binary operation.
+func (r *resolver) ProcessBinOpExp(b ast.BinOp) {
+	b.Left.ProcessExp(r)
+	for _, o := range b.Right {
+		o.Operand.ProcessExp(r)
+	}
+}
+
+// ProcesBoolExp does nothing.
+func (r *resolver) ProcesBoolExp(b ast.Bool) {}
+
+// ProcessEtcExp does nothing.
+func (r *resolver) ProcessEtcExp(e ast.Etc) {}
+
+// ProcessFunctionExp analyses a function definition.
+func (r *resolver) ProcessFunctionExp(f ast.Function) {
+	r.function(f)
+}
+
+// ProcessFunctionCallExp analyses a function call.
+func (r *resolver) ProcessFunctionCallExp(f ast.FunctionCall) {
+	f.Target.ProcessExp(r)
+	r.exps(f.Args)
+}
+
+// ProcessIndexExp analyses an index expression, recording the field if it
+// indexes a global with a name, e.g. "string.format".
+func (r *resolver) ProcessIndexExp(e ast.IndexExp) {
+	e.Coll.ProcessExp(r)
+	e.Idx.ProcessExp(r)
+	coll, ok := e.Coll.(ast.Name)
+	if !ok || r.lookup(coll.Val) != nil {
+		return
+	}
+	k, ok := e.Idx.(ast.String)
+	if !ok || k.StartPos() == nil {
+		return
+	}
+	r.occurrences = append(r.occurrences, occurrence{
+		pos:   k.StartPos(),
+		name:  string(k.Val),
+		field: coll.Val + "." + string(k.Val),
+	})
+}
+
+// ProcessNameExp records the occurrence of a name.
+func (r *resolver) ProcessNameExp(n ast.Name) {
+	r.use(n, false)
+}
+
+// ProcessNilExp does nothing.
+func (r *resolver) ProcessNilExp(n ast.Nil) {}
+
+// ProcessIntExp does nothing.
+func (r *resolver) ProcessIntExp(n ast.Int) {}
+
+// ProcessFloatExp does nothing.
+func (r *resolver) ProcessFloatExp(f ast.Float) {}
+
+// ProcessStringExp does nothing.
+func (r *resolver) ProcessStringExp(s ast.String) {}
+
+// ProcessTableConstructorExp analyses the fields of a table constructor.
+func (r *resolver) ProcessTableConstructorExp(t ast.TableConstructor) {
+	for _, f := range t.Fields {
+		if _, ok := f.Key.(ast.NoTableKey); !ok {
+			f.Key.ProcessExp(r)
+		}
+		f.Value.ProcessExp(r)
+	}
+}
+
+// ProcessUnOpExp analyses the operand of a unary operation.
+func (r *resolver) ProcessUnOpExp(u ast.UnOp) {
+	u.Operand.ProcessExp(r)
+}
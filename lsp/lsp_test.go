@@ -0,0 +1,479 @@
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lsp"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// A testClient talks to an lsp.Server over an in-memory connection.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   int
+	msgs chan *testMessage
+	done chan error
+}
+
+type testMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int
+		Message string
+	} `json:"error"`
+}
+
+func newTestClient(t *testing.T) *testClient {
+	clientConn, serverConn := net.Pipe()
+	c := &testClient{
+		t:    t,
+		conn: clientConn,
+		msgs: make(chan *testMessage, 100),
+		done: make(chan error, 1),
+	}
+	go func() {
+		c.done <- lsp.Serve(serverConn)
+		serverConn.Close()
+	}()
+	go c.readMessages()
+	c.request("initialize", map[string]interface{}{"capabilities": map[string]interface{}{}}, nil)
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+func (c *testClient) readMessages() {
+	defer close(c.msgs)
+	r := textproto.NewReader(bufio.NewReader(c.conn))
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			return
+		}
+		msg := new(testMessage)
+		if err := json.Unmarshal(body, msg); err != nil {
+			c.t.Errorf("invalid message %q: %s", body, err)
+			return
+		}
+		c.msgs <- msg
+	}
+}
+
+func (c *testClient) write(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.write(map[string]interface{}{"method": method, "params": params})
+}
+
+func (c *testClient) next() *testMessage {
+	c.t.Helper()
+	select {
+	case msg, ok := <-c.msgs:
+		if !ok {
+			c.t.Fatal("connection closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for a message")
+	}
+	return nil
+}
+
+// Sends a request and returns its response.
+func (c *testClient) send(method string, params interface{}) *testMessage {
+	c.t.Helper()
+	c.id++
+	c.write(map[string]interface{}{"id": c.id, "method": method, "params": params})
+	msg := c.next()
+	if msg.ID == nil || *msg.ID != c.id {
+		c.t.Fatalf("expected response to %s, got %+v", method, msg)
+	}
+	return msg
+}
+
+// Sends a request and checks that it gets a successful response, whose result
+// is decoded into result if it is not nil.
+func (c *testClient) request(method string, params interface{}, result interface{}) {
+	c.t.Helper()
+	msg := c.send(method, params)
+	if msg.Error != nil {
+		c.t.Fatalf("%s failed: %s", method, msg.Error.Message)
+	}
+	if result != nil {
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+type testDiagnostic struct {
+	Range   testRange
+	Message string
+}
+
+type testRange struct {
+	Start, End testPosition
+}
+
+type testPosition struct {
+	Line, Character int
+}
+
+type testLocation struct {
+	URI   string
+	Range testRange
+}
+
+func (l testLocation) String() string {
+	return fmt.Sprintf("%s:%d:%d", l.URI, l.Range.Start.Line, l.Range.Start.Character)
+}
+
+// Opens a document and returns the diagnostics published for it.
+func (c *testClient) open(uri, text string) []testDiagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "languageId": "lua", "version": 1, "text": text},
+	})
+	return c.expectDiagnostics(uri)
+}
+
+func (c *testClient) change(uri, text string) []testDiagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []interface{}{map[string]interface{}{"text": text}},
+	})
+	return c.expectDiagnostics(uri)
+}
+
+func (c *testClient) expectDiagnostics(uri string) []testDiagnostic {
+	c.t.Helper()
+	msg := c.next()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("expected diagnostics, got %+v", msg)
+	}
+	var params struct {
+		URI         string
+		Diagnostics []testDiagnostic
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	if params.URI != uri {
+		c.t.Fatalf("expected diagnostics for %s, got %s", uri, params.URI)
+	}
+	return params.Diagnostics
+}
+
+func positionParams(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri},
+		"position":     map[string]interface{}{"line": line, "character": char},
+	}
+}
+
+func (c *testClient) shutdown() {
+	c.t.Helper()
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	select {
+	case err := <-c.done:
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for the server to exit")
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := newTestClient(t)
+	defer c.shutdown()
+
+	if diags := c.open("file:///tmp/ok.lua", "#!/usr/bin/env golua\nlocal x = 1\nprint(x)\n"); len(diags) != 0 {
+		t.Errorf("expected no diagnostics, got %+v", diags)
+	}
+
+	r := rt.New(ioutil.Discard)
+	for _, src := range []string{
+		"local x = 1\nif x then\n  print(x)\n",
+		"local t = {1, 2\nprint(t)",
+		"x = 1 +* 2",
+		"local s = 'abc",
+		"do\n  goto done\nend\n::done::\ngoto nowhere\n",
+		"local x <const> = 1\nx = 2",
+	} {
+		diags := c.change("file:///tmp/ok.lua", src)
+		if len(diags) != 1 {
+			t.Errorf("%q: expected one diagnostic, got %+v", src, diags)
+			continue
+		}
+		_, _, err := r.CompileLuaChunk("/tmp/ok.lua", []byte(src))
+		if err == nil {
+			t.Errorf("%q: expected load to fail", src)
+			continue
+		}
+		d := diags[0]
+		prefix := fmt.Sprintf("/tmp/ok.lua:%d:", d.Range.Start.Line+1)
+		if !strings.HasPrefix(err.Error(), prefix) || !strings.HasSuffix(err.Error(), ": "+d.Message) {
+			t.Errorf("%q: diagnostic %q on line %d does not match load error %q", src, d.Message, d.Range.Start.Line+1, err)
+		}
+	}
+
+	// All the syntax errors are reported
+	diags := c.change("file:///tmp/ok.lua", "x = = 1\ny = 2\nz = (")
+	var got []string
+	for _, d := range diags {
+		got = append(got, fmt.Sprintf("%d:%d-%d:%d: %s", d.Range.Start.Line, d.Range.Start.Character, d.Range.End.Line, d.Range.End.Character, d.Message))
+	}
+	want := []string{
+		"0:4-0:5: unexpected symbol near '='",
+		"2:5-2:5: unexpected symbol near <eof>",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diagnostics %q, want %q", got, want)
+	}
+
+	if diags := c.change("file:///tmp/ok.lua", "print(1)"); len(diags) != 0 {
+		t.Errorf("expected the diagnostics to be cleared, got %+v", diags)
+	}
+}
+
+const testSource = `local M = {}
+local count = 0
+
+local function incr(n)
+  count = count + n
+  return count
+end
+
+function M.add(a, b)
+  local function helper()
+    return a + b
+  end
+  return helper()
+end
+
+function M:name()
+  return string.format("%s", self)
+end
+
+total = incr(1)
+return M
+`
+
+func TestDocumentSymbols(t *testing.T) {
+	c := newTestClient(t)
+	defer c.shutdown()
+	c.open("file:///m.lua", testSource)
+
+	type symbol struct {
+		Name     string
+		Detail   string
+		Range    testRange
+		Children []symbol
+	}
+	var symbols []symbol
+	c.request("textDocument/documentSymbol", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": "file:///m.lua"},
+	}, &symbols)
+	var got []string
+	var walk func(prefix string, symbols []symbol)
+	walk = func(prefix string, symbols []symbol) {
+		for _, s := range symbols {
+			got = append(got, fmt.Sprintf("%s%s %s %d-%d", prefix, s.Name, s.Detail, s.Range.Start.Line, s.Range.End.Line))
+			walk(prefix+"  ", s.Children)
+		}
+	}
+	walk("", symbols)
+	want := []string{
+		"M  0-0",
+		"count  1-1",
+		"incr function(n) 3-6",
+		"M.add function(a, b) 8-13",
+		"  helper function() 9-11",
+		"M:name function(self) 15-17",
+		"total  19-19",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got symbols:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDefinitionAndReferences(t *testing.T) {
+	c := newTestClient(t)
+	defer c.shutdown()
+	c.open("file:///m.lua", testSource)
+	c.open("file:///n.lua", "print(total)\ntotal = nil\n")
+
+	tests := []struct {
+		line, char int
+		definition []string
+		references []string
+	}{
+		// "count" in the body of incr is an upvalue
+		{4, 2, []string{"file:///m.lua:1:6"}, []string{"file:///m.lua:1:6", "file:///m.lua:4:2", "file:///m.lua:4:10", "file:///m.lua:5:9"}},
+		// The parameter "a" used in helper
+		{10, 11, []string{"file:///m.lua:8:15"}, []string{"file:///m.lua:8:15", "file:///m.lua:10:11"}},
+		// The global "total", across documents
+		{19, 0, []string{"file:///m.lua:19:0", "file:///n.lua:1:0"}, []string{"file:///m.lua:19:0", "file:///n.lua:0:6", "file:///n.lua:1:0"}},
+		// "string" is a global without definition
+		{16, 10, []string{}, []string{"file:///m.lua:16:9"}},
+		// Nothing at this position
+		{2, 0, []string{}, []string{}},
+	}
+	for _, test := range tests {
+		var defs, refs []testLocation
+		c.request("textDocument/definition", positionParams("file:///m.lua", test.line, test.char), &defs)
+		params := positionParams("file:///m.lua", test.line, test.char)
+		params["context"] = map[string]interface{}{"includeDeclaration": true}
+		c.request("textDocument/references", params, &refs)
+		if got := locationStrings(defs); strings.Join(got, " ") != strings.Join(test.definition, " ") {
+			t.Errorf("%d:%d: got definitions %v, want %v", test.line, test.char, got, test.definition)
+		}
+		if got := locationStrings(refs); strings.Join(got, " ") != strings.Join(test.references, " ") {
+			t.Errorf("%d:%d: got references %v, want %v", test.line, test.char, got, test.references)
+		}
+	}
+}
+
+func locationStrings(locs []testLocation) []string {
+	strs := []string{}
+	for _, l := range locs {
+		strs = append(strs, l.String())
+	}
+	return strs
+}
+
+func TestHover(t *testing.T) {
+	c := newTestClient(t)
+	defer c.shutdown()
+	c.open("file:///m.lua", testSource)
+
+	tests := []struct {
+		line, char int
+		want       string // A substring of the hover text, or "" for no hover
+	}{
+		{16, 17, "string.format(formatstring, ···)"},
+		{16, 10, "(module) string"},
+		{4, 3, "(upvalue) count"},
+		{1, 7, "(local variable) count"},
+		{19, 9, "(local function) incr(n)"},
+		{10, 15, "(upvalue) b"},
+		{19, 1, "(global) total"},
+		{0, 0, ""},
+	}
+	for _, test := range tests {
+		var result *struct {
+			Contents struct{ Value string }
+		}
+		c.request("textDocument/hover", positionParams("file:///m.lua", test.line, test.char), &result)
+		switch {
+		case result == nil && test.want != "":
+			t.Errorf("%d:%d: expected hover containing %q, got none", test.line, test.char, test.want)
+		case result != nil && test.want == "":
+			t.Errorf("%d:%d: expected no hover, got %q", test.line, test.char, result.Contents.Value)
+		case result != nil && !strings.Contains(result.Contents.Value, test.want):
+			t.Errorf("%d:%d: expected hover containing %q, got %q", test.line, test.char, test.want, result.Contents.Value)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c := newTestClient(t)
+	defer c.shutdown()
+	c.open("file:///m.lua", testSource)
+
+	complete := func(text string, line, char int) []string {
+		t.Helper()
+		c.change("file:///m.lua", text)
+		var items []struct{ Label string }
+		c.request("textDocument/completion", positionParams("file:///m.lua", line, char), &items)
+		var labels []string
+		for _, item := range items {
+			labels = append(labels, item.Label)
+		}
+		return labels
+	}
+	src := testSource
+	tests := []struct {
+		text       string
+		line, char int
+		want       []string
+		notWant    []string
+	}{
+		// Fields of a library module, while the document cannot be parsed
+		{src + "string.fo", 21, 9, []string{"format"}, []string{"byte"}},
+		{src + "table.", 21, 6, []string{"concat", "insert", "unpack"}, nil},
+		// Fields of a table defined in the document
+		{src + "M.", 21, 2, []string{"add", "name"}, []string{"format"}},
+		// Locals in scope and globals
+		{strings.Replace(src, "    return a + b", "    return a + co", 1), 10, 17, []string{"collectgarbage", "coroutine", "count"}, []string{"a", "incr"}},
+		{src, 10, 12, []string{"a", "assert"}, []string{"b"}},
+		{src + "to", 21, 2, []string{"tonumber", "tostring", "total"}, []string{"count"}},
+	}
+	for _, test := range tests {
+		got := complete(test.text, test.line, test.char)
+		for _, w := range test.want {
+			if !contains(got, w) {
+				t.Errorf("%d:%d: expected completion %q, got %v", test.line, test.char, w, got)
+			}
+		}
+		for _, w := range test.notWant {
+			if contains(got, w) {
+				t.Errorf("%d:%d: unexpected completion %q in %v", test.line, test.char, w, got)
+			}
+		}
+	}
+}
+
+func contains(strs []string, s string) bool {
+	for _, x := range strs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProtocolErrors(t *testing.T) {
+	c := newTestClient(t)
+	if msg := c.send("textDocument/foo", nil); msg.Error == nil || msg.Error.Code != -32601 {
+		t.Errorf("expected method not found error, got %+v", msg)
+	}
+	c.request("shutdown", nil, nil)
+	if msg := c.send("textDocument/hover", positionParams("file:///m.lua", 0, 0)); msg.Error == nil {
+		t.Errorf("expected an error after shutdown, got %+v", msg)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,244 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/arnodel/golua/internal/framing"
+)
+
+// Messages are JSON-RPC 2.0 messages framed with a "Content-Length" header, an
+// empty line and then the JSON encoded message.
+
+// A message is a request (it has an id and a method), a notification (it has a
+// method but no id) or a response from the client (it has an id but no
+// method).
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+// A response must not have a result when it has an error.
+type errorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   responseError    `json:"error"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// JSON-RPC error codes.
+const (
+	codeInvalidParams        = -32602
+	codeMethodNotFound       = -32601
+	codeServerNotInitialized = -32002
+	codeInvalidRequest       = -32600
+)
+
+// A conn reads and writes LSP messages.  Writing is safe for concurrent use.
+type conn struct {
+	r *framing.Reader
+
+	mu sync.Mutex // protects w
+	w  io.Writer
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{
+		r: framing.NewReader(rw),
+		w: rw,
+	}
+}
+
+func (c *conn) readMessage() (*message, error) {
+	body, err := c.r.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (c *conn) reply(msg *message, result interface{}) error {
+	return c.write(&response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+func (c *conn) replyError(msg *message, code int, err error) error {
+	return c.write(&errorResponse{
+		JSONRPC: "2.0",
+		ID:      msg.ID,
+		Error:   responseError{Code: code, Message: err.Error()},
+	})
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	return c.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (c *conn) write(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return framing.WriteMessage(c.w, data)
+}
+
+// Parameters and results of the requests and notifications that are supported.
+// Only the fields that are used are declared.
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type location struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+	ServerInfo   serverInfo         `json:"serverInfo"`
+}
+
+type serverInfo struct {
+	Name string `json:"name"`
+}
+
+type serverCapabilities struct {
+	TextDocumentSync       int               `json:"textDocumentSync"`
+	DocumentSymbolProvider bool              `json:"documentSymbolProvider"`
+	DefinitionProvider     bool              `json:"definitionProvider"`
+	ReferencesProvider     bool              `json:"referencesProvider"`
+	HoverProvider          bool              `json:"hoverProvider"`
+	CompletionProvider     completionOptions `json:"completionProvider"`
+}
+
+// Documents are synchronised by sending their full content on each change.
+const textDocumentSyncFull = 1
+
+type completionOptions struct {
+	TriggerCharacters []string `json:"triggerCharacters"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type diagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+const severityError = 1
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type documentSymbolParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type documentSymbol struct {
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          lspRange         `json:"range"`
+	SelectionRange lspRange         `json:"selectionRange"`
+	Children       []documentSymbol `json:"children,omitempty"`
+}
+
+// Symbol kinds.
+const (
+	symbolKindFunction = 12
+	symbolKindVariable = 13
+)
+
+type referenceParams struct {
+	textDocumentPositionParams
+	Context struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    *lspRange     `json:"range,omitempty"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type completionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Completion item kinds.
+const (
+	completionKindFunction = 3
+	completionKindField    = 5
+	completionKindVariable = 6
+	completionKindModule   = 9
+)
@@ -0,0 +1,491 @@
+// Package lsp implements a language server for Lua, i.e. a server for the
+// Language Server Protocol (see https://microsoft.github.io/language-server-protocol/).
+// It gives editors that support the protocol diagnostics for syntax and
+// compilation errors, document symbols, go to definition and find references
+// for local variables, upvalues and globals, hover information with the
+// signatures of the standard library functions and completion of names and of
+// the fields of modules.
+//
+// Diagnostics are computed with the parser and compiler used by the runtime, so
+// they are exactly the errors that load would return, except that the parser
+// recovers from syntax errors to report all of them.  Documents are analysed
+// as a whole each time they change.  When a document cannot be parsed, the
+// analysis of its last version which could be is used.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/arnodel/golua/luastdlib"
+)
+
+var (
+	errNotInitialized = errors.New("server not initialized")
+	errShutdown       = errors.New("server is shutting down")
+	errNoShutdown     = errors.New("exit notification received before shutdown")
+)
+
+// A Server serves the requests of a client for the documents it opens.
+type Server struct {
+	conn        *conn
+	documents   map[string]*document // by URI
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a new server for a client that sends requests to rw and
+// reads responses and notifications from it.  Call Run to serve the requests.
+func NewServer(rw io.ReadWriter) *Server {
+	return &Server{
+		conn:      newConn(rw),
+		documents: map[string]*document{},
+	}
+}
+
+// Run serves the requests sent by the client until it sends the "exit"
+// notification or rw is closed.  It returns an error if the client exits
+// without requesting a shutdown first.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.conn.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// Serve runs a server for a client over rw (see Server.Run).
+func Serve(rw io.ReadWriter) error {
+	return NewServer(rw).Run()
+}
+
+type messageHandler func(s *Server, msg *message) error
+
+var messageHandlers = map[string]messageHandler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 (*Server).ignore,
+	"shutdown":                    (*Server).shutdownRequest,
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/references":     (*Server).references,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/completion":     (*Server).completion,
+}
+
+// Handles a message.  Only errors writing to the client are returned, other
+// errors are reported in the response.  Unknown notifications are ignored.
+func (s *Server) handle(msg *message) error {
+	isRequest := msg.ID != nil
+	if msg.Method == "" {
+		// A response to a request from the server, which sends none.
+		return nil
+	}
+	handler, ok := messageHandlers[msg.Method]
+	switch {
+	case !ok && isRequest:
+		return s.conn.replyError(msg, codeMethodNotFound, fmt.Errorf("unsupported method: %q", msg.Method))
+	case !ok:
+		return nil
+	case !s.initialized && msg.Method != "initialize":
+		if isRequest {
+			return s.conn.replyError(msg, codeServerNotInitialized, errNotInitialized)
+		}
+		return nil
+	case s.shutdown && isRequest:
+		return s.conn.replyError(msg, codeInvalidRequest, errShutdown)
+	}
+	return handler(s, msg)
+}
+
+// Decodes the parameters of a message into params.  If that fails and the
+// message is a request, the error is sent in the response.
+func (s *Server) decodeParams(msg *message, params interface{}) (bool, error) {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		if msg.ID == nil {
+			return false, nil
+		}
+		return false, s.conn.replyError(msg, codeInvalidParams, err)
+	}
+	return true, nil
+}
+
+func (s *Server) initialize(msg *message) error {
+	s.initialized = true
+	return s.conn.reply(msg, initializeResult{
+		Capabilities: serverCapabilities{
+			TextDocumentSync:       textDocumentSyncFull,
+			DocumentSymbolProvider: true,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+			HoverProvider:          true,
+			CompletionProvider:     completionOptions{TriggerCharacters: []string{".", ":"}},
+		},
+		ServerInfo: serverInfo{Name: "golua"},
+	})
+}
+
+func (s *Server) ignore(msg *message) error {
+	return nil
+}
+
+func (s *Server) shutdownRequest(msg *message) error {
+	s.shutdown = true
+	return s.conn.reply(msg, nil)
+}
+
+//
+// Document synchronisation
+//
+
+func (s *Server) didOpen(msg *message) error {
+	var params didOpenParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	doc := &document{uri: params.TextDocument.URI, name: chunkName(params.TextDocument.URI)}
+	s.documents[doc.uri] = doc
+	return s.updateDocument(doc, params.TextDocument.Text)
+}
+
+func (s *Server) didChange(msg *message) error {
+	var params didChangeParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil || len(params.ContentChanges) == 0 {
+		return nil
+	}
+	// Changes contain the full text as that is the only kind of
+	// synchronisation the server supports.
+	return s.updateDocument(doc, params.ContentChanges[len(params.ContentChanges)-1].Text)
+}
+
+func (s *Server) didClose(msg *message) error {
+	var params didCloseParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	delete(s.documents, params.TextDocument.URI)
+	return s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         params.TextDocument.URI,
+		Diagnostics: []diagnostic{},
+	})
+}
+
+func (s *Server) updateDocument(doc *document, text string) error {
+	doc.update(text)
+	return s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+		URI:         doc.uri,
+		Diagnostics: doc.diagnostics,
+	})
+}
+
+// Returns the name of the chunk for a document, which is its path for a file.
+func chunkName(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return uri
+	}
+	return u.Path
+}
+
+// Returns the analysed documents, sorted by URI.
+func (s *Server) analyses() []*document {
+	var docs []*document
+	for _, doc := range s.documents {
+		if doc.analysis != nil {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].uri < docs[j].uri })
+	return docs
+}
+
+//
+// Language features
+//
+
+func (s *Server) documentSymbol(msg *message) error {
+	var params documentSymbolParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	symbols := []documentSymbol{}
+	if doc := s.documents[params.TextDocument.URI]; doc != nil && doc.analysis != nil {
+		symbols = append(symbols, doc.analysis.symbols...)
+	}
+	return s.conn.reply(msg, symbols)
+}
+
+// Returns the document and the occurrence of a name at the given position, if
+// there is one.
+func (s *Server) occurrenceAt(params textDocumentPositionParams) (*document, *occurrence) {
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil || doc.analysis == nil {
+		return nil, nil
+	}
+	a := doc.analysis
+	return doc, a.occurrenceAt(a.lines.offset(a.text, params.Position))
+}
+
+func (s *Server) definition(msg *message) error {
+	var params textDocumentPositionParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	doc, occ := s.occurrenceAt(params)
+	locations := []location{}
+	switch {
+	case occ == nil || occ.v == nil:
+	case occ.v.kind != "global":
+		if occ.v.decl != nil {
+			decl := occurrence{pos: occ.v.decl, name: occ.v.name}
+			locations = append(locations, location{URI: doc.uri, Range: doc.analysis.rangeOf(decl)})
+		}
+	default:
+		// The definitions of a global are the assignments to it.
+		for _, d := range s.analyses() {
+			for _, o := range d.analysis.occurrencesOf(d.analysis.globals[occ.name]) {
+				if o.write {
+					locations = append(locations, location{URI: d.uri, Range: d.analysis.rangeOf(o)})
+				}
+			}
+		}
+	}
+	return s.conn.reply(msg, locations)
+}
+
+func (s *Server) references(msg *message) error {
+	var params referenceParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	doc, occ := s.occurrenceAt(params.textDocumentPositionParams)
+	locations := []location{}
+	switch {
+	case occ == nil || occ.v == nil:
+	case occ.v.kind != "global":
+		for _, o := range doc.analysis.occurrencesOf(occ.v) {
+			if o.pos != occ.v.decl || params.Context.IncludeDeclaration {
+				locations = append(locations, location{URI: doc.uri, Range: doc.analysis.rangeOf(o)})
+			}
+		}
+	default:
+		for _, d := range s.analyses() {
+			for _, o := range d.analysis.occurrencesOf(d.analysis.globals[occ.name]) {
+				locations = append(locations, location{URI: d.uri, Range: d.analysis.rangeOf(o)})
+			}
+		}
+	}
+	return s.conn.reply(msg, locations)
+}
+
+func (s *Server) hover(msg *message) error {
+	var params textDocumentPositionParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	doc, occ := s.occurrenceAt(params)
+	if occ == nil {
+		return s.conn.reply(msg, nil)
+	}
+	text := s.hoverText(occ)
+	if text == "" {
+		return s.conn.reply(msg, nil)
+	}
+	rg := doc.analysis.rangeOf(*occ)
+	return s.conn.reply(msg, hover{
+		Contents: markupContent{Kind: "markdown", Value: text},
+		Range:    &rg,
+	})
+}
+
+// Returns the markdown shown when hovering over an occurrence, or "" if
+// there is nothing to show.
+func (s *Server) hoverText(occ *occurrence) string {
+	if occ.v == nil {
+		return stdlibHoverText(occ.field)
+	}
+	v := occ.v
+	if v.kind == "global" {
+		if !s.isAssigned(v.name) {
+			if text := stdlibHoverText(v.name); text != "" {
+				return text
+			}
+		}
+		return codeBlock("(global) " + v.name)
+	}
+	kind := v.kind
+	if occ.upvalue {
+		kind = "upvalue"
+	}
+	text := codeBlock(fmt.Sprintf("(%s) %s%s", kind, v.name, strings.TrimPrefix(v.detail, "function")))
+	if v.decl != nil {
+		text += fmt.Sprintf("\n\nDeclared on line %d.", v.decl.Line)
+	}
+	return text
+}
+
+// Returns true if a global is assigned to in an open document, in which case
+// it does not refer to the standard library.
+func (s *Server) isAssigned(name string) bool {
+	for _, d := range s.analyses() {
+		for _, o := range d.analysis.occurrencesOf(d.analysis.globals[name]) {
+			if o.write {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the markdown describing a global or a field of a global (e.g.
+// "string.format") defined by the standard library, or "" if it does not
+// define it.
+func stdlibHoverText(name string) string {
+	if f, ok := luastdlib.Functions[name]; ok {
+		return codeBlock(f.Signature) + "\n\n" + f.Doc
+	}
+	loadStdlib()
+	var kind int
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		var ok bool
+		if kind, ok = stdlibFields[name[:i]][name[i+1:]]; !ok {
+			return ""
+		}
+	} else {
+		var ok bool
+		if kind, ok = stdlibGlobals[name]; !ok {
+			return ""
+		}
+	}
+	switch kind {
+	case completionKindModule:
+		return codeBlock("(module) " + name)
+	case completionKindFunction:
+		return codeBlock("(function) " + name)
+	default:
+		return codeBlock("(field) " + name)
+	}
+}
+
+func codeBlock(code string) string {
+	return "```lua\n" + code + "\n```"
+}
+
+// Matches the end of the text before the cursor when completing a field, e.g.
+// "string.fo" or "obj:".
+var fieldPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z_0-9]*)\s*[.:]\s*([A-Za-z_0-9]*)$`)
+
+// Matches the end of the text before the cursor when completing a name.
+var namePrefix = regexp.MustCompile(`(^|[^.:A-Za-z_0-9])([A-Za-z_][A-Za-z_0-9]*)?$`)
+
+func (s *Server) completion(msg *message) error {
+	var params textDocumentPositionParams
+	if ok, err := s.decodeParams(msg, &params); !ok {
+		return err
+	}
+	items := []completionItem{}
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil {
+		return s.conn.reply(msg, items)
+	}
+	// The text before the cursor is taken from the current version of the
+	// document, which may not have been analysed if it cannot be parsed.
+	lines := newLineIndex(doc.text)
+	offset := lines.offset(doc.text, params.Position)
+	pos := params.Position
+	pos.Character = 0
+	before := doc.text[lines.offset(doc.text, pos):offset]
+	var locals []*variable
+	if a := doc.analysis; a != nil {
+		locals = a.localsAt(a.lines.offset(a.text, params.Position))
+	}
+	if m := fieldPrefix.FindStringSubmatch(before); m != nil {
+		items = s.fieldCompletions(m[1], m[2], locals)
+	} else if m := namePrefix.FindStringSubmatch(before); m != nil {
+		items = s.nameCompletions(m[2], locals)
+	}
+	return s.conn.reply(msg, items)
+}
+
+// Returns the fields of the table with the given name that start with prefix.
+func (s *Server) fieldCompletions(table, prefix string, locals []*variable) []completionItem {
+	items := map[string]completionItem{}
+	isLocal := false
+	for _, v := range locals {
+		isLocal = isLocal || v.name == table
+	}
+	if !isLocal {
+		loadStdlib()
+		for field, kind := range stdlibFields[table] {
+			items[field] = completionItem{Label: field, Kind: kind, Detail: luastdlib.Functions[table+"."+field].Signature}
+		}
+	}
+	for _, d := range s.analyses() {
+		for field := range d.analysis.fields[table] {
+			if _, ok := items[field]; !ok {
+				items[field] = completionItem{Label: field, Kind: completionKindField}
+			}
+		}
+	}
+	return sortedCompletions(items, prefix)
+}
+
+// Returns the local variables and globals that start with prefix.
+func (s *Server) nameCompletions(prefix string, locals []*variable) []completionItem {
+	items := map[string]completionItem{}
+	loadStdlib()
+	for name, kind := range stdlibGlobals {
+		items[name] = completionItem{Label: name, Kind: kind, Detail: luastdlib.Functions[name].Signature}
+	}
+	for _, d := range s.analyses() {
+		for name := range d.analysis.globals {
+			if _, ok := items[name]; !ok && s.isAssigned(name) {
+				items[name] = completionItem{Label: name, Kind: completionKindVariable, Detail: "global"}
+			}
+		}
+	}
+	for _, v := range locals {
+		kind := completionKindVariable
+		if v.kind == "local function" {
+			kind = completionKindFunction
+		}
+		items[v.name] = completionItem{Label: v.name, Kind: kind, Detail: v.kind}
+	}
+	return sortedCompletions(items, prefix)
+}
+
+func sortedCompletions(items map[string]completionItem, prefix string) []completionItem {
+	sorted := []completionItem{}
+	for label, item := range items {
+		if strings.HasPrefix(label, prefix) {
+			sorted = append(sorted, item)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Label < sorted[j].Label })
+	return sorted
+}
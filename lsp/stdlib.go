@@ -0,0 +1,60 @@
+package lsp
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// What the libraries in lib/ define: the globals and the fields of those which
+// are tables, mapped to completion item kinds.  It is computed once by loading
+// the libraries in a runtime.
+var (
+	stdlibOnce    sync.Once
+	stdlibGlobals map[string]int
+	stdlibFields  map[string]map[string]int
+)
+
+func loadStdlib() {
+	stdlibOnce.Do(func() {
+		r := rt.New(ioutil.Discard)
+		cleanup := lib.LoadAll(r)
+		defer cleanup()
+		stdlibGlobals = map[string]int{}
+		stdlibFields = map[string]map[string]int{}
+		env := r.GlobalEnv()
+		for k, v, _ := env.Next(rt.NilValue); !k.IsNil(); k, v, _ = env.Next(k) {
+			name, ok := k.TryString()
+			if !ok {
+				continue
+			}
+			stdlibGlobals[name] = completionKind(v, completionKindVariable)
+			t, ok := v.TryTable()
+			if !ok || t == env {
+				continue
+			}
+			fields := map[string]int{}
+			for fk, fv, _ := t.Next(rt.NilValue); !fk.IsNil(); fk, fv, _ = t.Next(fk) {
+				if field, ok := fk.TryString(); ok {
+					fields[field] = completionKind(fv, completionKindField)
+				}
+			}
+			stdlibFields[name] = fields
+		}
+	})
+}
+
+// Returns the kind of completion item for a value, which is dflt unless it is
+// a function or a table.
+func completionKind(v rt.Value, dflt int) int {
+	switch v.Type() {
+	case rt.FunctionType:
+		return completionKindFunction
+	case rt.TableType:
+		return completionKindModule
+	default:
+		return dflt
+	}
+}
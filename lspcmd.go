@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/arnodel/golua/lsp"
+)
+
+// Runs "golua lsp", a language server for Lua speaking the Language Server
+// Protocol on stdin / stdout.
+func lspMain(args []string) int {
+	flags := flag.NewFlagSet("golua lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: golua lsp")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	if err := lsp.Serve(stdio{Reader: os.Stdin, Writer: os.Stdout}); err != nil {
+		return fatal("Error serving LSP: %s", err)
+	}
+	return 0
+}
@@ -0,0 +1,260 @@
+// Package lua is a simple way of running Lua code from Go programs, for when
+// the full control given by the runtime package is not needed.  E.g.
+//
+//	res, err := lua.Eval(ctx, "return 1 + 2", nil)
+//
+// sets res to []interface{}{int64(3)}.  To run the same code many times,
+// compile it once with Compile and call the Run method of the returned Script.
+//
+// Each run of a script happens in a new runtime, so runs do not interfere with
+// each other and can be made concurrently.  Go values are passed to Lua code
+// and results are passed back with runtime.Encode and runtime.Decode.  The
+// runtimes share a cache of compiled code (see runtime.UnitCache), so
+// evaluating the same source or requiring the same module in many runs only
+// compiles it once.
+package lua
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arnodel/golua/code"
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/lib/base"
+	"github.com/arnodel/golua/lib/coroutine"
+	"github.com/arnodel/golua/lib/debuglib"
+	"github.com/arnodel/golua/lib/iolib"
+	"github.com/arnodel/golua/lib/mathlib"
+	"github.com/arnodel/golua/lib/oslib"
+	"github.com/arnodel/golua/lib/packagelib"
+	"github.com/arnodel/golua/lib/runtimelib"
+	"github.com/arnodel/golua/lib/stringlib"
+	"github.com/arnodel/golua/lib/tablelib"
+	"github.com/arnodel/golua/lib/utf8lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+// StandardLibs are the libraries loaded by default: the Lua standard library
+// and the runtime library.  Note that golib, which gives access to Go
+// packages, is not included.  In scripts run by this package, os.exit stops the
+// script rather than the process (see ExitError).
+var StandardLibs = []packagelib.Loader{
+	base.LibLoader,
+	packagelib.LibLoader,
+	coroutine.LibLoader,
+	stringlib.LibLoader,
+	tablelib.LibLoader,
+	mathlib.LibLoader,
+	iolib.LibLoader,
+	utf8lib.LibLoader,
+	oslib.LibLoader,
+	debuglib.LibLoader,
+	runtimelib.LibLoader,
+}
+
+// ErrKilled is returned by Run and Eval when the script is stopped because it
+// exceeded one of the hard limits in Options.Context.
+var ErrKilled = errors.New("lua: script killed by exceeding a resource limit")
+
+// Options control how a script is compiled and run.  The zero value is usable
+// and a nil *Options is equivalent to it.
+type Options struct {
+	// Name of the chunk, used in error messages.  If empty, "script" is used.
+	Name string
+
+	// Libraries to load before running the script.  If nil, StandardLibs are
+	// loaded.  Use an empty slice to load no library.
+	Libs []packagelib.Loader
+
+	// Limits on the resources the script may use and compliance flags that Go
+	// functions must satisfy (see quotas.md).  The Go context passed to Run
+	// or Eval is used as Context.GoContext.
+	Context rt.RuntimeContextDef
+
+	// Standard streams of the script.  print and io.write write to Stdout,
+	// io.read reads from Stdin and warnings are written to Stderr.  If nil,
+	// the streams of the process are used.
+	Stdin          io.Reader
+	Stdout, Stderr io.Writer
+
+	// Optimization level used when compiling the script (see
+	// runtime.WithOptimizationLevel).
+	OptimizationLevel int
+}
+
+// A Script is compiled Lua code, ready to be run any number of times.  It is
+// safe to run a script concurrently in several goroutines.
+type Script struct {
+	unit *code.Unit
+	opts Options
+}
+
+// Compile compiles the Lua source into a Script which runs with the given
+// options.
+func Compile(source string, opts *Options) (*Script, error) {
+	o := withDefaults(opts)
+	unit, err := compile(source, &o)
+	if err != nil {
+		return nil, err
+	}
+	return &Script{unit: unit, opts: o}, nil
+}
+
+func withDefaults(opts *Options) Options {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Name == "" {
+		o.Name = "script"
+	}
+	return o
+}
+
+func compile(source string, o *Options) (*code.Unit, error) {
+	r := rt.New(nil, rt.WithOptimizationLevel(o.OptimizationLevel), rt.WithUnitCache(units))
+	unit, _, err := r.CompileLuaChunk(o.Name, []byte(source))
+	return unit, err
+}
+
+// Run runs the script in a new runtime, setting the global variables named in
+// vars to their values first.  It returns the values returned by the script.
+// If goCtx is cancelled or past its deadline, the script is stopped and the
+// error wraps goCtx.Err() (unless quotas are disabled with the noquotas build
+// tag).
+func (s *Script) Run(goCtx context.Context, vars map[string]interface{}) ([]interface{}, error) {
+	o := &s.opts
+	rtOpts := []rt.RuntimeOption{
+		rt.WithOptimizationLevel(o.OptimizationLevel),
+		rt.WithStdio(o.Stdin, o.Stdout, o.Stderr),
+		rt.WithUnitCache(units),
+	}
+	// The io library makes print write to io.stdout, so that their output
+	// is not reordered by buffering.
+	r := rt.New(nil, rtOpts...)
+	libs := o.Libs
+	if libs == nil {
+		libs = StandardLibs
+	}
+	cleanup := lib.LoadLibs(r, libs...)
+	defer cleanup()
+	if r.Stdout == nil {
+		r.Stdout = o.Stdout
+		if r.Stdout == nil {
+			r.Stdout = os.Stdout
+		}
+	}
+
+	exit := replaceExit(r)
+
+	def := o.Context
+	def.GoContext = goCtx
+	th := r.MainThread()
+	var res []interface{}
+	ctx, err := th.CallContext(def, func() error {
+		var err error
+		res, err = s.call(th, vars)
+		// Finalizers are run within the context too, so that they are subject
+		// to its quotas.
+		r.Close(th)
+		return err
+	})
+	switch {
+	case *exit != nil:
+		return nil, *exit
+	case err != nil:
+		return nil, err
+	case ctx != nil && ctx.Status() == rt.StatusKilled:
+		return nil, ErrKilled
+	}
+	return res, nil
+}
+
+// Runs the script in th after setting the global variables in vars and returns
+// the values it returns.  Converting values between Go and Lua counts against
+// the quotas of the current context.
+func (s *Script) call(th *rt.Thread, vars map[string]interface{}) ([]interface{}, error) {
+	r := th.Runtime
+	env := r.GlobalEnv()
+	for name, x := range vars {
+		v, err := r.Encode(x)
+		if err != nil {
+			return nil, err
+		}
+		r.SetEnv(env, name, v)
+	}
+	clos := r.LoadLuaUnit(s.unit, rt.TableValue(env))
+	term := rt.NewTerminationWith(nil, 0, true)
+	if err := rt.Call(th, rt.FunctionValue(clos), nil, term); err != nil {
+		return nil, err
+	}
+	etc := term.Etc()
+	res := make([]interface{}, len(etc))
+	for i, v := range etc {
+		if err := r.Decode(v, &res[i]); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// An ExitError is returned by Run and Eval when the script calls os.exit.
+type ExitError struct {
+	Code int // The exit code passed to os.exit
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("lua: script exited with code %d", e.Code)
+}
+
+// Replaces os.exit, which would terminate the process, with a function which
+// stops the script.  The returned pointer is set to the corresponding error
+// when it is called.
+func replaceExit(r *rt.Runtime) **ExitError {
+	var exit *ExitError
+	osPkg, ok := r.GlobalEnv().Get(rt.StringValue("os")).TryTable()
+	if !ok {
+		return &exit
+	}
+	exitFunc := func(t *rt.Thread, c *rt.GoCont) (rt.Cont, error) {
+		code := 0
+		if c.NArgs() > 0 {
+			arg := c.Arg(0)
+			if n, ok := rt.ToInt(arg); ok {
+				code = int(n)
+			} else if !rt.Truth(arg) {
+				code = 1
+			}
+		}
+		exit = &ExitError{Code: code}
+		// This cannot be caught by pcall, unlike an error.
+		t.TerminateContext("os.exit(%d)", code)
+		return nil, exit
+	}
+	rt.SolemnlyDeclareCompliance(
+		rt.ComplyCpuSafe|rt.ComplyMemSafe|rt.ComplyTimeSafe|rt.ComplyIoSafe,
+
+		r.SetEnvGoFunc(osPkg, "exit", exitFunc, 2, false),
+	)
+	return &exit
+}
+
+// Eval compiles and runs the Lua source, returning the values it returns.
+func Eval(ctx context.Context, source string, opts *Options) ([]interface{}, error) {
+	script, err := Compile(source, opts)
+	if err != nil {
+		return nil, err
+	}
+	return script.Run(ctx, nil)
+}
+
+// Maximum number of compiled units kept in the cache.
+const unitCacheSize = 256
+
+// Compiled code is shared by all the runtimes made by this package, so that
+// evaluating the same source or requiring the same module many times only
+// compiles it once.
+var units = rt.NewUnitCache(unitCacheSize)
@@ -0,0 +1,225 @@
+package lua_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lib/base"
+	"github.com/arnodel/golua/lib/packagelib"
+	"github.com/arnodel/golua/lua"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func ExampleEval() {
+	res, err := lua.Eval(context.Background(), `return 1 + 2, "hello", {x = 1}`, nil)
+	fmt.Println(res, err)
+	// Output:
+	// [3 hello map[x:1]] <nil>
+}
+
+func ExampleScript_Run() {
+	type request struct {
+		User  string   `lua:"user"`
+		Items []string `lua:"items"`
+	}
+	script, err := lua.Compile(`
+		print("user: " .. req.user)
+		return #req.items, table.concat(req.items, "+")
+	`, nil)
+	if err != nil {
+		panic(err)
+	}
+	res, err := script.Run(context.Background(), map[string]interface{}{
+		"req": request{User: "bob", Items: []string{"a", "b"}},
+	})
+	fmt.Println(res, err)
+	// Output:
+	// user: bob
+	// [2 a+b] <nil>
+}
+
+func TestRunOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	script, err := lua.Compile(`
+		print("a", 1)
+		io.write("b\n")
+		io.stderr:write("c\n")
+		warn("@on")
+		warn("d")
+		return io.read("l")
+	`, &lua.Options{
+		Stdin:  strings.NewReader("input\n"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := script.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, []interface{}{"input"}) {
+		t.Errorf("got %v", res)
+	}
+	if got := stdout.String(); got != "a\t1\nb\n" {
+		t.Errorf("stdout: got %q", got)
+	}
+	if got := stderr.String(); got != "c\nLua warning: d\n" {
+		t.Errorf("stderr: got %q", got)
+	}
+}
+
+func TestRunStdinChunk(t *testing.T) {
+	res, err := lua.Eval(context.Background(), `return dofile()`, &lua.Options{
+		Stdin: strings.NewReader("return 42"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, []interface{}{int64(42)}) {
+		t.Errorf("got %v", res)
+	}
+}
+
+func TestRunOutputOrder(t *testing.T) {
+	var stdout bytes.Buffer
+	_, err := lua.Eval(context.Background(), `io.write("a\n") print("b") io.write("c\n")`, &lua.Options{Stdout: &stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "a\nb\nc\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestRunFinalizers(t *testing.T) {
+	var stdout bytes.Buffer
+	_, err := lua.Eval(context.Background(), `x = setmetatable({}, {__gc = function() print("gc") end})`, &lua.Options{Stdout: &stdout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "gc\n" {
+		t.Errorf("got %q", got)
+	}
+	if !rt.QuotasAvailable {
+		return
+	}
+	// Finalizers are subject to the limits of the script
+	_, err = lua.Eval(context.Background(), `x = setmetatable({}, {__gc = function() while true do end end})`, &lua.Options{
+		Context: rt.RuntimeContextDef{HardLimits: rt.RuntimeResources{Cpu: 100000}},
+	})
+	if err != lua.ErrKilled {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRunExit(t *testing.T) {
+	tests := []struct {
+		src  string
+		code int
+	}{
+		{`os.exit()`, 0},
+		{`os.exit(false)`, 1},
+		{`os.exit(3)`, 3},
+		{`pcall(os.exit, 2) return "not reached"`, 2},
+		{`coroutine.wrap(function() os.exit(4) end)() return "not reached"`, 4},
+	}
+	for _, test := range tests {
+		res, err := lua.Eval(context.Background(), test.src, nil)
+		var exitErr *lua.ExitError
+		if !errors.As(err, &exitErr) || exitErr.Code != test.code {
+			t.Errorf("%s: got %v, %v", test.src, res, err)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ctx := context.Background()
+	if _, err := lua.Compile("x = = 1", nil); err == nil {
+		t.Error("expected syntax error")
+	}
+	_, err := lua.Eval(ctx, `error("oops")`, &lua.Options{Name: "test"})
+	if err == nil || !strings.Contains(err.Error(), "test:1: oops") {
+		t.Errorf("got %v", err)
+	}
+	_, err = lua.Eval(ctx, `print("x")`, &lua.Options{Libs: []packagelib.Loader{}})
+	if err == nil || !strings.Contains(err.Error(), "attempt to call a nil value") {
+		t.Errorf("got %v", err)
+	}
+	var stdout bytes.Buffer
+	_, err = lua.Eval(ctx, `print("x")`, &lua.Options{Libs: []packagelib.Loader{base.LibLoader}, Stdout: &stdout})
+	if err != nil || stdout.String() != "x\n" {
+		t.Errorf("got %v, %q", err, stdout.String())
+	}
+	_, err = lua.Compile("return x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	if !rt.QuotasAvailable {
+		t.Skip("quotas not available")
+	}
+	_, err := lua.Eval(context.Background(), `while true do end`, &lua.Options{
+		Context: rt.RuntimeContextDef{HardLimits: rt.RuntimeResources{Cpu: 100000}},
+	})
+	if err != lua.ErrKilled {
+		t.Errorf("got %v", err)
+	}
+
+	// Variables are encoded within the limits
+	script, err := lua.Compile(`return #s`, &lua.Options{
+		Context: rt.RuntimeContextDef{HardLimits: rt.RuntimeResources{Memory: 100000}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = script.Run(context.Background(), map[string]interface{}{"s": strings.Repeat("x", 1000000)})
+	if err != lua.ErrKilled {
+		t.Errorf("got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = lua.Eval(ctx, `while true do end`, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+
+	_, err = lua.Eval(context.Background(), `return io.open("x")`, &lua.Options{
+		Context: rt.RuntimeContextDef{RequiredFlags: rt.ComplyIoSafe},
+	})
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRunConcurrently(t *testing.T) {
+	script, err := lua.Compile(`local s = 0 for i = 1, n do s = s + i end return s`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error)
+	for n := 0; n < 10; n++ {
+		go func(n int) {
+			res, err := script.Run(context.Background(), map[string]interface{}{"n": n})
+			if err == nil && res[0] != int64(n*(n+1)/2) {
+				err = fmt.Errorf("n=%d: got %v", n, res)
+			}
+			errs <- err
+		}(n)
+	}
+	for n := 0; n < 10; n++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
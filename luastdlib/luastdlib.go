@@ -0,0 +1,191 @@
+// Package luastdlib describes the functions of the Lua standard library (and
+// of the golua specific libraries): their signature, a short description and
+// the number of arguments they accept.  It is used by the linter and the
+// language server, so that they agree.
+package luastdlib
+
+import "strings"
+
+// A Function describes a standard library function.
+type Function struct {
+	// The signature of the function in the style of the Lua manual, e.g.
+	// "string.rep(s, n [, sep])".  Optional arguments are in square brackets
+	// and "···" stands for any number of arguments.
+	Signature string
+
+	// A short description of what the function does.
+	Doc string
+}
+
+// Functions maps the names of the standard library functions (e.g. "print"
+// or "string.rep") to their description.
+var Functions = map[string]Function{
+	"assert":         {"assert(v [, message, ···])", "Raises an error if v is false or nil, otherwise returns all its arguments."},
+	"collectgarbage": {"collectgarbage([opt [, arg1 [, arg2 [, arg3]]]])", "Controls the garbage collector."},
+	"dofile":         {"dofile([filename])", "Runs the contents of a Lua file and returns the values it returns."},
+	"error":          {"error([message [, level]])", "Raises an error with the given message or value."},
+	"getmetatable":   {"getmetatable(object)", "Returns the metatable of object, or its __metatable field if it has one."},
+	"ipairs":         {"ipairs(t)", "Returns an iterator over the pairs (1, t[1]), (2, t[2]), ... up to the first nil value."},
+	"load":           {"load(chunk [, chunkname [, mode [, env]]])", "Compiles a chunk and returns it as a function, or nil plus an error message."},
+	"loadfile":       {"loadfile([filename [, mode [, env]]])", "Like load, but gets the chunk from a file."},
+	"next":           {"next(table [, index])", "Returns the next key of the table after index and its value."},
+	"pairs":          {"pairs(t)", "Returns an iterator over all the key-value pairs of t, using its __pairs metamethod if it has one."},
+	"pcall":          {"pcall(f [, arg1, ···])", "Calls f in protected mode, returning true and its results or false and the error."},
+	"print":          {"print(···)", "Writes its arguments to the standard output, converted with tostring and separated by tabs."},
+	"rawequal":       {"rawequal(v1, v2)", "Checks whether v1 is equal to v2 without invoking the __eq metamethod."},
+	"rawget":         {"rawget(table, index)", "Gets table[index] without invoking the __index metamethod."},
+	"rawlen":         {"rawlen(v)", "Returns the length of a table or string without invoking the __len metamethod."},
+	"rawset":         {"rawset(table, index, value)", "Sets table[index] to value without invoking the __newindex metamethod."},
+	"require":        {"require(modname)", "Loads the given module and returns its value."},
+	"select":         {"select(index, ···)", "Returns the arguments after index, or their number if index is \"#\"."},
+	"setmetatable":   {"setmetatable(table, metatable)", "Sets the metatable of table and returns table."},
+	"tonumber":       {"tonumber(e [, base])", "Converts its argument to a number, or returns nil if it cannot."},
+	"tostring":       {"tostring(v)", "Converts its argument to a string, using its __tostring metamethod if it has one."},
+	"type":           {"type(v)", "Returns the type of its argument as a string."},
+	"warn":           {"warn(msg1, ···)", "Emits a warning made of the concatenation of its arguments."},
+	"xpcall":         {"xpcall(f, msgh [, arg1, ···])", "Like pcall, but calls the message handler msgh on errors."},
+
+	"coroutine.close":       {"coroutine.close(co)", "Closes a suspended or dead coroutine."},
+	"coroutine.create":      {"coroutine.create(f)", "Creates a new coroutine with body f."},
+	"coroutine.isyieldable": {"coroutine.isyieldable([co])", "Returns true if the coroutine can yield."},
+	"coroutine.resume":      {"coroutine.resume(co [, val1, ···])", "Starts or continues the execution of the coroutine co."},
+	"coroutine.running":     {"coroutine.running()", "Returns the running coroutine and a boolean which is true if it is the main one."},
+	"coroutine.status":      {"coroutine.status(co)", "Returns the status of the coroutine co as a string."},
+	"coroutine.wrap":        {"coroutine.wrap(f)", "Creates a new coroutine with body f and returns a function that resumes it."},
+	"coroutine.yield":       {"coroutine.yield(···)", "Suspends the execution of the calling coroutine."},
+
+	"debug.gethook":      {"debug.gethook([thread])", "Returns the current hook function, mask and count."},
+	"debug.getinfo":      {"debug.getinfo([thread,] f [, what])", "Returns a table with information about a function or stack level."},
+	"debug.getlocal":     {"debug.getlocal([thread,] f, local)", "Returns the name and value of a local variable."},
+	"debug.getmetatable": {"debug.getmetatable(value)", "Returns the metatable of the given value, ignoring __metatable."},
+	"debug.getupvalue":   {"debug.getupvalue(f, up)", "Returns the name and value of the upvalue with index up of the function f."},
+	"debug.sethook":      {"debug.sethook([thread,] [hook, mask [, count]])", "Sets the given function as the debug hook."},
+	"debug.setlocal":     {"debug.setlocal([thread,] level, local, value)", "Assigns value to a local variable."},
+	"debug.setmetatable": {"debug.setmetatable(value, table)", "Sets the metatable of the given value, which can be any value."},
+	"debug.setupvalue":   {"debug.setupvalue(f, up, value)", "Assigns value to the upvalue with index up of the function f."},
+	"debug.traceback":    {"debug.traceback([thread,] [message [, level]])", "Returns a string with a traceback of the call stack."},
+	"debug.upvalueid":    {"debug.upvalueid(f, n)", "Returns a unique identifier for the upvalue numbered n of the function f."},
+	"debug.upvaluejoin":  {"debug.upvaluejoin(f1, n1, f2, n2)", "Makes the n1-th upvalue of f1 refer to the n2-th upvalue of f2."},
+
+	"golib.import": {"golib.import(path)", "Imports the Go package with the given path."},
+
+	"io.close":  {"io.close([file])", "Closes file, or the default output file."},
+	"io.input":  {"io.input([file])", "Sets or returns the default input file."},
+	"io.lines":  {"io.lines([filename, ···])", "Returns an iterator over the lines of the given file, or the default input."},
+	"io.open":   {"io.open(filename [, mode])", "Opens a file in the given mode and returns a file handle, or nil plus an error message."},
+	"io.output": {"io.output([file])", "Sets or returns the default output file."},
+	"io.read":   {"io.read(···)", "Reads from the default input file according to the given formats."},
+	"io.type":   {"io.type(obj)", "Returns \"file\", \"closed file\" or nil depending on whether obj is a file handle."},
+	"io.write":  {"io.write(···)", "Writes its arguments to the default output file."},
+
+	"math.abs":        {"math.abs(x)", "Returns the absolute value of x."},
+	"math.acos":       {"math.acos(x)", "Returns the arc cosine of x (in radians)."},
+	"math.asin":       {"math.asin(x)", "Returns the arc sine of x (in radians)."},
+	"math.atan":       {"math.atan(y [, x])", "Returns the arc tangent of y/x (in radians)."},
+	"math.ceil":       {"math.ceil(x)", "Returns the smallest integral value greater than or equal to x."},
+	"math.cos":        {"math.cos(x)", "Returns the cosine of x (in radians)."},
+	"math.deg":        {"math.deg(x)", "Converts the angle x from radians to degrees."},
+	"math.exp":        {"math.exp(x)", "Returns e raised to the power x."},
+	"math.floor":      {"math.floor(x)", "Returns the largest integral value less than or equal to x."},
+	"math.fmod":       {"math.fmod(x, y)", "Returns the remainder of the division of x by y, rounding the quotient towards zero."},
+	"math.log":        {"math.log(x [, base])", "Returns the logarithm of x in the given base (e by default)."},
+	"math.max":        {"math.max(x, ···)", "Returns the maximum of its arguments."},
+	"math.min":        {"math.min(x, ···)", "Returns the minimum of its arguments."},
+	"math.modf":       {"math.modf(x)", "Returns the integral part and the fractional part of x."},
+	"math.rad":        {"math.rad(x)", "Converts the angle x from degrees to radians."},
+	"math.random":     {"math.random([m [, n]])", "Returns a pseudo-random number."},
+	"math.randomseed": {"math.randomseed([x [, y]])", "Seeds the pseudo-random generator."},
+	"math.sin":        {"math.sin(x)", "Returns the sine of x (in radians)."},
+	"math.sqrt":       {"math.sqrt(x)", "Returns the square root of x."},
+	"math.tan":        {"math.tan(x)", "Returns the tangent of x (in radians)."},
+	"math.tointeger":  {"math.tointeger(x)", "Converts x to an integer if it is representable as one, otherwise returns nil."},
+	"math.type":       {"math.type(x)", "Returns \"integer\", \"float\" or nil depending on the type of x."},
+	"math.ult":        {"math.ult(m, n)", "Returns true if m is less than n when compared as unsigned integers."},
+
+	"os.clock":     {"os.clock()", "Returns the CPU time used by the program in seconds."},
+	"os.date":      {"os.date([format [, time]])", "Returns a string or a table containing the date and time."},
+	"os.difftime":  {"os.difftime(t2, t1)", "Returns the difference in seconds between the times t2 and t1."},
+	"os.execute":   {"os.execute([command])", "Runs a command in the operating system shell."},
+	"os.exit":      {"os.exit([code [, close]])", "Terminates the program."},
+	"os.getenv":    {"os.getenv(varname)", "Returns the value of an environment variable, or nil if it is not defined."},
+	"os.remove":    {"os.remove(filename)", "Deletes the file or empty directory with the given name."},
+	"os.rename":    {"os.rename(oldname, newname)", "Renames a file or directory."},
+	"os.setlocale": {"os.setlocale([locale [, category]])", "Sets the current locale of the program."},
+	"os.time":      {"os.time([table])", "Returns the current time, or the time represented by the given table."},
+	"os.tmpname":   {"os.tmpname()", "Returns a name that can be used for a temporary file."},
+
+	"package.searchpath": {"package.searchpath(name, path [, sep [, rep]])", "Searches for the given name in the given path."},
+
+	"runtime.callcontext": {"runtime.callcontext(ctxdef, f, ···)", "Calls f in a new execution context with the given resource limits."},
+	"runtime.context":     {"runtime.context()", "Returns the current execution context."},
+	"runtime.contextdue":  {"runtime.contextdue(ctx)", "Returns true if the context has reached its deadline."},
+	"runtime.killcontext": {"runtime.killcontext(ctx)", "Kills the given execution context."},
+	"runtime.stopcontext": {"runtime.stopcontext(ctx)", "Stops the given execution context."},
+
+	"string.byte":     {"string.byte(s [, i [, j]])", "Returns the internal numeric codes of the characters s[i], ..., s[j]."},
+	"string.char":     {"string.char(···)", "Returns a string made of the characters with the given numeric codes."},
+	"string.dump":     {"string.dump(function [, strip])", "Returns a string containing a binary representation of the given function."},
+	"string.find":     {"string.find(s, pattern [, init [, plain]])", "Looks for the first match of pattern in s and returns its start and end indices."},
+	"string.format":   {"string.format(formatstring, ···)", "Returns a formatted version of its arguments following the description in formatstring."},
+	"string.gmatch":   {"string.gmatch(s, pattern [, init])", "Returns an iterator over the matches of pattern in s."},
+	"string.gsub":     {"string.gsub(s, pattern, repl [, n])", "Returns a copy of s where (the first n) matches of pattern are replaced by repl."},
+	"string.len":      {"string.len(s)", "Returns the length of s."},
+	"string.lower":    {"string.lower(s)", "Returns a copy of s with all uppercase letters changed to lowercase."},
+	"string.match":    {"string.match(s, pattern [, init])", "Looks for the first match of pattern in s and returns its captures."},
+	"string.pack":     {"string.pack(fmt, ···)", "Returns a binary string containing the values serialized according to fmt."},
+	"string.packsize": {"string.packsize(fmt)", "Returns the size of a string resulting from string.pack with the given format."},
+	"string.rep":      {"string.rep(s, n [, sep])", "Returns a string made of n copies of s separated by sep."},
+	"string.reverse":  {"string.reverse(s)", "Returns s reversed."},
+	"string.sub":      {"string.sub(s, i [, j])", "Returns the substring of s from i to j."},
+	"string.unpack":   {"string.unpack(fmt, s [, pos])", "Returns the values packed in s according to fmt."},
+	"string.upper":    {"string.upper(s)", "Returns a copy of s with all lowercase letters changed to uppercase."},
+
+	"table.concat": {"table.concat(list [, sep [, i [, j]]])", "Returns the concatenation of the strings list[i], ..., list[j] separated by sep."},
+	"table.insert": {"table.insert(list, [pos,] value)", "Inserts value at position pos in list (at the end by default)."},
+	"table.move":   {"table.move(a1, f, e, t [, a2])", "Moves elements a1[f], ..., a1[e] to a2[t], ... and returns a2."},
+	"table.pack":   {"table.pack(···)", "Returns a new table with all its arguments and a field n with their number."},
+	"table.remove": {"table.remove(list [, pos])", "Removes the element at position pos from list (the last one by default) and returns it."},
+	"table.sort":   {"table.sort(list [, comp])", "Sorts the elements of list in place."},
+	"table.unpack": {"table.unpack(list [, i [, j]])", "Returns the elements list[i], ..., list[j]."},
+
+	"utf8.char":      {"utf8.char(···)", "Returns a string made of the UTF-8 encodings of the given code points."},
+	"utf8.codepoint": {"utf8.codepoint(s [, i [, j [, lax]]])", "Returns the code points of the characters of s between byte positions i and j."},
+	"utf8.codes":     {"utf8.codes(s [, lax])", "Returns an iterator over the positions and code points of the characters of s."},
+	"utf8.len":       {"utf8.len(s [, i [, j [, lax]]])", "Returns the number of UTF-8 characters in s between byte positions i and j."},
+	"utf8.offset":    {"utf8.offset(s, n [, i])", "Returns the byte position where the n-th character of s starts."},
+}
+
+// ArgCount returns the number of arguments the function accepts, worked out
+// from its signature.  If there is no maximum, max is -1.
+func (f Function) ArgCount() (min, max int) {
+	sig := f.Signature
+	if i, j := strings.IndexByte(sig, '('), strings.LastIndexByte(sig, ')'); i >= 0 && j > i {
+		sig = sig[i+1 : j]
+	}
+	var (
+		depth int  // Number of enclosing square brackets
+		param bool // True when scanning the name of a parameter
+	)
+	for _, r := range sig {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',', ' ':
+		case '·':
+			return min, -1
+		default:
+			if !param && depth == 0 {
+				min++
+			}
+			if !param {
+				max++
+			}
+			param = true
+			continue
+		}
+		param = false
+	}
+	return min, max
+}
@@ -0,0 +1,29 @@
+package luastdlib
+
+import "testing"
+
+func TestFunction_ArgCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+	}{
+		{"print", 0, -1},
+		{"tostring", 1, 1},
+		{"load", 1, 4},
+		{"select", 1, -1},
+		{"collectgarbage", 0, 4},
+		{"os.difftime", 2, 2},
+		{"table.insert", 2, 3},
+		{"debug.getlocal", 2, 3},
+	}
+	for _, test := range tests {
+		f, ok := Functions[test.name]
+		if !ok {
+			t.Errorf("%s: not found", test.name)
+			continue
+		}
+		if min, max := f.ArgCount(); min != test.min || max != test.max {
+			t.Errorf("%s: got %d, %d, want %d, %d", test.name, min, max, test.min, test.max)
+		}
+	}
+}
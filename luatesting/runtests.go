@@ -6,9 +6,11 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"path/filepath"
+	goruntime "runtime"
 	"strings"
 	"testing"
 
+	"github.com/arnodel/golua/ir"
 	"github.com/arnodel/golua/runtime"
 )
 
@@ -48,7 +50,13 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 		return
 	}
 	isQuotasTest := strings.HasSuffix(path, ".quotas.lua")
+	isUnixTest := strings.Contains(filepath.Base(path), ".unix.")
 	t.Run(path, func(t *testing.T) {
+		if isUnixTest && goruntime.GOOS == "windows" {
+			// These tests run commands with the system shell.
+			t.Skip("Skipping unix test on windows")
+			return
+		}
 		if isQuotasTest {
 			if !runtime.QuotasAvailable {
 				t.Skip("Skipping quotas test as build does not enforce quotas")
@@ -64,10 +72,36 @@ func RunLuaTestFile(t *testing.T, path string, setup func(*runtime.Runtime) func
 		if err != nil {
 			t.Error(err)
 		}
+		if isQuotasTest {
+			// Optimizations change the resources the code consumes, which
+			// quotas tests measure precisely.
+			return
+		}
+		// Optimized code must behave the same.
+		for level := ir.NoOptimization + 1; level <= ir.MaxOptimizationLevel; level++ {
+			err = RunLuaTest(src, withOptimizationLevel(level, setup))
+			if err != nil {
+				t.Errorf("at optimization level %d: %s", level, err)
+			}
+		}
 	})
 }
 
+func withOptimizationLevel(level int, setup func(*runtime.Runtime) func()) func(*runtime.Runtime) func() {
+	return func(r *runtime.Runtime) func() {
+		r.SetOptimizationLevel(level)
+		if setup == nil {
+			return func() {}
+		}
+		return setup(r)
+	}
+}
+
 // RunLuaTestsInDir runs a test for each .lua file in the directory provided.
+// Files other than quotas tests are also run at each optimization level.
+// Files ending in ".quotas.lua" are skipped if quotas are not available and
+// files with ".unix." in their name (e.g. tests using a Unix shell, such as
+// "popen.unix.lua" or "popen.unix.quotas.lua") are skipped on Windows.
 func RunLuaTestsInDir(t *testing.T, dirpath string, setup func(*runtime.Runtime) func(), filters ...string) {
 	runTest := func(path string, entry fs.DirEntry, err error) error {
 		for _, filter := range filters {
@@ -9,6 +9,9 @@ import (
 )
 
 func main() {
+	if retcode, ok := runSubcommand(); ok {
+		os.Exit(retcode)
+	}
 	cmd := new(luaCmd)
 	cmd.setFlags()
 	flag.Parse()
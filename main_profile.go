@@ -11,10 +11,13 @@ import (
 )
 
 func main() {
+	if retcode, ok := runSubcommand(); ok {
+		os.Exit(retcode)
+	}
 	cmd := new(luaCmd)
 	cmd.setFlags()
-	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
-	memprofile := flag.String("memprofile", "", "write memory profile to `file`")
+	cpuprofile := flag.String("gocpuprofile", "", "write a cpu profile of the Go interpreter to `file`")
+	memprofile := flag.String("gomemprofile", "", "write a memory profile of the Go interpreter to `file`")
 	flag.Parse()
 
 	if *cpuprofile != "" {
@@ -0,0 +1,197 @@
+package parsing
+
+import (
+	"errors"
+
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/token"
+)
+
+// ParseChunkWithComments is like ParseChunk but it also records what is needed
+// to reproduce the source code faithfully: comments and blank lines are
+// attached to the statements of blocks and the fields of table constructors,
+// and the spelling of literals and function definitions are kept.  The
+// scanner should emit comment tokens (see scanner.WithComments).
+//
+// Comments which are inside a statement but not in a nested block or table
+// constructor are attached to the statement as leading comments.
+func ParseChunkWithComments(scanner Scanner) (stat ast.BlockStat, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			stat = ast.BlockStat{}
+			var ok bool
+			err, ok = r.(error)
+			if !ok {
+				err = errors.New("Unknown error")
+			}
+		}
+	}()
+	parser := &Parser{scanner: scanner, keepComments: true}
+	var t *token.Token
+	stat, t = parser.Block(parser.Scan())
+	expectType(t, token.EOF, "<eof>")
+	return
+}
+
+// A listCommenter attaches comments to the items of a list (statements in a
+// block or fields in a table constructor).  Its methods do nothing when the
+// receiver is nil, which is the case when the parser does not keep comments.
+type listCommenter struct {
+	p         *Parser
+	start     int // offset of the token opening the list, comments before it are not ours
+	lastLine  int // last line of the previous item, or -1
+	itemStart int // offset of the first token of the current item
+	comments  ast.ListComments
+}
+
+func (p *Parser) startList() *listCommenter {
+	if !p.keepComments {
+		return nil
+	}
+	lc := &listCommenter{p: p, start: -1, lastLine: -1}
+	if p.lastTok != nil {
+		lc.start = p.lastTok.Offset
+		lc.lastLine = endLine(p.lastTok)
+		lc.comments.Open = lc.takeOnLine(lc.start, lc.lastLine)
+		lc.lastLine = lc.commentsEnd(lc.comments.Open)
+	}
+	return lc
+}
+
+// Called with the first token of the item before it is parsed.
+func (lc *listCommenter) startItem(t *token.Token) {
+	if lc == nil {
+		return
+	}
+	leading := lc.take(lc.start, t.Offset)
+	lc.comments.Items = append(lc.comments.Items, ast.NodeComments{
+		Leading:         leading,
+		BlankLineBefore: lc.lastLine >= 0 && t.Line > lc.lastLine+1,
+	})
+	lc.itemStart = t.Offset
+}
+
+// Called once the item has been parsed (including the separator that follows
+// it, if any).
+func (lc *listCommenter) endItem() {
+	if lc == nil {
+		return
+	}
+	last := lc.p.lastTok
+	item := &lc.comments.Items[len(lc.comments.Items)-1]
+	for _, c := range lc.p.take(lc.itemStart, last.Offset) {
+		c.BlankLineBefore = false
+		item.Leading = append(item.Leading, c)
+	}
+	lc.lastLine = endLine(last)
+	item.Trailing = lc.takeOnLine(last.Offset, lc.lastLine)
+	lc.lastLine = lc.commentsEnd(item.Trailing)
+}
+
+// Called when the token closing the list is reached.  It returns the comments
+// attached to the list.
+func (lc *listCommenter) end() *ast.ListComments {
+	if lc == nil {
+		return nil
+	}
+	lc.comments.Close = lc.take(lc.start, -1)
+	return &lc.comments
+}
+
+// Takes the pending comments which start after offset 'from' and before offset
+// 'to' (or anywhere after 'from' if 'to' is negative), marking those which
+// come after a blank line.
+func (lc *listCommenter) take(from, to int) []ast.Comment {
+	var comments []ast.Comment
+	for _, tok := range lc.p.takeTokens(from, to, -1) {
+		comments = append(comments, ast.Comment{
+			Text:            string(tok.Lit),
+			BlankLineBefore: lc.lastLine >= 0 && tok.Line > lc.lastLine+1,
+		})
+		lc.lastLine = endLine(tok)
+	}
+	return comments
+}
+
+// Takes the pending comments which start after offset 'from' on the given
+// line.
+func (lc *listCommenter) takeOnLine(from, line int) []ast.Comment {
+	var comments []ast.Comment
+	for _, tok := range lc.p.takeTokens(from, -1, line) {
+		comments = append(comments, ast.Comment{Text: string(tok.Lit)})
+	}
+	return comments
+}
+
+// Returns the last line of the comments, or of the previous item if there are
+// none.
+func (lc *listCommenter) commentsEnd(comments []ast.Comment) int {
+	if len(comments) == 0 {
+		return lc.lastLine
+	}
+	return lc.p.lastCommentLine
+}
+
+// Takes the pending comments in the (from, to) offset range as ast comments
+// without blank line information.
+func (p *Parser) take(from, to int) []ast.Comment {
+	var comments []ast.Comment
+	for _, tok := range p.takeTokens(from, to, -1) {
+		comments = append(comments, ast.Comment{Text: string(tok.Lit)})
+	}
+	return comments
+}
+
+// Removes from the pending comments and returns those which start after offset
+// 'from', before offset 'to' if it is not negative and on the given line if it
+// is not negative.
+func (p *Parser) takeTokens(from, to, line int) []*token.Token {
+	var taken []*token.Token
+	kept := p.comments[:0]
+	for _, tok := range p.comments {
+		if tok.Offset > from && (to < 0 || tok.Offset < to) && (line < 0 || tok.Line == line) {
+			taken = append(taken, tok)
+			p.lastCommentLine = endLine(tok)
+		} else {
+			kept = append(kept, tok)
+		}
+	}
+	p.comments = kept
+	return taken
+}
+
+// Returns the line where the token ends.
+func endLine(t *token.Token) int {
+	line := t.Line
+	lit := t.Lit
+	for i, c := range lit {
+		if c == '\n' || c == '\r' && (i+1 == len(lit) || lit[i+1] != '\n') {
+			line++
+		}
+	}
+	return line
+}
+
+// Records the spelling of a literal when keeping comments.
+func (p *Parser) withLit(exp ast.ExpNode, t *token.Token) ast.ExpNode {
+	if !p.keepComments {
+		return exp
+	}
+	switch e := exp.(type) {
+	case ast.Int:
+		e.Lit = string(t.Lit)
+		return e
+	case ast.Float:
+		e.Lit = string(t.Lit)
+		return e
+	case ast.String:
+		e.Lit = string(t.Lit)
+		return e
+	}
+	return exp
+}
+
+func withBlockComments(b ast.BlockStat, lc *listCommenter) ast.BlockStat {
+	b.Comments = lc.end()
+	return b
+}
@@ -14,6 +14,21 @@ import (
 // Parser can parse lua statements or expressions
 type Parser struct {
 	scanner Scanner
+
+	// When keepComments is true, comment tokens are collected in comments
+	// until they are attached to the AST.
+	keepComments    bool
+	comments        []*token.Token
+	lastCommentLine int
+
+	// curTok is the last token returned by Scan and lastTok the one before it.
+	lastTok, curTok *token.Token
+
+	// When recovering is true, syntax errors are collected in errors instead
+	// of stopping the parser (see ParseChunkRecovering).
+	recovering bool
+	errors     []Error
+	eof        *token.Token // Returned by Scan when the scanner has stopped
 }
 
 type Scanner interface {
@@ -21,12 +36,17 @@ type Scanner interface {
 	ErrorMsg() string
 }
 
+// An Error is a syntax error.
 type Error struct {
-	Got      *token.Token
-	Expected string
+	Got      *token.Token // The token where the error was found
+	Expected string       // What was expected instead, e.g. "'end'" (may be empty)
+	Message  string       // If not empty, describes the error instead of Expected
 }
 
 func (e Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%d:%d: %s", e.Got.Line, e.Got.Column, e.Message)
+	}
 	expected := e.Expected
 	if e.Got.Type == token.INVALID {
 		expected = "invalid token: " + expected
@@ -59,7 +79,7 @@ func ParseExp(scanner Scanner) (exp ast.ExpNode, err error) {
 			}
 		}
 	}()
-	parser := &Parser{scanner}
+	parser := &Parser{scanner: scanner}
 	var t *token.Token
 	exp, t = parser.Exp(parser.Scan())
 	expectType(t, token.EOF, "<eof>")
@@ -79,7 +99,7 @@ func ParseChunk(scanner Scanner) (stat ast.BlockStat, err error) {
 			}
 		}
 	}()
-	parser := &Parser{scanner}
+	parser := &Parser{scanner: scanner}
 	var t *token.Token
 	stat, t = parser.Block(parser.Scan())
 	expectType(t, token.EOF, "<eof>")
@@ -89,6 +109,18 @@ func ParseChunk(scanner Scanner) (stat ast.BlockStat, err error) {
 // Scan returns the next token.
 func (p *Parser) Scan() *token.Token {
 	tok := p.scanner.Scan()
+	for tok != nil && tok.Type == token.COMMENT {
+		if p.keepComments {
+			p.comments = append(p.comments, tok)
+		}
+		tok = p.scanner.Scan()
+	}
+	if tok == nil {
+		// The scanner stops after EOF or an invalid token, which can only be
+		// scanned past when recovering from errors.
+		tok = p.eofToken()
+	}
+	p.lastTok, p.curTok = p.curTok, tok
 	if tok.Type == token.INVALID {
 		panic(Error{Got: tok, Expected: p.scanner.ErrorMsg()})
 	}
@@ -271,7 +303,16 @@ func (p *Parser) FunctionStat(*token.Token) (ast.Stat, *token.Token) {
 		method, t = p.Name(p.Scan())
 	}
 	fx, t := p.FunctionDef(t)
-	return ast.NewFunctionStat(v, method, fx), t
+	stat := ast.NewFunctionStat(v, method, fx)
+	if p.keepComments {
+		fx = stat.Src[0].(ast.Function)
+		fx.Syntax = ast.FunctionStatSyntax
+		if method.Val != "" {
+			fx.Syntax = ast.MethodStatSyntax
+		}
+		stat.Src[0] = fx
+	}
+	return stat, t
 }
 
 // Block parses a block whose starting token (e.g. "do") has already been
@@ -280,16 +321,37 @@ func (p *Parser) FunctionStat(*token.Token) (ast.Stat, *token.Token) {
 func (p *Parser) Block(t *token.Token) (ast.BlockStat, *token.Token) {
 	var stats []ast.Stat
 	var next ast.Stat
+	lc := p.startList()
 	for {
 		switch t.Type {
 		case token.KwReturn:
-			ret, t := p.Return(t)
-			return ast.NewBlockStat(stats, ret), t
+			lc.startItem(t)
+			var ret []ast.ExpNode
+			if p.recovering {
+				var ok bool
+				if ret, t, ok = p.recoverReturn(t); !ok {
+					// Carry on with the statements that follow.
+					lc.endItem()
+					continue
+				}
+			} else {
+				ret, t = p.Return(t)
+			}
+			lc.endItem()
+			return withBlockComments(ast.NewBlockStat(stats, ret), lc), t
 		case token.KwEnd, token.KwElse, token.KwElseIf, token.KwUntil, token.EOF:
-			return ast.NewBlockStat(stats, nil), t
+			return withBlockComments(ast.NewBlockStat(stats, nil), lc), t
 		default:
-			next, t = p.Stat(t)
-			stats = append(stats, next)
+			lc.startItem(t)
+			if p.recovering {
+				next, t = p.recoverStat(t)
+			} else {
+				next, t = p.Stat(t)
+			}
+			lc.endItem()
+			if next != nil {
+				stats = append(stats, next)
+			}
 		}
 	}
 }
@@ -370,15 +432,15 @@ func (p *Parser) ShortExp(t *token.Token) (ast.ExpNode, *token.Token) {
 		if err != nil {
 			panic(err)
 		}
-		exp, t = n, p.Scan()
+		exp, t = p.withLit(n, t), p.Scan()
 	case token.STRING:
 		s, err := ast.NewString(t)
 		if err != nil {
 			panic(err)
 		}
-		exp, t = s, p.Scan()
+		exp, t = p.withLit(s, t), p.Scan()
 	case token.LONGSTRING:
-		exp, t = ast.NewLongString(t), p.Scan()
+		exp, t = p.withLit(ast.NewLongString(t), t), p.Scan()
 	case token.SgOpenBrace:
 		exp, t = p.TableConstructor(t)
 	case token.SgEtc:
@@ -479,8 +541,11 @@ func (p *Parser) PrefixExp(t *token.Token) (ast.ExpNode, *token.Token) {
 	switch t.Type {
 	case token.SgOpenBkt:
 		exp, t = p.Exp(p.Scan())
-		if f, ok := exp.(ast.FunctionCall); ok {
-			exp = f.InBrackets()
+		switch e := exp.(type) {
+		case ast.FunctionCall:
+			exp = e.InBrackets()
+		case ast.Etc:
+			exp = e.InBrackets()
 		}
 		expectType(t, token.SgCloseBkt, "')'")
 	case token.IDENT:
@@ -541,9 +606,9 @@ func (p *Parser) Args(t *token.Token) ([]ast.ExpNode, *token.Token) {
 		if err != nil {
 			panic(err)
 		}
-		return []ast.ExpNode{arg}, p.Scan()
+		return []ast.ExpNode{p.withLit(arg, t)}, p.Scan()
 	case token.LONGSTRING:
-		return []ast.ExpNode{ast.NewLongString(t)}, p.Scan()
+		return []ast.ExpNode{p.withLit(ast.NewLongString(t), t)}, p.Scan()
 	}
 	return nil, t
 }
@@ -564,21 +629,23 @@ func (p *Parser) ExpList(t *token.Token) ([]ast.ExpNode, *token.Token) {
 func (p *Parser) TableConstructor(opTok *token.Token) (ast.TableConstructor, *token.Token) {
 	t := p.Scan()
 	var fields []ast.TableField
-	if t.Type != token.SgCloseBrace {
+	lc := p.startList()
+	for t.Type != token.SgCloseBrace {
 		var field ast.TableField
+		lc.startItem(t)
 		field, t = p.Field(t)
-		fields = []ast.TableField{field}
-		for t.Type == token.SgComma || t.Type == token.SgSemicolon {
-			t = p.Scan()
-			if t.Type == token.SgCloseBrace {
-				break
-			}
-			field, t = p.Field(t)
-			fields = append(fields, field)
+		fields = append(fields, field)
+		if t.Type != token.SgComma && t.Type != token.SgSemicolon {
+			lc.endItem()
+			break
 		}
+		t = p.Scan()
+		lc.endItem()
 	}
 	expectType(t, token.SgCloseBrace, "'}'")
-	return ast.NewTableConstructor(opTok, t, fields), p.Scan()
+	tc := ast.NewTableConstructor(opTok, t, fields)
+	tc.Comments = lc.end()
+	return tc, p.Scan()
 }
 
 // Field parses a table constructor field.
@@ -2,6 +2,7 @@ package parsing
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/arnodel/golua/ast"
@@ -161,6 +162,12 @@ func TestParser_PrefixExp(t *testing.T) {
 			want:  ast.NewFunctionCall(name("f"), ast.Name{}, []ast.ExpNode{name("x")}).InBrackets(),
 			want1: tok(token.SgSlash, "/"),
 		},
+		{
+			name:  "ellipsis in brackets",
+			input: `(...) /`,
+			want:  ast.Etc{}.InBrackets(),
+			want1: tok(token.SgSlash, "/"),
+		},
 		{
 			name:  "chain index, dot, function call, method call",
 			input: "x[1].abc():meth(1)",
@@ -1402,3 +1409,169 @@ func TestError_Error(t *testing.T) {
 		})
 	}
 }
+
+func TestParseChunkWithComments(t *testing.T) {
+	src := `-- header
+
+local x = 1 -- one
+f(x, -- inside
+  2)
+local t = { -- open
+  a = 1, -- a
+
+  -- b
+  b = 2
+  -- close
+}
+return x
+-- end`
+	chunk, err := ParseChunkWithComments(scanner.New("test", []byte(src), scanner.WithComments()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments := func(texts ...string) []ast.Comment {
+		var cs []ast.Comment
+		for _, text := range texts {
+			cs = append(cs, ast.Comment{Text: text})
+		}
+		return cs
+	}
+	wantChunk := &ast.ListComments{
+		Items: []ast.NodeComments{
+			{Leading: comments("-- header"), Trailing: comments("-- one"), BlankLineBefore: true},
+			{Leading: comments("-- inside")},
+			{},
+			{},
+		},
+		Close: comments("-- end"),
+	}
+	if !reflect.DeepEqual(chunk.Comments, wantChunk) {
+		t.Errorf("chunk comments: got %+v, want %+v", chunk.Comments, wantChunk)
+	}
+	wantTable := &ast.ListComments{
+		Open: comments("-- open"),
+		Items: []ast.NodeComments{
+			{Trailing: comments("-- a")},
+			{Leading: []ast.Comment{{Text: "-- b", BlankLineBefore: true}}},
+		},
+		Close: comments("-- close"),
+	}
+	tc := chunk.Stats[2].(ast.LocalStat).Values[0].(ast.TableConstructor)
+	if !reflect.DeepEqual(tc.Comments, wantTable) {
+		t.Errorf("table comments: got %+v, want %+v", tc.Comments, wantTable)
+	}
+	if n := chunk.Stats[0].(ast.LocalStat).Values[0].(ast.Int); n.Lit != "1" {
+		t.Errorf("expected literal to be kept, got %q", n.Lit)
+	}
+}
+
+func TestParseChunkRecovering(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		errors []string
+		stats  int // Number of statements in the partial AST
+	}{
+		{
+			name:  "no error",
+			src:   "local x = 1\nprint(x)",
+			stats: 2,
+		},
+		{
+			name: "errors in several statements",
+			src: `local x = = 1
+print(x)
+y = 2 +
+local z = 3
+f(`,
+			errors: []string{
+				"1:11: unexpected symbol near '='",
+				"4:1: unexpected symbol near 'local'",
+				"5:3: unexpected symbol near <eof>",
+			},
+			stats: 2,
+		},
+		{
+			name: "error in a nested block",
+			src: `function f()
+  if x then
+    y = )
+  end
+  return 1
+end
+print(f())`,
+			errors: []string{"3:9: unexpected symbol near ')'"},
+			stats:  2,
+		},
+		{
+			name:   "missing end",
+			src:    "function f()\n  local x = 1\n\nprint(f())",
+			errors: []string{"4:11: expected 'end' near <eof>"},
+		},
+		{
+			name: "unexpected end",
+			src:  "x = 1\nend\ny = 2",
+			errors: []string{
+				"2:1: expected <eof> near 'end'",
+			},
+			stats: 2,
+		},
+		{
+			name: "statements after return",
+			src:  "return 1\nx = 1\ny = = 2",
+			errors: []string{
+				"2:1: expected <eof> near 'x'",
+				"3:5: unexpected symbol near '='",
+			},
+			stats: 1,
+		},
+		{
+			name: "invalid token stops the scan",
+			src:  "x = 1\ny = 'abc\nz = (",
+			errors: []string{
+				`2:5: invalid token: illegal new line in string literal near '\'abc\n'`,
+			},
+			stats: 1,
+		},
+		{
+			name:   "bad escape sequence",
+			src:    `x = "\300"` + "\ny = 2",
+			errors: []string{`1:5: decimal escape sequence out of range near '\300'`},
+			stats:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk, errs := ParseChunkRecovering(scanner.New("test", []byte(tt.src)))
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tt.errors) {
+				t.Errorf("got errors %q, want %q", got, tt.errors)
+			}
+			if len(chunk.Stats) != tt.stats {
+				t.Errorf("got %d statements, want %d", len(chunk.Stats), tt.stats)
+			}
+			_, err := ParseChunk(scanner.New("test", []byte(tt.src)))
+			switch {
+			case err == nil && len(errs) > 0:
+				t.Errorf("ParseChunk succeeded but errors were found")
+			case err != nil && (len(errs) == 0 || !strings.HasSuffix(errs[0].Error(), err.Error())):
+				t.Errorf("first error is not the ParseChunk error %q", err)
+			}
+		})
+	}
+}
+
+func TestError_EndPos(t *testing.T) {
+	err := Error{Got: &token.Token{
+		Type: token.LONGSTRING,
+		Lit:  []byte("[[é\r\nab]]"),
+		Pos:  token.Pos{Offset: 10, Line: 2, Column: 5},
+	}}
+	want := token.Pos{Offset: 20, Line: 3, Column: 5}
+	if got := err.EndPos(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
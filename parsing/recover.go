@@ -0,0 +1,172 @@
+package parsing
+
+import (
+	"github.com/arnodel/golua/ast"
+	"github.com/arnodel/golua/token"
+)
+
+// ParseChunkRecovering is like ParseChunk but it does not stop at the first
+// syntax error.  When a statement cannot be parsed, the error is recorded and
+// the tokens that follow are skipped until one which can start a statement or
+// close a block (or a name at the start of a new line).  It returns the errors
+// found in the order of the source, the first one being the error returned by
+// ParseChunk, and the AST made of the statements which could be parsed.
+//
+// After an invalid token (e.g. an unfinished string), the scanner cannot carry
+// on so the rest of the source is not checked.
+func ParseChunkRecovering(scanner Scanner) (ast.BlockStat, []Error) {
+	p := &Parser{scanner: scanner, recovering: true}
+	var (
+		stats []ast.Stat
+		ret   []ast.ExpNode
+	)
+	t := p.recoverScan()
+	for {
+		b, next := p.Block(t)
+		stats = append(stats, b.Stats...)
+		if b.Return != nil {
+			ret = b.Return
+		}
+		if next.Type == token.EOF {
+			break
+		}
+		p.addError(Error{Got: next, Expected: "<eof>"})
+		t = next
+		if closesBlock(next) {
+			t = p.recoverScan()
+		}
+	}
+	return ast.NewBlockStat(stats, ret), p.errors
+}
+
+// StartPos returns the position of the token where the error was found.
+func (e Error) StartPos() token.Pos {
+	return e.Got.Pos
+}
+
+// EndPos returns the position just after the token where the error was found.
+func (e Error) EndPos() token.Pos {
+	pos := e.Got.Pos
+	lit := e.Got.Lit
+	for i := 0; i < len(lit); i++ {
+		switch c := lit[i]; {
+		case c == '\n' || c == '\r':
+			if i+1 < len(lit) && lit[i+1] != c && (lit[i+1] == '\n' || lit[i+1] == '\r') {
+				i++
+			}
+			pos.Line++
+			pos.Column = 1
+		case c < 0x80 || c >= 0xC0:
+			// Columns count characters, not continuation bytes.
+			pos.Column++
+		}
+	}
+	pos.Offset += len(lit)
+	return pos
+}
+
+// Parses a statement.  If that fails, the error is recorded and a nil
+// statement is returned with the token where parsing can resume.
+func (p *Parser) recoverStat(t *token.Token) (stat ast.Stat, next *token.Token) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.addError(r)
+			stat, next = nil, p.skipToStat(t)
+		}
+	}()
+	return p.Stat(t)
+}
+
+// Parses a return statement.  If that fails, the error is recorded and ok is
+// false.
+func (p *Parser) recoverReturn(t *token.Token) (ret []ast.ExpNode, next *token.Token, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.addError(r)
+			ret, next, ok = nil, p.skipToStat(t), false
+		}
+	}()
+	ret, next = p.Return(t)
+	return ret, next, true
+}
+
+// Returns the next token, recording the error if it is invalid.  The scanner
+// stops after an invalid token so an EOF token is returned in that case.
+func (p *Parser) recoverScan() (t *token.Token) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.addError(r)
+			t = p.eofToken()
+		}
+	}()
+	return p.Scan()
+}
+
+// Skips tokens after an error in a statement starting at token start, until
+// one where parsing can resume.
+func (p *Parser) skipToStat(start *token.Token) *token.Token {
+	t := p.curTok
+	errLine := t.Line
+	if t == start {
+		// Make sure some progress is made
+		t = p.recoverScan()
+	}
+	for {
+		switch t.Type {
+		case token.EOF, token.KwEnd, token.KwElse, token.KwElseIf, token.KwUntil,
+			token.KwLocal, token.KwFunction, token.KwIf, token.KwWhile, token.KwFor,
+			token.KwRepeat, token.KwDo, token.KwReturn, token.KwGoto, token.KwBreak,
+			token.SgDoubleColon, token.SgSemicolon:
+			return t
+		case token.IDENT:
+			if t.Line > errLine {
+				return t
+			}
+		}
+		t = p.recoverScan()
+	}
+}
+
+func closesBlock(t *token.Token) bool {
+	switch t.Type {
+	case token.KwEnd, token.KwElse, token.KwElseIf, token.KwUntil:
+		return true
+	}
+	return false
+}
+
+// Records the error that the parser panicked with.  Errors from other packages
+// (e.g. about escape sequences in strings) are attached to the current token.
+// An error on the same token as the previous one is not recorded, nor one on
+// the EOF token made up after an invalid token as the source after it could
+// not be scanned.
+func (p *Parser) addError(r interface{}) {
+	var err Error
+	switch e := r.(type) {
+	case Error:
+		err = e
+	case error:
+		err = Error{Got: p.curTok, Message: e.Error()}
+	default:
+		panic(r)
+	}
+	if err.Got == p.eof {
+		return
+	}
+	if n := len(p.errors); n > 0 && p.errors[n-1].Got == err.Got {
+		return
+	}
+	p.errors = append(p.errors, err)
+}
+
+// Returns the token to use when the scanner has stopped, which is an EOF token
+// at the end of the last token.
+func (p *Parser) eofToken() *token.Token {
+	if p.eof == nil {
+		p.eof = &token.Token{Type: token.EOF}
+		if p.curTok != nil {
+			p.eof.Pos = Error{Got: p.curTok}.EndPos()
+		}
+	}
+	return p.eof
+}
@@ -230,15 +230,16 @@ func binaryArithFallback(t *Thread, op string, x, y Value) (Value, error) {
 // perform x op y.
 func BinaryArithmeticError(op string, x, y Value) error {
 	var wrongVal Value
+	var operand int
 	switch {
 	case numberType(y) != NaN:
 		wrongVal = x
 	case numberType(x) != NaN:
-		wrongVal = y
+		wrongVal, operand = y, 1
 	default:
 		return fmt.Errorf("attempt to %s a '%s' with a '%s'", op, x.CustomTypeName(), y.CustomTypeName())
 	}
-	return fmt.Errorf("attempt to perform arithmetic on a %s value", wrongVal.CustomTypeName())
+	return newOperandError(operand, "attempt to perform arithmetic on a %s value", wrongVal.CustomTypeName())
 }
 
 func unaryArithFallback(t *Thread, op string, x Value) (Value, error) {
@@ -252,5 +253,5 @@ func unaryArithFallback(t *Thread, op string, x Value) (Value, error) {
 // UnaryArithmeticError returns an error describing the problem with trying to
 // perform the unary operation op(x).
 func UnaryArithmeticError(op string, x Value) error {
-	return fmt.Errorf("attempt to %s a '%s'", op, x.CustomTypeName())
+	return newOperandError(0, "attempt to %s a '%s'", op, x.CustomTypeName())
 }
@@ -93,15 +93,16 @@ func bnot(t *Thread, x Value) (Value, error) {
 
 func binaryBitwiseError(op string, x, y Value, okx, oky bool) error {
 	var wrongVal Value
+	var operand int
 	switch {
 	case oky:
 		wrongVal = x
 	case okx:
-		wrongVal = y
+		wrongVal, operand = y, 1
 	case x.Type() != FloatType:
 		wrongVal = x
 	case y.Type() != FloatType:
-		wrongVal = y
+		wrongVal, operand = y, 1
 	default:
 		// Both x, and y are floats
 		wrongVal = x
@@ -109,5 +110,5 @@ func binaryBitwiseError(op string, x, y Value, okx, oky bool) error {
 	if wrongVal.Type() == FloatType {
 		return fmt.Errorf("number has no integer representation")
 	}
-	return fmt.Errorf("attempt to perform bitwise %s on a %s value", op, wrongVal.CustomTypeName())
+	return newOperandError(operand, "attempt to perform bitwise %s on a %s value", op, wrongVal.CustomTypeName())
 }
@@ -0,0 +1,331 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// A Class describes how values of the Go type T are seen from Lua, as
+// userdata.  It is declared once, typically in a package level variable, and
+// can then be used in any number of runtimes.  E.g.
+//
+//	var pointClass = NewClass[*Point]("point").
+//		Method(Func1("norm", func(p *Point) (float64, error) {
+//			return math.Hypot(p.X, p.Y), nil
+//		})).
+//		Property("x", getX, setX).
+//		ToString(func(p *Point) string {
+//			return fmt.Sprintf("point(%g, %g)", p.X, p.Y)
+//		})
+//
+// Then pointClass.New(r, &Point{1, 2}) returns a userdata in the runtime r.
+//
+// Once a class is declared, values of type T are turned into userdata of that
+// class by Encode (and so when they are the result of functions made with
+// Func1, Func2...), and userdata values are accepted as arguments of type T by
+// Decode, CheckUserData and functions made with Func1, Func2...
+//
+// The metatable of the class is built in a runtime the first time an instance
+// is created in it, so the class must be fully declared by then.
+type Class[T any] struct {
+	classInfo
+}
+
+// The part of a class which does not depend on its Go type.
+type classInfo struct {
+	name        string
+	goType      reflect.Type
+	parent      *classInfo
+	methods     []*GoFunction
+	properties  map[string]classProperty
+	metamethods []classMetamethod
+}
+
+type classProperty struct {
+	get func(*Thread, interface{}) (Value, error)
+	set func(*Thread, interface{}, Value) error // nil if read-only
+}
+
+type classMetamethod struct {
+	event string
+	f     *GoFunction
+}
+
+var classes sync.Map // Maps a Go type to the *classInfo of its class
+
+// NewClass returns a new class called name for the Go type T.  There can only
+// be one class for a given Go type, otherwise NewClass panics.
+func NewClass[T any](name string) *Class[T] {
+	c := &Class[T]{classInfo{
+		name:       name,
+		goType:     reflect.TypeOf((*T)(nil)).Elem(),
+		properties: map[string]classProperty{},
+	}}
+	if _, loaded := classes.LoadOrStore(c.goType, &c.classInfo); loaded {
+		panic(fmt.Sprintf("a class already exists for type %s", c.goType))
+	}
+	return c
+}
+
+// Name returns the name of the class, which is the value of "__name" in the
+// metatable of its instances.
+func (c *Class[T]) Name() string {
+	return c.name
+}
+
+// Method adds methods to the class.  Each method is named after the function
+// that implements it, whose first argument is the instance it is called on.
+// This function is typically made with Func1, Func2... with a first parameter
+// of type T.
+func (c *Class[T]) Method(fs ...*GoFunction) *Class[T] {
+	c.methods = append(c.methods, fs...)
+	return c
+}
+
+// Property adds a property to the class, i.e. a field of its instances which
+// is computed by get when read, and set by set when assigned to.  If set is nil,
+// the property is read-only.
+func (c *Class[T]) Property(name string, get func(t *Thread, self T) (Value, error), set func(t *Thread, self T, v Value) error) *Class[T] {
+	p := classProperty{
+		get: func(t *Thread, self interface{}) (Value, error) {
+			return get(t, self.(T))
+		},
+	}
+	if set != nil {
+		p.set = func(t *Thread, self interface{}, v Value) error {
+			return set(t, self.(T), v)
+		}
+	}
+	c.properties[name] = p
+	return c
+}
+
+// Metamethod sets the metamethod for event (e.g. "__add", "__eq", "__len",
+// "__call") to f.  The "__index" and "__newindex" metamethods are used to
+// implement methods and properties and "__name" is the name of the class, so
+// they cannot be set.  If "__gc" is set, instances are marked for finalization
+// when they are created.
+func (c *Class[T]) Metamethod(event string, f *GoFunction) *Class[T] {
+	switch event {
+	case "__index", "__newindex", "__name":
+		panic(fmt.Sprintf("cannot set metamethod %s of a class", event))
+	}
+	c.metamethods = append(c.metamethods, classMetamethod{event: event, f: f})
+	return c
+}
+
+// ToString sets the "__tostring" metamethod of the class to return f(self).
+func (c *Class[T]) ToString(f func(self T) string) *Class[T] {
+	return c.Metamethod("__tostring", Func1("__tostring", func(self T) (string, error) {
+		return f(self), nil
+	}))
+}
+
+// Close sets the "__close" metamethod of the class to call f(self), so that
+// instances can be used as to-be-closed variables.
+func (c *Class[T]) Close(f func(self T) error) *Class[T] {
+	return c.Metamethod("__close", Proc1("__close", f))
+}
+
+// Extends makes c a subclass of parent.  Instances of c have the methods,
+// properties and metamethods of parent, unless c defines its own with the same
+// name.  The Go type of c must be assignable to the Go type of parent (which is
+// typically an interface implemented by the Go type of c), otherwise Extends
+// panics.
+func (c *Class[T]) Extends(parent ClassDef) *Class[T] {
+	p := parent.info()
+	if !c.goType.AssignableTo(p.goType) {
+		panic(fmt.Sprintf("class %s cannot extend class %s: %s is not assignable to %s", c.name, p.name, c.goType, p.goType))
+	}
+	c.parent = p
+	return c
+}
+
+// New returns a new instance of the class in the runtime r, i.e. a userdata
+// whose value is v.
+func (c *Class[T]) New(r *Runtime, v T) Value {
+	return UserDataValue(c.newUserData(r, v))
+}
+
+// A ClassDef is a Class for some Go type.
+type ClassDef interface {
+	info() *classInfo
+}
+
+var _ ClassDef = (*Class[int])(nil)
+
+func (c *classInfo) info() *classInfo {
+	return c
+}
+
+func (c *classInfo) newUserData(r *Runtime, v interface{}) *UserData {
+	r.RequireSize(unsafe.Sizeof(UserData{}))
+	meta := c.metatable(r)
+	u := NewUserData(v, meta)
+	if !RawGet(meta, StringValue("__gc")).IsNil() {
+		// As if the metatable had been set with setmetatable.
+		r.MarkForFinalization(UserDataValue(u))
+	}
+	return u
+}
+
+// Returns the class for the Go type tp, or nil if there is none.
+func classOf(tp reflect.Type) *classInfo {
+	c, ok := classes.Load(tp)
+	if !ok {
+		return nil
+	}
+	return c.(*classInfo)
+}
+
+// Returns the metatable of c in the runtime r, building it if needed.  It is
+// stored in the registry.
+func (c *classInfo) metatable(r *Runtime) *Table {
+	key := LightUserDataValue(LightUserData{Data: c})
+	if meta, ok := r.Registry(key).TryTable(); ok {
+		return meta
+	}
+	var chain []*classInfo
+	for cc := c; cc != nil; cc = cc.parent {
+		chain = append(chain, cc)
+	}
+	meta := NewTable()
+	methods := NewTable()
+	properties := map[string]classProperty{}
+
+	// Start with the root class so that subclasses can override.
+	for i := len(chain) - 1; i >= 0; i-- {
+		cc := chain[i]
+		for _, m := range cc.methods {
+			r.SetTable(methods, StringValue(m.name), FunctionValue(m))
+		}
+		for name, p := range cc.properties {
+			properties[name] = p
+		}
+		for _, m := range cc.metamethods {
+			r.SetTable(meta, StringValue(m.event), FunctionValue(m.f))
+		}
+	}
+	r.SetTable(meta, StringValue("__name"), StringValue(c.name))
+	if len(properties) == 0 {
+		// Methods can be found without calling a Go function.
+		r.SetTable(meta, StringValue("__index"), TableValue(methods))
+	} else {
+		index := func(t *Thread, cont *GoCont) (Cont, error) {
+			self, name, err := c.selfAndName(cont)
+			if err != nil {
+				return nil, err
+			}
+			if p, ok := properties[name]; ok {
+				v, err := p.get(t, self)
+				if err != nil {
+					return nil, err
+				}
+				return cont.PushingNext1(t.Runtime, v), nil
+			}
+			return cont.PushingNext1(t.Runtime, methods.Get(cont.Arg(1))), nil
+		}
+		r.SetEnvGoFunc(meta, "__index", index, 2, false)
+	}
+	newIndex := func(t *Thread, cont *GoCont) (Cont, error) {
+		if err := cont.CheckNArgs(3); err != nil {
+			return nil, err
+		}
+		self, name, err := c.selfAndName(cont)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := properties[name]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s has no property '%s'", c.name, name)
+		case p.set == nil:
+			return nil, fmt.Errorf("property '%s' of %s is read-only", name, c.name)
+		}
+		if err := p.set(t, self, cont.Arg(2)); err != nil {
+			return nil, err
+		}
+		return cont.Next(), nil
+	}
+	r.SetEnvGoFunc(meta, "__newindex", newIndex, 3, false)
+	r.SetRegistry(key, TableValue(meta))
+	return meta
+}
+
+// Returns the value of the userdata and the field name passed to "__index" or
+// "__newindex".  The name is empty if the field is not a string.  The userdata
+// must be an instance of c (or of a subclass), as these metamethods can be
+// called directly from Lua with any value.
+func (c *classInfo) selfAndName(cont *GoCont) (interface{}, string, error) {
+	if err := cont.CheckNArgs(2); err != nil {
+		return nil, "", err
+	}
+	self := cont.Arg(0)
+	if !c.isInstance(self) {
+		return nil, "", fmt.Errorf("bad argument #1 to '%s' (%s expected, got %s)", cont.Name(), c.name, typeNameInError(self))
+	}
+	name, _ := cont.Arg(1).TryString()
+	return self.AsUserData().Value(), name, nil
+}
+
+// Returns true if v is a userdata whose value can be used as a value of the Go
+// type of c.
+func (c *classInfo) isInstance(v Value) bool {
+	u, ok := v.TryUserData()
+	if !ok {
+		return false
+	}
+	tp := reflect.TypeOf(u.Value())
+	return tp != nil && tp.AssignableTo(c.goType)
+}
+
+// CheckUserData returns the value of the n-th argument of c if it is a
+// userdata whose value has type T (or can be assigned to T when T is an
+// interface), and otherwise an error such as
+//
+//	bad argument #1 to 'find' (regex expected, got string)
+//
+// where the expected name is the name of the class for T if there is one.
+func CheckUserData[T any](c *GoCont, n int) (T, error) {
+	if n < c.NArgs() {
+		if x, ok := userDataAs[T](c.Arg(n)); ok {
+			return x, nil
+		}
+	}
+	var x T
+	got := "no value"
+	if n < c.NArgs() {
+		got = typeNameInError(c.Arg(n))
+	}
+	return x, fmt.Errorf("bad argument #%d to '%s' (%s expected, got %s)", n+1, c.Name(), typeNameOf[T](), got)
+}
+
+// Returns the value of v as a T if it is a userdata holding a T.
+func userDataAs[T any](v Value) (x T, ok bool) {
+	u, ok := v.TryUserData()
+	if ok {
+		x, ok = u.Value().(T)
+	}
+	return
+}
+
+// Returns the name of the class of T if there is one, otherwise the name of
+// the Go type.
+func typeNameOf[T any]() string {
+	tp := reflect.TypeOf((*T)(nil)).Elem()
+	if c := classOf(tp); c != nil {
+		return c.name
+	}
+	return tp.String()
+}
+
+// Returns the type name of v, as used in error messages.  It is the value of
+// the "__name" metafield of userdata if it exists.
+func typeNameInError(v Value) string {
+	if u, ok := v.TryUserData(); ok {
+		return getName(v.TypeName(), u.Metatable())
+	}
+	return v.TypeName()
+}
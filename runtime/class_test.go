@@ -0,0 +1,188 @@
+package runtime_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/runtime"
+)
+
+type classShape interface {
+	Area() float64
+}
+
+type classRect struct {
+	W, H float64
+}
+
+func (r *classRect) Area() float64 { return r.W * r.H }
+
+type classResource struct {
+	name   string
+	closed bool
+}
+
+var (
+	shapeClass = runtime.NewClass[classShape]("shape").
+			Method(runtime.Func1("area", func(s classShape) (float64, error) {
+			return s.Area(), nil
+		})).
+		Property("kind", func(t *runtime.Thread, s classShape) (runtime.Value, error) {
+			return runtime.StringValue("shape"), nil
+		}, nil).
+		ToString(func(s classShape) string {
+			return fmt.Sprintf("shape(%g)", s.Area())
+		})
+
+	rectClass = runtime.NewClass[*classRect]("rect").
+			Extends(shapeClass).
+			Method(runtime.Func2("scale", func(r *classRect, k float64) (*classRect, error) {
+			return &classRect{W: k * r.W, H: k * r.H}, nil
+		})).
+		Property("width", func(t *runtime.Thread, r *classRect) (runtime.Value, error) {
+			return runtime.FloatValue(r.W), nil
+		}, func(t *runtime.Thread, r *classRect, v runtime.Value) error {
+			return t.Decode(v, &r.W)
+		}).
+		Property("height", func(t *runtime.Thread, r *classRect) (runtime.Value, error) {
+			return runtime.FloatValue(r.H), nil
+		}, nil).
+		Metamethod("__eq", runtime.Func2("__eq", func(a, b *classRect) (bool, error) {
+			return *a == *b, nil
+		}))
+
+	resourceClass = runtime.NewClass[*classResource]("resource").
+			Close(func(r *classResource) error {
+			r.closed = true
+			return nil
+		})
+)
+
+func TestClass(t *testing.T) {
+	r := runtime.New(nil)
+	lib.LoadAll(r)
+	env := r.GlobalEnv()
+	res := &classResource{name: "res"}
+	r.SetEnv(env, "rect", rectClass.New(r, &classRect{W: 2, H: 3}))
+	r.SetEnv(env, "res", resourceClass.New(r, res))
+	r.SetEnv(env, "newrect", runtime.FunctionValue(runtime.Func2("newrect", func(w, h float64) (*classRect, error) {
+		return &classRect{W: w, H: h}, nil
+	})))
+	r.SetEnv(env, "area", runtime.FunctionValue(runtime.NewGoFunction(func(t *runtime.Thread, c *runtime.GoCont) (runtime.Cont, error) {
+		s, err := runtime.CheckUserData[classShape](c, 0)
+		if err != nil {
+			return nil, err
+		}
+		return c.PushingNext1(t.Runtime, runtime.FloatValue(s.Area())), nil
+	}, "area", 1, false)))
+
+	tests := []struct {
+		src, want string
+	}{
+		{`rect:area() == 6`, "true"},
+		{`rect.kind`, "shape"},
+		{`rect.width + rect.height == 5`, "true"},
+		{`(function() rect.width = 4 return rect:area() == 12 end)()`, "true"},
+		{`select(2, pcall(function() rect.height = 1 end))`, "property 'height' of rect is read-only"},
+		{`select(2, pcall(function() rect.foo = 1 end))`, "rect has no property 'foo'"},
+		{`rect.foo`, "nil"},
+		{`rect`, "shape(12)"},
+		{`rect:scale(2)`, "shape(48)"},
+		{`getmetatable(rect:scale(2)).__name`, "rect"},
+		{`rect:scale(0.5) == newrect(2, 1.5)`, "true"},
+		{`rect == newrect(1, 1)`, "false"},
+		{`area(newrect(1, 2)) == 2`, "true"},
+		{`select(2, pcall(area, res))`, "bad argument #1 to 'area' (shape expected, got resource)"},
+		{`select(2, pcall(area))`, "bad argument #1 to 'area' (shape expected, got no value)"},
+		{`select(2, pcall(rect.scale, res, 1))`, "bad argument #1 to 'scale' (rect expected, got resource)"},
+		{`select(2, pcall(rect.area, {}))`, "bad argument #1 to 'area' (shape expected, got table)"},
+		{`(function() do local x <close> = res end return "ok" end)()`, "ok"},
+		{`select(2, pcall(getmetatable(rect).__index, res, "width"))`, "bad argument #1 to '__index' (rect expected, got resource)"},
+		{`select(2, pcall(getmetatable(rect).__newindex, res, "width", 1))`, "bad argument #1 to '__newindex' (rect expected, got resource)"},
+		{`select(2, pcall(getmetatable(rect).__index, {}, "width"))`, "bad argument #1 to '__index' (rect expected, got table)"},
+	}
+	for _, test := range tests {
+		chunk, err := r.CompileAndLoadLuaChunk("test", []byte("return tostring("+test.src+")"), runtime.TableValue(env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := runtime.Call1(r.MainThread(), runtime.FunctionValue(chunk))
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		got, _ := v.ToString()
+		got = strings.TrimPrefix(got, "test:1: ")
+		if got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.src, got, test.want)
+		}
+	}
+	if !res.closed {
+		t.Error("resource not closed")
+	}
+
+	// Decode and Encode know about classes.
+	var rr *classRect
+	if err := r.Decode(rectClass.New(r, &classRect{W: 1}), &rr); err != nil || rr.W != 1 {
+		t.Errorf("decode: got %v, %v", rr, err)
+	}
+	v, err := r.Encode(struct{ R *classRect }{&classRect{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.AsTable().Get(runtime.StringValue("R")).TryUserData(); !ok {
+		t.Error("encode: expected userdata")
+	}
+}
+
+func TestClassFinalizer(t *testing.T) {
+	type gcObject struct{ name string }
+	var finalized []string
+	gcClass := runtime.NewClass[*gcObject]("gcobject").
+		Metamethod("__gc", runtime.Proc1("__gc", func(o *gcObject) error {
+			finalized = append(finalized, o.name)
+			return nil
+		}))
+	r := runtime.New(nil)
+	kept := []runtime.Value{gcClass.New(r, &gcObject{"a"})}
+	v, err := r.Encode(&gcObject{"b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept = append(kept, v)
+	r.Close(r.MainThread())
+	if want := []string{"b", "a"}; !reflect.DeepEqual(finalized, want) {
+		t.Errorf("Close() finalized %v, want %v", finalized, want)
+	}
+	_ = kept
+}
+
+func TestClassPanics(t *testing.T) {
+	type notAShape struct{}
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{"duplicate", func() { runtime.NewClass[*classRect]("rect2") }},
+		{"extends", func() { runtime.NewClass[notAShape]("nas").Extends(shapeClass) }},
+		{"index", func() {
+			runtime.NewClass[*int]("int").Metamethod("__index", runtime.Func0("f", func() (int, error) {
+				return 0, errors.New("unused")
+			}))
+		}},
+	}
+	for _, test := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected panic", test.name)
+				}
+			}()
+			test.f()
+		}()
+	}
+}
@@ -8,6 +8,9 @@ type Closure struct {
 	*Code
 	Upvalues     []Cell
 	upvalueIndex int
+
+	// Stands for this closure in weak keys (see weakref.go).
+	weakHandle *weakHandle
 }
 
 var _ Callable = (*Closure)(nil)
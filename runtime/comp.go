@@ -92,12 +92,17 @@ func eq(t *Thread, x, y Value) (bool, error) {
 	if res, ok := RawEqual(x, y); ok {
 		return res, nil
 	}
+	// Only tables and full userdata may have an "__eq" metamethod, and both
+	// values must have the same type.
 	if _, ok := x.TryTable(); ok {
 		if _, ok := y.TryTable(); !ok {
 			return false, nil
 		}
+	} else if _, ok := x.TryUserData(); ok {
+		if _, ok := y.TryUserData(); !ok {
+			return false, nil
+		}
 	} else {
-		// TODO: deal with UserData
 		return false, nil
 	}
 	res, err, ok := metabin(t, "__eq", x, y)
@@ -1,7 +1,9 @@
 package runtime
 
 import (
+	"errors"
 	"os"
+	"os/exec"
 	"syscall"
 )
 
@@ -83,3 +85,34 @@ func (r *Runtime) ProcessIoError(c Cont, ioErr error) (Cont, error) {
 	}
 	return c, nil
 }
+
+// PushExitStatus pushes to c the values that Lua uses to report how a process
+// terminated (e.g. in os.execute), given the error returned when waiting for
+// it: true or nil (fail), followed by "exit" and the exit code or "signal" and
+// the signal number.  If err is not nil and does not describe the exit status
+// of a process, it is handled as in PushIoError.
+func (r *Runtime) PushExitStatus(c Cont, err error) error {
+	if err == nil {
+		r.Push(c, BoolValue(true), StringValue("exit"), IntValue(0))
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return r.PushIoError(c, err)
+	}
+	what, code := "exit", exitErr.ExitCode()
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		what, code = "signal", int(ws.Signal())
+	}
+	r.Push(c, NilValue, StringValue(what), IntValue(int64(code)))
+	return nil
+}
+
+// ProcessExitStatus is like PushExitStatus but its signature makes it
+// convenient to use in a return statement from a GoFunc implementation.
+func (r *Runtime) ProcessExitStatus(c Cont, err error) (Cont, error) {
+	if err := r.PushExitStatus(c, err); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
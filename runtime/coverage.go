@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"github.com/arnodel/golua/code"
+	"github.com/arnodel/golua/coverage"
+)
+
+// StartCoverage starts recording which lines of Lua code are executed, and the
+// outcomes of conditional jumps.  Code loaded after coverage is started is
+// reported in full; of the code loaded before, only the functions that are
+// called are reported.
+func (r *Runtime) StartCoverage() {
+	if r.coverage == nil {
+		r.coverage = &coverageRecorder{codes: map[*Code]*codeCoverage{}}
+	}
+}
+
+// StopCoverage stops recording coverage and returns what was recorded since
+// StartCoverage was called, or nil if coverage was not started.  It must not
+// be called while Lua code is running in the runtime.
+func (r *Runtime) StopCoverage() *coverage.Profile {
+	rec := r.coverage
+	if rec == nil {
+		return nil
+	}
+	r.coverage = nil
+	return rec.profile()
+}
+
+// A coverageRecorder keeps execution counts for each instruction of the
+// functions it knows about.
+type coverageRecorder struct {
+	codes map[*Code]*codeCoverage
+	order []*Code // So that the profile is built deterministically
+}
+
+type codeCoverage struct {
+	hits  []uint64 // number of times each instruction was executed
+	taken []uint64 // number of times each conditional jump was taken
+}
+
+// Returns the counters for c, creating them if needed.
+func (r *coverageRecorder) counters(c *Code) *codeCoverage {
+	cc := r.codes[c]
+	if cc == nil {
+		cc = &codeCoverage{
+			hits:  make([]uint64, len(c.code)),
+			taken: make([]uint64, len(c.code)),
+		}
+		r.codes[c] = cc
+		r.order = append(r.order, c)
+	}
+	return cc
+}
+
+// Builds the profile.  Within a function, the count of a line is that of its
+// most executed instruction; counts for the same file are summed.  Receive
+// instructions are not counted as they are not executed in the main loop.
+func (r *coverageRecorder) profile() *coverage.Profile {
+	p := coverage.NewProfile()
+	for _, c := range r.order {
+		cc := r.codes[c]
+		lines := map[int]uint64{}
+		branches := map[int][]uint64{}
+		for pc, opcode := range c.code {
+			if pc >= len(c.lines) || c.lines[pc] <= 0 || opcode.HasType0() {
+				continue
+			}
+			line := int(c.lines[pc])
+			hits := cc.hits[pc]
+			if n, ok := lines[line]; !ok || hits > n {
+				lines[line] = hits
+			}
+			if isConditionalJump(opcode) {
+				taken := cc.taken[pc]
+				branches[line] = append(branches[line], taken, hits-taken)
+			}
+		}
+		f := p.File(c.source)
+		for line, n := range lines {
+			f.AddLine(line, n)
+		}
+		for line, counts := range branches {
+			f.AddBranches(line, counts)
+		}
+	}
+	return p
+}
+
+func isConditionalJump(opcode code.Opcode) bool {
+	return opcode.TypePfx() == code.Type5Pfx && opcode.GetJ() == code.OpJumpIf
+}
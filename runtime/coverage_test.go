@@ -0,0 +1,58 @@
+package runtime_test
+
+import (
+	"reflect"
+	"testing"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const coveredSource = `local function classify(n)
+  if n < 0 then
+    return "negative"
+  end
+  return "positive"
+end
+
+local function unused()
+  return 1
+end
+
+return classify(1), classify(2)
+`
+
+func TestCoverage(t *testing.T) {
+	r := rt.New(nil)
+	if r.StopCoverage() != nil {
+		t.Fatal("expected no coverage")
+	}
+	r.StartCoverage()
+	for i := 0; i < 2; i++ {
+		clos, err := r.MainThread().LoadFromSourceOrCode("covered.lua", []byte(coveredSource), "t", rt.TableValue(r.GlobalEnv()), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := r.StopCoverage()
+	files := p.Files()
+	if len(files) != 1 || files[0].Name != "covered.lua" {
+		t.Fatalf("unexpected files: %v", files)
+	}
+	f := files[0]
+	expectedLines := map[int]uint64{1: 2, 2: 4, 3: 0, 5: 4, 8: 2, 9: 0, 12: 2}
+	for line, n := range expectedLines {
+		if f.Lines[line] != n {
+			t.Errorf("expected line %d to be executed %d times, got %d", line, n, f.Lines[line])
+		}
+	}
+	if found, hit := f.LineStats(); found != len(expectedLines) || hit != 5 {
+		t.Errorf("unexpected line stats: %d, %d", found, hit)
+	}
+	// The jump over "return negative" is always taken
+	if !reflect.DeepEqual(f.Branches[2], []uint64{4, 0}) {
+		t.Errorf("unexpected branches: %v", f.Branches)
+	}
+}
@@ -14,3 +14,19 @@ type DebugInfo struct {
 func (i DebugInfo) String() string {
 	return fmt.Sprintf("file=%s func=%s line=%d", i.Source, i.Name, i.CurrentLine)
 }
+
+// LuaContOf returns the Lua continuation that c stands for in a call stack, or
+// nil if there is none.  A Termination stands for the continuation it was
+// created with (its DebugInfo is that of its parent), so this is what is
+// returned in that case.
+func LuaContOf(c Cont) *LuaCont {
+	switch cc := c.(type) {
+	case *LuaCont:
+		return cc
+	case *Termination:
+		if cc.parent != nil {
+			return LuaContOf(cc.parent)
+		}
+	}
+	return nil
+}
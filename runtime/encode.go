@@ -0,0 +1,599 @@
+package runtime
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Encode returns a Lua value representing v, building Lua tables for Go
+// structured values.  It is meant for passing Go data (e.g. requests or
+// configuration) to Lua code.  Values are converted as follows.
+//
+//   - nil pointers, interfaces, maps and slices become nil;
+//   - booleans, integers, floats and strings become the corresponding Lua
+//     values (unsigned integers too large for an int64 are an error);
+//   - []byte becomes a string;
+//   - values implementing encoding.TextMarshaler, such as time.Time, become
+//     the string they marshal to;
+//   - slices and arrays become sequences (tables with keys 1 to n);
+//   - maps become tables with the same keys;
+//   - structs become tables with a field for each exported field (see below);
+//   - pointers and interfaces are converted as the value they point to;
+//   - Value, *Table, *UserData and Callable values are passed as they are;
+//   - values whose type has a Class become userdata of that class.
+//
+// The name of a struct field in Lua can be set with a "lua" tag, e.g.
+//
+//	Name string `lua:"name"`
+//
+// The "omitempty" option, as in `lua:"name,omitempty"`, leaves out the field
+// if it has a zero value, and the field is skipped if the tag is "-".  Fields
+// of embedded structs are added to the table as if they were fields of the
+// embedding struct, unless the embedded struct is given a name by a tag.
+//
+// Memory used by the tables and strings created is accounted for.  Go values
+// referring to themselves (via pointers, maps or slices) cannot be encoded and
+// result in an error.
+func (r *Runtime) Encode(v interface{}) (Value, error) {
+	e := encoder{r: r, visiting: map[encodeRef]bool{}}
+	return e.encode(reflect.ValueOf(v))
+}
+
+// Decode stores the Go representation of v in the value pointed to by dst,
+// which must be a non-nil pointer.  It is the inverse of Encode, so the rules
+// for converting values are the same.  In addition:
+//
+//   - time.Time can also be decoded from a number, which is the number of
+//     seconds since the Unix epoch;
+//   - strings are decoded to values implementing encoding.TextUnmarshaler;
+//   - nil leaves the destination unchanged;
+//   - userdata are decoded to their value, if it is assignable to the
+//     destination;
+//   - pointers are allocated as needed;
+//   - tables decode to a struct by looking up the (possibly tagged) names of
+//     the struct fields, other entries in the table being ignored;
+//   - values decoded to an empty interface are stored as nil, bool, int64,
+//     float64, string, []interface{} for sequences, map[string]interface{}
+//     for tables with only string keys and map[interface{}]interface{} for
+//     other tables.  Functions, threads and userdata are stored as they are.
+//
+// It is an error to decode a table which contains itself.
+func (r *Runtime) Decode(v Value, dst interface{}) error {
+	p := reflect.ValueOf(dst)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return fmt.Errorf("decode: destination must be a non-nil pointer, got %T", dst)
+	}
+	d := decoder{r: r, visiting: map[*Table]bool{}}
+	return d.decode(v, p.Elem())
+}
+
+var (
+	valueType           = reflect.TypeOf(Value{})
+	timeType            = reflect.TypeOf(time.Time{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+//
+// Encoding
+//
+
+type encoder struct {
+	r        *Runtime
+	visiting map[encodeRef]bool // Pointers, maps and slices being encoded
+}
+
+// Identifies a Go value which may refer to itself.  The type is needed because
+// e.g. a struct and its first field have the same address.  The length of
+// slices is included so that different slices of the same array are not
+// confused.
+type encodeRef struct {
+	ptr uintptr
+	tp  reflect.Type
+	len int
+}
+
+func (e *encoder) encode(v reflect.Value) (Value, error) {
+	if !v.IsValid() {
+		return NilValue, nil
+	}
+	tp := v.Type()
+	switch tp.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return NilValue, nil
+		}
+	}
+	if c := classOf(tp); c != nil {
+		return UserDataValue(c.newUserData(e.r, v.Interface())), nil
+	}
+	switch x := v.Interface().(type) {
+	case Value:
+		return x, nil
+	case *Table:
+		return TableValue(x), nil
+	case *UserData:
+		return UserDataValue(x), nil
+	case Callable:
+		return FunctionValue(x), nil
+	case encoding.TextMarshaler:
+		return e.encodeText(x)
+	}
+	if v.CanAddr() && reflect.PtrTo(tp).Implements(textMarshalerType) {
+		return e.encodeText(v.Addr().Interface().(encoding.TextMarshaler))
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		return BoolValue(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return IntValue(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt64 {
+			return NilValue, fmt.Errorf("encode: %d does not fit in an integer", n)
+		}
+		return IntValue(int64(n)), nil
+	case reflect.Float32, reflect.Float64:
+		return FloatValue(v.Float()), nil
+	case reflect.String:
+		return e.encodeString(v.String()), nil
+	case reflect.Ptr:
+		return e.encodeRef(v, 0, func() (Value, error) {
+			return e.encode(v.Elem())
+		})
+	case reflect.Interface:
+		return e.encode(v.Elem())
+	case reflect.Slice:
+		if tp.Elem().Kind() == reflect.Uint8 {
+			return e.encodeString(string(v.Bytes())), nil
+		}
+		return e.encodeRef(v, v.Len(), func() (Value, error) {
+			return e.encodeSeq(v)
+		})
+	case reflect.Array:
+		return e.encodeSeq(v)
+	case reflect.Map:
+		return e.encodeRef(v, 0, func() (Value, error) {
+			return e.encodeMap(v)
+		})
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return NilValue, fmt.Errorf("encode: cannot encode value of type %s", tp)
+	}
+}
+
+// Encodes v with the function enc, failing if v is already being encoded.
+func (e *encoder) encodeRef(v reflect.Value, n int, enc func() (Value, error)) (Value, error) {
+	ref := encodeRef{ptr: v.Pointer(), tp: v.Type(), len: n}
+	if e.visiting[ref] {
+		return NilValue, fmt.Errorf("encode: cycle detected at value of type %s", ref.tp)
+	}
+	e.visiting[ref] = true
+	defer delete(e.visiting, ref)
+	return enc()
+}
+
+func (e *encoder) encodeString(s string) Value {
+	e.r.RequireBytes(len(s))
+	return StringValue(s)
+}
+
+func (e *encoder) encodeText(m encoding.TextMarshaler) (Value, error) {
+	b, err := m.MarshalText()
+	if err != nil {
+		return NilValue, fmt.Errorf("encode: %s", err)
+	}
+	return e.encodeString(string(b)), nil
+}
+
+func (e *encoder) newTable() *Table {
+	e.r.RequireSize(unsafe.Sizeof(Table{}))
+	return NewTable()
+}
+
+func (e *encoder) encodeSeq(v reflect.Value) (Value, error) {
+	tbl := e.newTable()
+	for i := 0; i < v.Len(); i++ {
+		item, err := e.encode(v.Index(i))
+		if err != nil {
+			return NilValue, err
+		}
+		e.r.SetTable(tbl, IntValue(int64(i+1)), item)
+	}
+	return TableValue(tbl), nil
+}
+
+func (e *encoder) encodeMap(v reflect.Value) (Value, error) {
+	tbl := e.newTable()
+	iter := v.MapRange()
+	for iter.Next() {
+		k, err := e.encode(iter.Key())
+		if err != nil {
+			return NilValue, err
+		}
+		item, err := e.encode(iter.Value())
+		if err != nil {
+			return NilValue, err
+		}
+		if err := e.r.SetTableCheck(tbl, k, item); err != nil {
+			return NilValue, fmt.Errorf("encode: %s", err)
+		}
+	}
+	return TableValue(tbl), nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) (Value, error) {
+	tbl := e.newTable()
+	for _, f := range luaFieldsOf(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && fv.IsZero() {
+			continue
+		}
+		item, err := e.encode(fv)
+		if err != nil {
+			return NilValue, err
+		}
+		e.r.SetTable(tbl, f.key, item)
+	}
+	return TableValue(tbl), nil
+}
+
+//
+// Decoding
+//
+
+type decoder struct {
+	r        *Runtime
+	visiting map[*Table]bool // Tables being decoded
+}
+
+// Decodes v into dst, which must be settable.
+func (d *decoder) decode(v Value, dst reflect.Value) error {
+	tp := dst.Type()
+	if tp == valueType {
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if v.IsNil() {
+		return nil
+	}
+	if u, ok := v.TryUserData(); ok {
+		if x := reflect.ValueOf(u.Value()); x.IsValid() && x.Type().AssignableTo(tp) {
+			dst.Set(x)
+			return nil
+		}
+	}
+	if tp == timeType {
+		if n, ok := v.TryInt(); ok {
+			dst.Set(reflect.ValueOf(time.Unix(n, 0)))
+			return nil
+		}
+		if f, ok := v.TryFloat(); ok {
+			sec, frac := math.Modf(f)
+			dst.Set(reflect.ValueOf(time.Unix(int64(sec), int64(frac*1e9))))
+			return nil
+		}
+	}
+	if tp.Kind() != reflect.Ptr && reflect.PtrTo(tp).Implements(textUnmarshalerType) {
+		s, ok := v.TryString()
+		if !ok {
+			return d.typeError(v, tp)
+		}
+		if err := dst.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("decode: %s", err)
+		}
+		return nil
+	}
+	switch tp.Kind() {
+	case reflect.Bool:
+		b, ok := v.TryBool()
+		if !ok {
+			return d.typeError(v, tp)
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := ToInt(v)
+		if !ok {
+			return d.typeError(v, tp)
+		}
+		if dst.OverflowInt(n) {
+			return fmt.Errorf("decode: %d overflows %s", n, tp)
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := ToInt(v)
+		if !ok {
+			return d.typeError(v, tp)
+		}
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return fmt.Errorf("decode: %d overflows %s", n, tp)
+		}
+		dst.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		f, ok := ToFloat(v)
+		if !ok {
+			return d.typeError(v, tp)
+		}
+		dst.SetFloat(f)
+	case reflect.String:
+		s, ok := v.TryString()
+		if !ok {
+			return d.typeError(v, tp)
+		}
+		dst.SetString(s)
+	case reflect.Ptr:
+		if dst.IsNil() {
+			d.r.RequireSize(tp.Elem().Size())
+			dst.Set(reflect.New(tp.Elem()))
+		}
+		return d.decode(v, dst.Elem())
+	case reflect.Interface:
+		if tp.NumMethod() != 0 {
+			return d.typeError(v, tp)
+		}
+		x, err := d.decodeInterface(v)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			dst.Set(reflect.Zero(tp))
+		} else {
+			dst.Set(reflect.ValueOf(x))
+		}
+	case reflect.Slice:
+		if s, ok := v.TryString(); ok && tp.Elem().Kind() == reflect.Uint8 {
+			d.r.RequireBytes(len(s))
+			dst.SetBytes([]byte(s))
+			return nil
+		}
+		return d.decodeTable(v, tp, func(tbl *Table) error {
+			n := int(tbl.Len())
+			d.r.RequireArrSize(tp.Elem().Size(), n)
+			s := reflect.MakeSlice(tp, n, n)
+			if err := d.decodeSeq(tbl, s); err != nil {
+				return err
+			}
+			dst.Set(s)
+			return nil
+		})
+	case reflect.Array:
+		return d.decodeTable(v, tp, func(tbl *Table) error {
+			if n := int(tbl.Len()); n > dst.Len() {
+				return fmt.Errorf("decode: sequence of length %d too long for %s", n, tp)
+			}
+			return d.decodeSeq(tbl, dst)
+		})
+	case reflect.Map:
+		return d.decodeTable(v, tp, func(tbl *Table) error {
+			if dst.IsNil() {
+				dst.Set(reflect.MakeMap(tp))
+			}
+			return d.decodeMap(tbl, dst)
+		})
+	case reflect.Struct:
+		return d.decodeTable(v, tp, func(tbl *Table) error {
+			return d.decodeStruct(tbl, dst)
+		})
+	default:
+		return fmt.Errorf("decode: cannot decode to value of type %s", tp)
+	}
+	return nil
+}
+
+func (d *decoder) typeError(v Value, tp reflect.Type) error {
+	return fmt.Errorf("decode: cannot decode %s to value of type %s", v.TypeName(), tp)
+}
+
+// Decodes the table v with the function dec, failing if v is not a table or is
+// already being decoded.
+func (d *decoder) decodeTable(v Value, tp reflect.Type, dec func(*Table) error) error {
+	tbl, ok := v.TryTable()
+	if !ok {
+		return d.typeError(v, tp)
+	}
+	if d.visiting[tbl] {
+		return errors.New("decode: cycle detected")
+	}
+	d.visiting[tbl] = true
+	defer delete(d.visiting, tbl)
+	return dec(tbl)
+}
+
+func (d *decoder) decodeSeq(tbl *Table, dst reflect.Value) error {
+	n := int(tbl.Len())
+	for i := 0; i < n; i++ {
+		d.r.RequireCPU(1)
+		if err := d.decode(tbl.Get(IntValue(int64(i+1))), dst.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeMap(tbl *Table, dst reflect.Value) error {
+	tp := dst.Type()
+	var k, v Value
+	var ok bool
+	for {
+		k, v, ok = tbl.Next(k)
+		if !ok || k.IsNil() {
+			return nil
+		}
+		d.r.RequireCPU(1)
+		d.r.RequireSize(tp.Key().Size() + tp.Elem().Size())
+		key := reflect.New(tp.Key()).Elem()
+		if err := d.decode(k, key); err != nil {
+			return err
+		}
+		item := reflect.New(tp.Elem()).Elem()
+		if err := d.decode(v, item); err != nil {
+			return err
+		}
+		dst.SetMapIndex(key, item)
+	}
+}
+
+func (d *decoder) decodeStruct(tbl *Table, dst reflect.Value) error {
+	for _, f := range luaFieldsOf(dst.Type()) {
+		d.r.RequireCPU(1)
+		v := tbl.Get(f.key)
+		if v.IsNil() {
+			continue
+		}
+		if err := d.decode(v, dst.FieldByIndex(f.index)); err != nil {
+			return fmt.Errorf("%s (field %q)", err, f.name)
+		}
+	}
+	return nil
+}
+
+// Returns the Go value v decodes to when the destination is an empty
+// interface.
+func (d *decoder) decodeInterface(v Value) (interface{}, error) {
+	tbl, ok := v.TryTable()
+	if !ok {
+		return v.Interface(), nil
+	}
+	if d.visiting[tbl] {
+		return nil, errors.New("decode: cycle detected")
+	}
+	d.visiting[tbl] = true
+	defer delete(d.visiting, tbl)
+
+	// Find out what kind of table it is.
+	var count int
+	allStrings := true
+	var k Value
+	for {
+		k, _, ok = tbl.Next(k)
+		if !ok || k.IsNil() {
+			break
+		}
+		d.r.RequireCPU(1)
+		count++
+		if _, ok := k.TryString(); !ok {
+			allStrings = false
+		}
+	}
+	n := int(tbl.Len())
+	switch {
+	case n > 0 && n == count:
+		d.r.RequireArrSize(unsafe.Sizeof(interface{}(nil)), n)
+		s := make([]interface{}, n)
+		for i := range s {
+			x, err := d.decodeInterface(tbl.Get(IntValue(int64(i + 1))))
+			if err != nil {
+				return nil, err
+			}
+			s[i] = x
+		}
+		return s, nil
+	case allStrings:
+		m := make(map[string]interface{}, count)
+		err := d.decodeEntries(tbl, func(k Value, x interface{}) {
+			m[k.AsString()] = x
+		})
+		return m, err
+	default:
+		m := make(map[interface{}]interface{}, count)
+		err := d.decodeEntries(tbl, func(k Value, x interface{}) {
+			m[k.Interface()] = x
+		})
+		return m, err
+	}
+}
+
+// Calls add(k, x) for each key k in tbl, x being the decoded value for k.
+func (d *decoder) decodeEntries(tbl *Table, add func(Value, interface{})) error {
+	var k, v Value
+	var ok bool
+	for {
+		k, v, ok = tbl.Next(k)
+		if !ok || k.IsNil() {
+			return nil
+		}
+		d.r.RequireCPU(1)
+		d.r.RequireSize(2 * unsafe.Sizeof(interface{}(nil)))
+		x, err := d.decodeInterface(v)
+		if err != nil {
+			return err
+		}
+		add(k, x)
+	}
+}
+
+//
+// Struct fields
+//
+
+// A luaField describes a field of a struct as it is encoded.
+type luaField struct {
+	name      string // Name of the field in Lua
+	key       Value  // The name as a Value
+	index     []int  // Index sequence for reflect.Value.FieldByIndex
+	omitEmpty bool
+}
+
+var luaFieldsCache sync.Map // Maps reflect.Type to []luaField
+
+// Returns the fields of the struct type tp, as determined by "lua" tags.
+func luaFieldsOf(tp reflect.Type) []luaField {
+	if fields, ok := luaFieldsCache.Load(tp); ok {
+		return fields.([]luaField)
+	}
+	var fields []luaField
+	seen := map[string]bool{}
+	addLuaFields(tp, nil, seen, &fields)
+	luaFieldsCache.Store(tp, fields)
+	return fields
+}
+
+// Appends the fields of tp to fields, in order, except those whose name is in
+// seen.  Fields of embedded structs come after all the other fields so that
+// the latter take precedence.
+func addLuaFields(tp reflect.Type, index []int, seen map[string]bool, fields *[]luaField) {
+	var embedded []reflect.StructField
+	for i := 0; i < tp.NumField(); i++ {
+		f := tp.Field(i)
+		tag := f.Tag.Get("lua")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			embedded = append(embedded, f)
+			continue
+		}
+		if f.PkgPath != "" {
+			// Unexported field
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		*fields = append(*fields, luaField{
+			name:      name,
+			key:       StringValue(name),
+			index:     append(append([]int(nil), index...), i),
+			omitEmpty: opts == "omitempty",
+		})
+	}
+	for _, f := range embedded {
+		addLuaFields(f.Type, append(append([]int(nil), index...), f.Index...), seen, fields)
+	}
+}
@@ -0,0 +1,236 @@
+package runtime_test
+
+import (
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/runtime"
+)
+
+type encodeAddress struct {
+	City string `lua:"city"`
+	Zip  string `lua:"zip,omitempty"`
+}
+
+type encodeMeta struct {
+	Version int `lua:"version"`
+	Owner   string
+}
+
+type encodeRequest struct {
+	encodeMeta
+	ID       int64              `lua:"id"`
+	Name     string             `lua:"name"`
+	Tags     []string           `lua:"tags"`
+	Scores   map[string]float64 `lua:"scores,omitempty"`
+	Address  *encodeAddress     `lua:"address"`
+	Created  time.Time          `lua:"created"`
+	IP       net.IP             `lua:"ip"`
+	Data     []byte             `lua:"data"`
+	Extra    interface{}        `lua:"extra"`
+	Secret   string             `lua:"-"`
+	internal int
+}
+
+// Runs a Lua function taking one argument and returning one value.
+func runLuaFunc(t *testing.T, r *runtime.Runtime, src string, arg runtime.Value) runtime.Value {
+	t.Helper()
+	clos, err := r.CompileAndLoadLuaChunk("test", []byte("return "+src), runtime.TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := runtime.Call1(r.MainThread(), runtime.FunctionValue(clos))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := runtime.Call1(r.MainThread(), f, arg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestEncodeDecode(t *testing.T) {
+	r := runtime.New(os.Stdout)
+	lib.LoadAll(r)
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	req := encodeRequest{
+		encodeMeta: encodeMeta{Version: 2, Owner: "bob"},
+		ID:         42,
+		Name:       "hello",
+		Tags:       []string{"a", "b"},
+		Address:    &encodeAddress{City: "Paris"},
+		Created:    created,
+		IP:         net.IPv4(10, 0, 0, 1),
+		Data:       []byte("bytes"),
+		Extra:      map[string]interface{}{"n": 1},
+		Secret:     "xxx",
+	}
+	v, err := r.Encode(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := runLuaFunc(t, r, `function(req)
+		local keys = {}
+		for k in pairs(req) do keys[#keys + 1] = k end
+		table.sort(keys)
+		return table.concat(keys, ",") .. "|" .. req.id .. "," .. req.name .. "," ..
+			table.concat(req.tags, ":") .. "," .. req.address.city .. "," ..
+			tostring(req.address.zip) .. "," .. req.created .. "," .. req.ip .. "," ..
+			req.data .. "," .. req.extra.n .. "," .. req.version .. "," .. req.Owner
+	end`, v)
+	expected := "Owner,address,created,data,extra,id,ip,name,tags,version|42,hello,a:b,Paris,nil,2021-03-04T05:06:07Z,10.0.0.1,bytes,1,2,bob"
+	if s, _ := desc.TryString(); s != expected {
+		t.Errorf("got %q, expected %q", s, expected)
+	}
+
+	// Modify the table in Lua and decode it back.
+	v = runLuaFunc(t, r, `function(req)
+		req.name = "bye"
+		req.tags[3] = "c"
+		req.scores = {x = 1.5, y = 2}
+		req.address.zip = "75001"
+		req.created = 0
+		req.ip = "192.168.0.1"
+		req.version = 3
+		req.extra = {1, 2, {a = "b"}}
+		return req
+	end`, v)
+	var got encodeRequest
+	if err := r.Decode(v, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := req
+	want.Secret = ""
+	want.Name = "bye"
+	want.Tags = []string{"a", "b", "c"}
+	want.Scores = map[string]float64{"x": 1.5, "y": 2}
+	want.Address = &encodeAddress{City: "Paris", Zip: "75001"}
+	want.Created = time.Unix(0, 0)
+	want.IP = net.ParseIP("192.168.0.1")
+	want.Version = 3
+	want.Extra = []interface{}{int64(1), int64(2), map[string]interface{}{"a": "b"}}
+	if !got.Created.Equal(want.Created) {
+		t.Errorf("got time %s, expected %s", got.Created, want.Created)
+	}
+	got.Created = want.Created
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+}
+
+func TestEncodeValues(t *testing.T) {
+	r := runtime.New(nil)
+	tbl := runtime.NewTable()
+	tests := []struct {
+		in   interface{}
+		want runtime.Value
+	}{
+		{nil, runtime.NilValue},
+		{true, runtime.BoolValue(true)},
+		{int8(-3), runtime.IntValue(-3)},
+		{uint32(7), runtime.IntValue(7)},
+		{float32(1.5), runtime.FloatValue(1.5)},
+		{"s", runtime.StringValue("s")},
+		{(*int)(nil), runtime.NilValue},
+		{[]int(nil), runtime.NilValue},
+		{tbl, runtime.TableValue(tbl)},
+		{runtime.IntValue(5), runtime.IntValue(5)},
+	}
+	for _, test := range tests {
+		v, err := r.Encode(test.in)
+		if err != nil {
+			t.Errorf("%#v: %s", test.in, err)
+		} else if v != test.want {
+			t.Errorf("%#v: got %v, expected %v", test.in, v, test.want)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	r := runtime.New(nil)
+	type node struct {
+		Next *node
+	}
+	n := &node{}
+	n.Next = n
+	m := map[string]interface{}{}
+	m["m"] = m
+	tests := []struct {
+		in  interface{}
+		err string
+	}{
+		{n, "cycle detected"},
+		{m, "cycle detected"},
+		{uint64(math.MaxUint64), "does not fit"},
+		{make(chan int), "cannot encode"},
+		{map[float64]int{math.NaN(): 1}, "index is NaN"},
+	}
+	for _, test := range tests {
+		_, err := r.Encode(test.in)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: got error %v, expected %q", test.in, err, test.err)
+		}
+	}
+
+	// Values shared without a cycle are fine.
+	shared := &node{}
+	if _, err := r.Encode([]*node{shared, shared}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	r := runtime.New(nil)
+	cyclic := runtime.NewTable()
+	cyclic.Set(runtime.StringValue("self"), runtime.TableValue(cyclic))
+	var i interface{}
+	var n int8
+	var u uint
+	var s string
+	var p struct {
+		Self interface{} `lua:"self"`
+	}
+	tests := []struct {
+		in  runtime.Value
+		dst interface{}
+		err string
+	}{
+		{runtime.IntValue(1), n, "non-nil pointer"},
+		{runtime.TableValue(cyclic), &i, "cycle detected"},
+		{runtime.TableValue(cyclic), &p, "cycle detected"},
+		{runtime.IntValue(1000), &n, "overflows"},
+		{runtime.IntValue(-1), &u, "overflows"},
+		{runtime.BoolValue(true), &s, "cannot decode boolean"},
+		{runtime.FloatValue(1.5), &n, "cannot decode number"},
+	}
+	for _, test := range tests {
+		err := r.Decode(test.in, test.dst)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: got error %v, expected %q", test.dst, err, test.err)
+		}
+	}
+}
+
+func TestEncodeMemoryAccounted(t *testing.T) {
+	if !runtime.QuotasAvailable {
+		t.Skip("quotas not available")
+	}
+	r := runtime.New(nil)
+	th := r.MainThread()
+	ctx, _ := th.CallContext(runtime.RuntimeContextDef{
+		HardLimits: runtime.RuntimeResources{Memory: 100000},
+	}, func() error {
+		_, err := r.Encode(make([]string, 100000))
+		return err
+	})
+	if ctx.Status() != runtime.StatusKilled {
+		t.Errorf("expected memory limit to be exceeded")
+	}
+}
@@ -0,0 +1,276 @@
+package runtime
+
+import (
+	goruntime "runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+//
+// Support for the __gc metamethod (Lua 5.4 finalizers).
+//
+// Golua does not have its own garbage collector, it relies on the Go one.  So
+// in order to know when a Lua value becomes unreachable, it is given a Go
+// finalizer when it is marked for finalization (i.e. when it is given a
+// metatable with a "__gc" field).  When the value becomes unreachable, the Go
+// finalizer puts it in a "pending" queue (which resurrects it) and the Lua
+// finalizer is run at a later safe point in the runtime's main thread (or when
+// collectgarbage() is called).
+//
+// The Go garbage collector never collects a value with a finalizer which is
+// part of a reference cycle (e.g. a table which contains a reference to
+// itself), so the Lua finalizers of such values only run when the runtime is
+// closed.  Values which refer to a cycle without being part of it (e.g. an
+// instance of a class whose metatable is its own __index) are not affected.
+//
+
+// A gcPool keeps track of Lua values which are marked for finalization.  It
+// does not keep references to the values until they become unreachable, so it
+// doesn't prevent them from being garbage collected.
+type gcPool struct {
+	mx         sync.Mutex
+	markOrder  uint64
+	marked     map[*gcMark]struct{} // Values marked for finalization
+	pending    []gcPending          // Unreachable values waiting for finalization
+	hasPending int32                // Set atomically to 1 when pending is not empty
+	running    bool                 // True when finalizers are being run
+}
+
+// A gcMark records that a value is marked for finalization.
+type gcMark struct {
+	ref   Value   // Weak key for the value (see makeWeakKey)
+	order uint64  // Rank of the value in the marking order of its pool
+	pool  *gcPool // Set to nil when the value is removed from the pool (protected by the gcMarks mutex)
+}
+
+// Registry of the values marked for finalization, by weak key.  As a Go
+// value can only have one finalizer, it is shared by all runtimes so that a
+// value cannot be marked in two of them.
+var gcMarks = struct {
+	sync.Mutex
+	marks map[Value]*gcMark
+}{marks: map[Value]*gcMark{}}
+
+// A gcPending is a value which became unreachable and whose Lua finalizer
+// should be run.
+type gcPending struct {
+	v     Value
+	order uint64
+}
+
+// Mark v for finalization, if it is not already marked.  v must be a table or a
+// userdata.
+func (p *gcPool) mark(v Value) bool {
+	ref, ok := makeWeakKey(v)
+	if !ok {
+		return false
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	gcMarks.Lock()
+	defer gcMarks.Unlock()
+	if gcMarks.marks[ref] != nil {
+		return false
+	}
+	if p.marked == nil {
+		p.marked = make(map[*gcMark]struct{})
+	}
+	p.markOrder++
+	m := &gcMark{ref: ref, order: p.markOrder, pool: p}
+	gcMarks.marks[ref] = m
+	p.marked[m] = struct{}{}
+	switch x := v.iface.(type) {
+	case *Table:
+		goruntime.SetFinalizer(x, func(x *Table) { m.unreachable(TableValue(x)) })
+	case *UserData:
+		goruntime.SetFinalizer(x, func(x *UserData) { m.unreachable(UserDataValue(x)) })
+	}
+	return true
+}
+
+// This is called by the Go finalizer of a value marked for finalization.  It
+// runs in the goroutine dedicated to Go finalizers.  The value is resurrected by
+// being queued in its pool.
+func (m *gcMark) unreachable(v Value) {
+	gcMarks.Lock()
+	pool := m.pool
+	m.pool = nil
+	if gcMarks.marks[m.ref] == m {
+		delete(gcMarks.marks, m.ref)
+	}
+	gcMarks.Unlock()
+	if pool != nil {
+		pool.addPending(m, v)
+	}
+}
+
+// This is called when a value marked for finalization has become unreachable.
+func (p *gcPool) addPending(m *gcMark, v Value) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	delete(p.marked, m)
+	p.pending = append(p.pending, gcPending{v: v, order: m.order})
+	atomic.StoreInt32(&p.hasPending, 1)
+}
+
+// Returns true if there may be pending finalizers to run.
+func (p *gcPool) mayHavePending() bool {
+	return atomic.LoadInt32(&p.hasPending) != 0
+}
+
+// Removes the values pending finalization from the pool and returns them,
+// latest marked first.  If all is true, then all values marked for
+// finalization are also removed and returned (this is for when the runtime is
+// closed).
+func (p *gcPool) extractPending(all bool) []gcPending {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	pending := p.pending
+	p.pending = nil
+	atomic.StoreInt32(&p.hasPending, 0)
+	if all {
+		gcMarks.Lock()
+		for m := range p.marked {
+			delete(p.marked, m)
+			v := resolveWeakRef(m.ref)
+			if v.IsNil() || m.pool != p {
+				// The value has become unreachable, its Go finalizer will add
+				// it to the pending queue.
+				continue
+			}
+			m.pool = nil
+			delete(gcMarks.marks, m.ref)
+			clearGoFinalizer(v)
+			pending = append(pending, gcPending{v: v, order: m.order})
+		}
+		gcMarks.Unlock()
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].order > pending[j].order
+	})
+	return pending
+}
+
+// MarkForFinalization marks v so that its "__gc" metamethod is called when it
+// becomes unreachable or when the runtime is closed.  This is called by
+// SetRawMetatable when the metatable has a "__gc" field, so there is usually no
+// need to call it directly.  It has no effect if v is not a table or a
+// userdata, or if it has already been marked.
+func (r *Runtime) MarkForFinalization(v Value) {
+	if tp := v.Type(); tp != TableType && tp != UserDataType {
+		return
+	}
+	if r.gcPool.mark(v) {
+		r.RequireSize(unsafe.Sizeof(gcMark{}))
+	}
+}
+
+// RunPendingFinalizers runs the "__gc" metamethods of the values marked for
+// finalization which have been found to be unreachable by the Go garbage
+// collector, in the reverse order in which they were marked.  Finalizers run in
+// the current runtime context, so they are subject to its resource limits.
+// Errors in finalizers are emitted as warnings.
+func (t *Thread) RunPendingFinalizers() {
+	if t.gcPool.running || !t.gcPool.mayHavePending() {
+		return
+	}
+	t.runFinalizers(t.gcPool.extractPending(false))
+}
+
+// CollectGarbage runs the Go garbage collector, then runs the Lua finalizers of
+// the values that were found to be unreachable.
+func (t *Thread) CollectGarbage() {
+	runGoFinalizers()
+	t.RunPendingFinalizers()
+}
+
+// Close runs the finalizers of all the values still marked for finalization,
+// whether they are reachable or not (this is the equivalent of lua_close() in
+// the C API).  Finalizers marked while closing are run as well.  If the
+// runtime context is terminated while running finalizers, Close stops and
+// emits a warning.
+func (r *Runtime) Close(t *Thread) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			termErr, ok := rec.(ContextTerminationError)
+			if !ok {
+				panic(rec)
+			}
+			r.Warn("error in __gc metamethod (", termErr.Error(), ")")
+		}
+	}()
+	for {
+		pending := r.gcPool.extractPending(true)
+		if len(pending) == 0 {
+			return
+		}
+		t.runFinalizers(pending)
+	}
+}
+
+func (t *Thread) runFinalizers(pending []gcPending) {
+	t.gcPool.running = true
+	defer func() { t.gcPool.running = false }()
+	for _, p := range pending {
+		resurrectWeakHandle(p.v)
+		gc := t.metaGetS(p.v, "__gc")
+		if gc.IsNil() {
+			continue
+		}
+		term := NewTerminationWith(t.CurrentCont(), 0, false)
+		if err := Call(t, gc, []Value{p.v}, term); err != nil {
+			msg, ok := ErrorValue(err).ToString()
+			if !ok {
+				msg = "error object is not a string"
+			}
+			t.Warn("error in __gc metamethod (", msg, ")")
+		}
+	}
+}
+
+// This is a value with a Go finalizer which is used to detect that Go
+// finalizers have been run after a GC cycle.
+type gcSentinel struct {
+	done chan struct{}
+}
+
+// Maximum time to wait for Go finalizers to run after a GC cycle.
+const goFinalizersTimeout = time.Second
+
+// Runs the Go garbage collector and waits for the finalizers of the values
+// found unreachable to be run.  Go runs finalizers in sequence in a dedicated
+// goroutine, one GC cycle at a time.  So when the finalizer of a sentinel
+// created in a second GC cycle has run, it is known that the finalizers for the
+// first cycle have all been run.
+func runGoFinalizers() {
+	for i := 0; i < 2; i++ {
+		done := newGcSentinel()
+		goruntime.GC()
+		select {
+		case <-done:
+		case <-time.After(goFinalizersTimeout):
+			return
+		}
+	}
+}
+
+// Returns a channel which is closed when the Go finalizer of a new unreachable
+// sentinel value is run.
+func newGcSentinel() <-chan struct{} {
+	s := &gcSentinel{done: make(chan struct{})}
+	goruntime.SetFinalizer(s, func(s *gcSentinel) { close(s.done) })
+	return s.done
+}
+
+// Removes the Go finalizer of a value marked for finalization.
+func clearGoFinalizer(v Value) {
+	switch x := v.iface.(type) {
+	case *Table:
+		goruntime.SetFinalizer(x, nil)
+	case *UserData:
+		goruntime.SetFinalizer(x, nil)
+	}
+}
@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRuntime_Close(t *testing.T) {
+	r := New(nil)
+	var finalized []string
+	gc := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		name, _ := c.Arg(0).AsTable().Get(StringValue("name")).TryString()
+		finalized = append(finalized, name)
+		return c.Next(), nil
+	}, "gc", 1, false)
+	meta := NewTable()
+	meta.Set(StringValue("__gc"), FunctionValue(gc))
+
+	var kept []Value
+	for _, name := range []string{"a", "b", "c"} {
+		v := NewTable()
+		v.Set(StringValue("name"), StringValue(name))
+		r.SetRawMetatable(TableValue(v), meta)
+		kept = append(kept, TableValue(v))
+	}
+	r.Close(r.MainThread())
+	if want := []string{"c", "b", "a"}; !reflect.DeepEqual(finalized, want) {
+		t.Errorf("Close() finalized %v, want %v", finalized, want)
+	}
+
+	// Values are only finalized once
+	finalized = nil
+	r.Close(r.MainThread())
+	if len(finalized) != 0 {
+		t.Errorf("Close() finalized %v again", finalized)
+	}
+	_ = kept
+}
+
+func TestRuntime_CloseError(t *testing.T) {
+	out := new(bytes.Buffer)
+	r := New(nil)
+	r.SetWarner(NewLogWarner(out, ""))
+	r.Warn("@on")
+	gc := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		return nil, NewError(StringValue("boom"))
+	}, "gc", 1, false)
+	meta := NewTable()
+	meta.Set(StringValue("__gc"), FunctionValue(gc))
+	r.SetRawMetatable(UserDataValue(NewUserData(nil, nil)), meta)
+	r.Close(r.MainThread())
+	if got, want := out.String(), "error in __gc metamethod (boom)\n"; got != want {
+		t.Errorf("Close() warned %q, want %q", got, want)
+	}
+}
+
+// Values which are part of a reference cycle are only finalized when the
+// runtime is closed, but values which merely refer to a cycle are finalized
+// when they become unreachable.
+func TestRuntime_FinalizeCycles(t *testing.T) {
+	r := New(nil)
+	var finalized []string
+	gc := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		name, _ := c.Arg(0).AsTable().Get(StringValue("name")).TryString()
+		finalized = append(finalized, name)
+		return c.Next(), nil
+	}, "gc", 1, false)
+	class := NewTable()
+	class.Set(StringValue("__gc"), FunctionValue(gc))
+	class.Set(StringValue("__index"), TableValue(class))
+
+	func() {
+		instance := NewTable()
+		instance.Set(StringValue("name"), StringValue("instance"))
+		r.SetRawMetatable(TableValue(instance), class)
+
+		cycle := NewTable()
+		cycle.Set(StringValue("name"), StringValue("cycle"))
+		cycle.Set(StringValue("self"), TableValue(cycle))
+		r.SetRawMetatable(TableValue(cycle), class)
+	}()
+	r.MainThread().CollectGarbage()
+	if want := []string{"instance"}; !reflect.DeepEqual(finalized, want) {
+		t.Errorf("CollectGarbage() finalized %v, want %v", finalized, want)
+	}
+	finalized = nil
+	r.Close(r.MainThread())
+	if want := []string{"cycle"}; !reflect.DeepEqual(finalized, want) {
+		t.Errorf("Close() finalized %v, want %v", finalized, want)
+	}
+}
@@ -0,0 +1,97 @@
+//go:build !noquotas
+// +build !noquotas
+
+package runtime_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arnodel/golua/lib"
+	rt "github.com/arnodel/golua/runtime"
+)
+
+func loadTestFunc(t *testing.T, r *rt.Runtime, src string) rt.Value {
+	clos, err := r.MainThread().LoadFromSourceOrCode("test", []byte(src), "t", rt.TableValue(r.GlobalEnv()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.FunctionValue(clos)
+}
+
+func TestCallWithContext(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "loop",
+			src:  `while true do end`,
+		},
+		{
+			name: "loop in pcall",
+			src:  `print(pcall(function() while true do end end))`,
+		},
+		{
+			name: "loop in coroutine",
+			src:  `coroutine.wrap(function() while true do end end)()`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			r := rt.New(out)
+			defer lib.LoadAll(r)()
+			// The to-be-closed variable should be closed after cancellation.
+			f := loadTestFunc(t, r, `
+				local x <close> = setmetatable({}, {__close = function(_, e) print("closed", e) end})
+				`+test.src)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			err := rt.CallWithContext(ctx, r.MainThread(), f, nil, rt.NewTerminationWith(nil, 0, false))
+
+			var termErr rt.ContextTerminationError
+			if !errors.As(err, &termErr) || !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected termination by cancellation, got %v", err)
+			}
+			if got, want := out.String(), "closed\tinterrupted: context canceled\n"; got != want {
+				t.Errorf("got output %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCallWithContextDeadline(t *testing.T) {
+	r := rt.New(nil)
+	defer lib.LoadAll(r)()
+	f := loadTestFunc(t, r, `while true do end`)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := rt.CallWithContext(ctx, r.MainThread(), f, nil, rt.NewTerminationWith(nil, 0, false))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// The runtime can still be used after that.
+	f = loadTestFunc(t, r, `return 42`)
+	res, err := rt.Call1(r.MainThread(), f)
+	if err != nil || res != rt.IntValue(42) {
+		t.Fatalf("got %v, %v", res, err)
+	}
+}
+
+func TestCallWithContextDone(t *testing.T) {
+	r := rt.New(nil)
+	defer lib.LoadAll(r)()
+	f := loadTestFunc(t, r, `return 1`)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := rt.CallWithContext(ctx, r.MainThread(), f, nil, rt.NewTerminationWith(nil, 0, false))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
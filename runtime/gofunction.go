@@ -23,6 +23,11 @@ func NewGoFunction(f GoFunctionFunc, name string, nArgs int, hasEtc bool) *GoFun
 	}
 }
 
+// Name returns the name of the function, as used in error messages.
+func (f *GoFunction) Name() string {
+	return f.name
+}
+
 // Continuation implements Callable.Continuation.
 func (f *GoFunction) Continuation(t *Thread, next Cont) Cont {
 	return NewGoCont(t, f, next)
@@ -0,0 +1,149 @@
+//go:build !noinlinecache
+// +build !noinlinecache
+
+package runtime
+
+// Inline caches speed up accessing table fields by name, i.e. Lua code such as
+// obj.field, obj.field = val or obj:method().  Each instruction indexing a
+// table gets its own cache, which remembers in which hash table slot the last
+// string key it looked up was found, in the table itself and in each table of
+// its '__index' chain (typically a class table and its base classes).  Next
+// time a string key is looked up, the remembered slots are tried first, which
+// saves hashing the key and following collision chains.
+//
+// Caches never hold on to values or keys, only to slot numbers.  A remembered
+// slot is only used if it contains the key being looked up, which is checked
+// every time.  So when a table is mutated (fields added or removed, the table
+// rehashed, its metatable changed) or the instruction looks up another key,
+// the cache cannot give a wrong result: at worst a slot does not contain the
+// key and the lookup is done normally, updating the cache.
+//
+// The noinlinecache build tag disables inline caches, which is useful to
+// measure what they bring (see BenchmarkInlineCache).
+
+// Number of tables in an '__index' chain for which slots are cached.
+const indexCacheDepth = 4
+
+type indexCache struct {
+	slots     [indexCacheDepth]uint32 // Slots of key in the tables of the chain
+	metaSlots [indexCacheDepth]uint32 // Slots of "__index" in their metatables
+}
+
+// The caches for a function, one for each instruction.  They are created the
+// first time they are needed.
+type indexCaches []*indexCache
+
+var indexKey = StringValue("__index")
+
+// Returns the cache for the instruction at pc.  The memory used by caches is
+// not accounted for, as it is bounded by a small multiple of the size of the
+// code, which was accounted for when it was loaded.
+func (c *Code) indexCache(pc int16) *indexCache {
+	if c.indexCaches == nil {
+		c.indexCaches = make(indexCaches, len(c.code))
+	}
+	ic := c.indexCaches[pc]
+	if ic == nil {
+		ic = new(indexCache)
+		c.indexCaches[pc] = ic
+	}
+	return ic
+}
+
+// index is equivalent to Index(t, coll, k), using the inline cache of the
+// instruction at pc if k is a string.
+func (c *LuaCont) index(t *Thread, pc int16, coll Value, k Value) (Value, error) {
+	s, ok := k.iface.(string)
+	if !ok {
+		return Index(t, coll, k)
+	}
+	ic := c.indexCache(pc)
+	var slot, metaSlot *uint32
+	var unusedSlot, unusedMetaSlot uint32
+	for i := 0; i < maxIndexChainLength; i++ {
+		tbl, ok := coll.TryTable()
+		if !ok {
+			return indexFrom(t, coll, k, i)
+		}
+		if i < indexCacheDepth {
+			slot, metaSlot = &ic.slots[i], &ic.metaSlots[i]
+		} else {
+			slot, metaSlot = &unusedSlot, &unusedMetaSlot
+		}
+		t.RequireCPU(1)
+		if val := tbl.getCached(k, s, slot); !val.IsNil() {
+			return val, nil
+		}
+		metaIdx := tbl.meta.getCached(indexKey, "__index", metaSlot)
+		if metaIdx.IsNil() {
+			return NilValue, nil
+		}
+		if _, ok := metaIdx.TryTable(); !ok {
+			return callIndexMetamethod(t, metaIdx, coll, k)
+		}
+		coll = metaIdx
+	}
+	return indexFrom(t, coll, k, maxIndexChainLength)
+}
+
+// setIndex is equivalent to SetIndex(t, coll, k, val), using the inline cache
+// of the instruction at pc if k is a string.  Only updating an existing field
+// of coll is done via the cache, other cases are left to SetIndex.
+func (c *LuaCont) setIndex(t *Thread, pc int16, coll Value, k Value, val Value) error {
+	s, ok := k.iface.(string)
+	if !ok || val.IsNil() {
+		return SetIndex(t, coll, k, val)
+	}
+	tbl, ok := coll.TryTable()
+	if !ok || tbl.weakMode != 0 || tbl.hashTable == nil {
+		return SetIndex(t, coll, k, val)
+	}
+	ic := c.indexCache(pc)
+	it := tbl.hashTable.cachedSlot(k, s, &ic.slots[0])
+	if it == nil || it.value.IsNil() {
+		return SetIndex(t, coll, k, val)
+	}
+	t.RequireCPU(1)
+	it.value = val
+	return nil
+}
+
+// Returns t[k] for k holding the string s.  The slot where k is expected to be
+// is in *slot, and is updated if k is found in another slot.
+func (t *Table) getCached(k Value, s string, slot *uint32) Value {
+	if t == nil {
+		return NilValue
+	}
+	if t.weakMode != 0 {
+		return t.weakGet(k)
+	}
+	if it := t.hashTable.cachedSlot(k, s, slot); it != nil {
+		return it.value
+	}
+	return NilValue
+}
+
+// Returns the slot containing k, holding the string s, or nil if there is none.
+// The slot where k is expected to be is in *slot, and is updated if k is found
+// in another slot.
+func (t *hashTable) cachedSlot(k Value, s string, slot *uint32) *hashTableSlot {
+	if t == nil {
+		return nil
+	}
+	if i := *slot; int(i) < len(t.slots) {
+		if it := &t.slots[i]; it.key.scalar == k.scalar && isString(it.key, s) {
+			return it
+		}
+	}
+	it, i := findSlot(t.slots, (1<<t.base)-1, k)
+	if it != nil {
+		*slot = uint32(i)
+	}
+	return it
+}
+
+// Returns true if v holds the string s.  This is faster than comparing values.
+func isString(v Value, s string) bool {
+	vs, ok := v.iface.(string)
+	return ok && vs == s
+}
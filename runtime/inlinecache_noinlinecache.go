@@ -0,0 +1,18 @@
+//go:build noinlinecache
+// +build noinlinecache
+
+// This tag disables inline caches
+
+package runtime
+
+type indexCaches struct{}
+
+// Same as Index(t, coll, k).
+func (c *LuaCont) index(t *Thread, pc int16, coll Value, k Value) (Value, error) {
+	return Index(t, coll, k)
+}
+
+// Same as SetIndex(t, coll, k, val).
+func (c *LuaCont) setIndex(t *Thread, pc int16, coll Value, k Value, val Value) error {
+	return SetIndex(t, coll, k, val)
+}
@@ -0,0 +1,93 @@
+package runtime_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/runtime"
+)
+
+// Classes used by the inline cache benchmarks.  Base has enough methods that
+// its keys are hashed when looked up.
+const oopClasses = `
+local Base = {}
+Base.__index = Base
+for i = 1, 20 do
+	Base["method" .. i] = function(self) return i end
+end
+function Base:getX() return self.x end
+function Base:getY() return self.y end
+
+local Point = setmetatable({}, Base)
+Point.__index = Point
+function Point.new(x, y) return setmetatable({x = x, y = y}, Point) end
+function Point:norm1() return self.x + self.y end
+`
+
+var inlineCacheBenchmarks = []struct {
+	name string
+	src  string // Body of a function run at each iteration
+}{
+	{
+		name: "fields",
+		src: `
+local p = Point.new(1, 2)
+for i = 1, 1000 do
+	p.x = p.x + p.y
+end`,
+	},
+	{
+		name: "methods",
+		src: `
+local p = Point.new(1, 2)
+local n = 0
+for i = 1, 1000 do
+	n = n + p:norm1()
+end`,
+	},
+	{
+		name: "inheritedMethods",
+		src: `
+local p = Point.new(1, 2)
+local n = 0
+for i = 1, 1000 do
+	n = n + p:getX() + p:getY() + p:method10()
+end`,
+	},
+}
+
+// Run with and without the noinlinecache build tag to compare, e.g.
+//
+//	go test -run XXX -bench InlineCache -count 10 ./runtime > cached.txt
+//	go test -run XXX -bench InlineCache -count 10 -tags noinlinecache ./runtime > uncached.txt
+//	benchstat uncached.txt cached.txt
+//
+// Median times per iteration measured this way (-cpu 1, 10 runs):
+//
+//	                  noinlinecache   inline caches
+//	fields                  309µs     253µs  -18%
+//	methods                 658µs     487µs  -26%
+//	inheritedMethods       2295µs    1179µs  -49%
+func BenchmarkInlineCache(b *testing.B) {
+	for _, bench := range inlineCacheBenchmarks {
+		b.Run(bench.name, func(b *testing.B) {
+			r := runtime.New(nil)
+			lib.LoadAll(r)
+			src := oopClasses + "return function()" + bench.src + "\nend"
+			clos, err := r.CompileAndLoadLuaChunk(bench.name, []byte(src), runtime.TableValue(r.GlobalEnv()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f, err := runtime.Call1(r.MainThread(), runtime.FunctionValue(clos))
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := runtime.Call1(r.MainThread(), f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -30,7 +31,13 @@ const maxIndexChainLength = 100
 // '__index' metamethod if appropriate.
 // Index always consumes CPU.
 func Index(t *Thread, coll Value, k Value) (Value, error) {
-	for i := 0; i < maxIndexChainLength; i++ {
+	return indexFrom(t, coll, k, 0)
+}
+
+// indexFrom is like Index, but coll is the collection found after following i
+// '__index' metamethods.
+func indexFrom(t *Thread, coll Value, k Value, i int) (Value, error) {
+	for ; i < maxIndexChainLength; i++ {
 		t.RequireCPU(1)
 		tbl, ok := coll.TryTable()
 		if ok {
@@ -48,18 +55,23 @@ func Index(t *Thread, coll Value, k Value) (Value, error) {
 		if _, ok := metaIdx.TryTable(); ok {
 			coll = metaIdx
 		} else {
-			res := NewTerminationWith(t.CurrentCont(), 1, false)
-			if err := Call(t, metaIdx, []Value{coll, k}, res); err != nil {
-				return NilValue, err
-			}
-			return res.Get(0), nil
+			return callIndexMetamethod(t, metaIdx, coll, k)
 		}
 	}
 	return NilValue, fmt.Errorf("'__index' chain too long; possible loop")
 }
 
+// Calls the '__index' metamethod f of coll and returns its first result.
+func callIndexMetamethod(t *Thread, f Value, coll Value, k Value) (Value, error) {
+	res := NewTerminationWith(t.CurrentCont(), 1, false)
+	if err := Call(t, f, []Value{coll, k}, res); err != nil {
+		return NilValue, err
+	}
+	return res.Get(0), nil
+}
+
 func indexError(coll Value) error {
-	return fmt.Errorf("attempt to index a %s value", coll.CustomTypeName())
+	return newOperandError(0, "attempt to index a %s value", coll.CustomTypeName())
 }
 
 // SetIndex sets the item in a collection for the given key, using the
@@ -80,10 +92,11 @@ func SetIndex(t *Thread, coll Value, idx Value, val Value) error {
 		}
 		metaNewIndex := t.metaGetS(coll, "__newindex")
 		if metaNewIndex.IsNil() {
-			if isTable {
-				// No need to call SetTableCheck
-				t.SetTable(tbl, idx, val)
+			if !isTable {
+				return indexError(coll)
 			}
+			// No need to call SetTableCheck
+			t.SetTable(tbl, idx, val)
 			return nil
 		}
 		if _, ok := metaNewIndex.TryTable(); ok {
@@ -123,7 +136,7 @@ func Continue(t *Thread, f Value, next Cont) (Cont, error) {
 	}
 	cont, err, ok := metacont(t, f, "__call", next)
 	if !ok {
-		return nil, fmt.Errorf("attempt to call a %s value", f.CustomTypeName())
+		return nil, newOperandError(0, "attempt to call a %s value", f.CustomTypeName())
 	}
 	if cont != nil {
 		t.Push1(cont, f)
@@ -148,6 +161,18 @@ func Call(t *Thread, f Value, args []Value, next Cont) error {
 	return fmt.Errorf("attempt to call a %s value", f.CustomTypeName())
 }
 
+// CallWithContext is like Call but runs f in a new runtime context which is
+// terminated when goCtx is done, which makes it possible to stop f from another
+// goroutine.  In that case the returned error is a ContextTerminationError
+// wrapping goCtx.Err(), after to-be-closed values have been finalized (see
+// Thread.CallContext).
+func CallWithContext(goCtx context.Context, t *Thread, f Value, args []Value, next Cont) error {
+	_, err := t.CallContext(RuntimeContextDef{GoContext: goCtx}, func() error {
+		return Call(t, f, args, next)
+	})
+	return err
+}
+
 // Call1 is a convenience method that calls f with arguments args and returns
 // exactly one value.
 func Call1(t *Thread, f Value, args ...Value) (Value, error) {
@@ -160,13 +185,11 @@ func Call1(t *Thread, f Value, args ...Value) (Value, error) {
 
 // Concat returns x .. y, possibly calling the '__concat' metamethod.
 func Concat(t *Thread, x, y Value) (Value, error) {
-	var sx, sy string
-	var okx, oky bool
-	if sx, okx = x.ToString(); okx {
-		if sy, oky = y.ToString(); oky {
-			t.RequireBytes(len(sx) + len(sy))
-			return StringValue(sx + sy), nil
-		}
+	sx, okx := x.ToString()
+	sy, oky := y.ToString()
+	if okx && oky {
+		t.RequireBytes(len(sx) + len(sy))
+		return StringValue(sx + sy), nil
 	}
 	res, err, ok := metabin(t, "__concat", x, y)
 	if ok {
@@ -177,15 +200,16 @@ func Concat(t *Thread, x, y Value) (Value, error) {
 
 func concatError(x, y Value, okx, oky bool) error {
 	var wrongVal Value
+	var operand int
 	switch {
 	case oky:
 		wrongVal = x
 	case okx:
-		wrongVal = y
+		wrongVal, operand = y, 1
 	default:
 		return fmt.Errorf("attempt to concatenate a %s value with a %s value", x.CustomTypeName(), y.CustomTypeName())
 	}
-	return fmt.Errorf("attempt to concatenate a %s value", wrongVal.CustomTypeName())
+	return newOperandError(operand, "attempt to concatenate a %s value", wrongVal.CustomTypeName())
 }
 
 // IntLen returns the length of v as an int64, possibly calling the '__len'
@@ -232,7 +256,7 @@ func Len(t *Thread, v Value) (Value, error) {
 }
 
 func lenError(x Value) error {
-	return fmt.Errorf("attempt to get length of a %s value", x.CustomTypeName())
+	return newOperandError(0, "attempt to get length of a %s value", x.CustomTypeName())
 }
 
 // SetEnv sets the item in the table t for a string key.  Useful when writing
@@ -325,6 +349,12 @@ func (r *Runtime) compileLuaStat(name string, stat *ast.BlockStat, statSize uint
 
 	// "Optimise" the ir code
 	constants = ir.FoldConstants(constants, ir.DefaultFold)
+	if r.optLevel > ir.NoOptimization {
+		// Account for CPU needed by the optimization passes.  This is an
+		// estimate as above.
+		r.RequireCPU(constsSize / 4)
+		constants = ir.Optimize(constants, r.optLevel)
+	}
 
 	// Set up the IR to code compiler
 	kc := ircomp.NewConstantCompiler(constants, code.NewBuilder(name))
@@ -371,8 +401,18 @@ func (r *Runtime) CompileLuaChunkOrExp(name string, source []byte, scannerOption
 }
 
 // CompileLuaChunk parses and compiles the source as a Lua Chunk and returns the
-// compile code Unit.
+// compile code Unit.  The code is optimized according to the runtime's
+// optimization level (see SetOptimizationLevel).  If the runtime has a unit
+// cache (see WithUnitCache) and no scanner options are given, the unit is
+// looked up in the cache first.
 func (r *Runtime) CompileLuaChunk(name string, source []byte, scannerOptions ...scanner.Option) (*code.Unit, uint64, error) {
+	if len(scannerOptions) == 0 {
+		return r.compileLuaChunkCached(name, source, 1)
+	}
+	return r.compileLuaChunk(name, source, scannerOptions...)
+}
+
+func (r *Runtime) compileLuaChunk(name string, source []byte, scannerOptions ...scanner.Option) (*code.Unit, uint64, error) {
 	stat, statSize, err := r.ParseLuaChunk(name, source, scannerOptions...)
 	if err != nil {
 		return nil, 0, err
@@ -380,6 +420,15 @@ func (r *Runtime) CompileLuaChunk(name string, source []byte, scannerOptions ...
 	return r.compileLuaStat(name, stat, statSize)
 }
 
+// Compiles the source as a Lua chunk whose first line is startLine.
+func (r *Runtime) compileLuaChunkAt(name string, source []byte, startLine int) (*code.Unit, uint64, error) {
+	var opts []scanner.Option
+	if startLine != 1 {
+		opts = append(opts, scanner.WithStartLine(startLine))
+	}
+	return r.compileLuaChunk(name, source, opts...)
+}
+
 // CompileAndLoadLuaChunk parses, compiles and loads a Lua chunk from source and
 // returns the closure that runs the chunk in the given global environment.
 func (r *Runtime) CompileAndLoadLuaChunkOrExp(name string, source []byte, env Value, scannerOptions ...scanner.Option) (*Closure, error) {
@@ -417,36 +466,48 @@ func (r *Runtime) LoadFromSourceOrCode(name string, source []byte, mode string,
 
 	switch {
 	case canBeBinary && HasMarshalPrefix(source):
-		buf := bytes.NewBuffer(source)
-		k, used, err := UnmarshalConst(buf, r.LinearUnused(10))
-		r.LinearRequire(10, used)
-		if err != nil {
-			return nil, err
-		}
-		code, ok := k.TryCode()
-		if !ok {
-			return nil, errors.New("Expected function to load")
-		}
-		clos := NewClosure(r, code)
-		if code.UpvalueCount > 0 {
-			clos.AddUpvalue(newCell(env))
-			r.RequireCPU(uint64(code.UpvalueCount))
-			for i := int16(1); i < code.UpvalueCount; i++ {
-				clos.AddUpvalue(newCell(NilValue))
-			}
-		}
-		return clos, nil
-	case HasMarshalPrefix(source):
+		return r.loadDumpedChunk(source, env)
+	case canBeBinary && IsPrecompiledChunk(source):
+		return r.LoadPrecompiledChunk(source, env)
+	case HasMarshalPrefix(source), IsPrecompiledChunk(source):
 		return nil, errors.New("attempt to load a binary chunk")
 	case !canBeText:
 		return nil, errors.New("attempt to load a text chunk")
 	default:
-		var opts []scanner.Option
+		startLine := 1
 		if firstLineSkipped {
-			opts = append(opts, scanner.WithStartLine(2))
+			startLine = 2
+		}
+		unit, unitSize, err := r.compileLuaChunkCached(name, source, startLine)
+		defer r.ReleaseMem(unitSize)
+		if err != nil {
+			return nil, err
+		}
+		return r.LoadLuaUnit(unit, env), nil
+	}
+}
+
+// Loads a function serialized by string.dump, with env as its first upvalue.
+func (r *Runtime) loadDumpedChunk(source []byte, env Value) (*Closure, error) {
+	buf := bytes.NewBuffer(source)
+	k, used, err := UnmarshalConst(buf, r.LinearUnused(10))
+	r.LinearRequire(10, used)
+	if err != nil {
+		return nil, err
+	}
+	code, ok := k.TryCode()
+	if !ok {
+		return nil, errors.New("Expected function to load")
+	}
+	clos := NewClosure(r, code)
+	if code.UpvalueCount > 0 {
+		clos.AddUpvalue(newCell(env))
+		r.RequireCPU(uint64(code.UpvalueCount))
+		for i := int16(1); i < code.UpvalueCount; i++ {
+			clos.AddUpvalue(newCell(NilValue))
 		}
-		return r.CompileAndLoadLuaChunk(name, source, env, opts...)
 	}
+	return clos, nil
 }
 
 func stripFirstLineComment(chunk []byte) ([]byte, bool) {
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"sort"
 	"unsafe"
 
 	"github.com/arnodel/golua/code"
@@ -12,11 +13,77 @@ type Code struct {
 	source, name string
 	code         []code.Opcode
 	lines        []int32
+	operandNames []code.OperandNames // offsets are relative to the start of code
+	locals       []code.Local        // offsets are relative to the start of code
 	consts       []Value
 	UpvalueCount int16
 	UpNames      []string
 	RegCount     int16
 	CellCount    int16
+
+	lineDefined, lastLineDefined int32
+
+	indexCaches indexCaches // Inline caches, see inlinecache.go
+}
+
+// LineRange returns the lines where the definition of the function starts and
+// ends.  They are both 0 for the main chunk.
+func (c *Code) LineRange() (lineDefined, lastLineDefined int32) {
+	return c.lineDefined, c.lastLineDefined
+}
+
+// ActiveLines returns the lines that have code associated with them, in
+// increasing order.  As in Lua, the last line of a function (the line of its
+// "end") is always active because of the implicit return at the end of the
+// function body.
+func (c *Code) ActiveLines() []int32 {
+	seen := map[int32]bool{}
+	var lines []int32
+	if c.lastLineDefined > 0 {
+		seen[c.lastLineDefined] = true
+		lines = append(lines, c.lastLineDefined)
+	}
+	for _, l := range c.lines {
+		if l > 0 && !seen[l] {
+			seen[l] = true
+			lines = append(lines, l)
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// ParamInfo returns the number of fixed parameters of the function and
+// whether it is variadic.
+func (c *Code) ParamInfo() (nParams int, isVararg bool) {
+	for _, l := range c.locals {
+		if l.StartOffset > 0 {
+			break
+		}
+		if l.Name == "..." {
+			isVararg = true
+		} else {
+			nParams++
+		}
+	}
+	return
+}
+
+// ParamName returns the name of the n-th parameter of the function (starting
+// from 1), or false if there is no such parameter.
+func (c *Code) ParamName(n int) (string, bool) {
+	for _, l := range c.locals {
+		if l.StartOffset > 0 || n <= 0 {
+			break
+		}
+		if l.Name != "..." {
+			n--
+			if n == 0 {
+				return l.Name, true
+			}
+		}
+	}
+	return "", false
 }
 
 // RefactorConsts returns an equivalent *Code this consts "refactored", which
@@ -69,6 +136,8 @@ func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 	// code.Code case below
 	r.RequireArrSize(unsafe.Sizeof(code.Opcode(0)), len(unit.Code))
 	r.RequireArrSize(4, len(unit.Lines))
+	r.RequireArrSize(unsafe.Sizeof(code.OperandNames{}), len(unit.OperandNames))
+	r.RequireArrSize(unsafe.Sizeof(code.Local{}), len(unit.Locals))
 
 	// Require CPU for the loop below
 	r.RequireCPU(uint64(len(unit.Constants)))
@@ -92,17 +161,27 @@ func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 			if unit.Lines != nil {
 				lines = unit.Lines[k.StartOffset:k.EndOffset]
 			}
-			constants[i] = CodeValue(&Code{
+			c := &Code{
 				source:       unit.Source,
 				name:         k.Name,
 				code:         unit.Code[k.StartOffset:k.EndOffset],
 				lines:        lines,
+				operandNames: codeOperandNames(unit.OperandNames, int(k.StartOffset), int(k.EndOffset)),
+				locals:       codeLocals(unit.Locals, int(k.StartOffset), int(k.EndOffset)),
 				consts:       constants,
 				UpvalueCount: k.UpvalueCount,
 				UpNames:      k.UpNames,
 				RegCount:     k.RegCount,
 				CellCount:    k.CellCount,
-			})
+
+				lineDefined:     k.LineDefined,
+				lastLineDefined: k.LastLineDefined,
+			}
+			if r.coverage != nil {
+				// So that functions which are never called are reported
+				r.coverage.counters(c)
+			}
+			constants[i] = CodeValue(c)
 		default:
 			panic("Unsupported constant type")
 		}
@@ -114,3 +193,36 @@ func (r *Runtime) LoadLuaUnit(unit *code.Unit, env Value) *Closure {
 	}
 	return clos
 }
+
+// Returns the operand names for the opcodes in the [start, end) range, with
+// offsets relative to start.
+func codeOperandNames(names []code.OperandNames, start, end int) []code.OperandNames {
+	i := sort.Search(len(names), func(i int) bool { return names[i].Offset >= start })
+	j := sort.Search(len(names), func(i int) bool { return names[i].Offset >= end })
+	if i == j {
+		return nil
+	}
+	res := make([]code.OperandNames, j-i)
+	copy(res, names[i:j])
+	for k := range res {
+		res[k].Offset -= start
+	}
+	return res
+}
+
+// Returns the local variables declared in the [start, end) range, with offsets
+// relative to start.
+func codeLocals(locals []code.Local, start, end int) []code.Local {
+	i := sort.Search(len(locals), func(i int) bool { return locals[i].StartOffset >= start })
+	j := sort.Search(len(locals), func(i int) bool { return locals[i].StartOffset >= end })
+	if i == j {
+		return nil
+	}
+	res := make([]code.Local, j-i)
+	copy(res, locals[i:j])
+	for k := range res {
+		res[k].StartOffset -= start
+		res[k].EndOffset -= start
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+-- Runtime errors name the variable the offending value was read from
+
+print(pcall(function() return cfg.user end))
+--> ~false\t.*attempt to index a nil value \(global 'cfg'\)
+
+print(pcall(function() local cfg = {} return cfg.user.name end))
+--> ~false\t.*attempt to index a nil value \(field 'user'\)
+
+print(pcall(function() local cfg; cfg.user = 1 end))
+--> ~false\t.*attempt to index a nil value \(local 'cfg'\)
+
+do
+    local up
+    print(pcall(function() return up[1] end))
+    --> ~false\t.*attempt to index a nil value \(upvalue 'up'\)
+end
+
+print(pcall(function() undefined() end))
+--> ~false\t.*attempt to call a nil value \(global 'undefined'\)
+
+print(pcall(function() local t = {} t.f() end))
+--> ~false\t.*attempt to call a nil value \(field 'f'\)
+
+print(pcall(function() local t = {} t:m() end))
+--> ~false\t.*attempt to call a nil value \(method 'm'\)
+
+print(pcall(function() local t = {} return 1 + t end))
+--> ~false\t.*attempt to perform arithmetic on a table value \(local 't'\)
+
+print(pcall(function() local t = {} return t.x * 2 end))
+--> ~false\t.*attempt to perform arithmetic on a nil value \(field 'x'\)
+
+print(pcall(function() return -x end))
+--> ~false\t.*attempt to unm a 'nil' \(global 'x'\)
+
+print(pcall(function() return 1 & y end))
+--> ~false\t.*attempt to perform bitwise and on a nil value \(global 'y'\)
+
+print(pcall(function() local s = "a" return s .. z end))
+--> ~false\t.*attempt to concatenate a nil value \(global 'z'\)
+
+print(pcall(function() local s; return s .. "x" end))
+--> ~false\t.*attempt to concatenate a nil value \(local 's'\)
+
+print(pcall(function() return cfg .. 1 end))
+--> ~false\t.*attempt to concatenate a nil value \(global 'cfg'\)
+
+print(pcall(function() return #len end))
+--> ~false\t.*attempt to get length of a nil value \(global 'len'\)
+
+-- Intermediate results have no name
+print(pcall(function() local a = 1 return (a + 2) .. {} end))
+--> ~false\t.*attempt to concatenate a table value$
+
+-- Names survive string.dump
+print(pcall(load(string.dump(function() return cfg.user end))))
+--> ~false\t.*attempt to index a nil value \(global 'cfg'\)
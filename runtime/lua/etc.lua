@@ -68,3 +68,18 @@ do
     print(#t)
     --> =1
 end
+
+do
+    local function f(...)
+        return (...)
+    end
+    print(f(1, 2))
+    --> =1
+
+    local function g(...)
+        local a, b = (...)
+        print(a, b, select("#", (...)))
+    end
+    g(1, 2)
+    --> =1	nil	1
+end
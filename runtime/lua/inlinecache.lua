@@ -0,0 +1,124 @@
+-- Field accesses and method calls are sped up by inline caches.  These tests
+-- check they give the right results when tables change between accesses.
+
+local Point = {}
+Point.__index = Point
+
+function Point.new(x, y)
+    return setmetatable({x=x, y=y}, Point)
+end
+
+function Point:norm2()
+    return self.x * self.x + self.y * self.y
+end
+
+local function norms(points)
+    local res = {}
+    for i, p in ipairs(points) do
+        res[i] = p:norm2()
+    end
+    return table.concat(res, " ")
+end
+
+local pts = {Point.new(1, 2), Point.new(3, 4)}
+print(norms(pts))
+--> =5 25
+
+-- Shadow the method in an instance
+pts[2].norm2 = function() return "shadowed" end
+print(norms(pts))
+--> =5 shadowed
+
+-- Remove the shadowing method
+pts[2].norm2 = nil
+print(norms(pts))
+--> =5 25
+
+-- Change the method in the class
+function Point:norm2() return self.x + self.y end
+print(norms(pts))
+--> =3 7
+
+-- Change the metatable of an instance
+local Other = {norm2 = function() return "other" end}
+Other.__index = Other
+setmetatable(pts[1], Other)
+print(norms(pts))
+--> =other 7
+
+-- Make the class grow so its keys are moved to other slots
+for i = 1, 100 do
+    Point["m" .. i] = i
+end
+print(norms(pts), Point.m50)
+--> =other 7	50
+
+-- Remove a method from the class
+Point.norm2 = nil
+print(pcall(norms, pts))
+--> ~false\t.*attempt to call a nil value.*
+
+-- Different keys at the same instruction
+do
+    local t = {a=1, b=2, c=3}
+    local res = {}
+    for _, k in ipairs({"a", "b", "a", "c", "d", "b"}) do
+        res[#res + 1] = tostring(t[k])
+    end
+    print(table.concat(res, " "))
+    --> =1 2 1 3 nil 2
+end
+
+-- A function as '__index' in the chain
+do
+    local Base = setmetatable({}, {__index = function(_, k) return "computed " .. k end})
+    Base.__index = Base
+    local obj = setmetatable({}, Base)
+    print(obj.foo, obj.foo)
+    --> =computed foo	computed foo
+    Base.foo = "stored"
+    print(obj.foo)
+    --> =stored
+end
+
+-- A chain longer than what is cached
+do
+    local t = {name = "root"}
+    for i = 1, 10 do
+        t = setmetatable({}, {__index = t})
+    end
+    print(t.name, t.name)
+    --> =root	root
+    getmetatable(t).__index = {name = "other"}
+    print(t.name)
+    --> =other
+end
+
+-- Weak tables
+do
+    local cache = setmetatable({}, {__mode = "k"})
+    local t = setmetatable({}, {__index = cache, __mode = "v"})
+    cache.x = "x"
+    t.y = "y"
+    print(t.x, t.y)
+    --> =x	y
+end
+
+-- Setting fields
+do
+    local log = {}
+    local obj = setmetatable({x = 1}, {__newindex = function(t, k, v)
+        log[#log + 1] = k
+        rawset(t, k, v)
+    end})
+    for i = 1, 3 do
+        obj.x = obj.x + i
+    end
+    print(obj.x)
+    --> =7
+    obj.x = nil
+    obj.x = 10
+    obj.y = 20
+    print(obj.x, obj.y, table.concat(log, " "))
+    --> =10	20	x y
+end
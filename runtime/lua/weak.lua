@@ -0,0 +1,191 @@
+local function count(t)
+    local n = 0
+    for _ in pairs(t) do
+        n = n + 1
+    end
+    return n
+end
+
+-- Weak keys
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local kept = {}
+    local function fill()
+        t[kept] = 1
+        t[{}] = 2
+        t[{}] = 3
+        t.x = {}
+        t[1] = {}
+    end
+    fill()
+    print(count(t))
+    --> =5
+    collectgarbage()
+    print(count(t), t[kept], #t)
+    --> =3	1	1
+end
+
+-- Weak keys are ephemerons: a value which refers to its key does not keep the
+-- entry alive
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local kept = {}
+    local function fill()
+        local k = {}
+        t[k] = {k}
+        local f = function() end
+        t[f] = function() return f end
+        t[kept] = {kept}
+        t[{}] = setmetatable({}, {__index = kept})
+    end
+    fill()
+    print(count(t))
+    --> =4
+    collectgarbage()
+    print(count(t), t[kept][1] == kept)
+    --> =1	true
+end
+
+-- The values of entries with ephemeron keys are found after the table mode
+-- changes
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local k = {}
+    t[k] = "v"
+    setmetatable(t, {__mode = "kv"})
+    print(t[k])
+    --> =v
+    setmetatable(t, nil)
+    print(t[k])
+    --> =v
+    setmetatable(t, {__mode = "k"})
+    t[k] = nil
+    print(next(t))
+    --> =nil
+end
+
+-- Weak values
+do
+    local t = setmetatable({}, {__mode = "v"})
+    local kept = {}
+    local function fill()
+        t[1] = kept
+        t[2] = {}
+        t[3] = "strings are not collectable"
+        t.x = function() end
+        t.y = kept
+        t[kept] = {}
+    end
+    fill()
+    print(count(t))
+    --> =6
+    collectgarbage()
+    print(count(t), t[1] == kept, t[2], t[3], t.x, t.y == kept, t[kept])
+    --> =3	true	nil	strings are not collectable	nil	true	nil
+end
+
+-- Weak keys and values
+do
+    local t = setmetatable({}, {__mode = "kv"})
+    local k, v = {}, {}
+    local function fill()
+        t[k] = {}
+        t[{}] = v
+        t[k] = v
+        t[{}] = {}
+    end
+    fill()
+    collectgarbage()
+    print(count(t), t[k] == v)
+    --> =1	true
+end
+
+-- Setting the mode on a table with existing entries
+do
+    local t = {}
+    local function fill()
+        t[{}] = true
+        t[{}] = true
+    end
+    fill()
+    setmetatable(t, {__mode = "k"})
+    collectgarbage()
+    print(count(t))
+    --> =0
+    setmetatable(t, nil)
+    local function fill2()
+        t[{}] = true
+    end
+    fill2()
+    collectgarbage()
+    print(count(t))
+    --> =1
+end
+
+-- Entries can be removed and updated during traversal
+do
+    local t = setmetatable({}, {__mode = "v"})
+    local vals = {}
+    for i = 1, 10 do
+        vals[i] = {}
+        t[i] = vals[i]
+    end
+    for k in pairs(t) do
+        t[k] = nil
+    end
+    print(next(t))
+    --> =nil
+end
+
+-- Weak values are cleared before finalizers are run
+do
+    local t = setmetatable({}, {__mode = "v"})
+    local function fill()
+        t[1] = setmetatable({}, {__gc = function(x) print("gc", t[1]) end})
+    end
+    fill()
+    collectgarbage()
+    --> =gc	nil
+end
+
+-- Weak keys are only removed when they are reclaimed, so they are kept when
+-- they are resurrected by a finalizer
+do
+    local t = setmetatable({}, {__mode = "k"})
+    local saved
+    local function fill()
+        local x = setmetatable({}, {__gc = function(x)
+            saved = x
+            print("gc", t[x])
+        end})
+        t[x] = "val"
+    end
+    fill()
+    collectgarbage()
+    --> =gc	val
+    local function check()
+        print(t[saved], next(t) == saved)
+    end
+    check()
+    --> =val	true
+    saved = nil
+    collectgarbage()
+    print(next(t))
+    --> =nil
+end
+
+-- A weak table resurrected by its finalizer keeps its entries
+do
+    local k = {}
+    local saved
+    local function fill()
+        local t = setmetatable({}, {__mode = "k", __gc = function(t) saved = t end})
+        t[k] = "val"
+    end
+    fill()
+    collectgarbage()
+    local t2 = setmetatable({}, {__mode = "k"})
+    t2[k] = true
+    print(saved[k], t2[k])
+    --> =val	true
+end
@@ -18,6 +18,7 @@ type LuaCont struct {
 	acc            []Value
 	running        bool
 	borrowedCells  bool
+	tailCall       bool // True if the function was called with a tail call
 	closeStackBase int
 }
 
@@ -114,19 +115,35 @@ func (c *LuaCont) RunInThread(t *Thread) (Cont, error) {
 	opcodes := c.code
 	regs := c.registers
 	cells := c.cells
+	var hits, taken []uint64
+	if cov := t.coverage; cov != nil {
+		cc := cov.counters(c.Code)
+		hits, taken = cc.hits, cc.taken
+	}
 RunLoop:
 	for {
 		t.RequireCPU(1)
 
+		if hits != nil {
+			hits[pc]++
+		}
+
 		if t.DebugHooks.areFlagsEnabled(HookFlagLine) {
 			line := lines[pc]
 			if line > 0 && line != lastLine {
 				lastLine = line
+				c.pc = pc // So that the hook can find the current location
 				if err := t.triggerLine(t, c, line); err != nil {
 					return nil, err
 				}
 			}
 		}
+		if p := t.profiler; p != nil {
+			if n := p.due(); n > 0 {
+				c.pc = pc // So that the profiler can find the current line
+				p.sample(c, n)
+			}
+		}
 		opcode := opcodes[pc]
 		if opcode.HasType1() {
 			dst := opcode.GetA()
@@ -212,7 +229,7 @@ RunLoop:
 			}
 			if err != nil {
 				c.pc = pc
-				return nil, err
+				return nil, c.nameOperand(pc, err)
 			}
 			setReg(regs, cells, dst, res)
 			pc++
@@ -234,17 +251,17 @@ RunLoop:
 			coll := getReg(regs, cells, opcode.GetB())
 			idx := getReg(regs, cells, opcode.GetC())
 			if !opcode.GetF() {
-				val, err := Index(t, coll, idx)
+				val, err := c.index(t, pc, coll, idx)
 				if err != nil {
 					c.pc = pc
-					return nil, err
+					return nil, c.nameOperand(pc, err)
 				}
 				setReg(regs, cells, reg, val)
 			} else {
-				err := SetIndex(t, coll, idx, getReg(regs, cells, reg))
+				err := c.setIndex(t, pc, coll, idx, getReg(regs, cells, reg))
 				if err != nil {
 					c.pc = pc
-					return nil, err
+					return nil, c.nameOperand(pc, err)
 				}
 			}
 			pc++
@@ -298,6 +315,9 @@ RunLoop:
 				case code.OpTailCont:
 					var cont Cont
 					cont, err = Continue(t, val, c.Next())
+					if lc, ok := cont.(*LuaCont); ok {
+						lc.tailCall = true
+					}
 					res = ContValue(cont)
 				case code.OpId:
 					res = val
@@ -346,7 +366,7 @@ RunLoop:
 			}
 			if err != nil {
 				c.pc = pc
-				return nil, err
+				return nil, c.nameOperand(pc, err)
 			}
 			if opcode.GetF() {
 				getReg(regs, cells, dst).AsCont().Push(t.Runtime, res)
@@ -363,6 +383,9 @@ RunLoop:
 			case code.OpJumpIf:
 				test := Truth(getReg(regs, cells, opcode.GetA()))
 				if test == opcode.GetF() {
+					if taken != nil {
+						taken[pc]++
+					}
 					pc += int16(opcode.GetOffset())
 				} else {
 					pc++
@@ -441,7 +464,12 @@ RunLoop:
 				val = etc[idx]
 			}
 			if opcode.GetF() {
-				tbl := getReg(regs, cells, dst).AsTable()
+				tbl, ok := getReg(regs, cells, dst).TryTable()
+				if !ok {
+					// Only possible if a debug hook changed the register.
+					c.pc = pc
+					return nil, errors.New("attempt to fill a value which is not a table")
+				}
 				for i, v := range etc {
 					t.SetTable(tbl, IntValue(int64(i+idx)), v)
 				}
@@ -532,10 +560,7 @@ RunLoop:
 
 // DebugInfo implements Cont.DebugInfo.
 func (c *LuaCont) DebugInfo() *DebugInfo {
-	pc := c.pc
-	if !c.running {
-		pc--
-	}
+	pc := c.currentPC()
 	var currentLine int32 = -1
 	if pc >= 0 && int(pc) < len(c.lines) {
 		currentLine = c.lines[pc]
@@ -551,6 +576,136 @@ func (c *LuaCont) DebugInfo() *DebugInfo {
 	}
 }
 
+// Returns the offset of the instruction being executed, i.e. the call
+// instruction if the continuation is waiting for a call to return.
+func (c *LuaCont) currentPC() int16 {
+	if c.running {
+		return c.pc
+	}
+	return c.pc - 1
+}
+
+// IsTailCall returns true if the function running in c was called with a tail
+// call, in which case its caller is no longer in the call stack.
+func (c *LuaCont) IsTailCall() bool {
+	return c.tailCall
+}
+
+// CalledName returns the name of the variable that the function c is calling
+// was read from (e.g. global 'print' for print("hello")), if c is waiting for a
+// call to return and the name is known.
+func (c *LuaCont) CalledName() (name code.VarName, ok bool) {
+	pc := c.currentPC()
+	if c.running || pc < 0 || int(pc) >= len(c.code) {
+		return
+	}
+	opcode := c.code[pc]
+	if opcode.HasType1() || opcode.TypePfx() != code.Type5Pfx || opcode.GetJ() != code.OpCall {
+		return
+	}
+	// The continuation of the call is made from the function by an OpCont
+	// instruction before the arguments are pushed to it.  It is held in a
+	// register that no other instruction uses until the call.
+	contReg := opcode.GetA()
+	for pc--; pc >= 0; pc-- {
+		opcode = c.code[pc]
+		if opcode.HasType1() || opcode.TypePfx() != code.Type4Pfx || !opcode.HasType4a() {
+			continue
+		}
+		if opcode.GetUnOp() == code.OpCont && opcode.GetA() == contReg {
+			names, ok := c.getOperandNames(pc)
+			return names.B, ok && !names.B.IsZero()
+		}
+	}
+	return
+}
+
+// GetLocal returns the name and value of the n-th local variable (starting from
+// 1) in scope at the point in the code where c is, in the order they were
+// declared.  If there is no such variable, ok is false.
+//
+// As in Lua, if n is negative it refers to the n-th vararg of the function,
+// whose name is "(vararg)".
+func (c *LuaCont) GetLocal(n int) (name string, val Value, ok bool) {
+	if n < 0 {
+		etc, i, ok := c.getVararg(n)
+		if !ok {
+			return "", NilValue, false
+		}
+		return varargName, etc[i], true
+	}
+	l, ok := c.getLocal(n)
+	if !ok {
+		return "", NilValue, false
+	}
+	return l.Name, getReg(c.registers, c.cells, l.Reg), true
+}
+
+// SetLocal sets the value of the n-th local variable in scope (see GetLocal)
+// and returns its name.  If there is no such variable, ok is false.
+func (c *LuaCont) SetLocal(n int, val Value) (name string, ok bool) {
+	if n < 0 {
+		etc, i, ok := c.getVararg(n)
+		if !ok {
+			return "", false
+		}
+		etc[i] = val
+		return varargName, true
+	}
+	l, ok := c.getLocal(n)
+	if !ok {
+		return "", false
+	}
+	if !l.Reg.IsCell() && c.registers[l.Reg.Idx()].Type() == UnknownType {
+		// The register holds a continuation or varargs, which the code relies
+		// on (this cannot happen with code produced by the compiler).
+		return "", false
+	}
+	setReg(c.registers, c.cells, l.Reg, val)
+	return l.Name, true
+}
+
+func (c *LuaCont) getLocal(n int) (code.Local, bool) {
+	if n <= 0 {
+		return code.Local{}, false
+	}
+	pc := int(c.currentPC())
+	for _, l := range c.locals {
+		if l.StartOffset > pc {
+			break
+		}
+		if pc < l.EndOffset && l.Name != "..." {
+			n--
+			if n == 0 {
+				return l, true
+			}
+		}
+	}
+	return code.Local{}, false
+}
+
+const varargName = "(vararg)"
+
+// Returns the varargs of the function and the index in it of the vararg
+// referred to by n (which is negative, see GetLocal).
+func (c *LuaCont) getVararg(n int) ([]Value, int, bool) {
+	pc := int(c.currentPC())
+	for _, l := range c.locals {
+		if l.StartOffset > pc {
+			break
+		}
+		if l.Name == "..." && pc < l.EndOffset {
+			etc, _ := getReg(c.registers, c.cells, l.Reg).iface.([]Value)
+			i := -n - 1
+			if i >= len(etc) {
+				break
+			}
+			return etc, i, true
+		}
+	}
+	return nil, 0, false
+}
+
 func (c *LuaCont) getRegCell(reg code.Reg) Cell {
 	if reg.IsCell() {
 		return c.cells[reg.Idx()]
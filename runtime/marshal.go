@@ -5,17 +5,31 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"math"
+	"strings"
 
 	"github.com/arnodel/golua/code"
 )
 
-var marshalPrefix = []byte{6, 0, 4}
+// Marshalled values start with a magic prefix followed by a version byte.  The
+// version must be incremented each time the format changes, so that values
+// marshalled with a previous format are rejected rather than misread.
+var marshalMagic = []byte{6, 0}
+
+const marshalVersion = 8
+
+var marshalPrefix = append(append([]byte{}, marshalMagic...), marshalVersion)
+
 var ErrInvalidMarshalPrefix = errors.New("Invalid marshal prefix")
 
+// ErrMarshalVersion is returned when unmarshalling a value marshalled with a
+// different version of the format.
+var ErrMarshalVersion = errors.New("marshalled value has an incompatible format version")
+
 // HasMarshalPrefix returns true if the byte slice passed starts witht the magic
-// prefix for Lua marshalled values.
+// prefix for Lua marshalled values (whatever the version of the format).
 func HasMarshalPrefix(bs []byte) bool {
-	return len(bs) >= len(marshalPrefix) && bytes.Equal(marshalPrefix, bs[:len(marshalPrefix)])
+	return bytes.HasPrefix(bs, marshalMagic)
 }
 
 // MarshalConst serializes a const value to the writer w.
@@ -41,9 +55,13 @@ func UnmarshalConst(r io.Reader, budget uint64) (v Value, used uint64, err error
 		}
 	}()
 	pfx := make([]byte, len(marshalPrefix))
-	_, err = r.Read(pfx)
-	if !bytes.Equal(pfx, marshalPrefix) {
+	_, err = io.ReadFull(r, pfx)
+	switch {
+	case err != nil:
+	case !bytes.HasPrefix(pfx, marshalMagic):
 		err = ErrInvalidMarshalPrefix
+	case pfx[len(marshalMagic)] != marshalVersion:
+		err = ErrMarshalVersion
 	}
 	if err != nil {
 		return
@@ -103,16 +121,36 @@ func (w *bwriter) writeCode(c *Code) {
 	for _, k := range c.consts {
 		w.writeConst(k)
 	}
-	w.consumeBudget(2 + 2 + 2 + 8)
+	w.consumeBudget(2 + 2 + 2 + 4 + 4 + 8)
 	w.write(
 		c.UpvalueCount,
 		c.RegCount,
 		c.CellCount,
+		c.lineDefined,
+		c.lastLineDefined,
 		int64(len(c.UpNames)),
 	)
 	for _, n := range c.UpNames {
 		w.writeString(n)
 	}
+	w.consumeBudget(8)
+	w.write(int64(len(c.operandNames)))
+	for _, n := range c.operandNames {
+		w.consumeBudget(4 + 1 + 1)
+		w.write(int32(n.Offset), n.B.Kind, n.C.Kind)
+		for _, v := range [...]code.VarName{n.B, n.C} {
+			if !v.IsZero() {
+				w.writeString(v.Name)
+			}
+		}
+	}
+	w.consumeBudget(8)
+	w.write(int64(len(c.locals)))
+	for _, l := range c.locals {
+		w.consumeBudget(4 + 4 + 1 + 1)
+		w.write(int32(l.StartOffset), int32(l.EndOffset), l.Reg.RegType(), l.Reg.Idx())
+		w.writeString(l.Name)
+	}
 }
 
 func (w *bwriter) write(xs ...interface{}) {
@@ -157,12 +195,17 @@ var budgetConsumed interface{} = "budget consumed"
 //
 
 type breader struct {
-	r   io.Reader
-	err error
+	r     io.Reader
+	err   error
+	depth int // Nesting depth of the function being read
 
 	budget uint64
 }
 
+// Functions cannot be nested deeper than this, so that reading them does not
+// exhaust the stack.
+const maxCodeDepth = 200
+
 func (r *breader) readConst() (v Value) {
 	var tp ValueType
 	r.read(1, &tp)
@@ -182,8 +225,17 @@ func (r *breader) readConst() (v Value) {
 		s := r.readString()
 		v = StringValue(s)
 	case CodeType:
+		if r.depth >= maxCodeDepth {
+			r.err = errCodeTooDeep
+			break
+		}
 		x := new(Code)
+		r.depth++
 		r.readCode(x)
+		r.depth--
+		if r.err == nil {
+			r.err = x.verify()
+		}
 		v = CodeValue(x)
 	// Booleans and nil are inlined so this shouldn't be needed.  Keeping around
 	// in case this is reversed.
@@ -211,33 +263,71 @@ func (r *breader) readCode(c *Code) {
 		&c.name,
 		&sz,
 	)
-	c.code = make([]code.Opcode, sz)
+	c.code = make([]code.Opcode, r.checkLen(sz, math.MaxInt16))
 	r.read(
 		4*uint64(sz)+8,
 		c.code,
 		&sz,
 	)
-	c.lines = make([]int32, sz)
+	c.lines = make([]int32, r.checkLen(sz, math.MaxInt16))
 	r.read(
 		4*uint64(sz)+8,
 		c.lines,
 		&sz,
 	)
-	c.consts = make([]Value, sz)
+	c.consts = make([]Value, r.checkLen(sz, math.MaxUint16+1))
 	for i := range c.consts {
 		c.consts[i] = r.readConst()
 	}
 	r.read(
-		2+2+2+8,
+		2+2+2+4+4+8,
 		&c.UpvalueCount,
 		&c.RegCount,
 		&c.CellCount,
+		&c.lineDefined,
+		&c.lastLineDefined,
 		&sz,
 	)
-	c.UpNames = make([]string, sz)
+	c.UpNames = make([]string, r.checkLen(sz, math.MaxInt16))
 	for i := range c.UpNames {
 		c.UpNames[i] = r.readString()
 	}
+	r.read(8, &sz)
+	if n := r.checkLen(sz, math.MaxInt16); n > 0 {
+		c.operandNames = make([]code.OperandNames, n)
+	}
+	for i := range c.operandNames {
+		n := &c.operandNames[i]
+		var offset int32
+		r.read(4+1+1, &offset, &n.B.Kind, &n.C.Kind)
+		n.Offset = int(offset)
+		for _, v := range [...]*code.VarName{&n.B, &n.C} {
+			if !v.IsZero() {
+				v.Name = r.readString()
+			}
+		}
+	}
+	r.read(8, &sz)
+	if n := r.checkLen(sz, math.MaxUint16+1); n > 0 {
+		c.locals = make([]code.Local, n)
+	}
+	for i := range c.locals {
+		l := &c.locals[i]
+		var (
+			start, end int32
+			tp         code.RegType
+			idx        uint8
+		)
+		r.read(4+4+1+1, &start, &end, &tp, &idx)
+		l.StartOffset = int(start)
+		l.EndOffset = int(end)
+		if tp == code.CellRegType {
+			l.Reg = code.CellReg(idx)
+		} else {
+			l.Reg = code.ValueReg(idx)
+		}
+		l.Name = r.readString()
+	}
 }
 
 func (r *breader) read(sz uint64, xs ...interface{}) {
@@ -267,15 +357,34 @@ func (r *breader) readString() (s string) {
 	if r.err != nil {
 		return
 	}
+	if sl < 0 {
+		r.err = errInvalidLength
+		return
+	}
 	r.consumeBudget(uint64(sl))
-	b := make([]byte, sl)
-	_, r.err = r.r.Read(b)
-	if r.err == nil {
-		s = string(b)
+	// Do not trust the length to allocate the string before reading it.
+	var b strings.Builder
+	var n int64
+	n, r.err = io.CopyN(&b, r.r, sl)
+	if r.err == nil && n == sl {
+		s = b.String()
 	}
 	return
 }
 
+// Returns the length n if it is valid for a sequence of at most max items, 0
+// otherwise (and the error is set).
+func (r *breader) checkLen(n int64, max int) int {
+	if r.err != nil {
+		return 0
+	}
+	if n < 0 || n > int64(max) {
+		r.err = errInvalidLength
+		return 0
+	}
+	return int(n)
+}
+
 func (r *breader) consumeBudget(amount uint64) {
 	if r.budget == 0 {
 		return
@@ -286,4 +395,8 @@ func (r *breader) consumeBudget(amount uint64) {
 	r.budget -= amount
 }
 
-var errInvalidValueType = errors.New("Invalid value type")
+var (
+	errInvalidValueType = errors.New("Invalid value type")
+	errInvalidLength    = errors.New("Invalid length")
+	errCodeTooDeep      = errors.New("Functions nested too deeply")
+)
@@ -76,7 +76,7 @@ func TestUnmarshalConst(t *testing.T) {
 		{
 			name: "consume the budget",
 			args: args{
-				r:      bytes.NewBuffer([]byte{6, 0, 4, byte(StringType), 1, 1, 1, 1, 1, 1, 1, 1}), // would be very long
+				r:      bytes.NewBuffer([]byte{6, 0, marshalVersion, byte(StringType), 1, 1, 1, 1, 1, 1, 1, 1}), // would be very long
 				budget: 1000,
 			},
 			wantUsed: 1000,
@@ -89,11 +89,18 @@ func TestUnmarshalConst(t *testing.T) {
 			},
 			wantErr: true,
 		},
-
+		{
+			name: "wrong version",
+			args: args{
+				r:      bytes.NewBuffer([]byte{6, 0, marshalVersion - 1, byte(StringType), 0}),
+				budget: 1000,
+			},
+			wantErr: true,
+		},
 		{
 			name: "read wrong type",
 			args: args{
-				r: bytes.NewBuffer([]byte{6, 0, 4, byte(FunctionType)}),
+				r: bytes.NewBuffer([]byte{6, 0, marshalVersion, byte(FunctionType)}),
 			},
 			wantErr: true,
 		},
@@ -0,0 +1,57 @@
+package runtime
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/arnodel/golua/code"
+)
+
+// An operandError is returned when an operation fails because one of its
+// operands cannot be used for it (e.g. indexing a nil value).  When the
+// operation is performed by a Lua function, the message is completed with the
+// name of the variable the operand was read from if it is known, e.g. "attempt
+// to index a nil value (global 'cfg')".
+type operandError struct {
+	msg     string
+	operand int // 0 for the first operand, 1 for the second one
+}
+
+func newOperandError(operand int, format string, args ...interface{}) *operandError {
+	return &operandError{msg: fmt.Sprintf(format, args...), operand: operand}
+}
+
+func (e *operandError) Error() string {
+	return e.msg
+}
+
+// Returns the operand names for the opcode at the given offset.
+func (c *Code) getOperandNames(pc int16) (names code.OperandNames, ok bool) {
+	offset := int(pc)
+	i := sort.Search(len(c.operandNames), func(i int) bool { return c.operandNames[i].Offset >= offset })
+	if i == len(c.operandNames) || c.operandNames[i].Offset != offset {
+		return
+	}
+	return c.operandNames[i], true
+}
+
+// If err is an operandError, adds the name of the offending operand of the
+// opcode at pc to the error message if it is known.
+func (c *LuaCont) nameOperand(pc int16, err error) error {
+	opErr, ok := err.(*operandError)
+	if !ok {
+		return err
+	}
+	names, ok := c.getOperandNames(pc)
+	if !ok {
+		return err
+	}
+	name := names.B
+	if opErr.operand == 1 {
+		name = names.C
+	}
+	if name.IsZero() {
+		return err
+	}
+	return fmt.Errorf("%s (%s)", opErr.msg, name)
+}
@@ -0,0 +1,58 @@
+package runtime_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnodel/golua/ir"
+	"github.com/arnodel/golua/runtime"
+)
+
+// Compiles the file at the given optimization level and returns the number of
+// lines in its disassembly.
+func disassemblyLines(t *testing.T, path string, level int) int {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := runtime.New(nil, runtime.WithOptimizationLevel(level))
+	unit, _, err := r.CompileLuaChunk(path, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var w bytes.Buffer
+	unit.Disassemble(&w)
+	return bytes.Count(w.Bytes(), []byte{'\n'})
+}
+
+func TestOptimizationShrinksCode(t *testing.T) {
+	files, err := filepath.Glob("lua/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	libFiles, err := filepath.Glob("../lib/*/lua/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, libFiles...)
+	totals := make([]int, ir.MaxOptimizationLevel+1)
+	for _, file := range files {
+		prev := 0
+		for level := range totals {
+			n := disassemblyLines(t, file, level)
+			if level > 0 && n > prev {
+				t.Errorf("%s: %d lines at level %d, %d at level %d", file, n, level, prev, level-1)
+			}
+			totals[level] += n
+			prev = n
+		}
+	}
+	t.Logf("disassembly lines by optimization level: %v", totals)
+	for level := 1; level < len(totals); level++ {
+		if totals[level] >= totals[level-1] {
+			t.Errorf("optimization level %d does not shrink code: %v", level, totals)
+		}
+	}
+}
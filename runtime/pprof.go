@@ -0,0 +1,200 @@
+package runtime
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// A profile is the subset of the pprof profile format (see
+// https://github.com/google/pprof/blob/main/proto/profile.proto) used by the
+// CPU profiler.  It is encoded by hand to avoid depending on a protobuf
+// library.
+type profile struct {
+	sampleTypes   []profileValueType
+	samples       []profileSampleRecord
+	locations     []profileLocation
+	functions     []profileFunction
+	timeNanos     int64
+	durationNanos int64
+	periodType    profileValueType
+	period        int64
+}
+
+type profileValueType struct {
+	typ, unit string
+}
+
+type profileSampleRecord struct {
+	locations []uint64
+	values    []int64
+}
+
+type profileLocation struct {
+	id         uint64
+	functionID uint64
+	line       int64
+}
+
+type profileFunction struct {
+	id        uint64
+	name      string
+	filename  string
+	startLine int64
+}
+
+// Field numbers in profile.proto.
+const (
+	// Profile
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocationField = 4
+	profileFunctionField = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	// ValueType
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	// Sample
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	// Location
+	locationID   = 1
+	locationLine = 4
+
+	// Line
+	lineFunctionID = 1
+	lineLine       = 2
+
+	// Function
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+// Writes the profile gzip-compressed, which is what pprof tools expect.
+func (p *profile) write(w io.Writer) error {
+	var b protoBuffer
+	stringIndex := map[string]int64{"": 0}
+	stringTable := []string{""}
+	str := func(s string) int64 {
+		i, ok := stringIndex[s]
+		if !ok {
+			i = int64(len(stringTable))
+			stringIndex[s] = i
+			stringTable = append(stringTable, s)
+		}
+		return i
+	}
+	valueType := func(field int, vt profileValueType) {
+		var m protoBuffer
+		m.int64(valueTypeType, str(vt.typ))
+		m.int64(valueTypeUnit, str(vt.unit))
+		b.message(field, &m)
+	}
+	for _, st := range p.sampleTypes {
+		valueType(profileSampleType, st)
+	}
+	for _, s := range p.samples {
+		var m protoBuffer
+		m.packedUint64(sampleLocationID, s.locations)
+		m.packedInt64(sampleValue, s.values)
+		b.message(profileSample, &m)
+	}
+	for _, l := range p.locations {
+		var m, line protoBuffer
+		m.uint64(locationID, l.id)
+		line.uint64(lineFunctionID, l.functionID)
+		line.int64(lineLine, l.line)
+		m.message(locationLine, &line)
+		b.message(profileLocationField, &m)
+	}
+	for _, f := range p.functions {
+		var m protoBuffer
+		m.uint64(functionID, f.id)
+		m.int64(functionName, str(f.name))
+		m.int64(functionSystemName, str(f.name))
+		m.int64(functionFilename, str(f.filename))
+		m.int64(functionStartLine, f.startLine)
+		b.message(profileFunctionField, &m)
+	}
+	b.int64(profileTimeNanos, p.timeNanos)
+	b.int64(profileDurationNanos, p.durationNanos)
+	valueType(profilePeriodType, p.periodType)
+	b.int64(profilePeriod, p.period)
+	// The string table must be complete so it is written last.
+	for _, s := range stringTable {
+		b.bytes(profileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// A protoBuffer accumulates an encoded protobuf message.
+type protoBuffer []byte
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protoBuffer) key(field int, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(field, wireVarint)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, s []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(s)))
+	*b = append(*b, s...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.bytes(field, *m)
+}
+
+func (b *protoBuffer) packedUint64(field int, xs []uint64) {
+	var m protoBuffer
+	for _, x := range xs {
+		m.varint(x)
+	}
+	b.bytes(field, m)
+}
+
+func (b *protoBuffer) packedInt64(field int, xs []int64) {
+	var m protoBuffer
+	for _, x := range xs {
+		m.varint(uint64(x))
+	}
+	b.bytes(field, m)
+}
@@ -0,0 +1,110 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/arnodel/golua/code"
+)
+
+// A precompiled chunk is a Lua chunk compiled ahead of time (e.g. with "golua
+// -o out.luac script.lua") so that it can be loaded without parsing and
+// compiling it again.  It is made of
+//
+//   - the magic bytes "\x1bGoLuaC";
+//   - the length of CompilerVersion (one byte) followed by its bytes;
+//   - the CRC-32 (IEEE) checksum of the payload (4 bytes, little endian);
+//   - the payload, which is the main function of the chunk serialized in the
+//     same way as by string.dump.
+//
+// Loading a chunk fails cleanly if it was written by another compiler version
+// or if its payload does not match the checksum.
+
+// CompilerVersion identifies the format of the code produced by the compiler.
+// It is written in the header of precompiled chunks and must be changed when
+// code from previous versions can no longer be run correctly.
+const CompilerVersion = "golua-1"
+
+var precompiledMagic = []byte("\x1bGoLuaC")
+
+var (
+	// ErrPrecompiledVersion is returned when loading a precompiled chunk
+	// written by a different compiler version.
+	ErrPrecompiledVersion = errors.New("precompiled chunk has the wrong compiler version")
+
+	// ErrPrecompiledChecksum is returned when loading a precompiled chunk
+	// which is truncated or corrupted.
+	ErrPrecompiledChecksum = errors.New("precompiled chunk has a bad checksum")
+)
+
+// IsPrecompiledChunk returns true if the byte slice passed starts with the
+// magic prefix of precompiled chunks.
+func IsPrecompiledChunk(bs []byte) bool {
+	return bytes.HasPrefix(bs, precompiledMagic)
+}
+
+// Precompile parses and compiles a Lua chunk from source and writes it to w as
+// a precompiled chunk.  A first line starting with "#" is skipped, as when the
+// chunk is loaded from source.
+func (r *Runtime) Precompile(w io.Writer, name string, source []byte) error {
+	startLine := 1
+	source, firstLineSkipped := stripFirstLineComment(source)
+	if firstLineSkipped {
+		startLine = 2
+	}
+	unit, unitSize, err := r.compileLuaChunkCached(name, source, startLine)
+	defer r.ReleaseMem(unitSize)
+	if err != nil {
+		return err
+	}
+	return r.WritePrecompiledChunk(w, unit)
+}
+
+// WritePrecompiledChunk writes the main function of a compiled unit to w as a
+// precompiled chunk.
+func (r *Runtime) WritePrecompiledChunk(w io.Writer, unit *code.Unit) error {
+	clos := r.LoadLuaUnit(unit, NilValue)
+	var payload bytes.Buffer
+	used, err := MarshalConst(&payload, CodeValue(r.RefactorCodeConsts(clos.Code)), r.LinearUnused(10))
+	r.LinearRequire(10, used)
+	if err != nil {
+		return err
+	}
+	var header bytes.Buffer
+	header.Write(precompiledMagic)
+	header.WriteByte(byte(len(CompilerVersion)))
+	header.WriteString(CompilerVersion)
+	binary.Write(&header, binary.LittleEndian, crc32.ChecksumIEEE(payload.Bytes()))
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+	_, err = payload.WriteTo(w)
+	return err
+}
+
+// LoadPrecompiledChunk checks the header of a precompiled chunk and returns
+// the closure that runs it in the given global environment.
+func (r *Runtime) LoadPrecompiledChunk(data []byte, env Value) (*Closure, error) {
+	if !IsPrecompiledChunk(data) {
+		return nil, errors.New("not a precompiled chunk")
+	}
+	data = data[len(precompiledMagic):]
+	if len(data) == 0 || len(data) < 1+int(data[0])+4 {
+		return nil, ErrPrecompiledChecksum
+	}
+	version := string(data[1 : 1+data[0]])
+	if version != CompilerVersion {
+		return nil, fmt.Errorf("%w: got %q, expected %q", ErrPrecompiledVersion, version, CompilerVersion)
+	}
+	data = data[1+len(version):]
+	checksum := binary.LittleEndian.Uint32(data)
+	payload := data[4:]
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, ErrPrecompiledChecksum
+	}
+	return r.loadDumpedChunk(payload, env)
+}
@@ -0,0 +1,86 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func precompile(t *testing.T, r *Runtime, source string) []byte {
+	var w bytes.Buffer
+	if err := r.Precompile(&w, "test", []byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	return w.Bytes()
+}
+
+func TestRuntime_LoadPrecompiledChunk(t *testing.T) {
+	r := New(os.Stdout)
+	data := precompile(t, r, "#!/usr/bin/env golua\nlocal x = ...\nreturn x * 2, 'foo', debug\n")
+	if !IsPrecompiledChunk(data) {
+		t.Fatal("expected a precompiled chunk")
+	}
+	env := NewTable()
+	env.Set(StringValue("debug"), IntValue(42))
+	clos, err := r.LoadFromSourceOrCode("test", data, "b", TableValue(env), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	term := NewTerminationWith(nil, 0, true)
+	if err := Call(r.MainThread(), FunctionValue(clos), []Value{IntValue(21)}, term); err != nil {
+		t.Fatal(err)
+	}
+	got := term.Etc()
+	want := []Value{IntValue(42), StringValue("foo"), IntValue(42)}
+	if len(got) != len(want) {
+		t.Fatalf("got %d values, want %d", len(got), len(want))
+	}
+	for i, v := range want {
+		if eq, _ := RawEqual(got[i], v); !eq {
+			t.Errorf("value %d: got %v, want %v", i, got[i], v)
+		}
+	}
+	if _, err := r.LoadFromSourceOrCode("test", data, "t", TableValue(env), true); err == nil {
+		t.Error("expected text mode to reject a precompiled chunk")
+	}
+
+	// Line numbers are the same as in the source, with the first line skipped.
+	data = precompile(t, r, "#!/usr/bin/env golua\nlocal x = {} + 1\n")
+	clos, err = r.LoadPrecompiledChunk(data, TableValue(r.GlobalEnv()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Call(r.MainThread(), FunctionValue(clos), nil, NewTerminationWith(nil, 0, false))
+	if err == nil || !strings.Contains(err.Error(), "test:2:") {
+		t.Errorf("got error %v, want it at test:2", err)
+	}
+}
+
+func TestRuntime_LoadPrecompiledChunk_errors(t *testing.T) {
+	r := New(os.Stdout)
+	data := precompile(t, r, "return 1")
+
+	wrongVersion := append([]byte{}, data...)
+	wrongVersion[len(precompiledMagic)+1]++
+	if _, err := r.LoadPrecompiledChunk(wrongVersion, NilValue); !errors.Is(err, ErrPrecompiledVersion) {
+		t.Errorf("wrong version: got error %v", err)
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+	if _, err := r.LoadPrecompiledChunk(corrupt, NilValue); !errors.Is(err, ErrPrecompiledChecksum) {
+		t.Errorf("corrupt payload: got error %v", err)
+	}
+
+	for n := len(precompiledMagic); n < len(data); n++ {
+		if _, err := r.LoadPrecompiledChunk(data[:n], NilValue); err == nil {
+			t.Errorf("truncated to %d bytes: no error", n)
+		}
+	}
+
+	if _, err := r.LoadPrecompiledChunk([]byte("return 1"), NilValue); err == nil {
+		t.Error("expected an error for source code")
+	}
+}
@@ -0,0 +1,259 @@
+package runtime
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CPUProfileOptions controls how often the CPU profiler takes samples.
+type CPUProfileOptions struct {
+	// If Ticks is non-zero, a sample is taken every Ticks Lua instructions.
+	// This gives reproducible profiles.
+	Ticks uint64
+
+	// Otherwise a sample is taken every Interval of wall-clock time (10ms if
+	// Interval is zero).
+	Interval time.Duration
+}
+
+const (
+	defaultProfileInterval = 10 * time.Millisecond
+	maxProfileStackDepth   = 64
+)
+
+var errProfilingEnabled = errors.New("cpu profiling already enabled")
+
+// StartCPUProfile starts profiling the Lua code executed by the runtime.  The
+// profiler samples the call stack of the running thread (Lua functions and the
+// Go functions they called) and the profile is written to w in the pprof
+// format when StopCPUProfile is called, so that it can be looked at with "go
+// tool pprof".
+//
+// Samples are only taken while Lua instructions are executed, so time spent in
+// a Go function is attributed to the Lua line that follows the call.
+func (r *Runtime) StartCPUProfile(w io.Writer, opts CPUProfileOptions) error {
+	if r.profiler != nil {
+		return errProfilingEnabled
+	}
+	r.profiler = newCPUProfiler(w, opts)
+	return nil
+}
+
+// StopCPUProfile stops the current CPU profile, if any, and writes it.  It
+// must not be called while Lua code is running in the runtime.
+func (r *Runtime) StopCPUProfile() error {
+	p := r.profiler
+	if p == nil {
+		return nil
+	}
+	r.profiler = nil
+	return p.stop()
+}
+
+// A cpuProfiler aggregates samples of call stacks.  Sampling happens in the
+// goroutine that runs Lua code; in wall-clock mode, a ticker goroutine only
+// signals that samples are due.
+type cpuProfiler struct {
+	w         io.Writer
+	ticks     uint64 // if non-zero, sample every ticks instructions
+	interval  time.Duration
+	countdown uint64
+	pending   uint32 // number of samples due, updated atomically
+	done      chan struct{}
+	stopped   chan struct{}
+	startTime time.Time
+
+	functions map[string]uint64 // function key => function id
+	locations map[profileLoc]uint64
+	samples   map[string]*profileCount // location ids => sample
+	prof      profile
+}
+
+type profileLoc struct {
+	function uint64
+	line     int64
+}
+
+type profileCount struct {
+	locations []uint64
+	count     int64
+}
+
+func newCPUProfiler(w io.Writer, opts CPUProfileOptions) *cpuProfiler {
+	p := &cpuProfiler{
+		w:         w,
+		ticks:     opts.Ticks,
+		interval:  opts.Interval,
+		countdown: opts.Ticks,
+		startTime: time.Now(),
+		functions: map[string]uint64{},
+		locations: map[profileLoc]uint64{},
+		samples:   map[string]*profileCount{},
+	}
+	if p.ticks == 0 {
+		if p.interval <= 0 {
+			p.interval = defaultProfileInterval
+		}
+		p.done = make(chan struct{})
+		p.stopped = make(chan struct{})
+		go p.tick()
+	}
+	return p
+}
+
+// In wall-clock mode, this runs in its own goroutine until the profiler is
+// stopped.
+func (p *cpuProfiler) tick() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			atomic.AddUint32(&p.pending, 1)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Called before each Lua instruction, it returns the number of samples that
+// should be recorded.
+func (p *cpuProfiler) due() int64 {
+	if p.ticks > 0 {
+		p.countdown--
+		if p.countdown > 0 {
+			return 0
+		}
+		p.countdown = p.ticks
+		return 1
+	}
+	if atomic.LoadUint32(&p.pending) == 0 {
+		return 0
+	}
+	return int64(atomic.SwapUint32(&p.pending, 0))
+}
+
+// Records the call stack starting at c, count times.
+func (p *cpuProfiler) sample(c Cont, count int64) {
+	var locs []uint64
+	var key strings.Builder
+	for ; c != nil && len(locs) < maxProfileStackDepth; c = c.Parent() {
+		info := c.DebugInfo()
+		if info == nil {
+			continue
+		}
+		var startLine int32
+		line := info.CurrentLine
+		if lc := LuaContOf(c); lc != nil {
+			startLine, _ = lc.Closure.LineRange()
+			line = lc.nearestLine()
+		}
+		loc := p.location(profileFuncName(info.Name), info.Source, startLine, line)
+		locs = append(locs, loc)
+		key.WriteString(strconv.FormatUint(loc, 36))
+		key.WriteByte(',')
+	}
+	s := p.samples[key.String()]
+	if s == nil {
+		s = &profileCount{locations: locs}
+		p.samples[key.String()] = s
+	}
+	s.count += count
+}
+
+// Returns the id of the location for a frame, creating it if needed.
+func (p *cpuProfiler) location(name, source string, startLine, currentLine int32) uint64 {
+	fkey := name + "\x00" + source + "\x00" + strconv.Itoa(int(startLine))
+	fid, ok := p.functions[fkey]
+	if !ok {
+		fid = uint64(len(p.prof.functions) + 1)
+		p.functions[fkey] = fid
+		p.prof.functions = append(p.prof.functions, profileFunction{
+			id:        fid,
+			name:      name,
+			filename:  source,
+			startLine: int64(startLine),
+		})
+	}
+	line := int64(currentLine)
+	if line < 0 {
+		line = 0
+	}
+	lkey := profileLoc{function: fid, line: line}
+	lid, ok := p.locations[lkey]
+	if !ok {
+		lid = uint64(len(p.prof.locations) + 1)
+		p.locations[lkey] = lid
+		p.prof.locations = append(p.prof.locations, profileLocation{
+			id:         lid,
+			functionID: fid,
+			line:       line,
+		})
+	}
+	return lid
+}
+
+// pprof tools strip what is between angle brackets from function names, as
+// they look like C++ template parameters, so names like "<main chunk>" are
+// unwrapped.
+func profileFuncName(name string) string {
+	if len(name) > 2 && name[0] == '<' && name[len(name)-1] == '>' {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// Returns the line of the current instruction or, as some instructions have
+// no line information, of the closest instruction before it that has one.
+func (c *LuaCont) nearestLine() int32 {
+	pc := int(c.currentPC())
+	if pc >= len(c.lines) {
+		pc = len(c.lines) - 1
+	}
+	for ; pc >= 0; pc-- {
+		if line := c.lines[pc]; line > 0 {
+			return line
+		}
+	}
+	return 0
+}
+
+func (p *cpuProfiler) stop() error {
+	if p.done != nil {
+		close(p.done)
+		<-p.stopped
+	}
+	prof := &p.prof
+	prof.timeNanos = p.startTime.UnixNano()
+	prof.durationNanos = int64(time.Since(p.startTime))
+	var weight int64
+	if p.ticks > 0 {
+		prof.periodType = profileValueType{"instructions", "count"}
+		prof.period = int64(p.ticks)
+		weight = prof.period
+	} else {
+		prof.periodType = profileValueType{"wall", "nanoseconds"}
+		prof.period = int64(p.interval)
+		weight = prof.period
+	}
+	prof.sampleTypes = []profileValueType{{"samples", "count"}, prof.periodType}
+	keys := make([]string, 0, len(p.samples))
+	for k := range p.samples {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := p.samples[k]
+		prof.samples = append(prof.samples, profileSampleRecord{
+			locations: s.locations,
+			values:    []int64{s.count, s.count * weight},
+		})
+	}
+	return prof.write(p.w)
+}
@@ -0,0 +1,273 @@
+package runtime_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	rt "github.com/arnodel/golua/runtime"
+)
+
+const profiledSource = `
+local function hot(n)
+  local s = 0
+  for i = 1, n do
+    s = s + i % 7
+  end
+  return s
+end
+
+local function cold()
+  return hot(10)
+end
+
+return hot(20000) + cold()
+`
+
+func runProfiled(t *testing.T, opts rt.CPUProfileOptions) *decodedProfile {
+	r := rt.New(nil)
+	var buf bytes.Buffer
+	if err := r.StartCPUProfile(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StartCPUProfile(&buf, opts); err == nil {
+		t.Fatal("expected an error when profiling is already enabled")
+	}
+	clos, err := r.MainThread().LoadFromSourceOrCode("profiled.lua", []byte(profiledSource), "t", rt.TableValue(r.GlobalEnv()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StopCPUProfile(); err != nil {
+		t.Fatal(err)
+	}
+	return decodeProfile(t, buf.Bytes())
+}
+
+func TestCPUProfileTicks(t *testing.T) {
+	p := runProfiled(t, rt.CPUProfileOptions{Ticks: 100})
+	if p.periodType != "instructions" || p.period != 100 {
+		t.Fatalf("unexpected period: %d %s", p.period, p.periodType)
+	}
+	if len(p.sampleTypes) != 2 || p.sampleTypes[0] != "samples" || p.sampleTypes[1] != "instructions" {
+		t.Fatalf("unexpected sample types: %q", p.sampleTypes)
+	}
+	hot := p.samplesIn("hot")
+	if hot < 100 || hot != p.samplesAt("hot", "profiled.lua", 5)+p.samplesAt("hot", "profiled.lua", 4) {
+		t.Fatalf("unexpected samples in hot: %d", hot)
+	}
+	if p.samplesIn("main chunk") != p.total {
+		t.Fatal("all samples should have the main chunk in their stack")
+	}
+}
+
+func TestCPUProfileInterval(t *testing.T) {
+	r := rt.New(nil)
+	var buf bytes.Buffer
+	if err := r.StartCPUProfile(&buf, rt.CPUProfileOptions{Interval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	clos, err := r.MainThread().LoadFromSourceOrCode("loop.lua", []byte(`
+local n = 0
+while n < 3000000 do n = n + 1 end
+`), "t", rt.TableValue(r.GlobalEnv()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.Call1(r.MainThread(), rt.FunctionValue(clos)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.StopCPUProfile(); err != nil {
+		t.Fatal(err)
+	}
+	p := decodeProfile(t, buf.Bytes())
+	if p.periodType != "wall" || p.period != int64(time.Millisecond) {
+		t.Fatalf("unexpected period: %d %s", p.period, p.periodType)
+	}
+	if p.samplesAt("main chunk", "loop.lua", 3) == 0 {
+		t.Fatal("expected samples at line 3")
+	}
+}
+
+// A decodedProfile contains the parts of a pprof profile that are checked by
+// the tests.
+type decodedProfile struct {
+	sampleTypes []string
+	periodType  string
+	period      int64
+	total       int64
+	samples     []decodedSample
+}
+
+type decodedSample struct {
+	count  int64
+	frames []decodedFrame
+}
+
+type decodedFrame struct {
+	name, file string
+	line       int64
+}
+
+// Returns the number of samples with the function in their stack.
+func (p *decodedProfile) samplesIn(name string) (n int64) {
+	for _, s := range p.samples {
+		for _, f := range s.frames {
+			if f.name == name {
+				n += s.count
+				break
+			}
+		}
+	}
+	return
+}
+
+// Returns the number of samples taken at the given location.
+func (p *decodedProfile) samplesAt(name, file string, line int64) (n int64) {
+	for _, s := range p.samples {
+		if f := s.frames[0]; f.name == name && f.file == file && f.line == line {
+			n += s.count
+		}
+	}
+	return
+}
+
+func decodeProfile(t *testing.T, data []byte) *decodedProfile {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		strs        []string
+		sampleTypes [][]byte
+		periodType  []byte
+		samples     [][]byte
+		locations   = map[uint64][]byte{}
+		functions   = map[uint64][]byte{}
+		p           = new(decodedProfile)
+	)
+	for _, f := range protoFields(t, data) {
+		switch f.num {
+		case 1:
+			sampleTypes = append(sampleTypes, f.bytes)
+		case 2:
+			samples = append(samples, f.bytes)
+		case 4:
+			locations[protoField(t, f.bytes, 1).varint] = f.bytes
+		case 5:
+			functions[protoField(t, f.bytes, 1).varint] = f.bytes
+		case 6:
+			strs = append(strs, string(f.bytes))
+		case 11:
+			periodType = f.bytes
+		case 12:
+			p.period = int64(f.varint)
+		}
+	}
+	str := func(i uint64) string {
+		if i >= uint64(len(strs)) {
+			t.Fatalf("invalid string index %d", i)
+		}
+		return strs[i]
+	}
+	for _, st := range sampleTypes {
+		p.sampleTypes = append(p.sampleTypes, str(protoField(t, st, 1).varint))
+	}
+	p.periodType = str(protoField(t, periodType, 1).varint)
+	for _, sb := range samples {
+		var s decodedSample
+		locIDs := protoPacked(t, protoField(t, sb, 1).bytes)
+		s.count = int64(protoPacked(t, protoField(t, sb, 2).bytes)[0])
+		for _, id := range locIDs {
+			loc, ok := locations[id]
+			if !ok {
+				t.Fatalf("missing location %d", id)
+			}
+			line := protoField(t, loc, 4).bytes
+			fn, ok := functions[protoField(t, line, 1).varint]
+			if !ok {
+				t.Fatal("missing function")
+			}
+			s.frames = append(s.frames, decodedFrame{
+				name: str(protoField(t, fn, 2).varint),
+				file: str(protoField(t, fn, 4).varint),
+				line: int64(protoField(t, line, 2).varint),
+			})
+		}
+		p.total += s.count
+		p.samples = append(p.samples, s)
+	}
+	return p
+}
+
+type protoFieldValue struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+func protoVarint(t *testing.T, data []byte) (uint64, []byte) {
+	var x uint64
+	for i, b := range data {
+		if i >= 10 {
+			break
+		}
+		x |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return x, data[i+1:]
+		}
+	}
+	t.Fatal("invalid varint")
+	return 0, nil
+}
+
+func protoFields(t *testing.T, data []byte) []protoFieldValue {
+	var fields []protoFieldValue
+	for len(data) > 0 {
+		var key, n uint64
+		key, data = protoVarint(t, data)
+		f := protoFieldValue{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.varint, data = protoVarint(t, data)
+		case 2:
+			n, data = protoVarint(t, data)
+			if n > uint64(len(data)) {
+				t.Fatal("truncated field")
+			}
+			f.bytes, data = data[:n], data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// Returns the last occurrence of a field, or a zero value.
+func protoField(t *testing.T, data []byte, num int) (f protoFieldValue) {
+	for _, ff := range protoFields(t, data) {
+		if ff.num == num {
+			f = ff
+		}
+	}
+	return
+}
+
+func protoPacked(t *testing.T, data []byte) []uint64 {
+	var xs []uint64
+	for len(data) > 0 {
+		var x uint64
+		x, data = protoVarint(t, data)
+		xs = append(xs, x)
+	}
+	return xs
+}
@@ -0,0 +1,82 @@
+package runtime
+
+import (
+	crypto "crypto/rand"
+	"encoding/binary"
+	"math/bits"
+	"time"
+)
+
+// A RandomSource is a source of pseudo-random numbers.  Each Runtime owns one,
+// which is used by the math library (math.random and math.randomseed).
+type RandomSource interface {
+	// Uint64 returns the next pseudo-random 64 bits integer.
+	Uint64() uint64
+
+	// Seed resets the state of the source according to two seed values.
+	Seed(n1, n2 uint64)
+}
+
+// Xoshiro256StarStar is the xoshiro256** pseudo-random number generator, which
+// is also used by the reference implementation of Lua 5.4.  When seeded with
+// the same values, it produces the same sequence of numbers as
+// math.random() in PUC-Rio Lua 5.4.
+type Xoshiro256StarStar struct {
+	s [4]uint64
+}
+
+var _ RandomSource = (*Xoshiro256StarStar)(nil)
+
+// NewXoshiro256StarStar returns a new generator seeded with n1 and n2 (see
+// Seed).
+func NewXoshiro256StarStar(n1, n2 uint64) *Xoshiro256StarStar {
+	g := new(Xoshiro256StarStar)
+	g.Seed(n1, n2)
+	return g
+}
+
+// Uint64 implements RandomSource.Uint64.
+func (g *Xoshiro256StarStar) Uint64() uint64 {
+	s := &g.s
+	res := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return res
+}
+
+// Seed implements RandomSource.Seed.  It initialises the state in the same way
+// as math.randomseed(n1, n2) in Lua 5.4.
+func (g *Xoshiro256StarStar) Seed(n1, n2 uint64) {
+	g.s = [4]uint64{n1, 0xff, n2, 0} // 0xff avoids a zero state
+	// Discard initial values to "spread" the seed
+	for i := 0; i < 16; i++ {
+		g.Uint64()
+	}
+}
+
+// RandomSource returns the runtime's source of pseudo-random numbers.  Unless
+// one was provided with WithRandomSource or WithRandomSeed when creating the
+// runtime, this is a Xoshiro256StarStar generator with a random seed.
+func (r *Runtime) RandomSource() RandomSource {
+	if r.randomSource == nil {
+		n1, n2 := NewRandomSeed()
+		r.randomSource = NewXoshiro256StarStar(n1, n2)
+	}
+	return r.randomSource
+}
+
+// NewRandomSeed returns two values suitable for seeding a RandomSource, as
+// random as possible.
+func NewRandomSeed() (n1, n2 uint64) {
+	var b [16]byte
+	if _, err := crypto.Read(b[:]); err != nil {
+		// Fall back to something that varies at least.
+		return uint64(time.Now().UnixNano()), 0
+	}
+	return binary.LittleEndian.Uint64(b[:8]), binary.LittleEndian.Uint64(b[8:])
+}
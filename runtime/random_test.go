@@ -0,0 +1,34 @@
+package runtime
+
+import "testing"
+
+func TestXoshiro256StarStar(t *testing.T) {
+	// Expected values computed with the reference C implementation
+	g := NewXoshiro256StarStar(42, 0)
+	for _, want := range []uint64{17170454028988085989, 8333941968102511665} {
+		if got := g.Uint64(); got != want {
+			t.Errorf("Uint64() = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestRuntime_RandomSource(t *testing.T) {
+	r1 := New(nil, WithRandomSeed(1, 2))
+	r2 := New(nil, WithRandomSeed(1, 2))
+	r3 := New(nil)
+
+	// Seeding one runtime does not affect the others
+	r3.RandomSource().Seed(1, 2)
+	r3.RandomSource().Uint64()
+
+	for i := 0; i < 10; i++ {
+		x1, x2 := r1.RandomSource().Uint64(), r2.RandomSource().Uint64()
+		if x1 != x2 {
+			t.Fatalf("different values for same seed at step %d: %d, %d", i, x1, x2)
+		}
+	}
+	src := NewXoshiro256StarStar(5, 6)
+	if r := New(nil, WithRandomSource(src)); r.RandomSource() != src {
+		t.Errorf("RandomSource() did not return the source provided")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"io"
 	"os"
+
+	"github.com/arnodel/golua/vfs"
 )
 
 // A Runtime is a Lua runtime.  It contains all the global state of the runtime
@@ -22,6 +24,21 @@ type Runtime struct {
 
 	warner Warner // Lua 5.4 introduces a warning system, implemented by this
 
+	gcPool gcPool // Keeps track of values marked for finalization
+
+	randomSource RandomSource // Used by math.random, see RandomSource()
+
+	fs vfs.FS // Filesystem accessible to Lua code, see FS()
+
+	stdio stdio // Standard streams of the io library, see Stdio()
+
+	optLevel int // Optimization level used when compiling Lua source
+
+	unitCache *UnitCache // Compiled code shared with other runtimes, see WithUnitCache()
+
+	profiler *cpuProfiler      // Set while CPU profiling, see StartCPUProfile()
+	coverage *coverageRecorder // Set while recording coverage, see StartCoverage()
+
 	// This has an almost empty implementation when the noquotas build tag is
 	// set.  It should allow the compiler to compile away almost all runtime
 	// context manager methods.
@@ -42,11 +59,22 @@ type Runtime struct {
 type runtimeOptions struct {
 	regPoolSize  uint
 	regSetMaxAge uint
+	randomSource RandomSource
+	fs           vfs.FS
+	optLevel     int
+	stdio        stdio
+	unitCache    *UnitCache
+}
+
+type stdio struct {
+	in       io.Reader
+	out, err io.Writer
 }
 
 var defaultRuntimeOptions = runtimeOptions{
 	regPoolSize:  10,
 	regSetMaxAge: 10,
+	fs:           vfs.OS,
 }
 
 // A RuntimeOption configures the Runtime.
@@ -68,6 +96,66 @@ func WithRegSetMaxAge(age uint) RuntimeOption {
 	}
 }
 
+// WithRandomSource sets the source of pseudo-random numbers of the Runtime
+// (used e.g. by math.random).  By default each Runtime has its own randomly
+// seeded source.
+func WithRandomSource(src RandomSource) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.randomSource = src
+	}
+}
+
+// WithRandomSeed gives the Runtime a source of pseudo-random numbers seeded
+// with the given values, which is useful for reproducible runs.  The sequence
+// of numbers produced by math.random is then the same as after calling
+// math.randomseed(n1, n2).
+func WithRandomSeed(n1, n2 int64) RuntimeOption {
+	return WithRandomSource(NewXoshiro256StarStar(uint64(n1), uint64(n2)))
+}
+
+// WithFS sets the filesystem that Lua code can access through the io and os
+// libraries, loadfile, dofile and require.  By default it is the host
+// filesystem (vfs.OS).  When it is not, the system shell is not available to
+// Lua code (io.popen and os.execute fail) as processes would bypass it.
+func WithFS(fsys vfs.FS) RuntimeOption {
+	if fsys == nil {
+		fsys = vfs.OS
+	}
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.fs = fsys
+	}
+}
+
+// WithOptimizationLevel sets the optimization level used when compiling Lua
+// source (see SetOptimizationLevel).
+func WithOptimizationLevel(level int) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.optLevel = level
+	}
+}
+
+// WithStdio sets the standard input, output and error streams of the io library
+// (io.stdin, io.stdout and io.stderr), for example to capture the output of
+// Lua code.  Warnings are written to the error stream.  Streams which are nil
+// default to os.Stdin, os.Stdout and os.Stderr.  Note that print writes to the
+// stdout passed to New, which the io library uses if stdout is nil.
+func WithStdio(stdin io.Reader, stdout, stderr io.Writer) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.stdio = stdio{in: stdin, out: stdout, err: stderr}
+	}
+}
+
+// WithUnitCache makes the Runtime look up compiled Lua source in c before
+// compiling it, and add what it compiles to c.  This applies to Lua source
+// loaded with e.g. CompileLuaChunk, load, loadfile, dofile and require.  The
+// same cache can be given to many runtimes, so that code loaded in each of them
+// is only compiled once per process.
+func WithUnitCache(c *UnitCache) RuntimeOption {
+	return func(rtOpts *runtimeOptions) {
+		rtOpts.unitCache = c
+	}
+}
+
 // New returns a new pointer to a Runtime with the given stdout.
 func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
 	rtOpts := defaultRuntimeOptions
@@ -79,9 +167,18 @@ func New(stdout io.Writer, opts ...RuntimeOption) *Runtime {
 		Stdout:    stdout,
 		registry:  NewTable(),
 		warner:    NewLogWarner(os.Stderr, "Lua warning: "),
+		stdio:     rtOpts.stdio,
 		regPool:   mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
 		argsPool:  mkValuePool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
 		cellPool:  mkCellPool(rtOpts.regPoolSize, rtOpts.regSetMaxAge),
+
+		randomSource: rtOpts.randomSource,
+		fs:           rtOpts.fs,
+		optLevel:     rtOpts.optLevel,
+		unitCache:    rtOpts.unitCache,
+	}
+	if rtOpts.stdio.err != nil {
+		r.warner = NewLogWarner(rtOpts.stdio.err, "Lua warning: ")
 	}
 	mainThread := NewThread(r)
 	mainThread.status = ThreadOK
@@ -99,11 +196,44 @@ func (r *Runtime) Registry(key Value) Value {
 	return r.registry.Get(key)
 }
 
+// RegistryTable returns the registry table, which is what debug.getregistry()
+// returns.
+func (r *Runtime) RegistryTable() *Table {
+	return r.registry
+}
+
 // SetRegistry sets the value associated with the key k to v in the registry.
 func (r *Runtime) SetRegistry(k, v Value) {
 	r.SetTable(r.registry, k, v)
 }
 
+// Stdio returns the standard streams set with WithStdio.  They are nil if they
+// were not set.
+func (r *Runtime) Stdio() (stdin io.Reader, stdout, stderr io.Writer) {
+	return r.stdio.in, r.stdio.out, r.stdio.err
+}
+
+// FS returns the filesystem that Lua code can access (see WithFS).
+func (r *Runtime) FS() vfs.FS {
+	return r.fs
+}
+
+// SetOptimizationLevel sets the optimization level used when compiling Lua
+// source, e.g. by CompileLuaChunk, load or require.  Level 0 (the default)
+// disables optimizations, level 1 removes unreachable code, simplifies jumps
+// and reuses registers, and level 2 also propagates constants and removes dead
+// stores.  At level 2 the debug library may no longer see some local variables,
+// or see stale values in them.
+func (r *Runtime) SetOptimizationLevel(level int) {
+	r.optLevel = level
+}
+
+// OptimizationLevel returns the optimization level used when compiling Lua
+// source.
+func (r *Runtime) OptimizationLevel() int {
+	return r.optLevel
+}
+
 // MainThread returns the runtime's main thread.
 func (r *Runtime) MainThread() *Thread {
 	return r.mainThread
@@ -151,7 +281,8 @@ func (r *Runtime) RawMetatable(v Value) *Table {
 	}
 }
 
-// SetRawMetatable sets the metatable for value v to meta.
+// SetRawMetatable sets the metatable for value v to meta.  If v is a table or a
+// userdata and meta has a "__gc" field, v is marked for finalization.
 func (r *Runtime) SetRawMetatable(v Value, meta *Table) {
 	if v.IsNil() {
 		r.nilMeta = meta
@@ -169,6 +300,10 @@ func (r *Runtime) SetRawMetatable(v Value, meta *Table) {
 		v.AsUserData().SetMetatable(meta)
 	default:
 		// Shoul there be an error here?
+		return
+	}
+	if !RawGet(meta, StringValue("__gc")).IsNil() {
+		r.MarkForFinalization(v)
 	}
 }
 
@@ -1,11 +1,18 @@
 package runtime
 
+import "context"
+
 // RuntimeContextDef contains the data necessary to create an new runtime context.
 type RuntimeContextDef struct {
 	HardLimits     RuntimeResources
 	SoftLimits     RuntimeResources
 	RequiredFlags  ComplianceFlags
 	MessageHandler Callable
+
+	// If GoContext is set, the runtime context is terminated when GoContext is
+	// done (e.g. cancelled or past its deadline).  This is not available when
+	// the noquotas build tag is set.
+	GoContext context.Context
 }
 
 // RuntimeContext is an interface implemented by Runtime.RuntimeContext().  It
@@ -36,6 +43,7 @@ const (
 // should be terminated immediately.
 type ContextTerminationError struct {
 	message string
+	cause   error // Set when terminated because the Go context is done
 }
 
 var _ error = ContextTerminationError{}
@@ -45,6 +53,13 @@ func (e ContextTerminationError) Error() string {
 	return e.message
 }
 
+// Unwrap returns the error of the Go context (see RuntimeContextDef.GoContext)
+// if the runtime context was terminated because it was done, so that e.g.
+// errors.Is(err, context.Canceled) can be used to identify this case.
+func (e ContextTerminationError) Unwrap() error {
+	return e.cause
+}
+
 // RuntimeContextStatus describes the status of a context
 type RuntimeContextStatus uint16
 
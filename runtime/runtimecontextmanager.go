@@ -4,8 +4,10 @@
 package runtime
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +33,10 @@ type runtimeContextManager struct {
 
 	messageHandler Callable
 
+	// Set if this context or an enclosing one was given a Go context that can
+	// be done.
+	interrupt *interrupt
+
 	trackCpu         bool
 	trackMem         bool
 	trackTime        bool
@@ -112,8 +118,12 @@ func (m *runtimeContextManager) PushContext(ctx RuntimeContextDef) {
 	if ctx.HardLimits.Millis > 0 {
 		m.requiredFlags |= ComplyTimeSafe
 	}
+	if ctx.GoContext != nil && ctx.GoContext.Done() != nil {
+		m.interrupt = startInterrupt(ctx.GoContext, m.interrupt)
+	}
 	m.trackTime = m.hardLimits.Millis > 0 || m.softLimits.Millis > 0
-	m.trackCpu = m.hardLimits.Cpu > 0 || m.softLimits.Cpu > 0 || m.trackTime
+	// The interrupt is checked when requiring CPU
+	m.trackCpu = m.hardLimits.Cpu > 0 || m.softLimits.Cpu > 0 || m.trackTime || m.interrupt != nil
 	m.trackMem = m.hardLimits.Memory > 0 || m.softLimits.Memory > 0
 	m.status = StatusLive
 	m.messageHandler = ctx.MessageHandler
@@ -128,9 +138,14 @@ func (m *runtimeContextManager) PopContext() RuntimeContext {
 	if mCopy.status == StatusLive {
 		mCopy.status = StatusDone
 	}
-	m.parent.RequireCPU(m.usedResources.Cpu)
-	m.parent.RequireMem(m.usedResources.Memory)
+	if m.ownsInterrupt() {
+		m.interrupt.stop()
+	}
+	// Restore the parent before accounting for the resources used, as this
+	// may terminate it.
 	*m = *m.parent
+	m.RequireCPU(mCopy.usedResources.Cpu)
+	m.RequireMem(mCopy.usedResources.Memory)
 	if m.trackTime {
 		m.updateTimeUsed()
 	}
@@ -150,6 +165,9 @@ func (m *runtimeContextManager) requireCPU(cpuAmount uint64) {
 	if m.stopLevel&HardStop != 0 {
 		m.KillContext()
 	}
+	if m.interrupt != nil && m.interrupt.isDone() {
+		m.interruptContext()
+	}
 	cpuUsed := m.usedResources.Cpu + cpuAmount
 	if atLimit(cpuUsed, m.hardLimits.Cpu) {
 		m.TerminateContext("CPU limit of %d exceeded", m.hardLimits.Cpu)
@@ -280,6 +298,117 @@ func (m *runtimeContextManager) TerminateContext(format string, args ...interfac
 	})
 }
 
+// Terminates the context because its Go context is done.
+func (m *runtimeContextManager) interruptContext() {
+	if m.status != StatusLive {
+		return
+	}
+	m.status = StatusKilled
+	err := m.interrupt.err()
+	panic(ContextTerminationError{
+		message: fmt.Sprintf("interrupted: %s", err),
+		cause:   err,
+	})
+}
+
+// Returns true if the current context was given the Go context that its
+// interrupt watches, rather than inheriting it from an enclosing context.
+func (m *runtimeContextManager) ownsInterrupt() bool {
+	return m.interrupt != nil && (m.parent == nil || m.interrupt != m.parent.interrupt)
+}
+
+// Returns true if the Go context of the current context or of an enclosing one
+// is done.
+func (m *runtimeContextManager) interrupted() bool {
+	return m.interrupt != nil && m.interrupt.isDone()
+}
+
+// GoContextDone returns a channel which is closed when the Go context of the
+// current runtime context or of an enclosing one is done (see
+// RuntimeContextDef.GoContext), or nil if there is no such Go context.  Go
+// functions which block (e.g. waiting for a process) can select on it so that
+// they stop waiting, then call CheckInterrupt.
+func (m *runtimeContextManager) GoContextDone() <-chan struct{} {
+	if m.interrupt == nil {
+		return nil
+	}
+	return m.interrupt.doneCh
+}
+
+// CheckInterrupt terminates the current runtime context if the Go context of
+// this context or of an enclosing one is done.
+func (m *runtimeContextManager) CheckInterrupt() {
+	if m.interrupted() {
+		m.interruptContext()
+	}
+}
+
+// An interrupt records that a Go context is done, so that the runtime context
+// it was given to can be terminated.  The Go context may be done in any
+// goroutine, so the goroutine running Lua code checks the done flag when it
+// requires CPU.  Interrupts of nested runtime contexts are chained, as the
+// Go context of any enclosing runtime context can be done.
+type interrupt struct {
+	done   uint32        // Accessed atomically
+	doneCh chan struct{} // Closed when done is set
+	goCtx  context.Context
+	stopCh chan struct{}
+	parent *interrupt
+}
+
+func startInterrupt(goCtx context.Context, parent *interrupt) *interrupt {
+	i := &interrupt{
+		goCtx:  goCtx,
+		doneCh: make(chan struct{}),
+		stopCh: make(chan struct{}),
+		parent: parent,
+	}
+	if goCtx.Err() != nil {
+		i.done = 1
+		close(i.doneCh)
+		return i
+	}
+	var parentDone <-chan struct{}
+	if parent != nil {
+		parentDone = parent.doneCh
+	}
+	go func() {
+		select {
+		case <-goCtx.Done():
+		case <-parentDone:
+		case <-i.stopCh:
+			return
+		}
+		atomic.StoreUint32(&i.done, 1)
+		close(i.doneCh)
+	}()
+	return i
+}
+
+func (i *interrupt) isDone() bool {
+	for ; i != nil; i = i.parent {
+		if atomic.LoadUint32(&i.done) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the error of the first Go context in the chain that is done.
+func (i *interrupt) err() error {
+	for ; i != nil; i = i.parent {
+		if err := i.goCtx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stops watching the Go context.
+func (i *interrupt) stop() {
+	close(i.stopCh)
+}
+
 // Current unix time in ms
 func now() uint64 {
 	return uint64(time.Now().UnixNano() / 1e6)
@@ -123,6 +123,17 @@ func (m *runtimeContextManager) LinearRequire(cpuFactor uint64, amt uint64) {
 func (m *runtimeContextManager) ResetQuota() {
 }
 
+func (m *runtimeContextManager) interrupted() bool {
+	return false
+}
+
+func (m *runtimeContextManager) GoContextDone() <-chan struct{} {
+	return nil
+}
+
+func (m *runtimeContextManager) CheckInterrupt() {
+}
+
 func (m *runtimeContextManager) TerminateContext(format string, args ...interface{}) {
 	// I don't know if it should do it?
 	panic(ContextTerminationError{
@@ -1,13 +1,36 @@
 package runtime
 
+import (
+	"strings"
+	"weak"
+)
+
 // Table implements a Lua table.
 type Table struct {
 	// This is where the implementation details are.
 	mixedTable
 
 	meta *Table
+
+	// Weak tables store weak references to collectable keys and / or values
+	// (see weakref.go).
+	weakMode weakMode
+	weakSelf weak.Pointer[weakHandle] // Weak reference to this table, when it has weak keys
+	gcCycle  uint64                   // Value of gcCycles() when dead entries were last removed
+
+	// Stands for this table in weak keys (see weakref.go).
+	weakHandle *weakHandle
 }
 
+// A weakMode describes whether a table has weak keys and / or weak values,
+// which is determined by the "__mode" field of its metatable.
+type weakMode uint8
+
+const (
+	weakKeys weakMode = 1 << iota
+	weakValues
+)
+
 // NewTable returns a new Table.
 func NewTable() *Table {
 	return &Table{mixedTable: mixedTable{}}
@@ -18,18 +41,27 @@ func (t *Table) Metatable() *Table {
 	return t.meta
 }
 
-// SetMetatable sets the table's metatable.
+// SetMetatable sets the table's metatable.  If the metatable has a "__mode"
+// field containing 'k' (resp. 'v'), the table's keys (resp. values) become
+// weak.
 func (t *Table) SetMetatable(m *Table) {
 	t.meta = m
+	t.setWeakMode(getWeakMode(m))
 }
 
 // Get returns t[k].
 func (t *Table) Get(k Value) Value {
+	if t.weakMode != 0 {
+		return t.weakGet(k)
+	}
 	return t.get(k)
 }
 
 // Set implements t[k] = v (doesn't check if k is nil).
 func (t *Table) Set(k, v Value) uint64 {
+	if t.weakMode != 0 {
+		return t.weakSet(k, v)
+	}
 	if v.IsNil() {
 		t.mixedTable.remove(k)
 		return 0
@@ -40,6 +72,9 @@ func (t *Table) Set(k, v Value) uint64 {
 
 // Reset implements t[k] = v only if t[k] was already non-nil.
 func (t *Table) Reset(k, v Value) (wasSet bool) {
+	if t.weakMode != 0 {
+		return t.weakReset(k, v)
+	}
 	if v.IsNil() {
 		return t.mixedTable.remove(k)
 	}
@@ -48,10 +83,176 @@ func (t *Table) Reset(k, v Value) (wasSet bool) {
 
 // Len returns a length for t (see lua docs for details).
 func (t *Table) Len() int64 {
+	if t.weakMode != 0 {
+		t.removeDeadEntries()
+	}
 	return int64(t.mixedTable.len())
 }
 
 // Next returns the key-value pair that comes after k in the table t.
 func (t *Table) Next(k Value) (next Value, val Value, ok bool) {
+	if t.weakMode != 0 {
+		return t.weakNext(k)
+	}
 	return t.mixedTable.next(k)
 }
+
+//
+// Weak table implementation
+//
+
+// Returns the weak mode described by the "__mode" field of a metatable.
+func getWeakMode(meta *Table) (mode weakMode) {
+	s, ok := RawGet(meta, StringValue("__mode")).TryString()
+	if !ok {
+		return 0
+	}
+	if strings.IndexByte(s, 'k') >= 0 {
+		mode |= weakKeys
+	}
+	if strings.IndexByte(s, 'v') >= 0 {
+		mode |= weakValues
+	}
+	return
+}
+
+// Changes the weak mode of the table.  The existing entries are rebuilt so that
+// they are stored according to the new mode.
+func (t *Table) setWeakMode(mode weakMode) {
+	if mode == t.weakMode {
+		return
+	}
+	var keys, values []Value
+	for k, v, _ := t.Next(NilValue); !k.IsNil(); k, v, _ = t.Next(k) {
+		keys = append(keys, k)
+		values = append(values, v)
+		if t.isEphemeron(k) {
+			ephemeronsOf(k).set(t.weakSelf, NilValue)
+		}
+	}
+	t.mixedTable = mixedTable{}
+	t.weakMode = mode
+	t.gcCycle = gcCycles()
+	if mode&weakKeys != 0 && t.weakSelf == (weak.Pointer[weakHandle]{}) {
+		t.weakSelf = weak.Make(weakHandleOf(TableValue(t)))
+	}
+	for i, k := range keys {
+		t.Set(k, values[i])
+	}
+}
+
+// Returns the key under which k is stored in the table.  If the table has weak
+// keys and k is collectable, this is a weak reference to k (see makeWeakKey).
+func (t *Table) weakKey(k Value) Value {
+	if t.weakMode&weakKeys == 0 {
+		return k
+	}
+	k, _ = makeWeakKey(k)
+	return k
+}
+
+// Returns true if the value associated with k is stored in k rather than in the
+// table, i.e. the table has weak keys and strong values and k is collectable.
+func (t *Table) isEphemeron(k Value) bool {
+	return t.weakMode == weakKeys && isCollectable(k)
+}
+
+// Returns the value that should be stored in the table for k => v.
+func (t *Table) storedWeakValue(k, v Value) Value {
+	if t.isEphemeron(k) {
+		ephemeronsOf(k).set(t.weakSelf, v)
+		return Value{iface: ephemeronMarker{}}
+	}
+	if t.weakMode&weakValues != 0 {
+		v, _ = makeWeakRef(v)
+	}
+	return v
+}
+
+// Returns the value associated with k given the value stored in the table for
+// it.  It is nil if the value has been reclaimed.
+func (t *Table) resolveWeakValue(k, v Value) Value {
+	if _, ok := v.iface.(ephemeronMarker); ok {
+		return ephemeronsOf(k).get(t.weakSelf)
+	}
+	return resolveWeakRef(v)
+}
+
+func (t *Table) weakGet(k Value) Value {
+	v := t.get(t.weakKey(k))
+	if v.IsNil() {
+		return NilValue
+	}
+	return t.resolveWeakValue(k, v)
+}
+
+func (t *Table) weakSet(k, v Value) uint64 {
+	t.removeDeadEntries()
+	wk := t.weakKey(k)
+	if v.IsNil() {
+		if t.mixedTable.remove(wk) && t.isEphemeron(k) {
+			ephemeronsOf(k).set(t.weakSelf, NilValue)
+		}
+		return 0
+	}
+	t.mixedTable.insert(wk, t.storedWeakValue(k, v))
+	return 16
+}
+
+func (t *Table) weakReset(k, v Value) bool {
+	if t.weakGet(k).IsNil() {
+		return false
+	}
+	wk := t.weakKey(k)
+	if v.IsNil() {
+		if t.isEphemeron(k) {
+			ephemeronsOf(k).set(t.weakSelf, NilValue)
+		}
+		return t.mixedTable.remove(wk)
+	}
+	return t.mixedTable.reset(wk, t.storedWeakValue(k, v))
+}
+
+func (t *Table) weakNext(k Value) (next Value, val Value, ok bool) {
+	wk := t.weakKey(k)
+	for {
+		wk, val, ok = t.mixedTable.next(wk)
+		if !ok || wk.IsNil() {
+			return
+		}
+		next = resolveWeakRef(wk)
+		if next.IsNil() {
+			continue
+		}
+		val = t.resolveWeakValue(next, val)
+		if !val.IsNil() {
+			return
+		}
+	}
+}
+
+// Removes the entries whose key or value refers to a value that has been
+// reclaimed.  This is a no-op if no GC cycle has completed since the last time
+// it was called.
+func (t *Table) removeDeadEntries() {
+	gcCycle := gcCycles()
+	if gcCycle == t.gcCycle {
+		return
+	}
+	t.gcCycle = gcCycle
+	if t.hashTable != nil {
+		for i := range t.hashTable.slots {
+			it := &t.hashTable.slots[i]
+			if isDeadWeakRef(it.key) || isDeadWeakRef(it.value) {
+				it.value = NilValue
+			}
+		}
+	}
+	if t.array != nil {
+		for i, v := range t.array.values {
+			if isDeadWeakRef(v) {
+				t.array.remove(int64(i + 1))
+			}
+		}
+	}
+}
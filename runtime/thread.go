@@ -55,6 +55,9 @@ type Thread struct {
 	DebugHooks
 
 	closeStack // Stack of pending to-be-closed values
+
+	// Stands for this thread in weak keys (see weakref.go).
+	weakHandle *weakHandle
 }
 
 // NewThread creates a new thread out of a Runtime.  Its initial
@@ -91,6 +94,10 @@ func (t *Thread) RunContinuation(c Cont) (err error) {
 	var errContCount = 0
 	_ = t.triggerCall(t, c)
 	for c != nil {
+		if t.IsMain() {
+			// This is a safe point to run Lua finalizers
+			t.RunPendingFinalizers()
+		}
 		t.currentCont = c
 		next, err = c.RunInThread(t)
 		if err != nil {
@@ -263,8 +270,11 @@ func (t *Thread) end(args []Value, err error, exception interface{}) {
 	t.caller = nil
 	err = t.cleanupCloseStack(nil, 0, err) // TODO: not nil
 	t.closeErr = err
+	// The goroutine will terminate after this.  The memory must be released
+	// before the caller resumes, as the runtime is not safe for concurrent
+	// use.
+	t.ReleaseBytes(2 << 10)
 	caller.sendResumeValues(args, err, exception)
-	t.ReleaseBytes(2 << 10) // The goroutine will terminate after this
 }
 
 func (t *Thread) call(c Callable, args []Value, next Cont) error {
@@ -297,19 +307,38 @@ func (t *Thread) sendResumeValues(args []Value, err error, exception interface{}
 // Otherwise (even if f() returns an error), pending to-be-closed values should
 // be finalized.
 //
+// If the context is terminated because its Go context (see
+// RuntimeContextDef.GoContext) is done, pending to-be-closed values are
+// finalized and the returned error is a ContextTerminationError wrapping the
+// error of the Go context.  If it is the Go context of an enclosing runtime
+// context that is done, the termination propagates to that context.
+//
 // See quotas.md for details about this API.
 func (t *Thread) CallContext(def RuntimeContextDef, f func() error) (ctx RuntimeContext, err error) {
 	t.PushContext(def)
 	c, h := t.CurrentCont(), t.closeStack.size()
 	defer func() {
-		ctx = t.PopContext()
-		t.closeStack.truncate(h) // No resources to run that, so just discard it.
-		if r := recover(); r != nil {
-			_, ok := r.(ContextTerminationError)
-			if !ok {
+		r := recover()
+		termErr, isTermination := r.(ContextTerminationError)
+		if r != nil && !isTermination {
+			ctx = t.PopContext()
+			panic(r)
+		}
+		if isTermination && termErr.cause != nil {
+			// The termination may originate from a nested context, so make
+			// sure this one is marked as killed as well.
+			t.Runtime.setStatus(StatusKilled)
+			ctx = t.PopContext()
+			if t.Runtime.interrupted() {
+				// Let the context whose Go context is done handle it.
 				panic(r)
 			}
+			_ = t.cleanupCloseStack(c, h, termErr)
+			err = termErr
+			return
 		}
+		ctx = t.PopContext()
+		t.closeStack.truncate(h) // No resources to run that, so just discard it.
 	}()
 	err = t.cleanupCloseStack(c, h, f())
 	if err != nil {
@@ -0,0 +1,372 @@
+package runtime
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// The functions below make GoFunction values out of Go functions with typed
+// parameters and results, taking care of checking and converting arguments and
+// pushing results.  E.g.
+//
+//	repeat := Func2("repeat", func(s string, n int) (string, error) {
+//		return strings.Repeat(s, n), nil
+//	})
+//
+// Arguments are converted without reflection for the following types: Value,
+// bool (using Lua truth), int, int64, float64, string (numbers are converted
+// as in Lua), *Table, *UserData, *Thread and Callable.  Results of these types
+// are also converted without reflection.  Userdata arguments whose value has
+// the expected type are accepted (see Class).  Other types are converted with
+// Decode for arguments and Encode for results.  Missing arguments are
+// converted from nil.
+//
+// Arguments of the wrong type result in an error such as
+//
+//	bad argument #2 to 'repeat' (number expected, got string)
+//
+// Compliance flags (see quotas.md) can be passed when creating the function.
+// As with (*GoFunction).SolemnlyDeclareCompliance, the Go function must
+// actually comply with them.
+
+// Func0 returns a GoFunction called name which calls f and returns its result.
+func Func0[R any](name string, f func() (R, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 0, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		r, err := f()
+		if err != nil {
+			return nil, err
+		}
+		return pushResults(t, c, r)
+	})
+}
+
+// Func1 returns a GoFunction called name which calls f with its argument and
+// returns its result.
+func Func1[A, R any](name string, f func(A) (R, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 1, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f(a)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults(t, c, r)
+	})
+}
+
+// Func2 returns a GoFunction called name which calls f with its 2 arguments
+// and returns its result.
+func Func2[A, B, R any](name string, f func(A, B) (R, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 2, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := typedArg[B](t, c, name, 1)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults(t, c, r)
+	})
+}
+
+// Func3 returns a GoFunction called name which calls f with its 3 arguments
+// and returns its result.
+func Func3[A, B, C, R any](name string, f func(A, B, C) (R, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 3, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := typedArg[B](t, c, name, 1)
+		if err != nil {
+			return nil, err
+		}
+		x, err := typedArg[C](t, c, name, 2)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f(a, b, x)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults(t, c, r)
+	})
+}
+
+// FuncVar returns a GoFunction called name which calls f with all its
+// arguments and returns its result.
+func FuncVar[V, R any](name string, f func(...V) (R, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 0, true, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		vs, err := typedEtc[V](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f(vs...)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults(t, c, r)
+	})
+}
+
+// Func1Var returns a GoFunction called name which calls f with its first
+// argument followed by the others and returns its result.
+func Func1Var[A, V, R any](name string, f func(A, ...V) (R, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 1, true, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		vs, err := typedEtc[V](t, c, name, 1)
+		if err != nil {
+			return nil, err
+		}
+		r, err := f(a, vs...)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults(t, c, r)
+	})
+}
+
+// Func1R2 returns a GoFunction called name which calls f with its argument and
+// returns its 2 results.
+func Func1R2[A, R1, R2 any](name string, f func(A) (R1, R2, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 1, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		r1, r2, err := f(a)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults2(t, c, r1, r2)
+	})
+}
+
+// Func2R2 returns a GoFunction called name which calls f with its 2 arguments
+// and returns its 2 results.
+func Func2R2[A, B, R1, R2 any](name string, f func(A, B) (R1, R2, error), flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 2, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := typedArg[B](t, c, name, 1)
+		if err != nil {
+			return nil, err
+		}
+		r1, r2, err := f(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return pushResults2(t, c, r1, r2)
+	})
+}
+
+// Proc1 returns a GoFunction called name which calls f with its argument and
+// returns no results.
+func Proc1[A any](name string, f func(A) error, flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 1, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := f(a); err != nil {
+			return nil, err
+		}
+		return c.Next(), nil
+	})
+}
+
+// Proc2 returns a GoFunction called name which calls f with its 2 arguments
+// and returns no results.
+func Proc2[A, B any](name string, f func(A, B) error, flags ...ComplianceFlags) *GoFunction {
+	return newTypedFunc(name, 2, false, flags, func(t *Thread, c *GoCont) (Cont, error) {
+		a, err := typedArg[A](t, c, name, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := typedArg[B](t, c, name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := f(a, b); err != nil {
+			return nil, err
+		}
+		return c.Next(), nil
+	})
+}
+
+func newTypedFunc(name string, nArgs int, hasEtc bool, flags []ComplianceFlags, f GoFunctionFunc) *GoFunction {
+	gof := NewGoFunction(f, name, nArgs, hasEtc)
+	for _, fl := range flags {
+		gof.SolemnlyDeclareCompliance(fl)
+	}
+	return gof
+}
+
+// Returns the n-th argument of c converted to T.  A missing argument is
+// considered to be nil.
+func typedArg[T any](t *Thread, c *GoCont, name string, n int) (T, error) {
+	if n >= c.NArgs() {
+		return toTyped[T](t, NilValue, name, n, "no value")
+	}
+	v := c.Arg(n)
+	return toTyped[T](t, v, name, n, typeNameInError(v))
+}
+
+// Returns the etc arguments of c converted to T, the first one being the n-th
+// argument.
+func typedEtc[T any](t *Thread, c *GoCont, name string, n int) ([]T, error) {
+	etc := c.Etc()
+	t.RequireArrSize(sizeOf[T](), len(etc))
+	res := make([]T, len(etc))
+	for i, v := range etc {
+		x, err := toTyped[T](t, v, name, n+i, typeNameInError(v))
+		if err != nil {
+			return nil, err
+		}
+		res[i] = x
+	}
+	return res, nil
+}
+
+func toTyped[T any](t *Thread, v Value, name string, n int, got string) (x T, err error) {
+	var ok bool
+	expected := "number"
+	switch p := any(&x).(type) {
+	case *Value:
+		*p, ok = v, true
+	case *bool:
+		*p, ok = Truth(v), true
+	case *int64:
+		*p, ok = ToInt(v)
+	case *int:
+		var i int64
+		i, ok = ToInt(v)
+		*p = int(i)
+	case *float64:
+		*p, ok = ToFloat(v)
+	case *string:
+		if ok = isNumber(v); ok {
+			*p, _ = v.ToString()
+		} else {
+			*p, ok = v.TryString()
+		}
+		expected = "string"
+	case **Table:
+		*p, ok = v.TryTable()
+		expected = "table"
+	case **UserData:
+		*p, ok = v.TryUserData()
+		expected = "userdata"
+	case **Thread:
+		*p, ok = v.TryThread()
+		expected = "thread"
+	case *Callable:
+		*p, ok = v.TryCallable()
+		expected = "function"
+	default:
+		if y, ok := userDataAs[T](v); ok {
+			return y, nil
+		}
+		if c := classOf(reflect.TypeOf(&x).Elem()); c != nil {
+			return x, fmt.Errorf("bad argument #%d to '%s' (%s expected, got %s)", n+1, name, c.name, got)
+		}
+		if err := t.Decode(v, &x); err != nil {
+			return x, fmt.Errorf("bad argument #%d to '%s' (%s)", n+1, name, err)
+		}
+		return x, nil
+	}
+	switch {
+	case ok:
+		return
+	case expected == "number" && isNumber(v):
+		// Only an integer conversion can fail with a number.
+		err = fmt.Errorf("bad argument #%d to '%s' (number has no integer representation)", n+1, name)
+	default:
+		err = fmt.Errorf("bad argument #%d to '%s' (%s expected, got %s)", n+1, name, expected, got)
+	}
+	return
+}
+
+func isNumber(v Value) bool {
+	switch v.iface.(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// Returns the Lua value for a result of type T.
+func fromTyped[T any](t *Thread, x T) (Value, error) {
+	switch y := any(x).(type) {
+	case Value:
+		return y, nil
+	case bool:
+		return BoolValue(y), nil
+	case int64:
+		return IntValue(y), nil
+	case int:
+		return IntValue(int64(y)), nil
+	case float64:
+		return FloatValue(y), nil
+	case string:
+		t.RequireBytes(len(y))
+		return StringValue(y), nil
+	case *Table:
+		if y == nil {
+			return NilValue, nil
+		}
+		return TableValue(y), nil
+	case *UserData:
+		if y == nil {
+			return NilValue, nil
+		}
+		return UserDataValue(y), nil
+	case *Thread:
+		if y == nil {
+			return NilValue, nil
+		}
+		return ThreadValue(y), nil
+	case Callable:
+		return FunctionValue(y), nil
+	default:
+		return t.Encode(x)
+	}
+}
+
+func pushResults[R any](t *Thread, c *GoCont, r R) (Cont, error) {
+	v, err := fromTyped(t, r)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext1(t.Runtime, v), nil
+}
+
+func pushResults2[R1, R2 any](t *Thread, c *GoCont, r1 R1, r2 R2) (Cont, error) {
+	v1, err := fromTyped(t, r1)
+	if err != nil {
+		return nil, err
+	}
+	v2, err := fromTyped(t, r2)
+	if err != nil {
+		return nil, err
+	}
+	return c.PushingNext(t.Runtime, v1, v2), nil
+}
+
+func sizeOf[T any]() uintptr {
+	var x T
+	return unsafe.Sizeof(x)
+}
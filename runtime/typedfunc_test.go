@@ -0,0 +1,125 @@
+package runtime_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/lib"
+	"github.com/arnodel/golua/runtime"
+)
+
+type typedPoint struct {
+	X int `lua:"x"`
+	Y int `lua:"y"`
+}
+
+func TestTypedFuncs(t *testing.T) {
+	r := runtime.New(nil)
+	lib.LoadAll(r)
+	env := r.GlobalEnv()
+	for _, f := range []*runtime.GoFunction{
+		runtime.Func0("answer", func() (int, error) { return 42, nil }),
+		runtime.Func1("double", func(x float64) (float64, error) { return 2 * x, nil }),
+		runtime.Func2("rep", func(s string, n int) (string, error) { return strings.Repeat(s, n), nil }),
+		runtime.Func3("clamp", func(x, lo, hi int64) (int64, error) {
+			if lo > hi {
+				return 0, errors.New("empty range")
+			}
+			if x < lo {
+				return lo, nil
+			}
+			if x > hi {
+				return hi, nil
+			}
+			return x, nil
+		}),
+		runtime.FuncVar("sum", func(xs ...int) (int, error) {
+			n := 0
+			for _, x := range xs {
+				n += x
+			}
+			return n, nil
+		}),
+		runtime.Func1Var("join", func(sep string, parts ...string) (string, error) {
+			return strings.Join(parts, sep), nil
+		}),
+		runtime.Func1R2("divmod", func(p typedPoint) (int, int, error) { return p.X / p.Y, p.X % p.Y, nil }),
+		runtime.Func2R2("swap", func(a, b runtime.Value) (runtime.Value, runtime.Value, error) { return b, a, nil }),
+		runtime.Func1("point", func(n int) (typedPoint, error) { return typedPoint{X: n, Y: -n}, nil }),
+		runtime.Func1("truth", func(b bool) (bool, error) { return b, nil }),
+		runtime.Func1("size", func(t *runtime.Table) (int64, error) { return t.Len(), nil }),
+		runtime.Proc2("setfield", func(t *runtime.Table, v runtime.Value) error {
+			t.Set(runtime.StringValue("field"), v)
+			return nil
+		}),
+	} {
+		r.SetEnv(env, f.Name(), runtime.FunctionValue(f))
+	}
+
+	tests := []struct {
+		src, want string
+	}{
+		{`answer()`, "42"},
+		{`double(1.5) == 3`, "true"},
+		{`math.type(double(1))`, "float"},
+		{`double("2") == 4`, "true"},
+		{`rep("ab", 3)`, "ababab"},
+		{`rep(12, 2)`, "1212"},
+		{`clamp(10, 1, 5)`, "5"},
+		{`select(2, pcall(clamp, 1, 5, 1))`, "empty range"},
+		{`sum()`, "0"},
+		{`sum(1, 2, 3)`, "6"},
+		{`join(", ", "a", "b")`, "a, b"},
+		{`table.concat({divmod({x=7, y=2})}, " ")`, "3 1"},
+		{`table.concat({swap(1, 2)}, " ")`, "2 1"},
+		{`point(3).x + point(3).y`, "0"},
+		{`truth(nil)`, "false"},
+		{`truth(0)`, "true"},
+		{`size({1, 2, 3})`, "3"},
+		{`(function() local t = {} setfield(t, "x") return t.field end)()`, "x"},
+		{`select('#', setfield({}, 1))`, "0"},
+		{`select(2, pcall(rep, "x"))`, "bad argument #2 to 'rep' (number expected, got no value)"},
+		{`select(2, pcall(rep, "x", "y"))`, "bad argument #2 to 'rep' (number expected, got string)"},
+		{`select(2, pcall(rep, {}, 1))`, "bad argument #1 to 'rep' (string expected, got table)"},
+		{`select(2, pcall(rep, "x", 1.5))`, "bad argument #2 to 'rep' (number has no integer representation)"},
+		{`select(2, pcall(sum, 1, "a"))`, "bad argument #2 to 'sum' (number expected, got string)"},
+		{`select(2, pcall(size, 1))`, "bad argument #1 to 'size' (table expected, got number)"},
+		{`select(2, pcall(divmod, {x="a"}))`, "bad argument #1 to 'divmod' (decode: cannot decode string to value of type int (field \"x\"))"},
+	}
+	for _, test := range tests {
+		chunk, err := r.CompileAndLoadLuaChunk("test", []byte("return tostring("+test.src+")"), runtime.TableValue(env))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := runtime.Call1(r.MainThread(), runtime.FunctionValue(chunk))
+		if err != nil {
+			t.Errorf("%s: %s", test.src, err)
+			continue
+		}
+		got, _ := res.ToString()
+		got = strings.TrimPrefix(got, "test:1: ") // Location of errors
+		if got != test.want {
+			t.Errorf("%s: got %q, expected %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestTypedFuncCompliance(t *testing.T) {
+	if !runtime.QuotasAvailable {
+		t.Skip("quotas not available")
+	}
+	f := runtime.Func0("f", func() (int, error) { return 1, nil }, runtime.ComplyCpuSafe|runtime.ComplyMemSafe)
+	r := runtime.New(nil)
+	th := r.MainThread()
+	_, err := th.CallContext(runtime.RuntimeContextDef{
+		RequiredFlags: runtime.ComplyCpuSafe | runtime.ComplyMemSafe | runtime.ComplyIoSafe,
+	}, func() error {
+		_, err := runtime.Call1(th, runtime.FunctionValue(f))
+		return err
+	})
+	if err == nil || !strings.Contains(fmt.Sprint(err), "missing flags") {
+		t.Errorf("expected missing flags error, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	"github.com/arnodel/golua/code"
+)
+
+// A UnitCache holds compiled code units so that Lua source which is loaded
+// many times, possibly in many runtimes, is only compiled once.  Give the same
+// cache to several runtimes with WithUnitCache; it is safe to use from several
+// goroutines.
+//
+// A code unit is never modified once compiled: each runtime that loads it makes
+// its own Code values (which hold per-runtime state such as inline caches), so
+// units can be shared freely.
+//
+// Units are found by hashing the source, so a file that is modified is
+// compiled again when it is next loaded.  The chunk name, the first line
+// number and the optimization level of the runtime are also part of the key, as
+// they change the compiled code.
+type UnitCache struct {
+	mux     sync.Mutex
+	maxSize int
+	units   map[unitCacheKey]*code.Unit
+}
+
+type unitCacheKey struct {
+	hash      [sha256.Size]byte
+	name      string
+	startLine int
+	optLevel  int
+}
+
+// NewUnitCache returns a new empty cache which holds at most maxSize units.
+// When it is full, an arbitrary unit is evicted to make room for a new one.  If
+// maxSize is 0 or less, the cache is unbounded.
+func NewUnitCache(maxSize int) *UnitCache {
+	return &UnitCache{
+		maxSize: maxSize,
+		units:   map[unitCacheKey]*code.Unit{},
+	}
+}
+
+// Len returns the number of units in the cache.
+func (c *UnitCache) Len() int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.units)
+}
+
+// Clear removes all units from the cache.
+func (c *UnitCache) Clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.units = map[unitCacheKey]*code.Unit{}
+}
+
+func (c *UnitCache) get(k unitCacheKey) *code.Unit {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.units[k]
+}
+
+func (c *UnitCache) add(k unitCacheKey, unit *code.Unit) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := c.units[k]; !ok && c.maxSize > 0 && len(c.units) >= c.maxSize {
+		for k := range c.units {
+			delete(c.units, k)
+			break
+		}
+	}
+	c.units[k] = unit
+}
+
+// Compiles the source as a Lua chunk whose first line is startLine, using the
+// unit cache of the runtime if it has one.  The returned size is the memory
+// used by the unit that the caller must release, which is 0 if the unit comes
+// from the cache as the cache owns it.
+func (r *Runtime) compileLuaChunkCached(name string, source []byte, startLine int) (*code.Unit, uint64, error) {
+	if r.unitCache == nil {
+		return r.compileLuaChunkAt(name, source, startLine)
+	}
+	// Hashing is linear in the size of the source, like compiling but much
+	// cheaper.
+	r.RequireCPU(uint64(len(source)) / 64)
+	key := unitCacheKey{
+		hash:      sha256.Sum256(source),
+		name:      name,
+		startLine: startLine,
+		optLevel:  r.optLevel,
+	}
+	if unit := r.unitCache.get(key); unit != nil {
+		return unit, 0, nil
+	}
+	unit, unitSize, err := r.compileLuaChunkAt(name, source, startLine)
+	if err != nil {
+		return nil, 0, err
+	}
+	r.unitCache.add(key, unit)
+	return unit, unitSize, nil
+}
@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestUnitCache(t *testing.T) {
+	c := NewUnitCache(0)
+	r1 := New(nil, WithUnitCache(c))
+	r2 := New(nil, WithUnitCache(c))
+
+	source := []byte("local x = ... return x + 1")
+	u1, _, err := r1.CompileLuaChunk("test", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, sz, err := r2.CompileLuaChunk("test", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u1 != u2 {
+		t.Error("expected the unit to be shared")
+	}
+	if sz != 0 {
+		t.Errorf("got size %d for a cached unit, want 0", sz)
+	}
+	if c.Len() != 1 {
+		t.Errorf("got %d units, want 1", c.Len())
+	}
+
+	// The chunk name, first line and optimization level are part of the key.
+	if u, _, _ := r1.CompileLuaChunk("other", source); u == u1 {
+		t.Error("expected a different unit for a different name")
+	}
+	clos, err := r1.LoadFromSourceOrCode("test", append([]byte("#!\n"), source...), "t", TableValue(r1.GlobalEnv()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := clos.Code.ActiveLines(); len(lines) == 0 || lines[0] != 2 {
+		t.Errorf("got lines %v, want them to start at 2", lines)
+	}
+	r3 := New(nil, WithUnitCache(c), WithOptimizationLevel(1))
+	if u, _, _ := r3.CompileLuaChunk("test", source); u == u1 {
+		t.Error("expected a different unit for a different optimization level")
+	}
+	if c.Len() != 4 {
+		t.Errorf("got %d units, want 4", c.Len())
+	}
+
+	// Errors are not cached.
+	if _, _, err := r1.CompileLuaChunk("test", []byte("x = = 1")); err == nil {
+		t.Error("expected a syntax error")
+	}
+	if c.Len() != 4 {
+		t.Errorf("got %d units, want 4", c.Len())
+	}
+
+	c.Clear()
+	if c.Len() != 0 {
+		t.Errorf("got %d units after Clear, want 0", c.Len())
+	}
+}
+
+func TestUnitCache_maxSize(t *testing.T) {
+	c := NewUnitCache(2)
+	r := New(nil, WithUnitCache(c))
+	for _, src := range []string{"return 1", "return 2", "return 3", "return 3"} {
+		if _, _, err := r.CompileLuaChunk("test", []byte(src)); err != nil {
+			t.Fatal(err)
+		}
+		if c.Len() > 2 {
+			t.Fatalf("got %d units, want at most 2", c.Len())
+		}
+	}
+}
+
+// Code loaded from the same unit in many runtimes in parallel must not
+// interfere (this is most useful with the -race flag).
+func TestUnitCache_concurrent(t *testing.T) {
+	c := NewUnitCache(0)
+	source := []byte(`
+local t = {n = 0}
+function t:inc() self.n = self.n + 1 end
+for i = 1, 100 do t:inc() end
+return t.n`)
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := New(nil, WithUnitCache(c))
+			clos, err := r.LoadFromSourceOrCode("test", source, "t", TableValue(r.GlobalEnv()), true)
+			if err != nil {
+				errs <- err
+				return
+			}
+			v, err := Call1(r.MainThread(), FunctionValue(clos))
+			if eq, _ := RawEqual(v, IntValue(100)); err == nil && !eq {
+				err = fmt.Errorf("got %v, want 100", v)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if c.Len() != 1 {
+		t.Errorf("got %d units, want 1", c.Len())
+	}
+}
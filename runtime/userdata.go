@@ -3,8 +3,12 @@ package runtime
 // A UserData is a Go value of any type wrapped to be used as a Lua value.  It
 // has a metatable which may allow Lua code to interact with it.
 type UserData struct {
-	value interface{}
-	meta  *Table
+	value     interface{}
+	meta      *Table
+	userValue Value
+
+	// Stands for this userdata in weak keys (see weakref.go).
+	weakHandle *weakHandle
 }
 
 // NewUserData returns a new UserData pointer for the value v, giving it meta as
@@ -28,6 +32,17 @@ func (d *UserData) SetMetatable(m *Table) {
 	d.meta = m
 }
 
+// UserValue returns the userdata's user value, a Lua value that can be
+// associated with the userdata (see debug.getuservalue).
+func (d *UserData) UserValue() Value {
+	return d.userValue
+}
+
+// SetUserValue sets the userdata's user value to v.
+func (d *UserData) SetUserValue(v Value) {
+	d.userValue = v
+}
+
 //
 // LightUserData
 //
@@ -5,6 +5,7 @@ package runtime
 
 import (
 	"fmt"
+	"hash/maphash"
 	"math"
 	"strconv"
 	"unsafe"
@@ -109,20 +110,15 @@ func (v Value) Equals(v2 Value) bool {
 	return v.iface == v2.iface
 }
 
-//go:linkname goRuntimeInt64Hash runtime.int64Hash
-//go:noescape
-func goRuntimeInt64Hash(i uint64, seed uintptr) uintptr
-
-//go:linkname goRuntimeEfaceHash runtime.efaceHash
-//go:noescape
-func goRuntimeEfaceHash(i interface{}, seed uintptr) uintptr
+// Seed used to hash values, e.g. in table keys.
+var valueHashSeed = maphash.MakeSeed()
 
 // Hash returns a hash for the value.
 func (v Value) Hash() uintptr {
 	if v.scalar != 0 {
-		return goRuntimeInt64Hash(v.scalar, 0)
+		return uintptr(maphash.Comparable(valueHashSeed, v.scalar))
 	}
-	return goRuntimeEfaceHash(v.iface, 0)
+	return uintptr(maphash.Comparable(valueHashSeed, v.iface))
 }
 
 // IntValue returns a Value holding the given arg.
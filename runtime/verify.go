@@ -0,0 +1,463 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/arnodel/golua/code"
+)
+
+// ErrInvalidCode is wrapped by the errors returned when loading a binary chunk
+// containing code which does not pass verification.
+var ErrInvalidCode = errors.New("invalid code")
+
+// The kinds of values that a register can hold, as far as the verifier is
+// concerned.  When a register may hold values of several kinds, they are
+// combined.
+type regKind uint8
+
+const (
+	luaKind  regKind = 1 << iota // A Lua value (all registers start as nil)
+	contKind                     // A continuation
+	etcKind                      // The values of an etc register
+)
+
+func (k regKind) String() string {
+	var names []string
+	if k&luaKind != 0 {
+		names = append(names, "a Lua value")
+	}
+	if k&contKind != 0 {
+		names = append(names, "a continuation")
+	}
+	if k&etcKind != 0 {
+		names = append(names, "varargs")
+	}
+	return strings.Join(names, " or ")
+}
+
+// verify checks that c can be run by a LuaCont without crashing, even if it was
+// not produced by the compiler (e.g. it was loaded from a binary chunk).  It
+// checks that
+//
+//   - the register counts, the lines and the upvalue names agree with each other;
+//   - opcodes are valid and their registers, constants and jump targets are in
+//     range;
+//   - a closure is given exactly the upvalues it needs right after it is made;
+//   - execution cannot run past the end of the code;
+//   - continuations and varargs are only found in registers where they are
+//     expected, and never mixed with Lua values.
+//
+// The code of functions defined in c is not checked, as it is verified when it
+// is unmarshalled.
+func (c *Code) verify() error {
+	v := verifier{c: c}
+	if err := v.checkCounts(); err != nil {
+		return err
+	}
+	if err := v.checkOpcodes(); err != nil {
+		return err
+	}
+	return v.checkFlow()
+}
+
+type verifier struct {
+	c *Code
+
+	upvalues []bool            // Opcodes giving upvalues to a closure
+	targets  []bool            // Opcodes which are jump targets
+	states   map[int][]regKind // Register kinds at jump targets
+	work     []int             // Jump targets whose state has changed
+
+	regs []regKind // Register kinds at the current opcode
+}
+
+func (v *verifier) errorf(pc int, format string, args ...interface{}) error {
+	name := v.c.name
+	if name == "" {
+		name = "<lua function>"
+	}
+	where := fmt.Sprintf("function %s", name)
+	if pc >= 0 {
+		where = fmt.Sprintf("%s at offset %d", where, pc)
+	}
+	return fmt.Errorf("%w in %s: %s", ErrInvalidCode, where, fmt.Sprintf(format, args...))
+}
+
+func (v *verifier) checkCounts() error {
+	c := v.c
+	switch {
+	case c.RegCount <= 0:
+		return v.errorf(-1, "register count %d must be positive", c.RegCount)
+	case c.UpvalueCount < 0 || c.CellCount < c.UpvalueCount:
+		return v.errorf(-1, "upvalue count %d and cell count %d are inconsistent", c.UpvalueCount, c.CellCount)
+	case len(c.code) == 0 || len(c.code) > math.MaxInt16:
+		return v.errorf(-1, "code length %d out of range", len(c.code))
+	case len(c.lines) != len(c.code):
+		return v.errorf(-1, "%d lines for %d opcodes", len(c.lines), len(c.code))
+	case len(c.UpNames) != int(c.UpvalueCount):
+		return v.errorf(-1, "%d upvalue names for %d upvalues", len(c.UpNames), c.UpvalueCount)
+	}
+	for _, l := range c.locals {
+		if err := v.checkReg(-1, l.Reg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *verifier) checkReg(pc int, r code.Reg) error {
+	if r.IsCell() {
+		if int(r.Idx()) >= int(v.c.CellCount) {
+			return v.errorf(pc, "cell register %s out of range", r)
+		}
+	} else if int(r.Idx()) >= int(v.c.RegCount) {
+		return v.errorf(pc, "register %s out of range", r)
+	}
+	return nil
+}
+
+func (v *verifier) checkRegs(pc int, regs ...code.Reg) error {
+	for _, r := range regs {
+		if err := v.checkReg(pc, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks each opcode on its own, and that closures are followed by the opcodes
+// which give them their upvalues.
+func (v *verifier) checkOpcodes() error {
+	opcodes := v.c.code
+	v.upvalues = make([]bool, len(opcodes))
+	v.targets = make([]bool, len(opcodes))
+	for pc, op := range opcodes {
+		var err error
+		if op.HasType1() {
+			err = v.checkRegs(pc, op.GetA(), op.GetB(), op.GetC())
+		} else {
+			switch op.TypePfx() {
+			case code.Type0Pfx:
+				err = v.checkReg(pc, op.GetA())
+			case code.Type2Pfx, code.Type7Pfx:
+				err = v.checkRegs(pc, op.GetA(), op.GetB(), op.GetC())
+			case code.Type3Pfx:
+				err = v.checkType3(pc, op)
+			case code.Type4Pfx:
+				err = v.checkType4(pc, op)
+			case code.Type5Pfx:
+				err = v.checkType5(pc, op)
+			case code.Type6Pfx:
+				err = v.checkRegs(pc, op.GetA(), op.GetB())
+			default:
+				err = v.errorf(pc, "unknown opcode %08x", uint32(op))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for pc, isTarget := range v.targets {
+		if isTarget && v.upvalues[pc] {
+			return v.errorf(pc, "jump into the upvalues of a closure")
+		}
+	}
+	return nil
+}
+
+func (v *verifier) checkType3(pc int, op code.Opcode) error {
+	rA := op.GetA()
+	if err := v.checkReg(pc, rA); err != nil {
+		return err
+	}
+	if !op.GetY().LoadsK() {
+		return nil
+	}
+	n := int(op.GetKIndex())
+	if n >= len(v.c.consts) {
+		return v.errorf(pc, "constant K%d out of range", n)
+	}
+	k, isCode := v.c.consts[n].TryCode()
+	if op.GetY() == code.OpK {
+		if isCode {
+			return v.errorf(pc, "constant K%d is a function", n)
+		}
+		return nil
+	}
+	if !isCode {
+		return v.errorf(pc, "constant K%d is not a function", n)
+	}
+	upvalueCount := int(k.UpvalueCount)
+	if upvalueCount == 0 {
+		return nil
+	}
+	if op.GetF() {
+		return v.errorf(pc, "cannot push a closure before it has its upvalues")
+	}
+	if pc+upvalueCount >= len(v.c.code) {
+		return v.errorf(pc, "missing upvalues for closure")
+	}
+	for i := pc + 1; i <= pc+upvalueCount; i++ {
+		up := v.c.code[i]
+		if up.TypePfx() != code.Type4Pfx || !up.HasType4a() || up.GetUnOp() != code.OpUpvalue ||
+			up.GetF() || up.GetA() != rA || !up.GetB().IsCell() {
+			return v.errorf(i, "expected upvalue %d for closure in %s", i-pc, rA)
+		}
+		if v.c.lines[i] != v.c.lines[pc] {
+			// Otherwise a line hook could run before the closure is ready.
+			return v.errorf(i, "upvalue for closure on a different line")
+		}
+		v.upvalues[i] = true
+	}
+	return nil
+}
+
+func (v *verifier) checkType4(pc int, op code.Opcode) error {
+	if op.HasType4a() {
+		if err := v.checkRegs(pc, op.GetA(), op.GetB()); err != nil {
+			return err
+		}
+		switch unop := op.GetUnOp(); {
+		case unop > code.OpEtcId:
+			return v.errorf(pc, "unknown unary operator %d", unop)
+		case unop == code.OpUpvalue && !v.upvalues[pc]:
+			return v.errorf(pc, "upvalue given outside of a closure definition")
+		}
+		return nil
+	}
+	if err := v.checkReg(pc, op.GetA()); err != nil {
+		return err
+	}
+	switch op.GetUnOpK() {
+	case code.OpNil, code.OpStr0, code.OpTable, code.OpStr1, code.OpBool, code.OpCC, code.OpClear:
+		return nil
+	default:
+		return v.errorf(pc, "unknown constant operator %d", op.GetUnOpK())
+	}
+}
+
+func (v *verifier) checkType5(pc int, op code.Opcode) error {
+	switch op.GetJ() {
+	case code.OpJump, code.OpJumpIf:
+		dest := pc + int(op.GetOffset())
+		if dest < 0 || dest >= len(v.c.code) {
+			return v.errorf(pc, "jump target %d out of range", dest)
+		}
+		v.targets[dest] = true
+	case code.OpClStack:
+		if !op.GetF() {
+			// Truncating the close stack does not use a register.
+			return nil
+		}
+	}
+	return v.checkReg(pc, op.GetA())
+}
+
+// Follows all the paths of execution from the start of the code, keeping track
+// of the kinds of values in registers to make sure opcodes only find what they
+// expect in them.
+func (v *verifier) checkFlow() error {
+	start := make([]regKind, v.c.RegCount)
+	for i := range start {
+		start[i] = luaKind
+	}
+	start[0] = contKind // The continuation to return to
+	v.targets[0] = true
+	v.states = map[int][]regKind{}
+	v.merge(0, start)
+	v.regs = make([]regKind, v.c.RegCount)
+	for len(v.work) > 0 {
+		pc := v.work[len(v.work)-1]
+		v.work = v.work[:len(v.work)-1]
+		copy(v.regs, v.states[pc])
+		for {
+			next, err := v.step(pc)
+			if err != nil {
+				return err
+			}
+			if next < 0 {
+				break
+			}
+			if next >= len(v.c.code) {
+				return v.errorf(pc, "execution runs past the end of the code")
+			}
+			if v.targets[next] {
+				v.merge(next, v.regs)
+				break
+			}
+			pc = next
+		}
+	}
+	return nil
+}
+
+// Merges the kinds of registers when jumping to pc into the state at pc.
+func (v *verifier) merge(pc int, regs []regKind) {
+	state, ok := v.states[pc]
+	if !ok {
+		v.states[pc] = append([]regKind(nil), regs...)
+		v.work = append(v.work, pc)
+		return
+	}
+	changed := false
+	for i, k := range regs {
+		if state[i]|k != state[i] {
+			state[i] |= k
+			changed = true
+		}
+	}
+	if changed {
+		v.work = append(v.work, pc)
+	}
+}
+
+func (v *verifier) get(r code.Reg) regKind {
+	if r.IsCell() {
+		return luaKind
+	}
+	return v.regs[r.Idx()]
+}
+
+func (v *verifier) set(pc int, r code.Reg, k regKind) error {
+	if r.IsCell() {
+		if k != luaKind {
+			return v.errorf(pc, "cannot store %s in %s", k, r)
+		}
+		return nil
+	}
+	v.regs[r.Idx()] = k
+	return nil
+}
+
+func (v *verifier) need(pc int, k regKind, regs ...code.Reg) error {
+	for _, r := range regs {
+		if got := v.get(r); got != k {
+			return v.errorf(pc, "%s holds %s, expected %s", r, got, k)
+		}
+	}
+	return nil
+}
+
+// Stores the result of an opcode, which may push it to a continuation.
+func (v *verifier) result(pc int, op code.Opcode, k regKind) error {
+	if !op.GetF() {
+		return v.set(pc, op.GetA(), k)
+	}
+	if k != luaKind {
+		return v.errorf(pc, "cannot push %s", k)
+	}
+	return v.need(pc, contKind, op.GetA())
+}
+
+// Updates the register kinds after the opcode at pc and returns where
+// execution continues (-1 if it does not).  The opcode is assumed to have
+// passed checkOpcodes.
+func (v *verifier) step(pc int) (int, error) {
+	op := v.c.code[pc]
+	next := pc + 1
+	var err error
+	if op.HasType1() {
+		err = v.need(pc, luaKind, op.GetB(), op.GetC())
+		if err == nil {
+			err = v.set(pc, op.GetA(), luaKind)
+		}
+		return next, err
+	}
+	switch op.TypePfx() {
+	case code.Type0Pfx:
+		if op.GetF() {
+			err = v.set(pc, op.GetA(), etcKind)
+		} else {
+			err = v.set(pc, op.GetA(), luaKind)
+		}
+	case code.Type2Pfx:
+		err = v.need(pc, luaKind, op.GetB(), op.GetC())
+		if err == nil {
+			if op.GetF() {
+				err = v.need(pc, luaKind, op.GetA())
+			} else {
+				err = v.set(pc, op.GetA(), luaKind)
+			}
+		}
+	case code.Type3Pfx:
+		err = v.result(pc, op, luaKind)
+	case code.Type4Pfx:
+		err = v.stepType4(pc, op)
+	case code.Type5Pfx:
+		switch op.GetJ() {
+		case code.OpJump:
+			v.merge(pc+int(op.GetOffset()), v.regs)
+			next = -1
+		case code.OpJumpIf:
+			v.merge(pc+int(op.GetOffset()), v.regs)
+		case code.OpCall:
+			err = v.need(pc, contKind, op.GetA())
+			if err == nil {
+				// The register is cleared.
+				err = v.set(pc, op.GetA(), luaKind)
+			}
+			if op.GetF() {
+				next = -1
+			}
+		case code.OpClStack:
+			if op.GetF() {
+				err = v.need(pc, luaKind, op.GetA())
+			}
+		}
+	case code.Type6Pfx:
+		err = v.need(pc, etcKind, op.GetB())
+		if err == nil {
+			if op.GetF() {
+				err = v.need(pc, luaKind, op.GetA())
+			} else {
+				err = v.set(pc, op.GetA(), luaKind)
+			}
+		}
+	case code.Type7Pfx:
+		err = v.need(pc, luaKind, op.GetA(), op.GetB(), op.GetC())
+	}
+	return next, err
+}
+
+func (v *verifier) stepType4(pc int, op code.Opcode) error {
+	if !op.HasType4a() {
+		switch op.GetUnOpK() {
+		case code.OpClear:
+			return v.set(pc, op.GetA(), luaKind)
+		case code.OpCC:
+			return v.result(pc, op, contKind)
+		default:
+			return v.result(pc, op, luaKind)
+		}
+	}
+	rB := op.GetB()
+	switch op.GetUnOp() {
+	case code.OpUpvalue:
+		return nil
+	case code.OpEtcId:
+		if err := v.need(pc, etcKind, rB); err != nil {
+			return err
+		}
+		return v.need(pc, contKind, op.GetA())
+	case code.OpId:
+		k := v.get(rB)
+		if k&contKind != 0 {
+			// A continuation can only be called once.
+			return v.errorf(pc, "cannot copy %s", k)
+		}
+		return v.result(pc, op, k)
+	case code.OpCont, code.OpTailCont:
+		if err := v.need(pc, luaKind, rB); err != nil {
+			return err
+		}
+		return v.result(pc, op, contKind)
+	default:
+		if err := v.need(pc, luaKind, rB); err != nil {
+			return err
+		}
+		return v.result(pc, op, luaKind)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build go1.18
+// +build go1.18
+
+package runtime
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzLoadBinaryChunk checks that loading arbitrary binary chunks and running
+// those which pass verification never crashes.  The seed corpus is made of the
+// Lua tests of this package (see also testdata/fuzz/FuzzLoadBinaryChunk).
+//
+// Run it with
+//
+//	go test -fuzz=FuzzLoadBinaryChunk ./runtime
+func FuzzLoadBinaryChunk(f *testing.F) {
+	files, err := filepath.Glob("lua/*.lua")
+	if err != nil {
+		f.Fatal(err)
+	}
+	r := New(os.Stdout)
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(dumpChunk(f, r, file, src))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := New(ioutil.Discard)
+		clos, err := r.LoadFromSourceOrCode("fuzz", data, "b", TableValue(r.GlobalEnv()), false)
+		if err != nil || !QuotasAvailable {
+			return
+		}
+		th := r.MainThread()
+		th.CallContext(RuntimeContextDef{
+			HardLimits: RuntimeResources{Cpu: 100000, Memory: 1 << 20},
+		}, func() error {
+			return Call(th, FunctionValue(clos), nil, NewTerminationWith(nil, 0, false))
+		})
+	})
+}
@@ -0,0 +1,286 @@
+package runtime
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/golua/code"
+	"github.com/arnodel/golua/ir"
+)
+
+// Compiles a Lua chunk and returns it marshalled as by string.dump.
+func dumpChunk(t testing.TB, r *Runtime, name string, src []byte) []byte {
+	src, _ = stripFirstLineComment(src)
+	unit, _, err := r.CompileLuaChunk(name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clos := r.LoadLuaUnit(unit, NilValue)
+	var w bytes.Buffer
+	if _, err := MarshalConst(&w, CodeValue(r.RefactorCodeConsts(clos.Code)), 0); err != nil {
+		t.Fatal(err)
+	}
+	return w.Bytes()
+}
+
+func TestVerify_compiledCode(t *testing.T) {
+	files, err := filepath.Glob("lua/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for level := ir.NoOptimization; level <= ir.MaxOptimizationLevel; level++ {
+		r := New(os.Stdout, WithOptimizationLevel(level))
+		for _, file := range files {
+			src, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := dumpChunk(t, r, file, src)
+			if _, _, err := UnmarshalConst(bytes.NewReader(data), 0); err != nil {
+				t.Errorf("%s at optimization level %d: %s", file, level, err)
+			}
+		}
+	}
+}
+
+func TestVerify_invalidCode(t *testing.T) {
+	var (
+		r0 = code.ValueReg(0)
+		r1 = code.ValueReg(1)
+		r2 = code.ValueReg(2)
+		r9 = code.ValueReg(9)
+		u0 = code.CellReg(0)
+		u3 = code.CellReg(3)
+	)
+	inner := &Code{
+		name:         "g",
+		code:         []code.Opcode{code.TailCall(r0)},
+		lines:        []int32{0},
+		UpvalueCount: 1,
+		UpNames:      []string{"x"},
+		RegCount:     1,
+		CellCount:    1,
+	}
+	mkCode := func(opcodes ...code.Opcode) *Code {
+		return &Code{
+			name:         "f",
+			code:         opcodes,
+			lines:        make([]int32, len(opcodes)),
+			consts:       []Value{StringValue("x"), CodeValue(inner)},
+			UpvalueCount: 1,
+			UpNames:      []string{"_ENV"},
+			RegCount:     3,
+			CellCount:    1,
+		}
+	}
+	tests := []struct {
+		name    string
+		code    *Code
+		wantErr string
+	}{
+		{
+			name: "valid code",
+			code: mkCode(
+				code.ReceiveEtc(r1),
+				code.LoadClosure(r2, 1),
+				code.Upval(r2, u0),
+				code.Cont(r2, r2),
+				code.PushEtc(r2, r1),
+				code.Call(r2),
+				code.TailCall(r0),
+			),
+		},
+		{
+			name: "no registers",
+			code: func() *Code {
+				c := mkCode(code.TailCall(r0))
+				c.RegCount = 0
+				return c
+			}(),
+			wantErr: "register count 0 must be positive",
+		},
+		{
+			name: "missing lines",
+			code: func() *Code {
+				c := mkCode(code.LoadNil(r1), code.TailCall(r0))
+				c.lines = c.lines[:1]
+				return c
+			}(),
+			wantErr: "1 lines for 2 opcodes",
+		},
+		{
+			name: "missing upvalue names",
+			code: func() *Code {
+				c := mkCode(code.TailCall(r0))
+				c.UpNames = nil
+				return c
+			}(),
+			wantErr: "0 upvalue names for 1 upvalues",
+		},
+		{
+			name:    "unknown opcode",
+			code:    mkCode(code.Opcode(1<<28), code.TailCall(r0)),
+			wantErr: "function f at offset 0: unknown opcode 10000000",
+		},
+		{
+			name:    "register out of range",
+			code:    mkCode(code.LoadNil(r9), code.TailCall(r0)),
+			wantErr: "register r9 out of range",
+		},
+		{
+			name:    "cell register out of range",
+			code:    mkCode(code.LoadNil(u3), code.TailCall(r0)),
+			wantErr: "cell register u3 out of range",
+		},
+		{
+			name:    "constant out of range",
+			code:    mkCode(code.LoadConst(r1, 5), code.TailCall(r0)),
+			wantErr: "constant K5 out of range",
+		},
+		{
+			name:    "function loaded as a constant",
+			code:    mkCode(code.LoadConst(r1, 1), code.TailCall(r0)),
+			wantErr: "constant K1 is a function",
+		},
+		{
+			name:    "closure of a string",
+			code:    mkCode(code.LoadClosure(r1, 0), code.TailCall(r0)),
+			wantErr: "constant K0 is not a function",
+		},
+		{
+			name:    "closure without its upvalues",
+			code:    mkCode(code.LoadClosure(r1, 1), code.TailCall(r0)),
+			wantErr: "offset 1: expected upvalue 1 for closure in r1",
+		},
+		{
+			name:    "upvalue given to a closure twice",
+			code:    mkCode(code.LoadClosure(r1, 1), code.Upval(r1, u0), code.Upval(r1, u0), code.TailCall(r0)),
+			wantErr: "offset 2: upvalue given outside of a closure definition",
+		},
+		{
+			name: "upvalue on a different line",
+			code: func() *Code {
+				c := mkCode(code.LoadClosure(r1, 1), code.Upval(r1, u0), code.TailCall(r0))
+				c.lines[1] = 2
+				return c
+			}(),
+			wantErr: "upvalue for closure on a different line",
+		},
+		{
+			name:    "jump out of range",
+			code:    mkCode(code.Jump(10), code.TailCall(r0)),
+			wantErr: "jump target 10 out of range",
+		},
+		{
+			name:    "jump into the upvalues of a closure",
+			code:    mkCode(code.LoadClosure(r1, 1), code.Upval(r1, u0), code.Jump(-1)),
+			wantErr: "offset 1: jump into the upvalues of a closure",
+		},
+		{
+			name:    "execution past the end",
+			code:    mkCode(code.LoadNil(r1)),
+			wantErr: "execution runs past the end of the code",
+		},
+		{
+			name:    "call a Lua value",
+			code:    mkCode(code.Call(r1), code.TailCall(r0)),
+			wantErr: "r1 holds a Lua value, expected a continuation",
+		},
+		{
+			name:    "push to a Lua value",
+			code:    mkCode(code.Push(r1, r2), code.TailCall(r0)),
+			wantErr: "r1 holds a Lua value, expected a continuation",
+		},
+		{
+			name:    "arithmetic on a continuation",
+			code:    mkCode(code.Combine(code.OpAdd, r1, r0, r2), code.TailCall(r0)),
+			wantErr: "r0 holds a continuation, expected a Lua value",
+		},
+		{
+			name:    "continuation in a cell",
+			code:    mkCode(code.Cont(u0, r1), code.TailCall(r0)),
+			wantErr: "cannot store a continuation in u0",
+		},
+		{
+			name:    "copy of a continuation",
+			code:    mkCode(code.Transform(code.OpId, r1, r0), code.TailCall(r0)),
+			wantErr: "cannot copy a continuation",
+		},
+		{
+			name:    "continuation used after it is called",
+			code:    mkCode(code.Cont(r1, r2), code.Call(r1), code.Call(r1), code.TailCall(r0)),
+			wantErr: "offset 2: r1 holds a Lua value, expected a continuation",
+		},
+		{
+			name:    "varargs lookup in a Lua value",
+			code:    mkCode(code.LoadEtcLookup(r1, r2, 0), code.TailCall(r0)),
+			wantErr: "r2 holds a Lua value, expected varargs",
+		},
+		{
+			name: "varargs on one path only",
+			code: mkCode(
+				code.JumpIf(2, r2),
+				code.ReceiveEtc(r1),
+				code.LoadEtcLookup(r2, r1, 0),
+				code.TailCall(r0),
+			),
+			wantErr: "offset 2: r1 holds a Lua value or varargs, expected varargs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.code.verify()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error %q", tt.wantErr)
+			}
+			if !errors.Is(err, ErrInvalidCode) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnmarshalConst_invalidCode(t *testing.T) {
+	r := New(os.Stdout)
+	data := dumpChunk(t, r, "test", []byte("local x = ... return function() return x end"))
+
+	// Find the first "tailcall r0" opcode and make it a call instead so that
+	// execution would run past the end of the code.
+	i := bytes.Index(data, []byte{0x00, 0x00, 0x00, 0x48})
+	if i < 0 {
+		t.Fatal("tailcall opcode not found")
+	}
+	data[i+3] = 0x40
+	_, _, err := UnmarshalConst(bytes.NewReader(data), 0)
+	if !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expected invalid code error, got %v", err)
+	}
+
+	// Truncated input must fail without crashing.
+	data = dumpChunk(t, r, "test", []byte("return 'hello'"))
+	for n := 0; n < len(data); n++ {
+		if _, _, err := UnmarshalConst(bytes.NewReader(data[:n]), 0); err == nil {
+			t.Errorf("truncated to %d bytes: no error", n)
+		}
+	}
+}
+
+func TestUnmarshalConst_nestedTooDeep(t *testing.T) {
+	r := New(os.Stdout)
+	src := strings.Repeat("return function() ", maxCodeDepth) + strings.Repeat("end ", maxCodeDepth)
+	data := dumpChunk(t, r, "test", []byte(src))
+	if _, _, err := UnmarshalConst(bytes.NewReader(data), 0); err != errCodeTooDeep {
+		t.Errorf("expected %v, got %v", errCodeTooDeep, err)
+	}
+}
@@ -0,0 +1,240 @@
+package runtime
+
+import (
+	goruntime "runtime"
+	"sync/atomic"
+	"weak"
+)
+
+//
+// Weak references to Lua values.
+//
+// Weak references are implemented with the weak package of the Go standard
+// library.  Only tables, userdata, Lua functions and threads can be weakly
+// referenced (they are the collectable values), and weak references to the
+// same value are equal so they can be used as table keys.  A weakly referenced
+// value can be reclaimed by the Go garbage collector as soon as it is not
+// strongly reachable, even when it is part of a reference cycle.
+//
+// A weak reference to a value is cleared as soon as the value's Go finalizer is
+// queued (see finalizers.go), and a new weak reference to the value does not
+// compare equal to it if the value is resurrected.  So weak keys are not weak
+// references to the values themselves but to their weakHandle, which stays
+// the same for as long as the value is allocated.  This way, like in Lua, a
+// value being finalized is removed from weak values straight away, but from
+// weak keys only when it is actually reclaimed.
+//
+// Tables with weak keys are ephemeron tables: the value associated with a
+// collectable key is not stored in the table but in the key's weakHandle (see
+// ephemeronValues), so it is only reachable through the table while the key is
+// reachable.  Therefore a value which refers to its own key does not keep the
+// entry alive.
+//
+
+// If v is collectable, returns a value holding a weak reference to v and true,
+// otherwise returns v and false.
+func makeWeakRef(v Value) (Value, bool) {
+	switch x := v.iface.(type) {
+	case *Table:
+		return Value{iface: weak.Make(x)}, true
+	case *UserData:
+		return Value{iface: weak.Make(x)}, true
+	case *Closure:
+		return Value{iface: weak.Make(x)}, true
+	case *Thread:
+		return Value{iface: weak.Make(x)}, true
+	default:
+		return v, false
+	}
+}
+
+// A weakHandle stands for a collectable value in weak keys.  It is allocated
+// the first time it is needed and stored in the value (Table, UserData, Closure
+// and Thread all have a weakHandle field).  It only refers weakly to the value
+// so that it does not create a reference cycle, which would prevent the value
+// from being finalized.
+type weakHandle struct {
+	ref        Value // Weak reference to the value (see makeWeakRef)
+	ephemerons ephemeronValues
+}
+
+// Returns the weakHandle of v if v is collectable, otherwise nil.
+func weakHandleOf(v Value) *weakHandle {
+	var h **weakHandle
+	switch x := v.iface.(type) {
+	case *Table:
+		h = &x.weakHandle
+	case *UserData:
+		h = &x.weakHandle
+	case *Closure:
+		h = &x.weakHandle
+	case *Thread:
+		h = &x.weakHandle
+	default:
+		return nil
+	}
+	if *h == nil {
+		ref, _ := makeWeakRef(v)
+		*h = &weakHandle{ref: ref}
+	}
+	return *h
+}
+
+// Returns true if v is a collectable value.
+func isCollectable(v Value) bool {
+	switch v.iface.(type) {
+	case *Table, *UserData, *Closure, *Thread:
+		return true
+	default:
+		return false
+	}
+}
+
+// If v is collectable, returns a value holding a weak reference to the
+// weakHandle of v and true, otherwise returns v and false.  Unlike the values
+// returned by makeWeakRef, it is still equal to the weak key for v after v has
+// been resurrected.
+func makeWeakKey(v Value) (Value, bool) {
+	h := weakHandleOf(v)
+	if h == nil {
+		return v, false
+	}
+	return Value{iface: weak.Make(h)}, true
+}
+
+// This must be called when v has been resurrected by its Go finalizer, so that
+// its weak keys can resolve to it again.
+func resurrectWeakHandle(v Value) {
+	if h := weakHandleOf(v); resolveWeakRef(h.ref).IsNil() {
+		h.ref, _ = makeWeakRef(v)
+	}
+}
+
+// If v holds a weak reference, returns the value it refers to (or nil if it
+// has been reclaimed), otherwise returns v.
+func resolveWeakRef(v Value) Value {
+	switch p := v.iface.(type) {
+	case weak.Pointer[Table]:
+		if x := p.Value(); x != nil {
+			return TableValue(x)
+		}
+	case weak.Pointer[UserData]:
+		if x := p.Value(); x != nil {
+			return UserDataValue(x)
+		}
+	case weak.Pointer[Closure]:
+		if x := p.Value(); x != nil {
+			return FunctionValue(x)
+		}
+	case weak.Pointer[Thread]:
+		if x := p.Value(); x != nil {
+			return ThreadValue(x)
+		}
+	case weak.Pointer[weakHandle]:
+		if h := p.Value(); h != nil {
+			return resolveWeakRef(h.ref)
+		}
+	default:
+		return v
+	}
+	return NilValue
+}
+
+// Returns true if v is a weak reference to a value that has been reclaimed.
+func isDeadWeakRef(v Value) bool {
+	switch p := v.iface.(type) {
+	case weak.Pointer[Table]:
+		return p.Value() == nil
+	case weak.Pointer[UserData]:
+		return p.Value() == nil
+	case weak.Pointer[Closure]:
+		return p.Value() == nil
+	case weak.Pointer[Thread]:
+		return p.Value() == nil
+	case weak.Pointer[weakHandle]:
+		return p.Value() == nil
+	default:
+		return false
+	}
+}
+
+// An ephemeronValues holds the values associated with a collectable value in
+// the tables with weak keys (and strong values) where it is a key.  It is
+// stored in the weakHandle of the key, so that a value is reachable through a
+// weak table only while its key is.  The Go garbage collector then reclaims
+// the key and the value together once they are only reachable from each other.
+// The tables are identified by weak references to their own weakHandle.
+type ephemeronValues map[weak.Pointer[weakHandle]]Value
+
+// A table with weak keys stores ephemeronMarker as the value of an entry whose
+// key is collectable, the actual value being in the key's ephemeronValues.
+type ephemeronMarker struct{}
+
+// Returns the ephemeronValues of v if v is collectable, otherwise nil.
+func ephemeronsOf(v Value) *ephemeronValues {
+	if h := weakHandleOf(v); h != nil {
+		return &h.ephemerons
+	}
+	return nil
+}
+
+// Returns the value associated with the key in the weak table referred to by
+// t.
+func (e ephemeronValues) get(t weak.Pointer[weakHandle]) Value {
+	return e[t]
+}
+
+// Associates v with the key in the weak table referred to by t (removing the
+// association if v is nil).  The associations with tables that have been
+// reclaimed are removed when a new one is added.
+func (e *ephemeronValues) set(t weak.Pointer[weakHandle], v Value) {
+	if v.IsNil() {
+		delete(*e, t)
+		return
+	}
+	if *e == nil {
+		*e = ephemeronValues{}
+	} else if _, ok := (*e)[t]; !ok {
+		for wt := range *e {
+			if wt.Value() == nil {
+				delete(*e, wt)
+			}
+		}
+	}
+	(*e)[t] = v
+}
+
+//
+// Counting Go GC cycles.
+//
+// Weakly referenced values can only be reclaimed during a GC cycle, so weak
+// tables only need to look for dead entries when a GC cycle has completed since
+// they last did.
+//
+
+// Incremented (atomically) after each Go GC cycle.
+var gcCycleCount uint64
+
+// A gcCycleCounter is allocated and immediately dropped.  Its finalizer
+// increments gcCycleCount and allocates a new one, so it runs once per GC
+// cycle.  It contains a pointer so that it is not allocated with the tiny
+// allocator (whose allocations may never be finalized).
+type gcCycleCounter struct {
+	_ *int
+}
+
+func init() {
+	newGcCycleCounter()
+}
+
+func newGcCycleCounter() {
+	goruntime.SetFinalizer(new(gcCycleCounter), func(*gcCycleCounter) {
+		atomic.AddUint64(&gcCycleCount, 1)
+		newGcCycleCounter()
+	})
+}
+
+// Returns the number of Go GC cycles that have completed so far.
+func gcCycles() uint64 {
+	return atomic.LoadUint64(&gcCycleCount)
+}
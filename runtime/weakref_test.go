@@ -0,0 +1,69 @@
+package runtime
+
+import (
+	goruntime "runtime"
+	"sync"
+	"testing"
+)
+
+// Values returned by weak table lookups must stay alive while they are used,
+// even when the Go garbage collector runs concurrently.
+func TestWeakTable_GetDuringGC(t *testing.T) {
+	r := New(nil)
+	th := r.MainThread()
+
+	finalized := map[int64]bool{}
+	gc := NewGoFunction(func(t *Thread, c *GoCont) (Cont, error) {
+		id, _ := c.Arg(0).AsTable().Get(StringValue("id")).TryInt()
+		finalized[id] = true
+		return c.Next(), nil
+	}, "gc", 1, false)
+	meta := NewTable()
+	meta.Set(StringValue("__gc"), FunctionValue(gc))
+	weakMeta := NewTable()
+	weakMeta.Set(StringValue("__mode"), StringValue("v"))
+	weakTable := NewTable()
+	weakTable.SetMetatable(weakMeta)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				goruntime.GC()
+			}
+		}
+	}()
+
+	var held []*Table
+	for i := int64(1); i <= 20000; i++ {
+		v := NewTable()
+		v.Set(StringValue("id"), IntValue(i))
+		r.SetRawMetatable(TableValue(v), meta)
+		weakTable.Set(IntValue(i%16), TableValue(v))
+		if x, ok := weakTable.Get(IntValue((i * 7) % 16)).TryTable(); ok {
+			held = append(held, x)
+		}
+		if i%1000 == 0 {
+			th.RunPendingFinalizers()
+			for _, x := range held {
+				id, _ := x.Get(StringValue("id")).TryInt()
+				if finalized[id] {
+					t.Fatalf("value %d finalized while reachable", id)
+				}
+			}
+			held = held[:0]
+		}
+	}
+	close(done)
+	wg.Wait()
+	th.CollectGarbage()
+	if len(finalized) == 0 {
+		t.Error("no value was finalized")
+	}
+}
@@ -0,0 +1,174 @@
+package safeio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	goruntime "runtime"
+	"sync"
+
+	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
+)
+
+// ShellCommand returns a command that runs cmdline with the system shell (as
+// the C function system() does).  Running a command allows arbitrary IO, so
+// this is not allowed when the runtime requires IO safety or when it does not
+// use the host filesystem.  If the current runtime context can be interrupted
+// (see KillOnInterrupt), the command runs in its own process group where
+// possible, so that the processes it starts can be killed with it.
+func ShellCommand(r *rt.Runtime, cmdline string) (*exec.Cmd, error) {
+	if !shellAllowed(r) {
+		return nil, ErrNotAllowed
+	}
+	sh, flag := shell()
+	cmd := exec.Command(sh, flag, cmdline)
+	if r.GoContextDone() != nil {
+		setProcessGroup(cmd)
+	}
+	return cmd, nil
+}
+
+// ShellAvailable returns true if commands can be run with ShellCommand.
+func ShellAvailable(r *rt.Runtime) bool {
+	if !shellAllowed(r) {
+		return false
+	}
+	sh, _ := shell()
+	_, err := exec.LookPath(sh)
+	return err == nil
+}
+
+func shellAllowed(r *rt.Runtime) bool {
+	return r.RequiredFlags()&rt.ComplyIoSafe == 0 && r.FS() == vfs.OS
+}
+
+func shell() (string, string) {
+	if goruntime.GOOS == "windows" {
+		return "cmd", "/C"
+	}
+	return "/bin/sh", "-c"
+}
+
+// SetCommandStdio connects the standard streams of cmd which are not already
+// set to those of the runtime: its stdin and stderr (see rt.WithStdio) and its
+// stdout, which is where print writes.  Streams which are files are inherited
+// by the process.  Writing to other streams while Lua code runs would not be
+// safe, so the output of the process to them is collected and written by the
+// returned function, which must be called after cmd.Wait().  The collected
+// output is counted against the memory quota of the runtime until it is
+// written.  It cannot exceed the memory left in the current runtime context:
+// if it would, the process is killed and the returned function terminates the
+// context.  The process gets no input if the stdin of the runtime is not a
+// file.
+func SetCommandStdio(r *rt.Runtime, cmd *exec.Cmd) (flush func() error) {
+	stdin, _, stderr := r.Stdio()
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	stdout := r.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	col := &collector{cmd: cmd}
+	if r.HardLimits().Memory > 0 {
+		// This must be computed here, as the runtime cannot be used by the
+		// goroutines that copy the output of the process.
+		col.max = r.UnusedMem()
+	}
+	var outputs []*collectedOutput
+	collect := func(w io.Writer) io.Writer {
+		if _, ok := w.(*os.File); ok {
+			return w
+		}
+		out := &collectedOutput{w: w, col: col}
+		outputs = append(outputs, out)
+		return out
+	}
+	if cmd.Stdin == nil {
+		if f, ok := stdin.(*os.File); ok {
+			cmd.Stdin = f
+		}
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = collect(stdout)
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = collect(stderr)
+	}
+	return func() error {
+		// This terminates the context if the process was killed for writing
+		// too much.
+		r.RequireMem(col.size)
+		defer r.ReleaseMem(col.size)
+		for _, out := range outputs {
+			if _, err := out.buf.WriteTo(out.w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// KillOnInterrupt kills the process of cmd, which must have been started, if
+// the Go context of the current runtime context is done (see
+// rt.RuntimeContextDef.GoContext) before the returned function is called.  The
+// closers (e.g. pipes to the process) are then closed as well, so that reading
+// from or writing to them does not block.  The returned function must be called
+// once the process has terminated.
+func KillOnInterrupt(r *rt.Runtime, cmd *exec.Cmd, closers ...io.Closer) (stop func()) {
+	done := r.GoContextDone()
+	if done == nil {
+		return func() {}
+	}
+	stopCh := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+			_ = killProcess(cmd)
+			for _, c := range closers {
+				_ = c.Close()
+			}
+		case <-stopCh:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+	}
+}
+
+var errOutputTooLarge = errors.New("command output too large")
+
+// A collector limits the total size of the collected outputs of a process.
+type collector struct {
+	cmd  *exec.Cmd
+	mu   sync.Mutex // protects size and the buffers of the outputs
+	max  uint64     // no limit if 0
+	size uint64     // may exceed max, in which case the process was killed
+}
+
+// A collectedOutput is an output of a process which is collected in memory so
+// that it can be written to w later.
+type collectedOutput struct {
+	w   io.Writer
+	col *collector
+	buf bytes.Buffer
+}
+
+func (o *collectedOutput) Write(b []byte) (int, error) {
+	col := o.col
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	col.size += uint64(len(b))
+	if col.max > 0 && col.size > col.max {
+		_ = killProcess(col.cmd)
+		return 0, errOutputTooLarge
+	}
+	return o.buf.Write(b)
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package safeio
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
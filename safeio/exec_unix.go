@@ -0,0 +1,21 @@
+//go:build unix
+
+package safeio
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// Makes cmd run in its own process group, so that killProcess also kills the
+// processes it starts (e.g. the shell may run a command in a child process).
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	if attr := cmd.SysProcAttr; attr != nil && attr.Setpgid {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd.Process.Kill()
+}
@@ -3,38 +3,41 @@ package safeio
 import (
 	"errors"
 	"io/fs"
-	"io/ioutil"
-	"os"
 
 	rt "github.com/arnodel/golua/runtime"
+	"github.com/arnodel/golua/vfs"
 )
 
-func OpenFile(r *rt.Runtime, name string, flag int, perm fs.FileMode) (*os.File, error) {
+// OpenFile opens a file in the filesystem of the runtime (see rt.WithFS).
+func OpenFile(r *rt.Runtime, name string, flag int, perm fs.FileMode) (vfs.File, error) {
 	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
 		return nil, ErrNotAllowed
 	}
-	return os.OpenFile(name, flag, perm)
+	return r.FS().OpenFile(name, flag, perm)
 }
 
-func TempFile(r *rt.Runtime, dir string, pattern string) (*os.File, error) {
+// TempFile creates a temporary file in the filesystem of the runtime.
+func TempFile(r *rt.Runtime, dir string, pattern string) (vfs.File, error) {
 	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
 		return nil, ErrNotAllowed
 	}
-	return ioutil.TempFile(dir, pattern)
+	return r.FS().TempFile(dir, pattern)
 }
 
+// RemoveFile removes a file from the filesystem of the runtime.
 func RemoveFile(r *rt.Runtime, name string) error {
 	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
 		return ErrNotAllowed
 	}
-	return os.Remove(name)
+	return r.FS().Remove(name)
 }
 
+// RenameFile renames a file in the filesystem of the runtime.
 func RenameFile(r *rt.Runtime, oldName, newName string) error {
 	if r.RequiredFlags()&rt.ComplyIoSafe != 0 {
 		return ErrNotAllowed
 	}
-	return os.Rename(oldName, newName)
+	return r.FS().Rename(oldName, newName)
 }
 
 var ErrNotAllowed = errors.New("safeio: operation not allowed")
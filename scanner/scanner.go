@@ -18,6 +18,7 @@ type Scanner struct {
 	items            chan *token.Token // channel of scanned items.
 	state            stateFn
 	errorMsg         string
+	comments         bool // emit comment tokens
 }
 
 type Option func(*Scanner)
@@ -37,6 +38,15 @@ func WithStartLine(l int) Option {
 	}
 }
 
+// WithComments makes the scanner emit COMMENT tokens rather than skip comments.
+// The literal of a comment token starts with "--" and does not include the end
+// of line that terminates a short comment.
+func WithComments() Option {
+	return func(s *Scanner) {
+		s.comments = true
+	}
+}
+
 // New creates a new scanner for the input string.
 func New(name string, input []byte, opts ...Option) *Scanner {
 	l := &Scanner{
@@ -230,3 +230,32 @@ func TestScanner(t *testing.T) {
 		})
 	}
 }
+
+func TestScannerWithComments(t *testing.T) {
+	text := "x --[[a\nb]] y -- c\n--[\nz --d"
+	toks := []tok{
+		{token.IDENT, "x", 0, 1, 1},
+		{token.COMMENT, "--[[a\nb]]", 2, 1, 3},
+		{token.IDENT, "y", 12, 2, 5},
+		{token.COMMENT, "-- c", 14, 2, 7},
+		{token.COMMENT, "--[", 19, 3, 1},
+		{token.IDENT, "z", 23, 4, 1},
+		{token.COMMENT, "--d", 25, 4, 3},
+		{token.EOF, "", 28, 4, 6},
+	}
+	scanner := New("test", []byte(text), WithComments())
+	for j, ts := range toks {
+		next := scanner.Scan()
+		if !reflect.DeepEqual(next, ts.Token()) {
+			t.Fatalf("Token %d: expected <%s>, got <%s>", j+1, tokenString(ts.Token()), tokenString(next))
+		}
+	}
+
+	// Without the option, comments are skipped
+	scanner = New("test", []byte(text))
+	for _, lit := range []string{"x", "y", "z", ""} {
+		if next := scanner.Scan(); string(next.Lit) != lit {
+			t.Fatalf("expected %q, got <%s>", lit, tokenString(next))
+		}
+	}
+}
@@ -77,10 +77,18 @@ func scanShortComment(l *Scanner) stateFn {
 	for {
 		switch c := l.next(); c {
 		case '\n':
+			if l.comments {
+				l.backup()
+				l.emit(token.COMMENT)
+				return scanToken
+			}
 			l.acceptRune('\r')
 			l.ignore()
 			return scanToken
 		case -1:
+			if l.comments {
+				l.emit(token.COMMENT)
+			}
 			l.ignore()
 			l.emit(token.EOF)
 			return nil
@@ -104,7 +112,11 @@ func scanLong(comment bool) stateFn {
 				break OpeningLoop
 			default:
 				if comment {
-					l.ignore()
+					// Not a long bracket, so this is a short comment
+					l.backup()
+					if !l.comments {
+						l.ignore()
+					}
 					return scanShortComment
 				}
 				return l.errorf(token.INVALID, "expected opening long bracket")
@@ -119,7 +131,11 @@ func scanLong(comment bool) stateFn {
 			case ']':
 				if closeLevel == level {
 					if comment {
-						l.ignore()
+						if l.comments {
+							l.emit(token.COMMENT)
+						} else {
+							l.ignore()
+						}
 					} else {
 						l.emit(token.LONGSTRING)
 					}
@@ -0,0 +1,23 @@
+package main
+
+import "os"
+
+// Subcommands of golua, e.g. "golua fmt file.lua".  They take the arguments
+// after the name of the subcommand and return the exit status.
+var subcommands = map[string]func(args []string) int{
+	"fmt":  fmtMain,
+	"lint": lintMain,
+	"lsp":  lspMain,
+}
+
+// Runs the subcommand given as first argument, if there is one.
+func runSubcommand() (int, bool) {
+	if len(os.Args) < 2 {
+		return 0, false
+	}
+	sub := subcommands[os.Args[1]]
+	if sub == nil {
+		return 0, false
+	}
+	return sub(os.Args[2:]), true
+}
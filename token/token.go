@@ -28,6 +28,7 @@ const (
 	STRING
 	NUMHEX
 	IDENT
+	COMMENT // Only emitted by scanners created with scanner.WithComments()
 
 	KwBreak
 	KwGoto
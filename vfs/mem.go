@@ -0,0 +1,426 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errIsDir       = errors.New("is a directory")
+	errNotEmpty    = errors.New("directory not empty")
+	errBadFileMode = errors.New("bad file descriptor")
+	errNegativePos = errors.New("negative position")
+	errFileTooBig  = errors.New("file too large")
+)
+
+// DefaultMaxFileSize is the maximum size of a file in a MemFS returned by
+// NewMemFS.
+const DefaultMaxFileSize = 64 << 20
+
+// MemFS is a writable filesystem held in memory.  It is safe for concurrent
+// use, so it can be shared between Runtimes.
+//
+// Directories are implicit: a directory exists as long as it contains a file,
+// and creating a file creates its parent directories.  As with ReadOnly, names
+// are relative to the root of the filesystem.  Temporary files are created in
+// "/tmp".
+//
+// Files cannot grow beyond MaxFileSize bytes, and seeking beyond that offset is
+// an error.  Writing to a file may allocate memory for the whole file, so files
+// implement MemoryFile.
+type MemFS struct {
+	// MaxFileSize can be changed before the filesystem is used.
+	MaxFileSize int64
+
+	mu       sync.Mutex
+	files    map[string]*memNode
+	tempSeed uint64
+}
+
+var _ FS = (*MemFS)(nil)
+
+type memNode struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns a new empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{MaxFileSize: DefaultMaxFileSize, files: map[string]*memNode{}}
+}
+
+// WriteFile writes data to the named file, creating it if necessary.  It is
+// convenient for populating the filesystem before handing it over to a Runtime.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := m.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// Open opens the named file or directory for reading.  As required by fs.FS,
+// name must satisfy fs.ValidPath.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if node, ok := m.files[name]; ok {
+		return &memFile{fsys: m, node: node, name: name, flag: os.O_RDONLY}, nil
+	}
+	if entries, ok := m.readDir(name); ok {
+		return &memDir{name: name, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// OpenFile opens the named file, following the same rules as os.OpenFile.
+func (m *MemFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.openFile(name, cleanName(name), flag, perm)
+}
+
+func (m *MemFS) openFile(name, cname string, flag int, perm fs.FileMode) (File, error) {
+	if m.isDir(cname) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errIsDir}
+	}
+	node, ok := m.files[cname]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		node = &memNode{mode: perm & fs.ModePerm, modTime: time.Now()}
+		m.files[cname] = node
+	case flag&os.O_TRUNC != 0:
+		node.data = nil
+		node.modTime = time.Now()
+	}
+	return &memFile{fsys: m, node: node, name: name, flag: flag}, nil
+}
+
+// Remove removes the named file or empty directory.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cname := cleanName(name)
+	if _, ok := m.files[cname]; ok {
+		delete(m.files, cname)
+		return nil
+	}
+	if m.isDir(cname) {
+		return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+	}
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+}
+
+// Rename renames a file, replacing newName if it already exists.  Directories
+// cannot be renamed.
+func (m *MemFS) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldCname, newCname := cleanName(oldName), cleanName(newName)
+	node, ok := m.files[oldCname]
+	var err error
+	switch {
+	case !ok && m.isDir(oldCname):
+		err = errIsDir
+	case !ok:
+		err = fs.ErrNotExist
+	case m.isDir(newCname):
+		err = fs.ErrExist
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	delete(m.files, oldCname)
+	m.files[newCname] = node
+	return nil
+}
+
+// TempFile creates a new file in dir (by default "/tmp") whose name is made by
+// replacing the last "*" in pattern with a number, or appending the number to
+// pattern if it contains no "*".
+func (m *MemFS) TempFile(dir, pattern string) (File, error) {
+	if dir == "" {
+		dir = "/tmp"
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndexByte(pattern, '*'); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		m.tempSeed++
+		name := path.Join(dir, prefix+strconv.FormatUint(m.tempSeed, 10)+suffix)
+		f, err := m.openFile(name, cleanName(name), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+// Returns true if cname is the name of a directory, i.e. some file is in it.
+// Must be called with m.mu held.
+func (m *MemFS) isDir(cname string) bool {
+	if cname == "." {
+		return true
+	}
+	prefix := cname + "/"
+	for name := range m.files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the entries of the directory cname, sorted by name, and true if the
+// directory exists.  Must be called with m.mu held.
+func (m *MemFS) readDir(cname string) ([]fs.DirEntry, bool) {
+	prefix := cname + "/"
+	if cname == "." {
+		prefix = ""
+	}
+	seen := map[string]bool{}
+	var entries []fs.DirEntry
+	for name, node := range m.files {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rest := name[len(prefix):]
+		entryName, isDir := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			entryName, isDir = rest[:i], true
+		}
+		if seen[entryName] {
+			continue
+		}
+		seen[entryName] = true
+		if isDir {
+			entries = append(entries, fs.FileInfoToDirEntry(memDirInfo(entryName)))
+		} else {
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: entryName, node: *node}))
+		}
+	}
+	if entries == nil && cname != "." {
+		return nil, false
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, true
+}
+
+// A memFile is a file opened in a MemFS.
+type memFile struct {
+	fsys   *MemFS
+	node   *memNode
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+var _ MemoryFile = (*memFile)(nil)
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return memFileInfo{name: path.Base(cleanName(f.name)), node: *f.node}, nil
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("read", os.O_WRONLY); err != nil {
+		return 0, err
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if err := f.check("write", os.O_RDONLY); err != nil {
+		return 0, err
+	}
+	node := f.node
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(node.data))
+	}
+	end := f.offset + int64(len(b))
+	if end > f.fsys.MaxFileSize {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: errFileTooBig}
+	}
+	if end > int64(len(node.data)) {
+		if end > int64(cap(node.data)) {
+			// Grow geometrically, but never allocate more than the file
+			// may need.
+			size := 2 * int64(cap(node.data))
+			if size < end {
+				size = end
+			}
+			if size > f.fsys.MaxFileSize {
+				size = f.fsys.MaxFileSize
+			}
+			data := make([]byte, end, size)
+			copy(data, node.data)
+			node.data = data
+		} else {
+			node.data = node.data[:end]
+		}
+	}
+	copy(node.data[f.offset:], b)
+	f.offset = end
+	node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errNegativePos}
+	}
+	if offset > f.fsys.MaxFileSize {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errFileTooBig}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memFile) WriteGrowth(n int) int64 {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	size := int64(len(f.node.data))
+	end := f.offset + int64(n)
+	if f.flag&os.O_APPEND != 0 {
+		end = size + int64(n)
+	}
+	if end <= size || end > f.fsys.MaxFileSize {
+		// Either the file does not grow or the write will fail.
+		return 0
+	}
+	return end - size
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
+
+// Checks that the file is open and was not opened with the access mode
+// badAccess.  Must be called with f.fsys.mu held.
+func (f *memFile) check(op string, badAccess int) error {
+	var err error
+	switch {
+	case f.closed:
+		err = fs.ErrClosed
+	case f.flag&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR) == badAccess:
+		err = errBadFileMode
+	default:
+		return nil
+	}
+	return &fs.PathError{Op: op, Path: f.name, Err: err}
+}
+
+// A memDir is a directory opened in a MemFS.  Its entries are those present
+// when it was opened.
+type memDir struct {
+	name    string
+	entries []fs.DirEntry
+}
+
+var _ fs.ReadDirFile = (*memDir)(nil)
+
+func (d *memDir) Stat() (fs.FileInfo, error) {
+	return memDirInfo(path.Base(d.name)), nil
+}
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *memDir) Close() error {
+	return nil
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n > 0 && len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+type memFileInfo struct {
+	name string
+	node memNode
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.node.data)) }
+func (i memFileInfo) Mode() fs.FileMode  { return i.node.mode }
+func (i memFileInfo) ModTime() time.Time { return i.node.modTime }
+func (i memFileInfo) IsDir() bool        { return false }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+type memDirInfo string
+
+func (i memDirInfo) Name() string       { return string(i) }
+func (i memDirInfo) Size() int64        { return 0 }
+func (i memDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0755 }
+func (i memDirInfo) ModTime() time.Time { return time.Time{} }
+func (i memDirInfo) IsDir() bool        { return true }
+func (i memDirInfo) Sys() interface{}   { return nil }
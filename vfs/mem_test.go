@@ -0,0 +1,114 @@
+package vfs_test
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/arnodel/golua/vfs"
+)
+
+func TestMemFS(t *testing.T) {
+	m := vfs.NewMemFS()
+	for _, name := range []string{"a.lua", "lib/b.lua", "lib/sub/c.lua"} {
+		if err := m.WriteFile(name, []byte("return '"+name+"'"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fstest.TestFS(m, "a.lua", "lib/b.lua", "lib/sub/c.lua"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFSReadWrite(t *testing.T) {
+	m := vfs.NewMemFS()
+	f, err := m.OpenFile("/dir/./x.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "hello, world"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "there"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	b, err := fs.ReadFile(m, "dir/x.txt")
+	if err != nil || string(b) != "hello, there" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+
+	if err := m.Rename("dir/x.txt", "y.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Open("dir"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected dir to be gone, got %v", err)
+	}
+	if _, err := m.OpenFile("y.txt", os.O_CREATE|os.O_EXCL, 0644); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("expected ErrExist, got %v", err)
+	}
+
+	r, _ := m.OpenFile("y.txt", os.O_RDONLY, 0)
+	if _, err := r.Write([]byte("x")); err == nil {
+		t.Fatal("expected error writing to read-only file")
+	}
+	if err := m.Remove("y.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("y.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemFSMaxFileSize(t *testing.T) {
+	m := vfs.NewMemFS()
+	m.MaxFileSize = 100
+	f, err := m.OpenFile("x.txt", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(1<<40, io.SeekStart); err == nil {
+		t.Fatal("expected error seeking beyond the maximum file size")
+	}
+	if _, err := f.Seek(95, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.(vfs.MemoryFile).WriteGrowth(5); n != 100 {
+		t.Fatalf("expected growth of 100, got %d", n)
+	}
+	if _, err := io.WriteString(f, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, "!"); err == nil {
+		t.Fatal("expected error writing beyond the maximum file size")
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 100 {
+		t.Fatalf("got %v, %v", info, err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ro := vfs.ReadOnly(fstest.MapFS{"foo/bar.lua": {Data: []byte("return 1")}})
+	f, err := ro.OpenFile("./foo/bar.lua", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil || string(b) != "return 1" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	if _, err := ro.OpenFile("foo/bar.lua", os.O_WRONLY, 0); err == nil {
+		t.Fatal("expected error opening file for writing")
+	}
+	if err := ro.Remove("foo/bar.lua"); err == nil {
+		t.Fatal("expected error removing file")
+	}
+}
@@ -0,0 +1,43 @@
+package vfs
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+)
+
+// OS is the host filesystem.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+var _ FS = osFS{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// Do not return a typed nil
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) TempFile(dir, pattern string) (File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,92 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var (
+	errReadOnly = errors.New("read-only file system")
+	errNoSeek   = errors.New("illegal seek")
+)
+
+// ReadOnly returns an FS that gives read access to the files in fsys (e.g. an
+// embed.FS) and refuses all attempts to write.  File names are made relative
+// to the root of fsys, so "/foo.lua", "./foo.lua" and "foo.lua" all name the
+// same file.
+func ReadOnly(fsys fs.FS) FS {
+	return readOnlyFS{fsys: fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+var _ FS = readOnlyFS{}
+
+func (r readOnlyFS) Open(name string) (fs.File, error) {
+	return r.fsys.Open(name)
+}
+
+func (r readOnlyFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errReadOnly}
+	}
+	f, err := r.fsys.Open(cleanName(name))
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyFile{File: f, name: name}, nil
+}
+
+func (r readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errReadOnly}
+}
+
+func (r readOnlyFS) Rename(oldName, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errReadOnly}
+}
+
+func (r readOnlyFS) TempFile(dir, pattern string) (File, error) {
+	return nil, &fs.PathError{Op: "createtemp", Path: path.Join(dir, pattern), Err: errReadOnly}
+}
+
+// A readOnlyFile turns an fs.File into a File.  It can seek if the underlying
+// file implements io.Seeker.
+type readOnlyFile struct {
+	fs.File
+	name string
+}
+
+func (f readOnlyFile) Name() string {
+	return f.name
+}
+
+func (f readOnlyFile) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: errReadOnly}
+}
+
+func (f readOnlyFile) Seek(offset int64, whence int) (int64, error) {
+	if s, ok := f.File.(io.Seeker); ok {
+		return s.Seek(offset, whence)
+	}
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errNoSeek}
+}
+
+func (f readOnlyFile) Sync() error {
+	return nil
+}
+
+// Turns a name given by Lua code into a valid fs.FS path (see fs.ValidPath),
+// interpreting it relative to the root of the filesystem.
+func cleanName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
@@ -0,0 +1,65 @@
+// Package vfs defines the filesystem interface through which Lua code accesses
+// files, so that a Runtime can be given a private filesystem (see
+// runtime.WithFS).
+//
+// Three implementations are provided: OS gives access to the host filesystem
+// (this is the default), ReadOnly exposes any fs.FS (e.g. an embed.FS) and
+// MemFS is a writable in-memory filesystem.
+package vfs
+
+import (
+	"io"
+	"io/fs"
+)
+
+// FS is a filesystem that supports writing as well as reading.  The Open method
+// follows the rules of fs.FS, but the other methods are passed file names as
+// given by Lua code, so they may be absolute or contain "." and ".." elements;
+// it is up to the implementation to interpret them.
+//
+// Errors should be *fs.PathError or *os.LinkError values where it makes sense,
+// as Lua functions report those to the caller as a nil, message pair rather
+// than raising an error.
+type FS interface {
+	fs.FS
+
+	// OpenFile opens the named file with the given flags (os.O_RDONLY etc.).
+	// If the file is created, it is given the permissions perm.
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+
+	// Remove removes the named file.
+	Remove(name string) error
+
+	// Rename renames (moves) oldName to newName.
+	Rename(oldName, newName string) error
+
+	// TempFile creates a new temporary file in the directory dir and opens it
+	// for reading and writing, as os.CreateTemp.  If dir is empty, a default
+	// directory is used.
+	TempFile(dir, pattern string) (File, error)
+}
+
+// File is a file opened by an FS.  *os.File implements it.
+type File interface {
+	fs.File
+	io.Writer
+	io.Seeker
+
+	// Name returns the name of the file as passed to OpenFile.
+	Name() string
+
+	// Sync commits the contents of the file to stable storage, if that means
+	// something for the filesystem.
+	Sync() error
+}
+
+// MemoryFile is implemented by files whose contents are held in memory (e.g.
+// files opened in a MemFS), so that the memory taken by writing to them can be
+// accounted for.
+type MemoryFile interface {
+	File
+
+	// WriteGrowth returns the number of bytes by which writing n bytes at the
+	// current offset would grow the file.
+	WriteGrowth(n int) int64
+}